@@ -251,9 +251,10 @@ func (l *DockerCluster) OneShot(
 }
 
 // SidecarContainer runs a container in the same bridge network as the
-// CockroachDB nodes.
+// CockroachDB nodes. Binds, if any, are volume bindings in the docker
+// `host-path:container-path[:options]` format.
 func (l *DockerCluster) SidecarContainer(
-	ctx context.Context, cfg container.Config, portMap map[string]string,
+	ctx context.Context, cfg container.Config, portMap map[string]string, binds ...string,
 ) (*Container, error) {
 	if err := pullImage(ctx, l, cfg.Image, types.ImagePullOptions{}); err != nil {
 		return nil, err
@@ -268,6 +269,7 @@ func (l *DockerCluster) SidecarContainer(
 		// upstream wildcard DNS matching and result in odd behavior.
 		DNSSearch:    []string{"."},
 		PortBindings: portBindings,
+		Binds:        binds,
 	}
 	containerName := fmt.Sprintf(`%s-%s`, cfg.Hostname, l.clusterID)
 	resp, err := l.client.ContainerCreate(ctx, &cfg, hostConfig, nil, containerName)
@@ -10,9 +10,12 @@ package acceptanceccl
 
 import (
 	"context"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net"
+	"net/http"
 	"reflect"
 	"strconv"
 	"strings"
@@ -23,14 +26,20 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/acceptance"
 	"github.com/cockroachdb/cockroach/pkg/acceptance/cluster"
 	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl"
+	"github.com/cockroachdb/cockroach/pkg/sql"
 	"github.com/cockroachdb/cockroach/pkg/sql/jobs"
 	"github.com/cockroachdb/cockroach/pkg/testutils"
 	"github.com/cockroachdb/cockroach/pkg/testutils/serverutils"
 	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
+	"github.com/cockroachdb/cockroach/pkg/util/envutil"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/go-connections/nat"
+	"github.com/pkg/errors"
 )
 
 func TestCDCPauseUnpause(t *testing.T) {
@@ -49,7 +58,7 @@ func TestCDCPauseUnpause(t *testing.T) {
 }
 
 func testCDCPauseUnpause(ctx context.Context, t *testing.T, c *cluster.DockerCluster) {
-	k, err := startDockerKafka(ctx, c)
+	k, err := startDockerKafka(ctx, c, 0 /* clockSkew */)
 	if err != nil {
 		t.Fatalf(`%+v`, err)
 	}
@@ -97,7 +106,11 @@ func testCDCPauseUnpause(ctx context.Context, t *testing.T, c *cluster.DockerClu
 		t.Fatalf(`expected a resolved timestamp got %s: %s->%s`, m.Topic, m.Key, m.Value)
 	}
 
-	sqlDB.Exec(t, `PAUSE JOB $1`, jobID)
+	sqlDB.Exec(t, `PAUSE JOB $1 WITH REASON = 'kafka maintenance'`, jobID)
+	sqlDB.CheckQueryResults(t,
+		`SELECT status, pause_reason FROM [SHOW JOBS] WHERE id = `+strconv.Itoa(jobID),
+		[][]string{{`paused`, `kafka maintenance`}},
+	)
 	sqlDB.Exec(t, `INSERT INTO foo VALUES (16, 'f')`)
 	sqlDB.Exec(t, `RESUME JOB $1`, jobID)
 	tc.assertPayloads(t, []string{
@@ -105,15 +118,205 @@ func testCDCPauseUnpause(ctx context.Context, t *testing.T, c *cluster.DockerClu
 	})
 }
 
+func TestCDCAvro(t *testing.T) {
+	acceptance.RunDocker(t, func(t *testing.T) {
+		ctx := context.Background()
+		cfg := acceptance.ReadConfigFromFlags()
+		cfg.Nodes = nil
+		c := acceptance.StartCluster(ctx, t, cfg).(*cluster.DockerCluster)
+		log.Infof(ctx, "cluster started successfully")
+		defer c.AssertAndStop(ctx, t)
+		testCDCAvro(ctx, t, c)
+	})
+}
+
+func testCDCAvro(ctx context.Context, t *testing.T, c *cluster.DockerCluster) {
+	k, err := startDockerKafka(ctx, c, 0 /* clockSkew */)
+	if err != nil {
+		t.Fatalf(`%+v`, err)
+	}
+	defer k.Close(ctx)
+
+	s, sqlDBRaw, _ := serverutils.StartServer(t, base.TestServerArgs{
+		UseDatabase: "d",
+	})
+	defer s.Stopper().Stop(ctx)
+	sqlDB := sqlutils.MakeSQLRunner(sqlDBRaw)
+
+	sqlDB.Exec(t, `SET CLUSTER SETTING changefeed.experimental_poll_interval = '0ns'`)
+	sqlDB.Exec(t, `CREATE DATABASE d`)
+	sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b STRING)`)
+	sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 'a')`)
+
+	sqlDB.Exec(t, `CREATE CHANGEFEED FOR foo INTO $1 WITH format=experimental_avro, confluent_schema_registry=$2`,
+		`kafka://localhost:`+k.kafkaPort, k.schemaRegistryURL())
+
+	tc, err := makeTopicsConsumer(k.consumer, `foo`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := tc.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	m := tc.nextMessage(t)
+	// The avro binary encoding of each field is the zig-zag varint union
+	// branch (1 for non-null) followed by the value.
+	const expectedKey, expectedValue = `0202`, `0202020261`
+	key, keySchema := k.decodeConfluentAvro(t, m.Key)
+	value, valueSchema := k.decodeConfluentAvro(t, m.Value)
+	if key != expectedKey || value != expectedValue {
+		t.Errorf(`expected %s->%s got %s->%s`, expectedKey, expectedValue, key, value)
+	}
+	const expectedKeySchema = `{"type":"record","name":"foo","fields":[` +
+		`{"name":"a","type":["null","long"],"default":null}]}`
+	if keySchema != expectedKeySchema {
+		t.Errorf("expected key schema\n  %s\ngot\n  %s", expectedKeySchema, keySchema)
+	}
+	const expectedValueSchema = `{"type":"record","name":"foo","fields":[` +
+		`{"name":"a","type":["null","long"],"default":null},` +
+		`{"name":"b","type":["null","string"],"default":null}]}`
+	if valueSchema != expectedValueSchema {
+		t.Errorf("expected value schema\n  %s\ngot\n  %s", expectedValueSchema, valueSchema)
+	}
+}
+
+// libfaketimeDir is a directory on the host with a libfaketime.so.1 built for
+// the confluent images (which are debian based). It's needed to skew the clocks
+// of the kafka sidecars, the clock skew tests are skipped without it.
+var libfaketimeDir = envutil.EnvOrDefaultString("COCKROACH_ACCEPTANCE_LIBFAKETIME_DIR", "")
+
+// TestCDCClockSkew checks that the changefeed guarantees hold when the clocks
+// of the kafka brokers and zookeeper are skewed from CockroachDB's, which is
+// common in customer environments. Changefeed timestamps come from
+// CockroachDB's clock alone, so neither the rows nor the resolved timestamps
+// should be affected by the brokers being ahead or behind.
+func TestCDCClockSkew(t *testing.T) {
+	if libfaketimeDir == `` {
+		t.Skip(`COCKROACH_ACCEPTANCE_LIBFAKETIME_DIR is not set`)
+	}
+	for _, skew := range []time.Duration{time.Hour, -time.Hour} {
+		t.Run(fmt.Sprintf(`skew=%s`, skew), func(t *testing.T) {
+			acceptance.RunDocker(t, func(t *testing.T) {
+				ctx := context.Background()
+				cfg := acceptance.ReadConfigFromFlags()
+				cfg.Nodes = nil
+				c := acceptance.StartCluster(ctx, t, cfg).(*cluster.DockerCluster)
+				log.Infof(ctx, "cluster started successfully")
+				defer c.AssertAndStop(ctx, t)
+				testCDCClockSkew(ctx, t, c, skew)
+			})
+		})
+	}
+}
+
+func testCDCClockSkew(
+	ctx context.Context, t *testing.T, c *cluster.DockerCluster, skew time.Duration,
+) {
+	k, err := startDockerKafka(ctx, c, skew)
+	if err != nil {
+		t.Fatalf(`%+v`, err)
+	}
+	defer k.Close(ctx)
+
+	s, sqlDBRaw, _ := serverutils.StartServer(t, base.TestServerArgs{
+		UseDatabase: "d",
+	})
+	defer s.Stopper().Stop(ctx)
+	sqlDB := sqlutils.MakeSQLRunner(sqlDBRaw)
+
+	sqlDB.Exec(t, `SET CLUSTER SETTING changefeed.experimental_poll_interval = '0ns'`)
+	sqlDB.Exec(t, `CREATE DATABASE d`)
+	sqlDB.Exec(t, `CREATE TABLE foo (a INT PRIMARY KEY, b INT)`)
+	sqlDB.Exec(t, `INSERT INTO foo VALUES (1, 0), (2, 0), (3, 0)`)
+
+	var jobID int
+	sqlDB.QueryRow(t, `CREATE CHANGEFEED FOR foo INTO $1 WITH timestamps`,
+		`kafka://localhost:`+k.kafkaPort).Scan(&jobID)
+	defer sqlDB.Exec(t, `CANCEL JOB $1`, jobID)
+
+	tc, err := makeTopicsConsumer(k.consumer, `foo`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := tc.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Keep updating the rows while the feed runs so that rows and resolved
+	// timestamps are interleaved in every partition.
+	const updates = 20
+	for i := 1; i <= updates; i++ {
+		sqlDB.Exec(t, `UPDATE foo SET b = $1`, i)
+	}
+
+	v := changefeedccl.NewOrderValidator(`foo`)
+	// Every row and resolved timestamp has to be from CockroachDB's clock,
+	// which is within a few minutes of the test's, nowhere near the skew.
+	const maxDrift = 5 * time.Minute
+	checkDrift := func(ts string) {
+		t.Helper()
+		if drift := timeutil.Since(parseDecimalHLC(t, ts).GoTime()); drift > maxDrift || drift < -maxDrift {
+			t.Errorf(`timestamp %s is %s away from the local clock`, ts, drift)
+		}
+	}
+	var resolvedSeen int
+	for rowsSeen := 0; rowsSeen < 3*(updates+1) || resolvedSeen < 3; {
+		m := tc.nextMessage(t)
+		partition := strconv.Itoa(int(m.Partition))
+		var valueRaw struct {
+			CRDB struct {
+				Updated  string `json:"updated"`
+				Resolved string `json:"resolved"`
+			} `json:"__crdb__"`
+		}
+		if err := json.Unmarshal(m.Value, &valueRaw); err != nil {
+			t.Fatal(err)
+		}
+		if len(m.Key) == 0 {
+			checkDrift(valueRaw.CRDB.Resolved)
+			if err := v.NoteResolved(partition, parseDecimalHLC(t, valueRaw.CRDB.Resolved)); err != nil {
+				t.Fatal(err)
+			}
+			if rowsSeen > 0 {
+				resolvedSeen++
+			}
+			continue
+		}
+		checkDrift(valueRaw.CRDB.Updated)
+		v.NoteRow(partition, string(m.Key), string(m.Value), parseDecimalHLC(t, valueRaw.CRDB.Updated))
+		rowsSeen++
+	}
+	if failures := v.Failures(); len(failures) > 0 {
+		t.Fatal("validator failures:\n" + strings.Join(failures, "\n"))
+	}
+}
+
+// parseDecimalHLC parses a timestamp in the decimal format of changefeed
+// messages.
+func parseDecimalHLC(t testing.TB, s string) hlc.Timestamp {
+	t.Helper()
+	ts, err := sql.ParseHLC(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
 const (
-	confluentVersion = `4.0.0`
-	zookeeperImage   = `docker.io/confluentinc/cp-zookeeper:` + confluentVersion
-	kafkaImage       = `docker.io/confluentinc/cp-kafka:` + confluentVersion
+	confluentVersion    = `4.0.0`
+	zookeeperImage      = `docker.io/confluentinc/cp-zookeeper:` + confluentVersion
+	kafkaImage          = `docker.io/confluentinc/cp-kafka:` + confluentVersion
+	schemaRegistryImage = `docker.io/confluentinc/cp-schema-registry:` + confluentVersion
 )
 
 type dockerKafka struct {
-	serviceContainers        map[string]*cluster.Container
-	zookeeperPort, kafkaPort string
+	serviceContainers                            map[string]*cluster.Container
+	zookeeperPort, kafkaPort, schemaRegistryPort string
 
 	consumer sarama.Consumer
 }
@@ -179,12 +382,29 @@ func getOpenPort() (string, error) {
 // we're done. \o/
 //
 // This is a monstrosity, so please fix it if you can figure out a better way.
+//
+// If clockSkew is non-zero, the clocks of all the sidecars are skewed from the
+// host's by that much, using the libfaketime in libfaketimeDir.
 func startDockerKafka(
-	ctx context.Context, d *cluster.DockerCluster, topics ...string,
+	ctx context.Context, d *cluster.DockerCluster, clockSkew time.Duration, topics ...string,
 ) (*dockerKafka, error) {
 	k := &dockerKafka{
 		serviceContainers: make(map[string]*cluster.Container),
 	}
+	var skewEnv, skewBinds []string
+	if clockSkew != 0 {
+		if libfaketimeDir == `` {
+			return nil, errors.New(`COCKROACH_ACCEPTANCE_LIBFAKETIME_DIR is required to skew clocks`)
+		}
+		const faketimeMount = `/faketime`
+		skewBinds = []string{libfaketimeDir + `:` + faketimeMount + `:ro`}
+		skewEnv = []string{
+			`LD_PRELOAD=` + faketimeMount + `/libfaketime.so.1`,
+			fmt.Sprintf(`FAKETIME=%+ds`, int64(clockSkew/time.Second)),
+			// The JVM hangs if its monotonic clock is faked.
+			`FAKETIME_DONT_FAKE_MONOTONIC=1`,
+		}
+	}
 	var err error
 	if k.zookeeperPort, err = getOpenPort(); err != nil {
 		return nil, err
@@ -192,6 +412,9 @@ func startDockerKafka(
 	if k.kafkaPort, err = getOpenPort(); err != nil {
 		return nil, err
 	}
+	if k.schemaRegistryPort, err = getOpenPort(); err != nil {
+		return nil, err
+	}
 
 	zookeeper, err := d.SidecarContainer(ctx, container.Config{
 		Hostname: `zookeeper`,
@@ -199,11 +422,11 @@ func startDockerKafka(
 		ExposedPorts: map[nat.Port]struct{}{
 			nat.Port(k.zookeeperPort + `/tcp`): {},
 		},
-		Env: []string{
+		Env: append([]string{
 			`ZOOKEEPER_CLIENT_PORT=` + k.zookeeperPort,
 			`ZOOKEEPER_TICK_TIME=2000`,
-		},
-	}, map[string]string{k.zookeeperPort: k.zookeeperPort})
+		}, skewEnv...),
+	}, map[string]string{k.zookeeperPort: k.zookeeperPort}, skewBinds...)
 	if err != nil {
 		return nil, err
 	}
@@ -213,21 +436,38 @@ func startDockerKafka(
 		ExposedPorts: map[nat.Port]struct{}{
 			nat.Port(k.kafkaPort + `/tcp`): {},
 		},
-		Env: []string{
+		Env: append([]string{
 			`KAFKA_ZOOKEEPER_CONNECT=` + zookeeper.Name() + `:` + k.zookeeperPort,
 			`KAFKA_OFFSETS_TOPIC_REPLICATION_FACTOR=1`,
 			`KAFKA_ADVERTISED_LISTENERS=PLAINTEXT://localhost:` + k.kafkaPort,
+		}, skewEnv...),
+	}, map[string]string{k.kafkaPort: k.kafkaPort}, skewBinds...)
+	if err != nil {
+		return nil, err
+	}
+
+	schemaRegistry, err := d.SidecarContainer(ctx, container.Config{
+		Hostname: `schema-registry`,
+		Image:    schemaRegistryImage,
+		ExposedPorts: map[nat.Port]struct{}{
+			nat.Port(k.schemaRegistryPort + `/tcp`): {},
 		},
-	}, map[string]string{k.kafkaPort: k.kafkaPort})
+		Env: append([]string{
+			`SCHEMA_REGISTRY_HOST_NAME=schema-registry`,
+			`SCHEMA_REGISTRY_KAFKASTORE_CONNECTION_URL=` + zookeeper.Name() + `:` + k.zookeeperPort,
+			`SCHEMA_REGISTRY_LISTENERS=http://0.0.0.0:` + k.schemaRegistryPort,
+		}, skewEnv...),
+	}, map[string]string{k.schemaRegistryPort: k.schemaRegistryPort}, skewBinds...)
 	if err != nil {
 		return nil, err
 	}
 
 	k.serviceContainers = map[string]*cluster.Container{
-		`zookeeper`: zookeeper,
-		`kafka`:     kafka,
+		`zookeeper`:       zookeeper,
+		`kafka`:           kafka,
+		`schema-registry`: schemaRegistry,
 	}
-	for _, n := range []string{`zookeeper`, `kafka`} {
+	for _, n := range []string{`zookeeper`, `kafka`, `schema-registry`} {
 		s := k.serviceContainers[n]
 		if err := s.Start(ctx); err != nil {
 			return nil, err
@@ -248,9 +488,53 @@ func startDockerKafka(
 		return nil, err
 	}
 
+	// Wait for the schema registry to be available. It stores schemas in kafka,
+	// so this has to come after kafka is up.
+	if err := retry.ForDuration(testutils.DefaultSucceedsSoonDuration, func() error {
+		resp, err := http.Get(k.schemaRegistryURL() + `/subjects`)
+		if err != nil {
+			log.Infof(ctx, "%+v", err)
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return errors.Errorf(`schema registry returned %s`, resp.Status)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
 	return k, nil
 }
 
+func (k *dockerKafka) schemaRegistryURL() string {
+	return `http://localhost:` + k.schemaRegistryPort
+}
+
+// decodeConfluentAvro checks that the given bytes are in the confluent wire
+// format and looks up the schema they reference in the schema registry. It
+// returns the hex of the avro encoded data and the schema.
+func (k *dockerKafka) decodeConfluentAvro(t testing.TB, buf []byte) (string, string) {
+	t.Helper()
+	if len(buf) < 5 || buf[0] != 0 {
+		t.Fatalf(`expected confluent wire format got %x`, buf)
+	}
+	id := binary.BigEndian.Uint32(buf[1:5])
+	resp, err := http.Get(fmt.Sprintf(`%s/schemas/ids/%d`, k.schemaRegistryURL(), id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var schemaRaw struct {
+		Schema string `json:"schema"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&schemaRaw); err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(buf[5:]), schemaRaw.Schema
+}
+
 func (k *dockerKafka) Close(ctx context.Context) {
 	for _, c := range k.serviceContainers {
 		if err := c.Kill(ctx); err != nil {
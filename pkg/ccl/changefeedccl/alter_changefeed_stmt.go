@@ -0,0 +1,263 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/backupccl"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/tracing"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	sql.AddPlanHook(alterChangefeedPlanHook)
+}
+
+// alterChangefeedPlanHook implements ALTER CHANGEFEED, which adds tables to
+// or drops tables from a paused changefeed. Once it's resumed, the tables the
+// changefeed was already watching carry on from its high-water mark instead of
+// being scanned again. Added tables get an initial scan of their own, as of
+// the high-water mark, unless the changefeed skipped its own initial scan with
+// initial_scan='no' or a cursor, and are then watched from there like the
+// rest. A changefeed that hasn't made any progress yet still starts with an
+// initial scan of every table.
+func alterChangefeedPlanHook(
+	_ context.Context, stmt tree.Statement, p sql.PlanHookState,
+) (sql.PlanHookRowFn, sqlbase.ResultColumns, []sql.PlanNode, error) {
+	alterStmt, ok := stmt.(*tree.AlterChangefeed)
+	if !ok {
+		return nil, nil, nil, nil
+	}
+
+	// The job ID may be a placeholder, which is typed by the placeholders of
+	// the statement.
+	evalCtx := &p.ExtendedEvalContext().EvalContext
+	semaCtx := tree.MakeSemaContext(false /* privileged */)
+	if evalCtx.HasPlaceholders() {
+		semaCtx.Placeholders = *evalCtx.Placeholders
+	}
+	typedJobID, err := tree.TypeCheckAndRequire(alterStmt.Jobs, &semaCtx, types.Int, `ALTER CHANGEFEED`)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	fn := func(ctx context.Context, _ []sql.PlanNode, _ chan<- tree.Datums) error {
+		ctx, span := tracing.ChildSpan(ctx, stmt.StatementTag())
+		defer tracing.FinishSpan(span)
+
+		d, err := typedJobID.Eval(evalCtx)
+		if err != nil {
+			return err
+		}
+		jobID, ok := d.(*tree.DInt)
+		if !ok {
+			return errors.Errorf(`invalid job ID: %s`, d)
+		}
+		return alterChangefeed(ctx, p, int64(*jobID), alterStmt.Cmds)
+	}
+	return fn, nil, nil, nil
+}
+
+// alterChangefeed applies the commands of an ALTER CHANGEFEED statement to
+// the changefeed with the given job ID.
+func alterChangefeed(
+	ctx context.Context, p sql.PlanHookState, jobID int64, cmds tree.AlterChangefeedCmds,
+) error {
+	job, err := p.ExecCfg().JobRegistry.LoadJobWithTxn(ctx, jobID, p.Txn())
+	if err != nil {
+		return err
+	}
+	details, ok := job.Details().(jobspb.ChangefeedDetails)
+	if !ok {
+		return errors.Errorf(`job %d is not a changefeed`, jobID)
+	}
+	if watchedDatabases(details) != nil {
+		return errors.Errorf(
+			`changefeed %d watches whole databases, its tables can't be altered`, jobID)
+	}
+	if err := p.CheckCanControlJob(ctx, jobID, job.Payload().Username); err != nil {
+		return err
+	}
+
+	// The added tables are resolved as of where the changefeed resumes from,
+	// and their rows are decoded with those descriptors.
+	var highwater hlc.Timestamp
+	var checkpoint []jobspb.ChangefeedProgress_CheckpointedSpan
+	if progress := job.Progress().GetChangefeed(); progress != nil {
+		highwater, checkpoint = progress.Highwater, progress.SpanCheckpoint
+	}
+	descriptorTime := highwater
+	if highwater == (hlc.Timestamp{}) {
+		// An initial scan that's restarted goes on at the timestamps of its
+		// checkpoint.
+		descriptorTime = p.ExecCfg().Clock.Now()
+		for _, s := range checkpoint {
+			if s.Timestamp.Less(descriptorTime) {
+				descriptorTime = s.Timestamp
+			}
+		}
+	}
+	tableDescs := append([]sqlbase.TableDescriptor(nil), details.TableDescs...)
+	find := func(descs []sqlbase.TableDescriptor, id sqlbase.ID) int {
+		for i := range descs {
+			if descs[i].ID == id {
+				return i
+			}
+		}
+		return -1
+	}
+	watched := func(id sqlbase.ID) int { return find(tableDescs, id) }
+	for _, cmd := range cmds {
+		switch cmd := cmd.(type) {
+		case *tree.AlterChangefeedAddTarget:
+			descs, err := resolveChangefeedTables(ctx, p, descriptorTime, cmd.Targets)
+			if err != nil {
+				return err
+			}
+			for _, desc := range descs {
+				if watched(desc.ID) >= 0 {
+					return errors.Errorf(
+						`table %s is already watched by changefeed %d`, desc.Name, jobID)
+				}
+				tableDescs = append(tableDescs, desc)
+			}
+		case *tree.AlterChangefeedDropTarget:
+			descs, err := resolveChangefeedTables(ctx, p, descriptorTime, cmd.Targets)
+			if err != nil {
+				return err
+			}
+			for _, desc := range descs {
+				i := watched(desc.ID)
+				if i < 0 {
+					return errors.Errorf(
+						`table %s is not watched by changefeed %d`, desc.Name, jobID)
+				}
+				tableDescs = append(tableDescs[:i], tableDescs[i+1:]...)
+			}
+		default:
+			return errors.Errorf(`unsupported ALTER CHANGEFEED command: %s`, cmd)
+		}
+	}
+	if len(tableDescs) == 0 {
+		return errors.Errorf(`cannot drop every table watched by changefeed %d`, jobID)
+	}
+
+	// The tables added to a changefeed with a high-water mark are scanned by
+	// having it go back to its initial scan, with a span checkpoint that has
+	// the tables it was already watching done up to the high-water mark, see
+	// span_checkpoint.go.
+	var oldSpans []roachpb.Span
+	var added []sqlbase.TableDescriptor
+	for i := range tableDescs {
+		if find(details.TableDescs, tableDescs[i].ID) >= 0 {
+			oldSpans = append(oldSpans, tableDescs[i].PrimaryIndexSpan())
+		} else {
+			added = append(added, tableDescs[i])
+		}
+	}
+	_, cursor := details.Opts[optCursor]
+	scanAdded := highwater != (hlc.Timestamp{}) && len(added) > 0 && !cursor &&
+		initialScanType(details.Opts[optInitialScan]) != optInitialScanNo
+	if scanAdded {
+		if _, ok := details.Opts[optAllowLargeInitialScan]; !ok {
+			if err := checkInitialScanSize(ctx, p.ExecCfg(), added); err != nil {
+				return err
+			}
+		}
+		done, rest := restoreSpanCheckpoint(oldSpans, checkpoint, highwater)
+		checkpoint = makeSpanCheckpoint(done)
+		for _, span := range rest {
+			checkpoint = append(checkpoint,
+				jobspb.ChangefeedProgress_CheckpointedSpan{Span: span, Timestamp: highwater})
+		}
+	}
+
+	details.TableDescs = tableDescs
+	if details, err = validateChangefeed(details); err != nil {
+		return err
+	}
+	targets, descIDs, err := changefeedTargets(ctx, p, details.TableDescs)
+	if err != nil {
+		return err
+	}
+	details.TableNames = changefeedTableNames(targets)
+	description, err := changefeedJobDescription(&tree.CreateChangefeed{Targets: targets}, details)
+	if err != nil {
+		return err
+	}
+	return job.WithTxn(p.Txn()).UpdatePaused(ctx, func(
+		payload *jobspb.Payload, progress *jobspb.Progress,
+	) error {
+		payload.Description = description
+		payload.DescriptorIDs = descIDs
+		payload.Details = jobspb.WrapPayloadDetails(details)
+		if scanAdded {
+			cfProgress := progress.GetChangefeed()
+			cfProgress.Highwater = hlc.Timestamp{}
+			cfProgress.SpanCheckpoint = checkpoint
+		}
+		return nil
+	})
+}
+
+// resolveChangefeedTables returns the descriptors, as of ts, of the tables
+// named by the targets of an ALTER CHANGEFEED command.
+func resolveChangefeedTables(
+	ctx context.Context, p sql.PlanHookState, ts hlc.Timestamp, targets tree.TargetList,
+) ([]sqlbase.TableDescriptor, error) {
+	descs, _, err := backupccl.ResolveTargetsToDescriptors(ctx, p, ts, targets)
+	if err != nil {
+		return nil, err
+	}
+	var tableDescs []sqlbase.TableDescriptor
+	for _, desc := range descs {
+		if tableDesc := desc.GetTable(); tableDesc != nil {
+			tableDescs = append(tableDescs, *tableDesc)
+		}
+	}
+	return tableDescs, nil
+}
+
+// changefeedTargets returns the fully qualified names of the given tables, for
+// the description of a changefeed, and the IDs of the descriptors the
+// changefeed depends on.
+func changefeedTargets(
+	ctx context.Context, p sql.PlanHookState, tableDescs []sqlbase.TableDescriptor,
+) (tree.TargetList, []sqlbase.ID, error) {
+	var targets tree.TargetList
+	var descIDs []sqlbase.ID
+	for i := range tableDescs {
+		dbDesc, err := sqlbase.GetDatabaseDescFromID(ctx, p.Txn(), tableDescs[i].ParentID)
+		if err != nil {
+			return tree.TargetList{}, nil, err
+		}
+		tn := tree.MakeTableName(tree.Name(dbDesc.Name), tree.Name(tableDescs[i].Name))
+		targets.Tables = append(targets.Tables, &tn)
+		descIDs = append(descIDs, tableDescs[i].ID)
+	}
+	return targets, descIDs, nil
+}
+
+// changefeedTableNames returns the fully qualified names of the tables in the
+// given targets, for the details of a changefeed.
+func changefeedTableNames(targets tree.TargetList) []string {
+	names := make([]string, len(targets.Tables))
+	for i, tn := range targets.Tables {
+		names[i] = tree.AsString(tn)
+	}
+	return names
+}
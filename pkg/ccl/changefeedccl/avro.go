@@ -0,0 +1,451 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"encoding/binary"
+	gojson "encoding/json"
+	"math"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/pkg/errors"
+)
+
+// This file is a minimal implementation of the Avro 1.8.2 binary encoding,
+// specialized to the records that changefeeds emit.
+//
+// https://avro.apache.org/docs/1.8.2/spec.html
+
+const (
+	avroSchemaBoolean = `boolean`
+	avroSchemaBytes   = `bytes`
+	avroSchemaDouble  = `double`
+	avroSchemaInt     = `int`
+	avroSchemaLong    = `long`
+	avroSchemaNull    = `null`
+	avroSchemaRecord  = `record`
+	avroSchemaString  = `string`
+)
+
+var avroDefaultNull = gojson.RawMessage(`null`)
+
+type avroLogicalType struct {
+	SchemaType  string `json:"type"`
+	LogicalType string `json:"logicalType"`
+}
+
+// avroSchemaField is our representation of the schema of a field in an Avro
+// record. Serializing it to JSON gives the standard schema representation.
+type avroSchemaField struct {
+	SchemaType interface{} `json:"type"`
+	Name       string      `json:"name"`
+	// Default is a null default for the fields that hold columns. These are
+	// always nullable, so this is always valid and it makes any addition or
+	// removal of a column a compatible change in the schema registry.
+	Default gojson.RawMessage `json:"default,omitempty"`
+
+	// encodeFn appends the binary encoding of a non-NULL datum to buf.
+	encodeFn func(buf []byte, d tree.Datum) ([]byte, error)
+	// decodeFn reads the binary encoding of a non-NULL datum from buf and
+	// returns it along with the remaining bytes.
+	decodeFn func(buf []byte) (tree.Datum, []byte, error)
+}
+
+// avroDataRecord is our representation of the schema of an Avro record.
+// Serializing it to JSON gives the standard schema representation.
+type avroDataRecord struct {
+	SchemaType string             `json:"type"`
+	Name       string             `json:"name"`
+	Fields     []*avroSchemaField `json:"fields"`
+
+	// colIdxByFieldIdx maps the index of a field to the index of the column it
+	// holds. Fields without a column, like the updated timestamp, are skipped
+	// by BinaryFromRow and must be appended by the caller.
+	colIdxByFieldIdx map[int]int
+}
+
+// avroMetadataRecord is the schema of the payload of a resolved timestamp.
+type avroMetadataRecord struct {
+	SchemaType string             `json:"type"`
+	Name       string             `json:"name"`
+	Fields     []*avroSchemaField `json:"fields"`
+}
+
+// sqlNameToAvroName converts a SQL name to a string that is a valid Avro name.
+// Avro names must start with [A-Za-z_] and subsequently contain only
+// [A-Za-z0-9_]. Invalid characters are replaced with `_`.
+func sqlNameToAvroName(s string) string {
+	var buf []byte
+	for i, r := range s {
+		valid := r == '_' || (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') ||
+			(i > 0 && r >= '0' && r <= '9')
+		if valid {
+			buf = append(buf, byte(r))
+		} else {
+			buf = append(buf, '_')
+		}
+	}
+	return string(buf)
+}
+
+// columnDescToAvroSchema converts a column descriptor into its corresponding
+// Avro field schema. Every field is a union with null, so NULLs (and columns
+// that are later dropped) can be represented.
+func columnDescToAvroSchema(colDesc *sqlbase.ColumnDescriptor) (*avroSchemaField, error) {
+	field := &avroSchemaField{
+		Name:    sqlNameToAvroName(colDesc.Name),
+		Default: avroDefaultNull,
+	}
+
+	var avroType interface{}
+	switch colDesc.Type.SemanticType {
+	case sqlbase.ColumnType_BOOL:
+		avroType = avroSchemaBoolean
+		field.encodeFn = func(buf []byte, d tree.Datum) ([]byte, error) {
+			if *d.(*tree.DBool) {
+				return append(buf, 1), nil
+			}
+			return append(buf, 0), nil
+		}
+		field.decodeFn = func(buf []byte) (tree.Datum, []byte, error) {
+			if len(buf) < 1 {
+				return nil, nil, errors.New(`unexpected end of avro boolean`)
+			}
+			return tree.MakeDBool(buf[0] != 0), buf[1:], nil
+		}
+	case sqlbase.ColumnType_INT:
+		avroType = avroSchemaLong
+		field.encodeFn = func(buf []byte, d tree.Datum) ([]byte, error) {
+			return avroAppendLong(buf, int64(*d.(*tree.DInt))), nil
+		}
+		field.decodeFn = func(buf []byte) (tree.Datum, []byte, error) {
+			i, buf, err := avroReadLong(buf)
+			return tree.NewDInt(tree.DInt(i)), buf, err
+		}
+	case sqlbase.ColumnType_FLOAT:
+		avroType = avroSchemaDouble
+		field.encodeFn = func(buf []byte, d tree.Datum) ([]byte, error) {
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(float64(*d.(*tree.DFloat))))
+			return append(buf, b[:]...), nil
+		}
+		field.decodeFn = func(buf []byte) (tree.Datum, []byte, error) {
+			if len(buf) < 8 {
+				return nil, nil, errors.New(`unexpected end of avro double`)
+			}
+			f := math.Float64frombits(binary.LittleEndian.Uint64(buf[:8]))
+			return tree.NewDFloat(tree.DFloat(f)), buf[8:], nil
+		}
+	case sqlbase.ColumnType_STRING:
+		avroType = avroSchemaString
+		field.encodeFn = func(buf []byte, d tree.Datum) ([]byte, error) {
+			return avroAppendBytes(buf, []byte(*d.(*tree.DString))), nil
+		}
+		field.decodeFn = func(buf []byte) (tree.Datum, []byte, error) {
+			b, buf, err := avroReadBytes(buf)
+			return tree.NewDString(string(b)), buf, err
+		}
+	case sqlbase.ColumnType_BYTES:
+		avroType = avroSchemaBytes
+		field.encodeFn = func(buf []byte, d tree.Datum) ([]byte, error) {
+			return avroAppendBytes(buf, []byte(*d.(*tree.DBytes))), nil
+		}
+		field.decodeFn = func(buf []byte) (tree.Datum, []byte, error) {
+			b, buf, err := avroReadBytes(buf)
+			return tree.NewDBytes(tree.DBytes(b)), buf, err
+		}
+	case sqlbase.ColumnType_DATE:
+		avroType = avroLogicalType{SchemaType: avroSchemaInt, LogicalType: `date`}
+		field.encodeFn = func(buf []byte, d tree.Datum) ([]byte, error) {
+			// DDate is days since the unix epoch, which is exactly the avro
+			// date logical type.
+			return avroAppendLong(buf, int64(*d.(*tree.DDate))), nil
+		}
+		field.decodeFn = func(buf []byte) (tree.Datum, []byte, error) {
+			i, buf, err := avroReadLong(buf)
+			return tree.NewDDate(tree.DDate(i)), buf, err
+		}
+	case sqlbase.ColumnType_TIMESTAMP:
+		avroType = avroLogicalType{SchemaType: avroSchemaLong, LogicalType: `timestamp-micros`}
+		field.encodeFn = func(buf []byte, d tree.Datum) ([]byte, error) {
+			return avroAppendLong(buf, d.(*tree.DTimestamp).UnixNano()/1000), nil
+		}
+		field.decodeFn = func(buf []byte) (tree.Datum, []byte, error) {
+			micros, buf, err := avroReadLong(buf)
+			t := timeutil.Unix(0, micros*1000)
+			return tree.MakeDTimestamp(t, 0 /* precision */), buf, err
+		}
+	case sqlbase.ColumnType_TIMESTAMPTZ:
+		avroType = avroLogicalType{SchemaType: avroSchemaLong, LogicalType: `timestamp-micros`}
+		field.encodeFn = func(buf []byte, d tree.Datum) ([]byte, error) {
+			return avroAppendLong(buf, d.(*tree.DTimestampTZ).UnixNano()/1000), nil
+		}
+		field.decodeFn = func(buf []byte) (tree.Datum, []byte, error) {
+			micros, buf, err := avroReadLong(buf)
+			t := timeutil.Unix(0, micros*1000)
+			return tree.MakeDTimestampTZ(t, 0 /* precision */), buf, err
+		}
+	case sqlbase.ColumnType_UUID:
+		avroType = avroLogicalType{SchemaType: avroSchemaString, LogicalType: `uuid`}
+		field.encodeFn, field.decodeFn = avroStringCodec(colDesc)
+	case sqlbase.ColumnType_DECIMAL, sqlbase.ColumnType_INTERVAL, sqlbase.ColumnType_INET,
+		sqlbase.ColumnType_TIME, sqlbase.ColumnType_JSON:
+		// TODO: DECIMAL columns with a precision could use the avro
+		// decimal logical type.
+		avroType = avroSchemaString
+		field.encodeFn, field.decodeFn = avroStringCodec(colDesc)
+	default:
+		// TODO: Once there are ENUM and other user-defined types, map
+		// ENUMs to avro enums with the type's values as symbols (in order,
+		// so the encoding is the index of the value) and a `default` symbol,
+		// so that readers with an older schema can still read the values
+		// added to the type later. The schema of a table then changes with
+		// the types of its columns as well as its descriptor, so the
+		// registered schemas would need to be keyed by the type versions too.
+		return nil, errors.Errorf(`column %s: type %s not yet supported with avro`,
+			colDesc.Name, colDesc.Type.SQLString())
+	}
+	field.SchemaType = []interface{}{avroSchemaNull, avroType}
+
+	return field, nil
+}
+
+// avroStringCodec returns an encodeFn and decodeFn that represent a datum as
+// its (unquoted) string representation.
+func avroStringCodec(
+	colDesc *sqlbase.ColumnDescriptor,
+) (
+	func(buf []byte, d tree.Datum) ([]byte, error),
+	func(buf []byte) (tree.Datum, []byte, error),
+) {
+	typ := colDesc.Type.ToDatumType()
+	encodeFn := func(buf []byte, d tree.Datum) ([]byte, error) {
+		var s string
+		if j, ok := d.(*tree.DJSON); ok {
+			s = j.JSON.String()
+		} else {
+			s = tree.AsStringWithFlags(d, tree.FmtBareStrings)
+		}
+		return avroAppendBytes(buf, []byte(s)), nil
+	}
+	decodeFn := func(buf []byte) (tree.Datum, []byte, error) {
+		b, buf, err := avroReadBytes(buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		evalCtx := &tree.EvalContext{SessionData: &sessiondata.SessionData{}}
+		d, err := tree.ParseStringAs(typ, string(b), evalCtx)
+		return d, buf, err
+	}
+	return encodeFn, decodeFn
+}
+
+// indexToAvroSchema converts a column descriptor into its corresponding Avro
+// record schema. The fields are kept in the same order as columns in the index.
+func indexToAvroSchema(
+	tableDesc *sqlbase.TableDescriptor, indexDesc *sqlbase.IndexDescriptor,
+) (*avroDataRecord, error) {
+	schema := &avroDataRecord{
+		SchemaType:       avroSchemaRecord,
+		Name:             sqlNameToAvroName(tableDesc.Name),
+		colIdxByFieldIdx: make(map[int]int),
+	}
+	colIdxByID := tableDesc.ColumnIdxMap()
+	for _, colID := range indexDesc.ColumnIDs {
+		colIdx, ok := colIdxByID[colID]
+		if !ok {
+			return nil, errors.Errorf(`unknown column id: %d`, colID)
+		}
+		field, err := columnDescToAvroSchema(&tableDesc.Columns[colIdx])
+		if err != nil {
+			return nil, err
+		}
+		schema.colIdxByFieldIdx[len(schema.Fields)] = colIdx
+		schema.Fields = append(schema.Fields, field)
+	}
+	return schema, nil
+}
+
+// tableToAvroSchema converts a column descriptor into its corresponding Avro
+// record schema. The fields are kept in the same order as `tableDesc.Columns`.
+func tableToAvroSchema(tableDesc *sqlbase.TableDescriptor) (*avroDataRecord, error) {
+	schema := &avroDataRecord{
+		SchemaType:       avroSchemaRecord,
+		Name:             sqlNameToAvroName(tableDesc.Name),
+		colIdxByFieldIdx: make(map[int]int),
+	}
+	for colIdx := range tableDesc.Columns {
+		field, err := columnDescToAvroSchema(&tableDesc.Columns[colIdx])
+		if err != nil {
+			return nil, err
+		}
+		schema.colIdxByFieldIdx[len(schema.Fields)] = colIdx
+		schema.Fields = append(schema.Fields, field)
+	}
+	return schema, nil
+}
+
+// appendUpdatedField adds the `__crdb__` field holding a row's updated
+// timestamp to the end of a record. It mirrors the json format: a nested record
+// with the timestamp as a decimal string. BinaryFromRow doesn't write it, so it
+// must be appended with BinaryFromUpdated.
+func (r *avroDataRecord) appendUpdatedField() {
+	r.Fields = append(r.Fields, &avroSchemaField{
+		Name:    jsonMetaSentinel,
+		Default: avroDefaultNull,
+		SchemaType: []interface{}{avroSchemaNull, avroMetadataRecord{
+			SchemaType: avroSchemaRecord,
+			Name:       jsonMetaSentinel,
+			Fields: []*avroSchemaField{
+				{Name: `updated`, SchemaType: avroSchemaString},
+			},
+		}},
+		decodeFn: func(buf []byte) (tree.Datum, []byte, error) {
+			b, buf, err := avroReadBytes(buf)
+			return tree.NewDString(string(b)), buf, err
+		},
+	})
+}
+
+// resolvedToAvroSchema returns the schema of a resolved timestamp payload. It
+// mirrors the json format: a record with the timestamp as a decimal string.
+func resolvedToAvroSchema() *avroMetadataRecord {
+	return &avroMetadataRecord{
+		SchemaType: avroSchemaRecord,
+		Name:       jsonMetaSentinel,
+		Fields: []*avroSchemaField{
+			{Name: `resolved`, SchemaType: avroSchemaString},
+		},
+	}
+}
+
+func (r *avroDataRecord) schemaJSON() (string, error) {
+	b, err := gojson.Marshal(r)
+	return string(b), err
+}
+
+func (r *avroMetadataRecord) schemaJSON() (string, error) {
+	b, err := gojson.Marshal(r)
+	return string(b), err
+}
+
+// BinaryFromRow appends the avro binary encoding of the given row to buf. The
+// datums are expected to match 1:1 with the table's `Columns`.
+func (r *avroDataRecord) BinaryFromRow(buf []byte, row tree.Datums) ([]byte, error) {
+	for fieldIdx, field := range r.Fields {
+		colIdx, ok := r.colIdxByFieldIdx[fieldIdx]
+		if !ok {
+			continue
+		}
+		d := row[colIdx]
+		if d == tree.DNull {
+			// Null is the first branch of the union and encodes as nothing.
+			buf = avroAppendLong(buf, 0)
+			continue
+		}
+		buf = avroAppendLong(buf, 1)
+		var err error
+		if buf, err = field.encodeFn(buf, d); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// RowFromBinary decodes the avro binary encoding of a record with this schema.
+// The returned datums match 1:1 with the table's `Columns`; any columns not in
+// this record are NULL.
+func (r *avroDataRecord) RowFromBinary(buf []byte) (tree.Datums, error) {
+	numCols := 0
+	for _, colIdx := range r.colIdxByFieldIdx {
+		if colIdx >= numCols {
+			numCols = colIdx + 1
+		}
+	}
+	row := make(tree.Datums, numCols)
+	for i := range row {
+		row[i] = tree.DNull
+	}
+	for fieldIdx, field := range r.Fields {
+		branch, rest, err := avroReadLong(buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = rest
+		switch branch {
+		case 0:
+			continue
+		case 1:
+			var d tree.Datum
+			if d, buf, err = field.decodeFn(buf); err != nil {
+				return nil, err
+			}
+			if colIdx, ok := r.colIdxByFieldIdx[fieldIdx]; ok {
+				row[colIdx] = d
+			}
+		default:
+			return nil, errors.Errorf(`field %s: unknown union branch %d`, field.Name, branch)
+		}
+	}
+	if len(buf) != 0 {
+		return nil, errors.Errorf(`%d trailing bytes after avro record`, len(buf))
+	}
+	return row, nil
+}
+
+// BinaryFromUpdated appends the avro binary encoding of the `__crdb__` field
+// added by appendUpdatedField to buf.
+func (r *avroDataRecord) BinaryFromUpdated(buf []byte, updated hlc.Timestamp) []byte {
+	s := tree.TimestampToDecimal(updated).Decimal.String()
+	buf = avroAppendLong(buf, 1)
+	return avroAppendBytes(buf, []byte(s))
+}
+
+// BinaryFromResolved appends the avro binary encoding of a resolved timestamp
+// payload to buf.
+func (r *avroMetadataRecord) BinaryFromResolved(buf []byte, resolved hlc.Timestamp) []byte {
+	s := tree.TimestampToDecimal(resolved).Decimal.String()
+	return avroAppendBytes(buf, []byte(s))
+}
+
+// avroAppendLong appends the zig-zag varint encoding of an avro int or long.
+func avroAppendLong(buf []byte, i int64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], i)
+	return append(buf, b[:n]...)
+}
+
+func avroReadLong(buf []byte) (int64, []byte, error) {
+	i, n := binary.Varint(buf)
+	if n <= 0 {
+		return 0, nil, errors.New(`malformed avro long`)
+	}
+	return i, buf[n:], nil
+}
+
+// avroAppendBytes appends the encoding of an avro bytes or string.
+func avroAppendBytes(buf []byte, b []byte) []byte {
+	buf = avroAppendLong(buf, int64(len(b)))
+	return append(buf, b...)
+}
+
+func avroReadBytes(buf []byte) ([]byte, []byte, error) {
+	l, buf, err := avroReadLong(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	if l < 0 || int64(len(buf)) < l {
+		return nil, nil, errors.New(`malformed avro bytes`)
+	}
+	return buf[:l], buf[l:], nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+func parseDatum(
+	t *testing.T, evalCtx *tree.EvalContext, typ sqlbase.ColumnType, s string,
+) tree.Datum {
+	t.Helper()
+	d, err := tree.ParseStringAs(typ.ToDatumType(), s, evalCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestAvroSchema(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	tableDesc := &sqlbase.TableDescriptor{
+		Name: `foo-bar`,
+		Columns: []sqlbase.ColumnDescriptor{
+			{ID: 1, Name: `a`, Type: sqlbase.ColumnType{SemanticType: sqlbase.ColumnType_INT}},
+			{ID: 2, Name: `b c`, Type: sqlbase.ColumnType{SemanticType: sqlbase.ColumnType_STRING}},
+		},
+		PrimaryIndex: sqlbase.IndexDescriptor{ColumnIDs: []sqlbase.ColumnID{1}},
+	}
+
+	t.Run(`key`, func(t *testing.T) {
+		schema, err := indexToAvroSchema(tableDesc, &tableDesc.PrimaryIndex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := schema.schemaJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `{"type":"record","name":"foo_bar","fields":[` +
+			`{"type":["null","long"],"name":"a","default":null}]}`
+		if actual != expected {
+			t.Errorf("expected\n  %s\ngot\n  %s", expected, actual)
+		}
+	})
+	t.Run(`value`, func(t *testing.T) {
+		schema, err := tableToAvroSchema(tableDesc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		schema.appendUpdatedField()
+		actual, err := schema.schemaJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `{"type":"record","name":"foo_bar","fields":[` +
+			`{"type":["null","long"],"name":"a","default":null},` +
+			`{"type":["null","string"],"name":"b_c","default":null},` +
+			`{"type":["null",{"type":"record","name":"__crdb__","fields":[` +
+			`{"type":"string","name":"updated"}]}],"name":"__crdb__","default":null}]}`
+		if actual != expected {
+			t.Errorf("expected\n  %s\ngot\n  %s", expected, actual)
+		}
+	})
+	t.Run(`unsupported`, func(t *testing.T) {
+		arrayDesc := &sqlbase.TableDescriptor{
+			Name: `arr`,
+			Columns: []sqlbase.ColumnDescriptor{{ID: 1, Name: `a`, Type: sqlbase.ColumnType{
+				SemanticType: sqlbase.ColumnType_ARRAY,
+			}}},
+		}
+		if _, err := tableToAvroSchema(arrayDesc); err == nil {
+			t.Fatal(`expected an error for an unsupported type`)
+		}
+	})
+}
+
+func TestAvroRoundtrip(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ts := timeutil.Unix(0, 1234567891000).UTC()
+	tests := []struct {
+		typ   sqlbase.ColumnType_SemanticType
+		datum tree.Datum
+	}{
+		{sqlbase.ColumnType_BOOL, tree.DBoolTrue},
+		{sqlbase.ColumnType_INT, tree.NewDInt(-12345)},
+		{sqlbase.ColumnType_FLOAT, tree.NewDFloat(1.5)},
+		{sqlbase.ColumnType_STRING, tree.NewDString(`☃`)},
+		{sqlbase.ColumnType_BYTES, tree.NewDBytes("\x00\x01")},
+		{sqlbase.ColumnType_DATE, tree.NewDDate(17000)},
+		{sqlbase.ColumnType_TIMESTAMP, tree.MakeDTimestamp(ts, time.Microsecond)},
+		{sqlbase.ColumnType_TIMESTAMPTZ, tree.MakeDTimestampTZ(ts, time.Microsecond)},
+		{sqlbase.ColumnType_DECIMAL, nil},
+		{sqlbase.ColumnType_UUID, nil},
+		{sqlbase.ColumnType_JSON, nil},
+		{sqlbase.ColumnType_INTERVAL, nil},
+		{sqlbase.ColumnType_INET, nil},
+		{sqlbase.ColumnType_TIME, nil},
+	}
+	strs := map[sqlbase.ColumnType_SemanticType]string{
+		sqlbase.ColumnType_DECIMAL:  `1.2345`,
+		sqlbase.ColumnType_UUID:     `63616665-6630-3064-6465-616462656562`,
+		sqlbase.ColumnType_JSON:     `{"a": [1, "b"]}`,
+		sqlbase.ColumnType_INTERVAL: `1h2m3s`,
+		sqlbase.ColumnType_INET:     `192.168.0.1`,
+		sqlbase.ColumnType_TIME:     `12:34:56.789`,
+	}
+
+	evalCtx := tree.NewTestingEvalContext(cluster.MakeTestingClusterSettings())
+	defer evalCtx.Stop(context.Background())
+	for _, test := range tests {
+		colType := sqlbase.ColumnType{SemanticType: test.typ}
+		t.Run(colType.SQLString(), func(t *testing.T) {
+			tableDesc := &sqlbase.TableDescriptor{
+				Name: `t`,
+				Columns: []sqlbase.ColumnDescriptor{
+					{ID: 1, Name: `a`, Type: colType},
+					{ID: 2, Name: `b`, Type: colType},
+				},
+			}
+			schema, err := tableToAvroSchema(tableDesc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := test.datum
+			if d == nil {
+				d = parseDatum(t, evalCtx, colType, strs[test.typ])
+			}
+			row := tree.Datums{d, tree.DNull}
+
+			buf, err := schema.BinaryFromRow(nil, row)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := schema.RowFromBinary(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(decoded) != len(row) {
+				t.Fatalf(`expected %d datums got %d`, len(row), len(decoded))
+			}
+			for i := range row {
+				if row[i].Compare(evalCtx, decoded[i]) != 0 {
+					t.Errorf(`expected %s got %s`, row[i], decoded[i])
+				}
+			}
+		})
+	}
+}
@@ -10,17 +10,22 @@ package changefeedccl
 
 import (
 	"context"
+	"fmt"
+	"net/url"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/ccl/utilccl"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/testutils/serverutils"
 	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/cockroach/pkg/workload/bank"
 )
@@ -74,3 +79,44 @@ func BenchmarkChangefeed(b *testing.B) {
 		}
 	}
 }
+
+// BenchmarkKafkaSinkPipelining emits batches of rows to a kafka sink with a
+// millisecond of latency to the brokers, either waiting for every batch to be
+// acknowledged, like EmitRows, or handing every batch to the sink and waiting
+// once at the end, like a changefeed does between resolved timestamps.
+func BenchmarkKafkaSinkPipelining(b *testing.B) {
+	const numBatches, batchSize = 10, 100
+	rows := make([]SinkRow, batchSize)
+	for i := range rows {
+		rows[i] = SinkRow{Topic: `t`, Key: []byte(strconv.Itoa(i)), Value: []byte(`{}`)}
+	}
+	for _, async := range []bool{false, true} {
+		b.Run(fmt.Sprintf(`async=%t`, async), func(b *testing.B) {
+			ctx := context.Background()
+			producer := makeFakeAsyncProducer(4 /* numPartitions */, time.Millisecond /* latency */)
+			st := cluster.MakeTestingClusterSettings()
+			sink := makeKafkaSink(st, nil /* client */, producer, url.Values{})
+			defer func() { _ = sink.Close() }()
+			metrics := MakeMetrics(st, metric.TestSampleInterval).(*Metrics)
+			emitter := makeAsyncEmitter(sink, metrics)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < numBatches; j++ {
+					var err error
+					if async {
+						err = emitter.emit(ctx, rows)
+					} else {
+						err = sink.EmitRows(ctx, rows)
+					}
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := emitter.flush(ctx); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -8,36 +8,138 @@
 
 package changefeedccl
 
-import "github.com/cockroachdb/cockroach/pkg/util/syncutil"
+import (
+	"context"
 
-// TODO(dan): Monitor memory usage and spill to disk when necessary.
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/util/mon"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+)
+
+var perChangefeedMemLimit = settings.RegisterByteSizeSetting(
+	"changefeed.memory.per_changefeed_limit",
+	"memory that each changefeed may buffer the changes it has read but not yet emitted in, "+
+		"after which it stops reading more until they've been emitted",
+	64<<20, // 64 MiB
+)
+
+// changefeedBuffer holds the changes read by a changefeed until they're
+// emitted. Its memory is accounted for in a monitor of the changefeed's own,
+// under the root SQL monitor, which is limited by the
+// changefeed.memory.per_changefeed_limit setting. Once the buffer is full,
+// the changefeed stops reading changes until the ones in the buffer have been
+// emitted, so that a slow sink backs up into the reads instead of into the
+// memory of the node.
+//
+// TODO: Spill to disk instead of stopping the reads.
 type changefeedBuffer struct {
 	syncutil.Mutex
-	buf []changedKVs
+	buf []bufferEntry
 	idx int
+
+	mon     *mon.BytesMonitor
+	acc     mon.BoundAccount
+	metrics *Metrics
+	// full is set once an append couldn't be accounted for, and cleared once
+	// the buffer is empty.
+	full bool
+}
+
+type bufferEntry struct {
+	kvs changedKVs
+	// accounted is the memory accounted for kvs.
+	accounted int64
 }
 
-// append adds new kvs to the buffer.
-func (b *changefeedBuffer) append(kvs changedKVs) {
+// makeChangefeedBuffer returns a buffer whose memory is accounted for under
+// the root SQL monitor of the node. It must be closed.
+func makeChangefeedBuffer(
+	ctx context.Context, execCfg *sql.ExecutorConfig, metrics *Metrics,
+) *changefeedBuffer {
+	b := &changefeedBuffer{metrics: metrics}
+	if execCfg.DistSQLSrv == nil || execCfg.DistSQLSrv.ParentMemoryMonitor == nil {
+		return b
+	}
+	parent := execCfg.DistSQLSrv.ParentMemoryMonitor
+	limit := perChangefeedMemLimit.Get(&execCfg.Settings.SV)
+	m := mon.MakeMonitorInheritWithLimit(`changefeed`, limit, parent)
+	b.mon = &m
+	b.mon.Start(ctx, parent, mon.BoundAccount{})
+	b.acc = b.mon.MakeBoundAccount()
+	return b
+}
+
+// makeBoundAccount returns an account in the buffer's monitor, for other
+// memory that the changefeed holds on to, or nil if the buffer's memory isn't
+// accounted for. The account must be closed before the buffer is.
+func (b *changefeedBuffer) makeBoundAccount() *mon.BoundAccount {
+	if b.mon == nil {
+		return nil
+	}
+	acc := b.mon.MakeBoundAccount()
+	return &acc
+}
+
+// close releases the memory of the buffer.
+func (b *changefeedBuffer) close(ctx context.Context) {
+	if b.mon == nil {
+		return
+	}
+	b.Lock()
+	b.acc.Close(ctx)
+	b.Unlock()
+	b.mon.Stop(ctx)
+}
+
+// append adds new kvs to the buffer. The kvs have already been read, so
+// they're added even if there's no memory left for them, but then the buffer
+// is full until it's been emptied.
+func (b *changefeedBuffer) append(ctx context.Context, kvs changedKVs) {
 	b.Lock()
 	if b.idx >= len(b.buf) {
 		// Attempt to minimize allocations by reusing the buffer.
 		b.buf = b.buf[:0]
 		b.idx = 0
 	}
-	b.buf = append(b.buf, kvs)
+	e := bufferEntry{kvs: kvs}
+	if size := int64(len(kvs.sst)); size > 0 && b.mon != nil {
+		if err := b.acc.Grow(ctx, size); err != nil {
+			b.full = true
+		} else {
+			e.accounted = size
+		}
+	}
+	b.buf = append(b.buf, e)
 	b.Unlock()
+	b.metrics.recordBufferEntry()
 }
 
 // get returns the next kvs or false if the buffer is empty.
-func (b *changefeedBuffer) get() (changedKVs, bool) {
+func (b *changefeedBuffer) get(ctx context.Context) (changedKVs, bool) {
 	var ret changedKVs
 	var ok bool
 	b.Lock()
 	if b.idx < len(b.buf) {
-		ret, ok = b.buf[b.idx], true
+		e := b.buf[b.idx]
+		ret, ok = e.kvs, true
+		b.buf[b.idx] = bufferEntry{}
 		b.idx++
+		if e.accounted > 0 {
+			b.acc.Shrink(ctx, e.accounted)
+		}
+		if b.idx >= len(b.buf) {
+			b.full = false
+		}
 	}
 	b.Unlock()
 	return ret, ok
 }
+
+// isFull returns whether the buffer has run out of memory. No more changes
+// should be read until it's been emptied.
+func (b *changefeedBuffer) isFull() bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.full
+}
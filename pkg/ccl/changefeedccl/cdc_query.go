@@ -0,0 +1,273 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+	"github.com/pkg/errors"
+)
+
+// cdcQuery is the projection and filter of a changefeed over a single table,
+// from the `columns` and `filter` options. With them, a changefeed only emits
+// the changes to the rows that match the filter, with only the projected
+// columns, which saves running a stream processor downstream to do the same.
+//
+// The projection is a comma-separated list of column names. The primary key
+// columns are always emitted, since the key of every message is made of them.
+// A projected column that's dropped from the table is no longer emitted.
+//
+// The filter is a scalar SQL expression over the columns of the table, like
+// `b != 'x'`. It's evaluated on the new value of every changed row, so an
+// update that makes a row stop matching isn't emitted. Deletions are always
+// emitted, since the columns of a deleted row are unknown. Aggregates,
+// subqueries and impure functions are rejected. If a schema change drops a
+// column the filter needs, the changefeed fails.
+//
+// TODO: Support the `CREATE CHANGEFEED AS SELECT ... FROM ... WHERE ...`
+// syntax, which needs a new production in the grammar.
+type cdcQuery struct {
+	// columns are the names of the projected columns, or nil to emit every
+	// column.
+	columns map[string]struct{}
+	// filter is the parsed filter, or nil. It's bound to the columns of every
+	// version of the table that it's evaluated on, see rowFilter.
+	filter tree.Expr
+
+	// filters are the bound filters of every table descriptor seen.
+	filters map[*sqlbase.TableDescriptor]*rowFilter
+	// descs are the projected descriptors of every table descriptor seen, and
+	// colIdxs are the indexes, in the columns of the table, of the columns of
+	// every projected descriptor.
+	descs   map[*sqlbase.TableDescriptor]*sqlbase.TableDescriptor
+	colIdxs map[*sqlbase.TableDescriptor][]int
+}
+
+// makeCDCQuery returns the query of a changefeed with the given options, or
+// nil if it has no `columns` or `filter` option.
+func makeCDCQuery(opts map[string]string) (*cdcQuery, error) {
+	columns, hasColumns := opts[optColumns]
+	filter, hasFilter := opts[optFilter]
+	if !hasColumns && !hasFilter {
+		return nil, nil
+	}
+	q := &cdcQuery{
+		filters: make(map[*sqlbase.TableDescriptor]*rowFilter),
+		descs:   make(map[*sqlbase.TableDescriptor]*sqlbase.TableDescriptor),
+		colIdxs: make(map[*sqlbase.TableDescriptor][]int),
+	}
+	if hasColumns {
+		q.columns = make(map[string]struct{})
+		for _, column := range strings.Split(columns, `,`) {
+			column = strings.TrimSpace(column)
+			if column == `` {
+				return nil, errors.Errorf(`invalid %s: expected a column name in: %s`, optColumns, columns)
+			}
+			if _, ok := q.columns[column]; ok {
+				return nil, errors.Errorf(`invalid %s: column %s is listed more than once`,
+					optColumns, column)
+			}
+			q.columns[column] = struct{}{}
+		}
+	}
+	if hasFilter {
+		var err error
+		if q.filter, err = parser.ParseExpr(filter); err != nil {
+			return nil, errors.Wrapf(err, `invalid %s`, optFilter)
+		}
+	}
+	return q, nil
+}
+
+// validate checks that the projected columns are columns of the table and
+// that the filter is a boolean expression over its columns.
+func (q *cdcQuery) validate(tableDesc *sqlbase.TableDescriptor) error {
+	for column := range q.columns {
+		found := false
+		for _, col := range tableDesc.Columns {
+			if col.Name == column {
+				found = true
+			}
+		}
+		if !found {
+			return errors.Errorf(`invalid %s: column %s does not exist`, optColumns, column)
+		}
+	}
+	if q.filter != nil {
+		if _, err := makeRowFilter(q.filter, tableDesc); err != nil {
+			return errors.Wrapf(err, `invalid %s`, optFilter)
+		}
+	}
+	return nil
+}
+
+// matches returns whether a changed row, with the columns of tableDesc,
+// should be emitted.
+func (q *cdcQuery) matches(
+	tableDesc *sqlbase.TableDescriptor, datums tree.Datums, deleted bool,
+) (bool, error) {
+	if q.filter == nil || deleted {
+		return true, nil
+	}
+	f, ok := q.filters[tableDesc]
+	if !ok {
+		var err error
+		if f, err = makeRowFilter(q.filter, tableDesc); err != nil {
+			return false, errors.Wrapf(err, `evaluating %s on version %d of %s`,
+				optFilter, tableDesc.Version, tableDesc.Name)
+		}
+		q.filters[tableDesc] = f
+	}
+	return f.eval(datums)
+}
+
+// projectedDesc returns a descriptor for the projected rows of a table, with
+// only the primary key columns and the projected columns.
+func (q *cdcQuery) projectedDesc(tableDesc *sqlbase.TableDescriptor) *sqlbase.TableDescriptor {
+	if q.columns == nil {
+		return tableDesc
+	}
+	if desc, ok := q.descs[tableDesc]; ok {
+		return desc
+	}
+	include := make(map[sqlbase.ColumnID]struct{})
+	for _, colID := range tableDesc.PrimaryIndex.ColumnIDs {
+		include[colID] = struct{}{}
+	}
+	desc := *tableDesc
+	desc.Columns = nil
+	var colIdxs []int
+	for i, col := range tableDesc.Columns {
+		_, projected := q.columns[col.Name]
+		if _, ok := include[col.ID]; ok || projected {
+			desc.Columns = append(desc.Columns, col)
+			colIdxs = append(colIdxs, i)
+		}
+	}
+	q.descs[tableDesc] = &desc
+	q.colIdxs[&desc] = colIdxs
+	return &desc
+}
+
+// project returns the datums of the columns of projectedDesc, as returned by
+// projectedDesc, from the datums of a whole row.
+func (q *cdcQuery) project(projectedDesc *sqlbase.TableDescriptor, datums tree.Datums) tree.Datums {
+	colIdxs, ok := q.colIdxs[projectedDesc]
+	if !ok {
+		return datums
+	}
+	projected := make(tree.Datums, len(colIdxs))
+	for i, colIdx := range colIdxs {
+		projected[i] = datums[colIdx]
+	}
+	return projected
+}
+
+// rowFilter is a filter bound to the columns of one version of a table. It's
+// the IndexedVarContainer of the filter's column references, which are
+// evaluated against the row being filtered.
+type rowFilter struct {
+	expr    tree.TypedExpr
+	evalCtx *tree.EvalContext
+
+	types []types.T
+	names []tree.Name
+	row   tree.Datums
+}
+
+var _ tree.IndexedVarContainer = &rowFilter{}
+
+func makeRowFilter(filter tree.Expr, tableDesc *sqlbase.TableDescriptor) (*rowFilter, error) {
+	f := &rowFilter{
+		evalCtx: &tree.EvalContext{SessionData: &sessiondata.SessionData{}},
+		types:   make([]types.T, len(tableDesc.Columns)),
+		names:   make([]tree.Name, len(tableDesc.Columns)),
+	}
+	for i, col := range tableDesc.Columns {
+		f.types[i] = col.Type.ToDatumType()
+		f.names[i] = tree.Name(col.Name)
+	}
+
+	h := tree.MakeIndexedVarHelper(f, len(tableDesc.Columns))
+	b := columnBinder{tableDesc: tableDesc, h: &h}
+	expr, _ := tree.WalkExpr(&b, filter)
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	semaCtx := tree.MakeSemaContext(false /* privileged */)
+	semaCtx.IVarContainer = f
+	semaCtx.Properties.Require(`changefeed filter`,
+		tree.RejectSpecial|tree.RejectImpureFunctions|tree.RejectSubqueries)
+	var err error
+	if f.expr, err = tree.TypeCheck(expr, &semaCtx, types.Bool); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// eval returns whether a row, with the columns of the filter's table, matches
+// the filter.
+func (f *rowFilter) eval(datums tree.Datums) (bool, error) {
+	f.row = datums
+	f.evalCtx.PushIVarContainer(f)
+	defer f.evalCtx.PopIVarContainer()
+	return sqlbase.RunFilter(f.expr, f.evalCtx)
+}
+
+// IndexedVarEval is part of the tree.IndexedVarContainer interface.
+func (f *rowFilter) IndexedVarEval(idx int, ctx *tree.EvalContext) (tree.Datum, error) {
+	return f.row[idx].Eval(ctx)
+}
+
+// IndexedVarResolvedType is part of the tree.IndexedVarContainer interface.
+func (f *rowFilter) IndexedVarResolvedType(idx int) types.T {
+	return f.types[idx]
+}
+
+// IndexedVarNodeFormatter is part of the tree.IndexedVarContainer interface.
+func (f *rowFilter) IndexedVarNodeFormatter(idx int) tree.NodeFormatter {
+	return &f.names[idx]
+}
+
+// columnBinder is a tree.Visitor that replaces the column names of a filter
+// with IndexedVars of the columns of a table.
+type columnBinder struct {
+	tableDesc *sqlbase.TableDescriptor
+	h         *tree.IndexedVarHelper
+	err       error
+}
+
+func (v *columnBinder) VisitPre(expr tree.Expr) (recurse bool, newExpr tree.Expr) {
+	if v.err != nil {
+		return false, expr
+	}
+	name, ok := expr.(*tree.UnresolvedName)
+	if !ok {
+		return true, expr
+	}
+	if name.Star || name.NumParts != 1 {
+		v.err = errors.Errorf(`unsupported column reference: %s`, name)
+		return false, expr
+	}
+	for i, col := range v.tableDesc.Columns {
+		if col.Name == name.Parts[0] {
+			return false, v.h.IndexedVar(i)
+		}
+	}
+	v.err = errors.Errorf(`column %s does not exist`, name.Parts[0])
+	return false, expr
+}
+
+func (*columnBinder) VisitPost(expr tree.Expr) tree.Expr { return expr }
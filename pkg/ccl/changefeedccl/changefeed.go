@@ -9,10 +9,9 @@
 package changefeedccl
 
 import (
-	"bytes"
 	"context"
-	gojson "encoding/json"
 	"net/url"
+	"sort"
 	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/ccl/storageccl/engineccl"
@@ -26,9 +25,11 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
 	"github.com/cockroachdb/cockroach/pkg/storage/engine"
 	"github.com/cockroachdb/cockroach/pkg/util/bufalloc"
+	"github.com/cockroachdb/cockroach/pkg/util/ctxgroup"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
-	"github.com/cockroachdb/cockroach/pkg/util/json"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/mon"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/pkg/errors"
 )
@@ -39,6 +40,14 @@ var changefeedPollInterval = settings.RegisterDurationSetting(
 	1*time.Second,
 )
 
+// changefeedCancelCheckInterval is how often a changefeed checks in with its
+// job in the middle of a poll. See makeCancelCheck.
+var changefeedCancelCheckInterval = settings.RegisterNonNegativeDurationSetting(
+	"changefeed.cancel_check_interval",
+	"how often a changefeed in the middle of a scan checks whether its job was paused or canceled",
+	10*time.Second,
+)
+
 func init() {
 	changefeedPollInterval.Hide()
 }
@@ -47,6 +56,17 @@ const (
 	jsonMetaSentinel = `__crdb__`
 )
 
+// The pausepoints of a changefeed, which tests can pause it at by listing them
+// in jobs.debug.pausepoints, see jobs.Registry.CheckPausepoint.
+const (
+	// pausepointBeforeFlush is right before a batch of rows is emitted to the
+	// sink.
+	pausepointBeforeFlush = `changefeed.before_flush`
+	// pausepointBeforeCheckpoint is right before the high-water mark of the
+	// job is updated to a resolved timestamp.
+	pausepointBeforeCheckpoint = `changefeed.before_checkpoint`
+)
+
 type changedKVs struct {
 	// sst, if non-nil, is an sstable with mvcc key values as returned by
 	// ExportRequest.
@@ -54,6 +74,12 @@ type changedKVs struct {
 	// resolved, if non-zero, is a guarantee that all key values in subsequent
 	// changedKVs will have an equal or higher timestamp.
 	resolved hlc.Timestamp
+	// initialScan is true if sst holds the initial scan of the changefeed,
+	// which has no previous values.
+	initialScan bool
+	// spanDone, if non-nil, is a span that every change up to its timestamp
+	// has been returned for, see span_checkpoint.go.
+	spanDone *timestampedSpan
 }
 
 type emitRow struct {
@@ -68,23 +94,76 @@ type emitRow struct {
 	// tableDesc is a TableDescriptor for the table containing `row`. It's valid
 	// for interpreting the row at `rowTimestamp`.
 	tableDesc *sqlbase.TableDescriptor
+	// prevRow, if non-nil, is the value of the row just before `rowTimestamp`
+	// and prevTableDesc the TableDescriptor valid for interpreting it. It's
+	// only looked up with the `diff` option, and nil if the row didn't exist.
+	prevRow       tree.Datums
+	prevTableDesc *sqlbase.TableDescriptor
+	// family is set with the `split_column_families` option to the column
+	// family of tableDesc that changed. Only it's emitted.
+	family *sqlbase.ColumnFamilyDescriptor
 	// resolved, if non-zero, is a guarantee that all key values in subsequent
 	// changedKVs will have an equal or higher timestamp.
 	resolved hlc.Timestamp
+	// spanDone, if non-nil, is a span that every row up to its timestamp has
+	// been returned for.
+	spanDone *timestampedSpan
 }
 
+// errInitialScanOnlyDone is returned by the changed kvs of a changefeed with
+// `initial_scan='only'` once its initial scan has been emitted, and by those
+// of a scheduled changefeed once its run has been, see
+// scheduled_changefeed.go.
+var errInitialScanOnlyDone = errors.New(`initial scan done`)
+
+// runChangefeedFlow runs a changefeed until it fails or ctx is canceled. jobID
+// is the ID of the feed's job, or 0 for a sinkless feed, which has no job.
+//
+// A changefeed with `initial_scan='only'` returns without error once it has
+// emitted its initial scan, so that its job succeeds. That makes a changefeed
+// a one-shot export of the watched tables. A changefeed with
+// `schema_registry_outage='pause'` pauses its job instead of failing when the
+// schema registry is unavailable, and one with `schema_change_policy='pause'`
+// pauses it at a schema change event. A changefeed with `on_error='pause'`
+// pauses its job on any error that would otherwise fail it once it's running,
+// like a sink that keeps rejecting messages, so that its high-water mark is
+// kept and it can be resumed with RESUME JOB once the sink is fixed.
 func runChangefeedFlow(
 	ctx context.Context,
 	execCfg *sql.ExecutorConfig,
+	jobID int64,
 	details jobspb.ChangefeedDetails,
 	progress jobspb.ChangefeedProgress,
 	resultsCh chan<- tree.Datums,
 	progressedFn func(context.Context, jobs.ProgressedFn) error,
+	status *changefeedStatus,
 ) error {
 	details, err := validateChangefeed(details)
 	if err != nil {
 		return err
 	}
+	// External connections are resolved here instead of when the changefeed is
+	// created so that the underlying URI (and any credentials in it) never end
+	// up in the job record.
+	details.SinkURI, err = resolveSinkURI(ctx, execCfg, details.SinkURI)
+	if err != nil {
+		return err
+	}
+	// SHOW JOBS shows the scheme of the resolved sink, see ChangefeedProgress.
+	if sinkURI, err := url.Parse(details.SinkURI); err != nil {
+		return err
+	} else if progressedFn != nil && sinkURI.Scheme != progress.SinkScheme {
+		if err := progressedFn(ctx, func(ctx context.Context, details jobspb.ProgressDetails) float32 {
+			details.(*jobspb.Progress_Changefeed).Changefeed.SinkScheme = sinkURI.Scheme
+			return 0.0
+		}); err != nil {
+			return err
+		}
+	}
+
+	metrics := getMetrics(execCfg).withLabel(details.Opts[optMetricsLabel])
+	metrics.Running.Inc(1)
+	defer metrics.Running.Dec(1)
 
 	jobProgressedFn := func(ctx context.Context, highwater hlc.Timestamp) error {
 		// Some benchmarks want to skip the job progress update for a bit more
@@ -92,21 +171,136 @@ func runChangefeedFlow(
 		if progressedFn == nil {
 			return nil
 		}
-		return progressedFn(ctx, func(ctx context.Context, details jobspb.ProgressDetails) float32 {
+		if err := progressedFn(ctx, func(ctx context.Context, details jobspb.ProgressDetails) float32 {
 			cfDetails := details.(*jobspb.Progress_Changefeed).Changefeed
 			cfDetails.Highwater = highwater
 			// TODO(dan): Having this stuck at 0% forever is bad UX. Revisit.
 			return 0.0
-		})
+		}); err != nil {
+			return err
+		}
+		status.checkpointed(highwater)
+		// The changefeed won't read anything from before its new high-water
+		// mark again.
+		return protectChangefeedData(ctx, execCfg, jobID, details, highwater)
 	}
 
+	spanCheckpointFn := func(ctx context.Context, spans []timestampedSpan) error {
+		if progressedFn == nil {
+			return nil
+		}
+		checkpoint := makeSpanCheckpoint(spans)
+		if err := progressedFn(ctx, func(ctx context.Context, details jobspb.ProgressDetails) float32 {
+			details.(*jobspb.Progress_Changefeed).Changefeed.SpanCheckpoint = checkpoint
+			return 0.0
+		}); err != nil {
+			return err
+		}
+		status.spansCheckpointed(spans)
+		return nil
+	}
+
+	lagAlerter, err := makeLagAlerter(execCfg, metrics, jobID, details.Opts)
+	if err != nil {
+		return err
+	}
+	if lagAlerter != nil {
+		defer lagAlerter.close()
+	}
+
+	// A changefeed over whole databases watches the tables in them as of its
+	// high-water mark, and restarts whenever they change.
+	watch := watchedDatabases(details)
+	for {
+		if watch != nil && progress.Highwater != (hlc.Timestamp{}) {
+			details.TableDescs, err = tablesInDatabases(ctx, execCfg.DB, progress.Highwater, watch)
+			if err != nil {
+				return err
+			}
+		}
+		if progressedFn != nil {
+			// Protect the data from where the changefeed starts, which for a
+			// changefeed with an initial scan is now.
+			protectTS := progress.Highwater
+			if protectTS == (hlc.Timestamp{}) {
+				protectTS = execCfg.Clock.Now()
+				// An initial scan that's restarted goes on at the timestamps
+				// of its checkpoint.
+				for _, s := range progress.SpanCheckpoint {
+					if s.Timestamp.Less(protectTS) {
+						protectTS = s.Timestamp
+					}
+				}
+			}
+			if err := protectChangefeedData(ctx, execCfg, jobID, details, protectTS); err != nil {
+				return err
+			}
+		}
+		err := runChangefeedFlowForTargets(
+			ctx, execCfg, jobID, details, progress, watch, metrics, jobProgressedFn,
+			spanCheckpointFn, lagAlerter, resultsCh, progressedFn, status)
+		if e, ok := errors.Cause(err).(*targetsChangedError); ok {
+			restarted := makeChangefeedLogEvent(changefeedLogEventSchemaChange, jobID, details)
+			restarted.Timestamp = e.ts.AsOfSystemTime()
+			restarted.Decision = `restart`
+			restarted.log(ctx)
+			progress.Highwater = e.ts
+			// Only the first setup of a sink signals CREATE CHANGEFEED, see
+			// getSink.
+			if details.SinkURI != `` {
+				resultsCh = make(chan tree.Datums, 1)
+			}
+			continue
+		}
+		return err
+	}
+}
+
+// runChangefeedFlowForTargets runs a changefeed over a fixed set of tables,
+// see runChangefeedFlow.
+func runChangefeedFlowForTargets(
+	ctx context.Context,
+	execCfg *sql.ExecutorConfig,
+	jobID int64,
+	details jobspb.ChangefeedDetails,
+	progress jobspb.ChangefeedProgress,
+	watch *databaseWatch,
+	metrics *Metrics,
+	jobProgressedFn func(context.Context, hlc.Timestamp) error,
+	spanCheckpointFn func(context.Context, []timestampedSpan) error,
+	lagAlerter *lagAlerter,
+	resultsCh chan<- tree.Datums,
+	progressedFn func(context.Context, jobs.ProgressedFn) error,
+	status *changefeedStatus,
+) error {
 	// The changefeed flow is intentionally structured as a pull model so it's
 	// easy to later make it into a DistSQL processor.
 	//
 	// TODO(dan): Make this into a DistSQL flow.
-	changedKVsFn := exportRequestPoll(execCfg, details, progress)
-	rowsFn := kvsToRows(execCfg, details, changedKVsFn)
-	emitRowsFn, closeFn, err := emitRows(details, jobProgressedFn, rowsFn, resultsCh)
+	cancelCheckFn := makeCancelCheck(execCfg, progressedFn)
+	pausepointFn := func(ctx context.Context, name string) error {
+		if jobID == 0 {
+			return nil
+		}
+		return execCfg.JobRegistry.CheckPausepoint(ctx, jobID, name)
+	}
+	buffer := makeChangefeedBuffer(ctx, execCfg, metrics)
+	defer buffer.close(ctx)
+	encoderAcc := buffer.makeBoundAccount()
+	if encoderAcc != nil {
+		defer encoderAcc.Close(ctx)
+	}
+	changedKVsFn := exportRequestPoll(
+		execCfg, details, progress, watch, metrics, cancelCheckFn, buffer)
+	limiter, err := makeBackfillLimiter(metrics, details.Opts)
+	if err != nil {
+		return err
+	}
+	rowsFn := kvsToRows(execCfg, details, limiter, changedKVsFn)
+	markers := makeMarkerPoller(execCfg, jobID, progress.Highwater)
+	emitRowsFn, closeFn, err := emitRows(
+		ctx, execCfg, details, metrics, jobProgressedFn, spanCheckpointFn, cancelCheckFn,
+		pausepointFn, lagAlerter, markers, rowsFn, resultsCh, status, encoderAcc)
 	if err != nil {
 		return err
 	}
@@ -116,13 +310,151 @@ func runChangefeedFlow(
 		}
 	}()
 
+	pauseOnRegistryOutage := schemaRegistryOutageType(details.Opts[optSchemaRegistryOutage]) ==
+		optSchemaRegistryOutagePause
+	pauseOnSchemaChange := schemaChangePolicyType(details.Opts[optSchemaChangePolicy]) ==
+		optSchemaChangePolicyPause
+	pauseOnError := onErrorType(details.Opts[optOnError]) == optOnErrorPause
 	for {
 		if err := emitRowsFn(ctx); err != nil {
+			if errors.Cause(err) == errInitialScanOnlyDone {
+				return nil
+			}
+			schemaChangeErr, schemaChange := errors.Cause(err).(*schemaChangeEventError)
+			// The job being paused or canceled, ctx being canceled, the
+			// tables of the watched databases changing and stopping at a
+			// schema change event aren't failures of the changefeed, and
+			// neither are transient errors, which the job retries, see
+			// runChangefeedFlowWithRetry.
+			_, jobStatusChanged := errors.Cause(err).(*jobs.InvalidStatusError)
+			_, targetsChanged := errors.Cause(err).(*targetsChangedError)
+			failed := !jobStatusChanged && !targetsChanged && !schemaChange && ctx.Err() == nil &&
+				!isRetryableChangefeedError(err)
+			pause := (pauseOnRegistryOutage && isSchemaRegistryUnavailableError(err)) ||
+				(pauseOnSchemaChange && schemaChange) || (pauseOnError && failed)
+			pause = pause && progressedFn != nil
+			if schemaChange {
+				decided := makeChangefeedLogEvent(changefeedLogEventSchemaChange, jobID, details)
+				decided.Table = schemaChangeErr.tableName
+				decided.Timestamp = schemaChangeErr.ts.AsOfSystemTime()
+				decided.Decision = `stop`
+				if pause {
+					decided.Decision = `pause`
+				}
+				decided.Error = err.Error()
+				decided.log(ctx)
+			} else if pause {
+				log.Warningf(ctx, `pausing changefeed: %s`, err)
+			}
+			if pause {
+				// The error is kept as the reason the job was paused.
+				if err := pauseChangefeed(ctx, execCfg, jobID, err.Error()); err != nil {
+					return err
+				}
+				return progressedFn(ctx, func(context.Context, jobspb.ProgressDetails) float32 {
+					// Leave the highwater as it is.
+					return 0.0
+				})
+			}
 			return err
 		}
 	}
 }
 
+// makeCancelCheck returns a closure that checks whether the job of a changefeed
+// has been paused or canceled, and returns an error if so.
+//
+// A job only notices that it was paused or canceled the next time it updates
+// its progress, which a changefeed does with every resolved timestamp. That's
+// at the end of a poll, which for a large initial scan can be a very long
+// time, so the closure is called between the requests of a poll and between
+// sink flushes as well. It checks by updating the job's progress without
+// changing it, which fails if the job isn't running anymore. To keep this
+// cheap, it only does so once per `changefeed.cancel_check_interval`.
+func makeCancelCheck(
+	execCfg *sql.ExecutorConfig, progressedFn func(context.Context, jobs.ProgressedFn) error,
+) func(context.Context) error {
+	var lastCheck time.Time
+	return func(ctx context.Context) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Sinkless changefeeds don't have a job, they're canceled by closing
+		// their connection, which cancels ctx.
+		if progressedFn == nil {
+			return nil
+		}
+		if timeutil.Since(lastCheck) < changefeedCancelCheckInterval.Get(&execCfg.Settings.SV) {
+			return nil
+		}
+		lastCheck = timeutil.Now()
+		return progressedFn(ctx, func(context.Context, jobspb.ProgressDetails) float32 {
+			// Leave the highwater as it is.
+			return 0.0
+		})
+	}
+}
+
+// changefeedConcurrentScanRequests is how many spans a changefeed reads at
+// once, see exportSpans.
+var changefeedConcurrentScanRequests = settings.RegisterIntSetting(
+	"changefeed.backfill.concurrent_scan_requests",
+	"number of ExportRequests that each changefeed on a node sends at once during its "+
+		"initial scan and catch-up scans, at least 1",
+	1,
+)
+
+func concurrentScanRequests(execCfg *sql.ExecutorConfig) int {
+	if n := int(changefeedConcurrentScanRequests.Get(&execCfg.Settings.SV)); n > 1 {
+		return n
+	}
+	return 1
+}
+
+// exportSpan is a span to read the changes to between two timestamps.
+type exportSpan struct {
+	span       roachpb.Span
+	start, end hlc.Timestamp
+}
+
+// exportSpans reads the changes to every one of the spans with an
+// ExportRequest each, all of them sent at once, and returns the files read for
+// each span. Callers pass up to changefeed.backfill.concurrent_scan_requests
+// spans at a time, which trades how fast a changefeed backfills for how much
+// load it puts on the cluster while doing so. The files are appended to the
+// buffer in the order of the spans once they've all been read, so the order
+// of the changes a changefeed emits doesn't depend on the setting.
+func exportSpans(
+	ctx context.Context, execCfg *sql.ExecutorConfig, reqs []exportSpan,
+) ([][]roachpb.ExportResponse_File, error) {
+	sender := execCfg.DB.NonTransactionalSender()
+	files := make([][]roachpb.ExportResponse_File, len(reqs))
+	g := ctxgroup.WithContext(ctx)
+	for i := range reqs {
+		i := i
+		g.GoCtx(func(ctx context.Context) error {
+			header := roachpb.Header{Timestamp: reqs[i].end}
+			req := &roachpb.ExportRequest{
+				RequestHeader: roachpb.RequestHeaderFromSpan(reqs[i].span),
+				StartTime:     reqs[i].start,
+				MVCCFilter:    roachpb.MVCCFilter_Latest,
+				ReturnSST:     true,
+			}
+			res, pErr := client.SendWrappedWith(ctx, sender, header, req)
+			if pErr != nil {
+				return errors.Wrapf(pErr.GoError(), `fetching changes for [%s,%s)`,
+					reqs[i].span.Key, reqs[i].span.EndKey)
+			}
+			files[i] = res.(*roachpb.ExportResponse).Files
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
 // exportRequestPoll uses ExportRequest with the `ReturnSST` to fetch every kvs
 // that changed between a set of timestamps. It returns a closure that may be
 // repeatedly called to pull new changes. The returned closure is not
@@ -135,64 +467,273 @@ func runChangefeedFlow(
 // are returned, the timestamp used for the fetch is returned as resolved.
 //
 // The fetches are rate limited to be no more often than the
-// `changefeed.experimental_poll_interval` setting.
+// `changefeed.experimental_poll_interval` setting. Fetches of an interval of
+// time longer than `changefeed.catchup_scan_threshold` are recorded in the
+// catch-up scan metrics. The spans of a poll, and the chunks of the initial
+// scan, are read a few at a time, see exportSpans. cancelCheckFn is called
+// before every few, so that a paused or canceled feed stops in the middle of a
+// poll. A poll also stops between them whenever the buffer is full, and
+// carries on once what's been read so far has been returned.
+//
+// TODO: Replace the polling with changes pushed by the ranges as they're
+// committed, so that the latency of a changefeed is bounded by how fast a
+// change propagates instead of by the poll interval, and the spans aren't
+// scanned over and over. That needs a streaming RangeFeed RPC on the Internal
+// service in roachpb, along with a registry of the feeds on every replica that
+// its raft application publishes to and that closes out timestamps as
+// resolved, none of which exist yet.
 func exportRequestPoll(
-	execCfg *sql.ExecutorConfig, details jobspb.ChangefeedDetails, progress jobspb.ChangefeedProgress,
+	execCfg *sql.ExecutorConfig,
+	details jobspb.ChangefeedDetails,
+	progress jobspb.ChangefeedProgress,
+	watch *databaseWatch,
+	metrics *Metrics,
+	cancelCheckFn func(context.Context) error,
+	buffer *changefeedBuffer,
 ) func(context.Context) (changedKVs, error) {
-	sender := execCfg.DB.NonTransactionalSender()
 	var spans []roachpb.Span
 	for _, tableDesc := range details.TableDescs {
 		spans = append(spans, tableDesc.PrimaryIndexSpan())
 	}
 
-	var buffer changefeedBuffer
 	highwater := progress.Highwater
+	scanOnly := initialScanType(details.Opts[optInitialScan]) == optInitialScanOnly
+
+	// A changefeed that's restarted picks up the spans it checkpointed from
+	// where they were, see span_checkpoint.go.
+	done, rest := restoreSpanCheckpoint(spans, progress.SpanCheckpoint, highwater)
+
+	var scan *initialScan
+	if highwater == (hlc.Timestamp{}) {
+		_, inconsistent := details.Opts[optInconsistentInitialScan]
+		scan = makeInitialScan(execCfg, inconsistent, rest)
+		scan.restore(done)
+	}
+	// Every run of a scheduled changefeed is either its initial scan, which
+	// is then like that of `initial_scan='only'`, or a single poll.
+	scheduled := details.Recurrence != ``
+	pollOnce := scheduled && scan == nil
+	scanOnly = scanOnly || scheduled
+	// catchUp, if set, is where the next poll starts from for each span
+	// instead of highwater. It's set by inconsistent initial scans and by
+	// span checkpoints.
+	var catchUp []timestampedSpan
+	if scan == nil && done != nil {
+		catchUp = done
+		for _, span := range rest {
+			catchUp = append(catchUp, timestampedSpan{span: span, ts: highwater})
+		}
+	}
+
+	// With `schema_change_policy` stop or pause, polls end right at the next
+	// schema change event, which stopErr is then set to.
+	policy := schemaChangePolicyType(details.Opts[optSchemaChangePolicy])
+	stopAtEvents := policy == optSchemaChangePolicyStop || policy == optSchemaChangePolicyPause
+	events := schemaChangeEventsType(details.Opts[optSchemaChangeEvents])
+	var stopErr error
+
+	// A poll is read a span at a time, and stops whenever the buffer is full,
+	// see changefeedBuffer. While polling is set, the spans of the poll that
+	// are left to read are pollSpans, and it reads up to nextHighwater.
+	var polling bool
+	var pollSpans []timestampedSpan
+	var nextHighwater hlc.Timestamp
+	var catchupScan bool
+	var catchupScanBytes int64
+	var catchupScanStart time.Time
+
+	// exportFn reads the changes to spans, see exportSpans.
+	exportFn := func(
+		ctx context.Context, reqs []exportSpan,
+	) ([][]roachpb.ExportResponse_File, error) {
+		if err := cancelCheckFn(ctx); err != nil {
+			return nil, err
+		}
+		return exportSpans(ctx, execCfg, reqs)
+	}
+	// appendFn appends the files read for a span to the buffer and returns
+	// their size.
+	appendFn := func(
+		ctx context.Context, files []roachpb.ExportResponse_File, initialScan bool,
+	) int64 {
+		var spanBytes int64
+		for _, file := range files {
+			buffer.append(ctx, changedKVs{sst: file.SST, initialScan: initialScan})
+			spanBytes += int64(len(file.SST))
+		}
+		return spanBytes
+	}
+
+	var scanBytes int64
+	scanStart := timeutil.Now()
 	return func(ctx context.Context) (changedKVs, error) {
-		if ret, ok := buffer.get(); ok {
+		if ret, ok := buffer.get(ctx); ok {
 			return ret, nil
 		}
 
-		pollDuration := changefeedPollInterval.Get(&execCfg.Settings.SV)
-		pollDuration = pollDuration - timeutil.Since(timeutil.Unix(0, highwater.WallTime))
-		if pollDuration > 0 {
-			log.VEventf(ctx, 1, `sleeping for %s`, pollDuration)
-			select {
-			case <-ctx.Done():
-				return changedKVs{}, ctx.Err()
-			case <-time.After(pollDuration):
+		// The initial scan is returned a chunk at a time, so that its rows are
+		// emitted as it goes.
+		for scan != nil {
+			if scan.done() {
+				highwater, catchUp = scan.finish()
+				scan = nil
+				metrics.recordCatchupScan(timeutil.Since(scanStart), scanBytes)
+				// A feed that only does the initial scan doesn't poll after
+				// it, so its chunks are never caught up.
+				if catchUp == nil || scanOnly {
+					buffer.append(ctx, changedKVs{resolved: highwater})
+				}
+				break
+			}
+			var chunks []timestampedSpan
+			for len(chunks) < concurrentScanRequests(execCfg) && !scan.done() {
+				chunk, err := scan.nextChunk(ctx)
+				if err != nil {
+					return changedKVs{}, errors.Wrap(err, `planning initial scan`)
+				}
+				chunks = append(chunks, chunk)
+			}
+			reqs := make([]exportSpan, len(chunks))
+			for i, chunk := range chunks {
+				reqs[i] = exportSpan{span: chunk.span, end: chunk.ts}
 			}
+			chunksStart := timeutil.Now()
+			files, err := exportFn(ctx, reqs)
+			if err != nil {
+				return changedKVs{}, err
+			}
+			for i := range chunks {
+				chunk := chunks[i]
+				chunkBytes := appendFn(ctx, files[i], true /* initialScan */)
+				scanBytes += chunkBytes
+				buffer.append(ctx, changedKVs{spanDone: &chunk})
+				log.VEventf(ctx, 1, `initial scan of [%s,%s) at %s read %d bytes in %s`,
+					chunk.span.Key, chunk.span.EndKey, chunk.ts, chunkBytes, timeutil.Since(chunksStart))
+			}
+			if ret, ok := buffer.get(ctx); ok {
+				return ret, nil
+			}
+		}
+		if ret, ok := buffer.get(ctx); ok {
+			return ret, nil
 		}
+		if scanOnly && !pollOnce {
+			return changedKVs{}, errInitialScanOnlyDone
+		}
+		if !polling {
+			if stopErr != nil {
+				return changedKVs{}, stopErr
+			}
 
-		nextHighwater := execCfg.Clock.Now()
-		log.VEventf(ctx, 1, `changefeed poll [%s,%s): %s`,
-			highwater, nextHighwater, time.Duration(nextHighwater.WallTime-highwater.WallTime))
+			pollDuration := changefeedPollInterval.Get(&execCfg.Settings.SV)
+			pollDuration = pollDuration - timeutil.Since(timeutil.Unix(0, highwater.WallTime))
+			if pollDuration > 0 {
+				log.VEventf(ctx, 1, `sleeping for %s`, pollDuration)
+				select {
+				case <-ctx.Done():
+					return changedKVs{}, ctx.Err()
+				case <-time.After(pollDuration):
+				}
+			}
 
-		// TODO(dan): Send these out in parallel.
-		for _, span := range spans {
-			header := roachpb.Header{Timestamp: nextHighwater}
-			req := &roachpb.ExportRequest{
-				RequestHeader: roachpb.RequestHeaderFromSpan(span),
-				StartTime:     highwater,
-				MVCCFilter:    roachpb.MVCCFilter_Latest,
-				ReturnSST:     true,
+			nextHighwater = execCfg.Clock.Now()
+			if stopAtEvents {
+				event, err := nextSchemaChangeEvent(
+					ctx, execCfg.LeaseManager, policy, events, details.TableDescs, highwater, nextHighwater)
+				if err != nil {
+					return changedKVs{}, err
+				}
+				if event != nil {
+					// The changes up to the event are emitted, along with a
+					// resolved timestamp at the event, before stopping. The
+					// rows rewritten by the schema change come after it.
+					nextHighwater, stopErr = event.ts, event
+				}
 			}
-			res, pErr := client.SendWrappedWith(ctx, sender, header, req)
-			if pErr != nil {
-				return changedKVs{}, errors.Wrapf(
-					pErr.GoError(), `fetching changes for [%s,%s)`, span.Key, span.EndKey)
+			if watch != nil {
+				change, err := nextTargetsChange(
+					ctx, execCfg, watch, details.TableDescs, highwater, nextHighwater)
+				if err != nil {
+					return changedKVs{}, err
+				}
+				if change != nil && (stopErr == nil || change.ts.Less(nextHighwater)) {
+					nextHighwater, stopErr = change.ts, change
+				}
 			}
-			for _, file := range res.(*roachpb.ExportResponse).Files {
-				buffer.append(changedKVs{sst: file.SST})
+			interval := time.Duration(nextHighwater.WallTime - highwater.WallTime)
+			log.VEventf(ctx, 1, `changefeed poll [%s,%s): %s`, highwater, nextHighwater, interval)
+			// TODO: Also surface these in the job details, once there's a
+			// way to show more than the highwater for a changefeed job.
+			catchupScan = metrics.isCatchupScan(interval)
+			catchupScanBytes = 0
+			catchupScanStart = timeutil.Now()
+
+			pollSpans = catchUp
+			if len(pollSpans) > 0 {
+				// The spans that are furthest behind hold back the resolved
+				// timestamp, so they're caught up first.
+				pollSpans = append([]timestampedSpan(nil), pollSpans...)
+				sort.SliceStable(pollSpans, func(i, j int) bool {
+					return pollSpans[i].ts.Less(pollSpans[j].ts)
+				})
+				log.VEventf(ctx, 1, `catching up %d spans, the laggiest of which is [%s,%s) at %s`,
+					len(pollSpans), pollSpans[0].span.Key, pollSpans[0].span.EndKey, pollSpans[0].ts)
+			} else {
+				pollSpans = make([]timestampedSpan, len(spans))
+				for i, span := range spans {
+					pollSpans[i] = timestampedSpan{span: span, ts: highwater}
+				}
+			}
+			polling = true
+		}
+
+		for len(pollSpans) > 0 {
+			// Once the buffer is full, what's been read so far is emitted
+			// before the poll goes on.
+			if buffer.isFull() {
+				ret, _ := buffer.get(ctx)
+				return ret, nil
+			}
+			batch := pollSpans
+			if n := concurrentScanRequests(execCfg); len(batch) > n {
+				batch = batch[:n]
+			}
+			pollSpans = pollSpans[len(batch):]
+			reqs := make([]exportSpan, len(batch))
+			for i, span := range batch {
+				reqs[i] = exportSpan{span: span.span, start: span.ts, end: nextHighwater}
+			}
+			batchStart := timeutil.Now()
+			files, err := exportFn(ctx, reqs)
+			if err != nil {
+				return changedKVs{}, err
+			}
+			for i, span := range batch {
+				spanBytes := appendFn(ctx, files[i], false /* initialScan */)
+				buffer.append(ctx, changedKVs{spanDone: &timestampedSpan{span: span.span, ts: nextHighwater}})
+				if catchupScan {
+					catchupScanBytes += spanBytes
+					log.VEventf(ctx, 1, `catch-up scan of [%s,%s) read %d bytes in %s`,
+						span.span.Key, span.span.EndKey, spanBytes, timeutil.Since(batchStart))
+				}
 			}
 		}
 		log.VEventf(ctx, 2, `poll took %s`,
 			time.Duration(execCfg.Clock.Now().WallTime-nextHighwater.WallTime))
+		if catchupScan {
+			metrics.recordCatchupScan(timeutil.Since(catchupScanStart), catchupScanBytes)
+		}
 
 		// There is guaranteed to be at least one entry in buffer because we
 		// always append the resolved timestamp.
 		highwater = nextHighwater
-		buffer.append(changedKVs{resolved: highwater})
-		ret, _ := buffer.get()
+		catchUp = nil
+		polling = false
+		if pollOnce && stopErr == nil {
+			stopErr = errInitialScanOnlyDone
+		}
+		buffer.append(ctx, changedKVs{resolved: highwater})
+		ret, _ := buffer.get(ctx)
 		return ret, nil
 	}
 }
@@ -200,16 +741,87 @@ func exportRequestPoll(
 // kvsToRows gets changed kvs from a closure and converts them into sql rows. It
 // returns a closure that may be repeatedly called to advance the changefeed.
 // The returned closure is not threadsafe.
+//
+// With the `diff` option, the previous value of every changed row, other than
+// those of the initial scan, is looked up as well. With the `full_deletes`
+// option, it's looked up for deletions, and with the `changed_columns` option,
+// for updates. The rows of tables with
+// several column families are read back whole, see column_families.go.
 func kvsToRows(
 	execCfg *sql.ExecutorConfig,
 	details jobspb.ChangefeedDetails,
+	limiter *byteLimiter,
 	inputFn func(context.Context) (changedKVs, error),
 ) func(context.Context) ([]emitRow, error) {
 	rfCache := newRowFetcherCache(execCfg.LeaseManager)
+	_, withDiff := details.Opts[optDiff]
+	_, fullDeletes := details.Opts[optFullDeletes]
+	_, changedColumns := details.Opts[optChangedColumns]
+	_, splitFamilies := details.Opts[optSplitColumnFamilies]
+	noBackfill := schemaChangePolicyType(details.Opts[optSchemaChangePolicy]) ==
+		optSchemaChangePolicyNoBackfill
+	sender := execCfg.DB.NonTransactionalSender()
+
+	// rowKVsFn returns the kvs of every column family of the row that the
+	// given key is part of, as of the given timestamp. There are none if the
+	// row didn't exist.
+	rowKVsFn := func(
+		ctx context.Context, key roachpb.Key, ts hlc.Timestamp,
+	) ([]roachpb.KeyValue, error) {
+		rowPrefix, _, err := decodeRowFamilyKey(key)
+		if err != nil {
+			return nil, err
+		}
+		header := roachpb.Header{Timestamp: ts}
+		req := &roachpb.ScanRequest{RequestHeader: roachpb.RequestHeader{
+			Key: rowPrefix, EndKey: rowPrefix.PrefixEnd(),
+		}}
+		res, pErr := client.SendWrappedWith(ctx, sender, header, req)
+		if pErr != nil {
+			return nil, errors.Wrapf(pErr.GoError(), `fetching row of %s at %s`, key, ts)
+		}
+		return res.(*roachpb.ScanResponse).Rows, nil
+	}
+
+	var prevKVs sqlbase.SpanKVFetcher
+	// prevRowFn returns the row that the given key was part of just before the
+	// given timestamp, or nil if there was none.
+	//
+	// TODO: This is a read for every changed row. Batch them, or get the
+	// previous values from the same request as the changes.
+	prevRowFn := func(
+		ctx context.Context, key roachpb.Key, ts hlc.Timestamp,
+	) (tree.Datums, *sqlbase.TableDescriptor, error) {
+		prevTS := ts.Prev()
+		rowKVs, err := rowKVsFn(ctx, key, prevTS)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, `fetching previous value of %s`, key)
+		}
+		if len(rowKVs) == 0 {
+			return nil, nil, nil
+		}
+		rf, _, err := rfCache.RowFetcherForKey(ctx, engine.MVCCKey{Key: key, Timestamp: prevTS})
+		if err != nil {
+			return nil, nil, err
+		}
+		prevKVs.KVs = append(prevKVs.KVs[:0], rowKVs...)
+		if err := rf.StartScanFrom(ctx, &prevKVs); err != nil {
+			return nil, nil, err
+		}
+		row, tableDesc, _, err := rf.NextRowDecoded(ctx)
+		if err != nil || row == nil || rf.RowIsDeleted() {
+			return nil, nil, err
+		}
+		return append(tree.Datums(nil), row...), tableDesc, nil
+	}
 
 	var output []emitRow
 	var kvs sqlbase.SpanKVFetcher
 	var scratch bufalloc.ByteAllocator
+	// lastRowPrefix and lastRowTimestamp are the row and timestamp of the last
+	// change read back whole, to skip the other families changed with it.
+	var lastRowPrefix roachpb.Key
+	var lastRowTimestamp hlc.Timestamp
 	return func(ctx context.Context) ([]emitRow, error) {
 		// Reuse output, kvs, scratch to save allocations.
 		output, kvs.KVs, scratch = output[:0], kvs.KVs[:0], scratch[:0]
@@ -232,7 +844,7 @@ func kvsToRows(
 				}
 
 				unsafeKey := it.UnsafeKey()
-				rf, err := rfCache.RowFetcherForKey(ctx, unsafeKey)
+				rf, tableDesc, err := rfCache.RowFetcherForKey(ctx, unsafeKey)
 				if err != nil {
 					return nil, err
 				}
@@ -242,18 +854,47 @@ func kvsToRows(
 				var key, value []byte
 				scratch, key = scratch.Copy(unsafeKey.Key, 0 /* extraCap */)
 				scratch, value = scratch.Copy(it.UnsafeValue(), 0 /* extraCap */)
-				// TODO(dan): Handle tables with multiple column families.
-				kvs.KVs = append(kvs.KVs, roachpb.KeyValue{
+				changedKV := roachpb.KeyValue{
 					Key: key,
 					Value: roachpb.Value{
 						Timestamp: unsafeKey.Timestamp,
 						RawBytes:  value,
 					},
-				})
+				}
+
+				var family *sqlbase.ColumnFamilyDescriptor
+				if splitFamilies && len(tableDesc.Families) == 1 {
+					family = &tableDesc.Families[0]
+				}
+				if len(tableDesc.Families) > 1 {
+					rowPrefix, familyID, err := decodeRowFamilyKey(key)
+					if err != nil {
+						return nil, err
+					}
+					if splitFamilies {
+						if family, err = tableDesc.FindFamilyByID(familyID); err != nil {
+							return nil, err
+						}
+					} else if rowPrefix.Equal(lastRowPrefix) && unsafeKey.Timestamp == lastRowTimestamp {
+						continue
+					}
+					lastRowPrefix, lastRowTimestamp = rowPrefix, unsafeKey.Timestamp
+					rowKVs, err := rowKVsFn(ctx, key, unsafeKey.Timestamp)
+					if err != nil {
+						return nil, err
+					}
+					kvs.KVs = append(kvs.KVs, rowKVs...)
+				}
+				// A row that doesn't exist anymore is decoded from the changed
+				// kv, which is a deletion.
+				if len(kvs.KVs) == 0 {
+					kvs.KVs = append(kvs.KVs, changedKV)
+				}
 				if err := rf.StartScanFrom(ctx, &kvs); err != nil {
 					return nil, err
 				}
 
+				rowsBefore := len(output)
 				for {
 					var r emitRow
 					r.row, r.tableDesc, _, err = rf.NextRowDecoded(ctx)
@@ -267,10 +908,40 @@ func kvsToRows(
 
 					r.deleted = rf.RowIsDeleted()
 					r.rowTimestamp = unsafeKey.Timestamp
+					r.family = family
 					output = append(output, r)
 				}
+				// Initial scans and schema change backfills are throttled,
+				// see makeBackfillLimiter.
+				if len(output) > rowsBefore &&
+					(input.initialScan || hasColumnBackfill(output[len(output)-1].tableDesc)) {
+					if err := limiter.wait(ctx, len(key)+len(value)); err != nil {
+						return nil, err
+					}
+				}
+				// The previous value is read after the row fetcher is done
+				// with the changed kv, because it may use the same fetcher.
+				if !input.initialScan && len(output) > rowsBefore {
+					r := &output[len(output)-1]
+					backfill := noBackfill && !r.deleted && hasColumnBackfill(r.tableDesc)
+					if withDiff || (fullDeletes && r.deleted) || (changedColumns && !r.deleted) || backfill {
+						r.prevRow, r.prevTableDesc, err = prevRowFn(ctx, key, r.rowTimestamp)
+						if err != nil {
+							return nil, err
+						}
+					}
+					// With `schema_change_policy='nobackfill'`, a row rewritten
+					// by a column backfill without any change to its columns
+					// isn't emitted.
+					if backfill && r.prevRow != nil && sameRow(r.tableDesc, r.row, r.prevTableDesc, r.prevRow) {
+						output = output[:len(output)-1]
+					}
+				}
 			}
 		}
+		if input.spanDone != nil {
+			output = append(output, emitRow{spanDone: input.spanDone})
+		}
 		if input.resolved != (hlc.Timestamp{}) {
 			output = append(output, emitRow{resolved: input.resolved})
 		}
@@ -281,106 +952,366 @@ func kvsToRows(
 // emitRows connects to a sink, receives rows from a closure, and repeatedly
 // emits them and close notifications to the sink. It returns a closure that may
 // be repeatedly called to advance the changefeed. The returned closure is not
-// threadsafe.
+// threadsafe. cancelCheckFn is called after every sink flush, and pausepointFn
+// with the name of every pausepoint that's reached. markers, if non-nil, finds
+// the markers to emit with every resolved timestamp. status, if non-nil, is
+// kept up to date with what's emitted. encoderAcc, if non-nil, accounts for
+// the memory the encoder holds on to across rows.
 func emitRows(
+	ctx context.Context,
+	execCfg *sql.ExecutorConfig,
 	details jobspb.ChangefeedDetails,
+	metrics *Metrics,
 	jobProgressedFn func(context.Context, hlc.Timestamp) error,
+	spanCheckpointFn func(context.Context, []timestampedSpan) error,
+	cancelCheckFn func(context.Context) error,
+	pausepointFn func(context.Context, string) error,
+	lagAlerter *lagAlerter,
+	markers *markerPoller,
 	inputFn func(context.Context) ([]emitRow, error),
 	resultsCh chan<- tree.Datums,
+	status *changefeedStatus,
+	encoderAcc *mon.BoundAccount,
 ) (emitFn func(context.Context) error, closeFn func() error, err error) {
-	var sink Sink
+	var projections jsonProjections
+	if projection, ok := details.Opts[optJSONProjection]; ok {
+		if projections, err = parseJSONProjections(projection); err != nil {
+			return nil, nil, err
+		}
+	}
 
-	sinkURI, err := url.Parse(details.SinkURI)
+	query, err := makeCDCQuery(details.Opts)
 	if err != nil {
 		return nil, nil, err
 	}
-	switch sinkURI.Scheme {
-	case sinkSchemeChannel:
-		sink = &channelSink{resultsCh: resultsCh}
-		closeFn = sink.Close
-	case sinkSchemeKafka:
-		kafkaTopicPrefix := sinkURI.Query().Get(sinkParamTopicPrefix)
-		sink, err = getKafkaSink(kafkaTopicPrefix, sinkURI.Host)
-		if err != nil {
-			return nil, nil, err
-		}
-		closeFn = sink.Close
+	topics, err := makeTopicNamer(ctx, execCfg, details)
+	if err != nil {
+		return nil, nil, err
+	}
+	encoder, err := getEncoder(details)
+	if err != nil {
+		return nil, nil, err
+	}
+	if e, ok := encoder.(memoryAccountedEncoder); ok && encoderAcc != nil {
+		e.setMemoryAccount(encoderAcc)
+	}
+	partitionColumn, err := kafkaPartitionColumn(details.Opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	sink, err := getSink(ctx, execCfg, details.SinkURI, details.Opts, encoder, resultsCh)
+	if err != nil {
+		return nil, nil, err
+	}
+	closeFn = sink.Close
+	status.sinkOpened(sink)
+	metrics = metrics.withSink(details.SinkURI)
+	markerEnc, ok := encoder.(markerEncoder)
+	if !ok {
+		markers = nil
+	}
 
-		// We abuse the job's results channel to make CREATE CHANGEFEED wait for
-		// this before returning to the user to ensure the setup went okay. Job
-		// resumption doesn't have the same hack, but at the moment ignores results
-		// and so is currently okay. Return nil instead of anything meaningful so
-		// that if we start doing anything with the results returned by resumed
-		// jobs, then it breaks instead of returning nonsense.
-		resultsCh <- tree.Datums(nil)
-	default:
-		return nil, nil, errors.Errorf(`unsupported sink: %s`, sinkURI.Scheme)
+	families := makeFamilyProjector()
+
+	// Sinks that can have many rows in flight are handed the rows without
+	// waiting for them, see asyncSink.
+	var async *asyncEmitter
+	if a, ok := sink.(asyncSink); ok {
+		async = makeAsyncEmitter(a, metrics)
 	}
 
 	var rows []SinkRow
 	var scratch bufalloc.ByteAllocator
+	sinkLimiter, err := makeSinkLimiter(metrics, details.Opts)
+	if err != nil {
+		return nil, nil, err
+	}
 	emitRows := func(ctx context.Context) error {
 		if len(rows) == 0 {
 			return nil
 		}
-		err := sink.EmitRows(ctx, rows)
+		if err := pausepointFn(ctx, pausepointBeforeFlush); err != nil {
+			return err
+		}
+		var bytes int64
+		for _, row := range rows {
+			bytes += int64(len(row.Key) + len(row.Value))
+		}
+		if err := sinkLimiter.wait(ctx, int(bytes)); err != nil {
+			return err
+		}
+		if async != nil {
+			err := async.emit(ctx, rows)
+			if err == nil {
+				status.emitted(len(rows), bytes)
+			}
+			// The rows are still in flight, so their keys and values can't
+			// be reused.
+			rows, scratch = rows[:0], nil
+			if err != nil {
+				status.sinkFailed(err)
+				return err
+			}
+			return cancelCheckFn(ctx)
+		}
+		err := emitWithRetry(ctx, func() error {
+			metrics.addSinkPending(int64(len(rows)))
+			start := timeutil.Now()
+			err := sink.EmitRows(ctx, rows)
+			metrics.recordSinkFlush(timeutil.Since(start))
+			metrics.addSinkPending(-int64(len(rows)))
+			return err
+		})
+		if err == nil {
+			metrics.recordEmit(len(rows), bytes)
+			metrics.recordFlush()
+			acked := timeutil.Now()
+			for _, row := range rows {
+				metrics.recordCommitLatency(row.Updated, acked)
+			}
+			status.emitted(len(rows), bytes)
+		}
 		rows = rows[:0]
 		scratch = scratch[:0]
-		return err
+		if err != nil {
+			status.sinkFailed(err)
+			return err
+		}
+		return cancelCheckFn(ctx)
+	}
+
+	// Resolved timestamp messages are emitted with the `timestamps` option, as
+	// often as the feed advances, or with the `resolved` option, which can limit
+	// them to one per interval of (mvcc) time so they don't drown out the rows
+	// in low-traffic topics. Either way, the job's highwater is always updated.
+	_, emitResolvedMessages := details.Opts[optTimestamps]
+	var resolvedInterval time.Duration
+	if interval, ok := details.Opts[optResolvedTimestamps]; ok {
+		emitResolvedMessages = true
+		// The interval was checked in validateChangefeed.
+		if resolvedInterval, err = time.ParseDuration(interval); err != nil {
+			return nil, nil, err
+		}
+	}
+	var lastResolvedEmitted hlc.Timestamp
+
+	// The job's highwater is updated at most once per interval of wall time
+	// with the `min_checkpoint_frequency` option, which trades fewer writes to
+	// the jobs table for more duplicates re-emitted after a restart. Resolved
+	// timestamp messages are only emitted along with an update, so that they
+	// never promise more than a restarted feed would keep.
+	var minCheckpointFrequency time.Duration
+	if frequency, ok := details.Opts[optMinCheckpointFrequency]; ok {
+		// The frequency was checked in validateChangefeed.
+		if minCheckpointFrequency, err = time.ParseDuration(frequency); err != nil {
+			return nil, nil, err
+		}
+	}
+	var lastCheckpoint time.Time
+	// checkpointer, if non-nil, collects the spans emitted past the highwater,
+	// see span_checkpoint.go.
+	var checkpointer *spanCheckpointer
+
+	// emitResolved emits a guarantee that every row at or below the resolved
+	// timestamp has been emitted, along with any rows still in the buffer.
+	emitResolved := func(ctx context.Context, resolved hlc.Timestamp) error {
+		status.resolved(resolved)
+		// Clear out any rows in the buffer, because we're about to emit a
+		// guarantee that they've all been emitted.
+		if err := emitRows(ctx); err != nil {
+			return err
+		}
+		if async != nil {
+			if err := async.flush(ctx); err != nil {
+				status.sinkFailed(err)
+				return err
+			}
+		}
+
+		// Markers are emitted after the rows at or below their timestamp and
+		// before the highwater passes them, so they're emitted at least once.
+		if markers != nil {
+			ms, err := markers.poll(ctx, resolved)
+			if err != nil {
+				return err
+			}
+			for _, m := range ms {
+				payload, err := markerEnc.EncodeMarker(ctx, m.marker, m.ts)
+				if err != nil {
+					return err
+				}
+				if err := emitWithRetry(ctx, func() error {
+					return sink.EmitResolvedTimestamp(ctx, payload)
+				}); err != nil {
+					return err
+				}
+			}
+		}
+
+		// NB: To minimize the chance that a user sees duplicates from below
+		// this resolved timestamp, keep this update of the highwater mark
+		// before emitting the resolved timestamp to the sink.
+		checkpointed := timeutil.Since(lastCheckpoint) >= minCheckpointFrequency
+		if checkpointed {
+			if err := pausepointFn(ctx, pausepointBeforeCheckpoint); err != nil {
+				return err
+			}
+			if err := jobProgressedFn(ctx, resolved); err != nil {
+				return err
+			}
+			lastCheckpoint = timeutil.Now()
+			if checkpointer != nil {
+				checkpointer.resolved()
+			}
+		}
+		if lagAlerter != nil {
+			if err := lagAlerter.check(ctx, resolved, jobProgressedFn); err != nil {
+				return err
+			}
+		}
+
+		sinceLastEmitted := time.Duration(resolved.WallTime - lastResolvedEmitted.WallTime)
+		if emitResolvedMessages && checkpointed && sinceLastEmitted >= resolvedInterval {
+			resolvedMeta, err := encoder.EncodeResolvedTimestamp(ctx, resolved)
+			if err != nil {
+				return err
+			}
+
+			// TODO(dan): Emit more fine-grained (table level) resolved
+			// timestamps.
+			if err := emitWithRetry(ctx, func() error {
+				return sink.EmitResolvedTimestamp(ctx, resolvedMeta)
+			}); err != nil {
+				status.sinkFailed(err)
+				return err
+			}
+			lastResolvedEmitted = resolved
+		}
+		if bootstrapSink, ok := sink.(offsetBootstrapSink); ok {
+			if err := emitWithRetry(ctx, func() error {
+				return bootstrapSink.EmitOffsetBootstrap(ctx, resolved)
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var coalescer *rowCoalescer
+	if interval, ok := details.Opts[optCoalesceInterval]; ok {
+		// The interval was checked in validateChangefeed.
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, nil, err
+		}
+		if d > 0 {
+			// The rows handed to an asyncSink are in flight until the next
+			// flush, which may come after the next window starts.
+			coalescer = makeRowCoalescer(d, async != nil /* fresh */)
+		}
+	}
+	if coalescer == nil {
+		checkpointer = makeSpanCheckpointer(spanCheckpointInterval.Get(&execCfg.Settings.SV))
 	}
 
-	var key, value bytes.Buffer
 	return func(ctx context.Context) error {
 		rows = rows[:0]
-		scratch = scratch[:0]
+		if async == nil {
+			scratch = scratch[:0]
+		}
 
 		inputs, err := inputFn(ctx)
 		if err != nil {
 			return err
 		}
 		for _, input := range inputs {
+			if input.row != nil && query != nil {
+				// The filter sees the whole row, so it can use columns that
+				// aren't projected.
+				matches, err := query.matches(input.tableDesc, input.row, input.deleted)
+				if err != nil {
+					return err
+				}
+				if !matches {
+					input.row = nil
+				}
+			}
 			if input.row != nil {
-				key.Reset()
-				value.Reset()
-
-				keyColumns := input.tableDesc.PrimaryIndex.ColumnNames
-				jsonKeyRaw := make([]interface{}, len(keyColumns))
-				jsonValueRaw := make(map[string]interface{}, len(input.row))
-				if _, ok := details.Opts[optTimestamps]; ok {
-					jsonValueRaw[jsonMetaSentinel] = map[string]interface{}{
-						`updated`: tree.TimestampToDecimal(input.rowTimestamp).Decimal.String(),
+				if query != nil {
+					input.tableDesc = query.projectedDesc(input.tableDesc)
+					input.row = query.project(input.tableDesc, input.row)
+					if input.prevRow != nil {
+						input.prevTableDesc = query.projectedDesc(input.prevTableDesc)
+						input.prevRow = query.project(input.prevTableDesc, input.prevRow)
 					}
 				}
-				for i := range input.row {
-					jsonValueRaw[input.tableDesc.Columns[i].Name], err = tree.AsJSON(input.row[i])
-					if err != nil {
+				if input.family != nil {
+					input.tableDesc = families.familyDesc(input.tableDesc, input.family)
+					input.row = families.project(input.tableDesc, input.row)
+					if input.prevRow != nil {
+						// The family may have been added since the previous
+						// value, then there's nothing to diff against.
+						if prevFamily, err := input.prevTableDesc.FindFamilyByID(input.family.ID); err != nil {
+							input.prevRow, input.prevTableDesc = nil, nil
+						} else {
+							input.prevTableDesc = families.familyDesc(input.prevTableDesc, prevFamily)
+							input.prevRow = families.project(input.prevTableDesc, input.prevRow)
+						}
+					}
+				}
+				if projections != nil {
+					if err := projections.project(input.tableDesc, input.row); err != nil {
 						return err
 					}
+					if input.prevRow != nil {
+						if err := projections.project(input.prevTableDesc, input.prevRow); err != nil {
+							return err
+						}
+					}
 				}
-				for i, columnName := range keyColumns {
-					jsonKeyRaw[i] = jsonValueRaw[columnName]
+				encRow := encodeRow{
+					topic:         topics.topic(input.tableDesc),
+					tableName:     topics.tableName(input.tableDesc),
+					datums:        input.row,
+					updated:       input.rowTimestamp,
+					mvccTimestamp: input.rowTimestamp,
+					deleted:       input.deleted,
+					tableDesc:     input.tableDesc,
+					prevDatums:    input.prevRow,
+					prevTableDesc: input.prevTableDesc,
 				}
-
-				jsonKey, err := json.MakeJSON(jsonKeyRaw)
+				row := SinkRow{Topic: encRow.topic, Updated: input.rowTimestamp}
+				key, err := encoder.EncodeKey(ctx, encRow)
 				if err != nil {
 					return err
 				}
-				jsonKey.Format(&key)
-				if !input.deleted && envelopeType(details.Opts[optEnvelope]) == optEnvelopeRow {
-					jsonValue, err := json.MakeJSON(jsonValueRaw)
+				// Copy the key before encoding the value, encoders are allowed
+				// to reuse their buffers.
+				scratch, row.Key = scratch.Copy(key, 0 /* extraCap */)
+				if partitionColumn != `` && !input.deleted {
+					for i := range input.tableDesc.Columns {
+						if input.tableDesc.Columns[i].Name == partitionColumn {
+							scratch, row.PartitionKey = scratch.Copy(
+								[]byte(tree.AsString(input.row[i])), 0 /* extraCap */)
+							break
+						}
+					}
+				}
+				if envelopeType(details.Opts[optEnvelope]) != optEnvelopeKeyOnly {
+					value, err := encoder.EncodeValue(ctx, encRow)
 					if err != nil {
 						return err
 					}
-					jsonValue.Format(&value)
+					scratch, row.Value = scratch.Copy(value, 0 /* extraCap */)
 				}
-
-				row := SinkRow{Topic: input.tableDesc.Name}
-				scratch, row.Key = scratch.Copy(key.Bytes(), 0 /* extraCap */)
-				scratch, row.Value = scratch.Copy(value.Bytes(), 0 /* extraCap */)
-				rows = append(rows, row)
 				if log.V(2) {
 					log.Infof(ctx, `row %s: %s -> %s`, row.Topic, row.Key, row.Value)
 				}
+				if coalescer != nil {
+					coalescer.add(row, timeutil.Now())
+				} else {
+					rows = append(rows, row)
+				}
 
 				// TODO(dan): Tune this and make it based on bytes.
 				const kafkaBatchSize = 1000
@@ -390,41 +1321,73 @@ func emitRows(
 					}
 				}
 			}
-			if input.resolved != (hlc.Timestamp{}) {
-				// Clear out any rows in the buffer, because we're about to emit
-				// a guarantee that they've all been emitted.
-				if err := emitRows(ctx); err != nil {
-					return err
-				}
-
-				// NB: To minimize the chance that a user sees duplicates from
-				// below this resolved timestamp, keep this update of the
-				// highwater mark before emitting the resolved timestamp to the
-				// sink.
-				if err := jobProgressedFn(ctx, input.resolved); err != nil {
-					return err
-				}
-
-				if _, ok := details.Opts[optTimestamps]; ok {
-					resolvedMetaRaw := map[string]interface{}{
-						jsonMetaSentinel: map[string]interface{}{
-							`resolved`: tree.TimestampToDecimal(input.resolved).Decimal.String(),
-						},
-					}
-					resolvedMeta, err := gojson.Marshal(resolvedMetaRaw)
-					if err != nil {
+			if input.spanDone != nil && checkpointer != nil {
+				checkpointer.add(*input.spanDone)
+				if spans := checkpointer.due(); spans != nil {
+					// The rows read from the spans are emitted before the
+					// checkpoint says they were.
+					if err := emitRows(ctx); err != nil {
 						return err
 					}
-
-					// TODO(dan): Emit more fine-grained (table level) resolved
-					// timestamps.
-					if err := sink.EmitResolvedTimestamp(ctx, resolvedMeta); err != nil {
+					if async != nil {
+						if err := async.flush(ctx); err != nil {
+							return err
+						}
+					}
+					if err := spanCheckpointFn(ctx, spans); err != nil {
 						return err
 					}
+					checkpointer.saved()
+				}
+			}
+			if input.resolved != (hlc.Timestamp{}) {
+				if coalescer != nil && coalescer.holdResolved(input.resolved, timeutil.Now()) {
+					continue
+				}
+				if coalescer != nil {
+					coalesced, _ := coalescer.flush()
+					rows = append(rows, coalesced...)
+				}
+				if err := emitResolved(ctx, input.resolved); err != nil {
+					return err
 				}
 			}
 		}
 
+		if coalescer != nil && coalescer.ready(timeutil.Now()) {
+			coalesced, held := coalescer.flush()
+			rows = append(rows, coalesced...)
+			if held != (hlc.Timestamp{}) {
+				return emitResolved(ctx, held)
+			}
+		}
 		return emitRows(ctx)
 	}, closeFn, nil
 }
+
+// sinkRetryOpts controls how emissions that fail with a retryableSinkError are
+// retried.
+var sinkRetryOpts = retry.Options{
+	InitialBackoff: 5 * time.Millisecond,
+	MaxBackoff:     time.Second,
+	Multiplier:     2,
+	MaxRetries:     20,
+}
+
+// emitWithRetry calls fn, which emits something to a sink, retrying it as
+// long as it fails with an error marked by MarkRetryableSinkError.
+func emitWithRetry(ctx context.Context, fn func() error) error {
+	var err error
+	for r := retry.StartWithCtx(ctx, sinkRetryOpts); r.Next(); {
+		if err = fn(); err == nil || !isRetryableSinkError(err) {
+			return err
+		}
+		if log.V(1) {
+			log.Infof(ctx, `retrying sink emission: %s`, err)
+		}
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
@@ -10,8 +10,13 @@ package changefeedccl
 
 import (
 	"context"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/ccl/backupccl"
+	"github.com/cockroachdb/cockroach/pkg/ccl/storageccl"
 	"github.com/cockroachdb/cockroach/pkg/internal/client"
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/sql"
@@ -20,7 +25,9 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
 	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+	"github.com/cockroachdb/cockroach/pkg/util"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/tracing"
 	"github.com/pkg/errors"
 )
@@ -31,30 +38,195 @@ func init() {
 }
 
 type envelopeType string
+type formatType string
+type droppedColumnsType string
+type schemaCompatibilityType string
+type compressionType string
+type initialStateType string
+type initialScanType string
+type onErrorType string
 
 const (
-	optCursor     = `cursor`
-	optEnvelope   = `envelope`
-	optTimestamps = `timestamps`
+	optAllowLargeInitialScan   = `allow_large_initial_scan`
+	optArrayEncoding           = `array_encoding`
+	optBackfillMaxRate         = `backfill_max_rate`
+	optBytesEncoding           = `bytes_encoding`
+	optChangedColumns          = `changed_columns`
+	optCoalesceInterval        = `coalesce_interval`
+	optColumns                 = `columns`
+	optCompression             = `compression`
+	optConfluentSchemaRegistry = `confluent_schema_registry`
+	optCursor                  = `cursor`
+	optDecimalEncoding         = `decimal_encoding`
+	optDelimiter               = `delimiter`
+	optDiff                    = `diff`
+	optDroppedColumns          = `dropped_columns`
+	optEnvelope                = `envelope`
+	optExecutionLocality       = `execution_locality`
+	optFilter                  = `filter`
+	optFormat                  = `format`
+	optFullDeletes             = `full_deletes`
+	optFullTableName           = `full_table_name`
+	optGCProtectExpiresAfter   = `gc_protect_expires_after`
+	optHeader                  = `header`
+	optInconsistentInitialScan = `inconsistent_initial_scan`
+	optInitialScan             = `initial_scan`
+	optInitialState            = `initial_state`
+	optInitialScanPriority     = `initial_scan_priority`
+	optIntervalEncoding        = `interval_encoding`
+	optJSONProjection          = `json_projection`
+	optKafkaConnectJSONSchema  = `kafka_connect_json_schema`
+	optKafkaSinkConfig         = `kafka_sink_config`
+	optKeyInValue              = `key_in_value`
+	optLabel                   = `label`
+	optLagAlert                = `lag_alert`
+	optLagAlertPolicy          = `lag_alert_policy`
+	optMetricsLabel            = `metrics_label`
+	optMinCheckpointFrequency  = `min_checkpoint_frequency`
+	optMVCCTimestamps          = `mvcc_timestamp`
+	optNullAs                  = `nullas`
+	optOnError                 = `on_error`
+	optResolvedTimestamps      = `resolved`
+	optSchemaChangeEvents      = `schema_change_events`
+	optSchemaChangePolicy      = `schema_change_policy`
+	optSchemaCompatibility     = `schema_compatibility`
+	optSchemaRegistryOutage    = `schema_registry_outage`
+	optSinkMaxRate             = `sink_max_rate`
+	optSplitColumnFamilies     = `split_column_families`
+	optTimestampEncoding       = `timestamp_encoding`
+	optTimestamps              = `timestamps`
+	optTopicInValue            = `topic_in_value`
+	optUpdatedTimestamps       = `updated`
+	optWebhookSinkConfig       = `webhook_sink_config`
 
+	optCompressionGzip compressionType = `gzip`
+	optCompressionZstd compressionType = `zstd`
+
+	optDroppedColumnsOmit      droppedColumnsType = `omit`
+	optDroppedColumnsNull      droppedColumnsType = `null`
+	optDroppedColumnsLastKnown droppedColumnsType = `last_known`
+
+	optEnvelopeBare    envelopeType = `bare`
 	optEnvelopeKeyOnly envelopeType = `key_only`
 	optEnvelopeRow     envelopeType = `row`
+	optEnvelopeWrapped envelopeType = `wrapped`
+
+	optInitialScanYes  initialScanType = `yes`
+	optInitialScanNo   initialScanType = `no`
+	optInitialScanOnly initialScanType = `only`
+
+	optInitialStateRunning initialStateType = `running`
+	optInitialStatePaused  initialStateType = `paused`
+
+	optOnErrorFail  onErrorType = `fail`
+	optOnErrorPause onErrorType = `pause`
+
+	optFormatJSON     formatType = `json`
+	optFormatAvro     formatType = `experimental_avro`
+	optFormatProtobuf formatType = `protobuf`
+	optFormatCSV      formatType = `csv`
+	optFormatParquet  formatType = `parquet`
+
+	optSchemaCompatibilityNone     schemaCompatibilityType = `none`
+	optSchemaCompatibilityBackward schemaCompatibilityType = `backward`
+	optSchemaCompatibilityForward  schemaCompatibilityType = `forward`
+	optSchemaCompatibilityFull     schemaCompatibilityType = `full`
 
 	sinkSchemeChannel    = ``
 	sinkSchemeKafka      = `kafka`
 	sinkParamTopicPrefix = `topic_prefix`
+	sinkParamTopicName   = `topic_name`
+
+	sinkParamAutoCreateTopics = `auto_create_topics`
+
+	sinkParamDialTimeout   = `dial_timeout`
+	sinkParamKafkaVersion  = `kafka_version`
+	sinkParamKeepAlive     = `keep_alive`
+	sinkParamTLSEnabled    = `tls_enabled`
+	sinkParamTLSServerName = `tls_server_name`
+	sinkParamCACert        = `ca_cert`
+	sinkParamClientCert    = `client_cert`
+	sinkParamClientKey     = `client_key`
+
+	sinkParamSASLEnabled   = `sasl_enabled`
+	sinkParamSASLHandshake = `sasl_handshake`
+	sinkParamSASLMechanism = `sasl_mechanism`
+	sinkParamSASLUser      = `sasl_user`
+	sinkParamSASLPassword  = `sasl_password`
+
+	sinkSASLMechanismPlain       = `PLAIN`
+	sinkSASLMechanismSCRAMSHA256 = `SCRAM-SHA-256`
+	sinkSASLMechanismSCRAMSHA512 = `SCRAM-SHA-512`
+	sinkSASLMechanismOAuthBearer = `OAUTHBEARER`
+	sinkSASLMechanismGSSAPI      = `GSSAPI`
+	sinkSASLMechanismAWSMSKIAM   = `AWS_MSK_IAM`
+
+	sinkParamOffsetBootstrapTopic = `offset_bootstrap_topic`
 )
 
 var changefeedOptionExpectValues = map[string]bool{
-	optCursor:     true,
-	optEnvelope:   true,
-	optTimestamps: false,
+	optAllowLargeInitialScan:   false,
+	optArrayEncoding:           true,
+	optBackfillMaxRate:         true,
+	optBytesEncoding:           true,
+	optChangedColumns:          false,
+	optCoalesceInterval:        true,
+	optColumns:                 true,
+	optCompression:             true,
+	optConfluentSchemaRegistry: true,
+	optCursor:                  true,
+	optDecimalEncoding:         true,
+	optDelimiter:               true,
+	optDiff:                    false,
+	optDroppedColumns:          true,
+	optEnvelope:                true,
+	optExecutionLocality:       true,
+	optFilter:                  true,
+	optFormat:                  true,
+	optFullDeletes:             false,
+	optFullTableName:           false,
+	optGCProtectExpiresAfter:   true,
+	optHeader:                  false,
+	optInconsistentInitialScan: false,
+	optInitialScan:             true,
+	optInitialState:            true,
+	optInitialScanPriority:     true,
+	optIntervalEncoding:        true,
+	optJSONProjection:          true,
+	optKafkaConnectJSONSchema:  false,
+	optKafkaSinkConfig:         true,
+	optKeyInValue:              false,
+	optLabel:                   true,
+	optLagAlert:                true,
+	optLagAlertPolicy:          true,
+	optMetricsLabel:            true,
+	optMinCheckpointFrequency:  true,
+	optMVCCTimestamps:          false,
+	optNullAs:                  true,
+	optOnError:                 true,
+	optResolvedTimestamps:      true,
+	optSchemaChangeEvents:      true,
+	optSchemaChangePolicy:      true,
+	optSchemaCompatibility:     true,
+	optSchemaRegistryOutage:    true,
+	optSinkMaxRate:             true,
+	optSplitColumnFamilies:     false,
+	optTimestampEncoding:       true,
+	optTimestamps:              false,
+	optTopicInValue:            false,
+	optUpdatedTimestamps:       false,
+	optWebhookSinkConfig:       true,
 }
 
-// changefeedPlanHook implements sql.PlanHookFn.
+// changefeedPlanHook implements sql.PlanHookFn. It also implements EXPLAIN
+// CREATE CHANGEFEED, see explainChangefeed.
 func changefeedPlanHook(
 	_ context.Context, stmt tree.Statement, p sql.PlanHookState,
 ) (sql.PlanHookRowFn, sqlbase.ResultColumns, []sql.PlanNode, error) {
+	var explain bool
+	if explainStmt, ok := stmt.(*tree.Explain); ok {
+		stmt, explain = explainStmt.Statement, true
+	}
 	changefeedStmt, ok := stmt.(*tree.CreateChangefeed)
 	if !ok {
 		return nil, nil, nil, nil
@@ -88,10 +260,21 @@ func changefeedPlanHook(
 		}
 	}
 
+	if explain {
+		header = explainChangefeedHeader
+	}
+
 	optsFn, err := p.TypeAsStringOpts(changefeedStmt.Options, changefeedOptionExpectValues)
 	if err != nil {
 		return nil, nil, nil, err
 	}
+	var recurrenceFn func() (string, error)
+	if changefeedStmt.Recurrence != nil {
+		recurrenceFn, err = p.TypeAsString(changefeedStmt.Recurrence, `CREATE SCHEDULE FOR CHANGEFEED`)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
 
 	fn := func(ctx context.Context, _ []sql.PlanNode, resultsCh chan<- tree.Datums) error {
 		ctx, span := tracing.ChildSpan(ctx, stmt.StatementTag())
@@ -106,12 +289,33 @@ func changefeedPlanHook(
 			// already sent the wrong result column headers.
 			return errors.New(`omit the SINK clause for inline results`)
 		}
+		if err := checkSinkURIAccess(ctx, p, sinkURI); err != nil {
+			return err
+		}
+		if sinkURI, err = normalizeUserFileURI(p.User(), sinkURI); err != nil {
+			return err
+		}
 
 		opts, err := optsFn()
 		if err != nil {
 			return err
 		}
+		var recurrence string
+		if recurrenceFn != nil {
+			// A scheduled changefeed runs again and again, see
+			// scheduled_changefeed.go.
+			if recurrence, err = recurrenceFn(); err != nil {
+				return err
+			}
+			if _, err := parseRecurrence(recurrence); err != nil {
+				return err
+			}
+		}
 
+		// A changefeed with a cursor starts from it instead of with an initial
+		// scan, as if it had emitted everything up to the cursor. A resolved
+		// timestamp emitted by another changefeed is a valid cursor, so a
+		// consumer that's rebuilt can resume from the last one it processed.
 		now := p.ExecCfg().Clock.Now()
 		var highwater hlc.Timestamp
 		if cursor, ok := opts[optCursor]; ok {
@@ -120,6 +324,9 @@ func changefeedPlanHook(
 			if highwater, err = p.EvalAsOfTimestamp(asOf, now); err != nil {
 				return err
 			}
+		} else if initialScanType(strings.ToLower(opts[optInitialScan])) == optInitialScanNo {
+			// Skipping the initial scan is the same as a cursor of now.
+			highwater = now
 		}
 
 		// TODO(dan): This grabs table descriptors once, but uses them to
@@ -129,15 +336,42 @@ func changefeedPlanHook(
 		if highwater != (hlc.Timestamp{}) {
 			descriptorTime = highwater
 		}
-		targetDescs, _, err := backupccl.ResolveTargetsToDescriptors(
-			ctx, p, descriptorTime, changefeedStmt.Targets)
-		if err != nil {
-			return err
+		var targetDescs []sqlbase.Descriptor
+		if !changefeedStmt.AllTables {
+			targetDescs, _, err = backupccl.ResolveTargetsToDescriptors(
+				ctx, p, descriptorTime, changefeedStmt.Targets)
+			if err != nil {
+				return err
+			}
 		}
 		var tableDescs []sqlbase.TableDescriptor
-		for _, desc := range targetDescs {
-			if tableDesc := desc.GetTable(); tableDesc != nil {
-				tableDescs = append(tableDescs, *tableDesc)
+		var watch *databaseWatch
+		if changefeedStmt.AllTables || changefeedStmt.Targets.Databases != nil {
+			// A changefeed over whole databases also watches the tables
+			// created in them later, see database_feed.go.
+			if changefeedStmt.AllTables {
+				if err := p.RequireSuperUser(ctx, `create a changefeed for all tables`); err != nil {
+					return err
+				}
+				watch = &databaseWatch{all: true}
+				// Tables in different databases may have the same name.
+				opts[optFullTableName] = ``
+			} else {
+				watch = &databaseWatch{ids: make(map[sqlbase.ID]struct{})}
+				for _, desc := range targetDescs {
+					if dbDesc := desc.GetDatabase(); dbDesc != nil {
+						watch.ids[dbDesc.ID] = struct{}{}
+					}
+				}
+			}
+			if tableDescs, err = tablesInDatabases(ctx, p.ExecCfg().DB, descriptorTime, watch); err != nil {
+				return err
+			}
+		} else {
+			for _, desc := range targetDescs {
+				if tableDesc := desc.GetTable(); tableDesc != nil {
+					tableDescs = append(tableDescs, *tableDesc)
+				}
 			}
 		}
 
@@ -145,23 +379,56 @@ func changefeedPlanHook(
 			TableDescs: tableDescs,
 			Opts:       opts,
 			SinkURI:    sinkURI,
+			Recurrence: recurrence,
+		}
+		if watch != nil {
+			watch.setDetails(&details)
+		}
+		tableTargets, _, err := changefeedTargets(ctx, p, tableDescs)
+		if err != nil {
+			return err
+		}
+		details.TableNames = changefeedTableNames(tableTargets)
+		// Validate here, and not only when the feed starts running, so that the
+		// job gets the normalized options and a canonical description.
+		if details, err = validateChangefeed(details); err != nil {
+			return err
+		}
+		// A feed that starts with an initial scan is checked to not read much
+		// more than was likely meant to.
+		if _, ok := details.Opts[optAllowLargeInitialScan]; !ok && highwater == (hlc.Timestamp{}) {
+			if err := checkInitialScanSize(ctx, p.ExecCfg(), details.TableDescs); err != nil {
+				return err
+			}
+		}
+		if explain {
+			return explainChangefeed(ctx, p, details, highwater, resultsCh)
 		}
 		progress := jobspb.ChangefeedProgress{
 			Highwater: highwater,
 		}
 
+		paused := initialStateType(details.Opts[optInitialState]) == optInitialStatePaused
 		if details.SinkURI == `` {
+			if paused {
+				return errors.Errorf(`%s='%s' is not supported without a sink`,
+					optInitialState, optInitialStatePaused)
+			}
 			return runChangefeedFlow(
-				ctx, p.ExecCfg(), details, progress, resultsCh, nil, /* progressedFn */
+				ctx, p.ExecCfg(), 0 /* jobID */, details, progress, resultsCh,
+				nil /* progressedFn */, nil, /* status */
 			)
 		}
 
 		// Make a channel for runChangefeedFlow to signal once everything has
 		// been setup okay. This intentionally abuses what would normally be
 		// hooked up to resultsCh to avoid a bunch of extra plumbing.
-		startedCh := make(chan tree.Datums)
-		job, errCh, err := p.ExecCfg().JobRegistry.StartJob(ctx, startedCh, jobs.Record{
-			Description: changefeedJobDescription(changefeedStmt),
+		description, err := changefeedJobDescription(changefeedStmt, details)
+		if err != nil {
+			return err
+		}
+		record := jobs.Record{
+			Description: description,
 			Username:    p.User(),
 			DescriptorIDs: func() (sqlDescIDs []sqlbase.ID) {
 				for _, desc := range targetDescs {
@@ -171,19 +438,47 @@ func changefeedPlanHook(
 			}(),
 			Details:  details,
 			Progress: progress,
-		})
-		if err != nil {
-			return err
 		}
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case err := <-errCh:
-			return err
-		case <-startedCh:
-			// The feed set up without error, return control to the user.
+		var job *jobs.Job
+		if paused {
+			if job, err = createPausedChangefeedJob(ctx, p.ExecCfg(), record); err != nil {
+				return err
+			}
+		} else if !p.ExecCfg().JobRegistry.MayRun(record) {
+			// This node is outside of the `execution_locality` of the feed,
+			// so it's left for a node inside of it to adopt and run. The
+			// sink isn't connected to from here, since the point of the
+			// option is to keep it from being reached from outside.
+			if _, err := getEncoder(details); err != nil {
+				return err
+			}
+			if job, err = p.ExecCfg().JobRegistry.CreateAdoptableJob(ctx, record); err != nil {
+				return err
+			}
+		} else {
+			startedCh := make(chan tree.Datums)
+			var errCh <-chan error
+			job, errCh, err = p.ExecCfg().JobRegistry.StartJob(ctx, startedCh, record)
+			if err != nil {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case err := <-errCh:
+				return err
+			case <-startedCh:
+				// The feed set up without error, return control to the user.
+			}
 		}
 
+		created := makeChangefeedLogEvent(changefeedLogEventCreated, *job.ID(), details)
+		for _, desc := range details.TableDescs {
+			created.Tables = append(created.Tables, desc.Name)
+		}
+		created.Options = redactChangefeedOpts(details.Opts)
+		created.log(ctx)
+
 		resultsCh <- tree.Datums{
 			tree.NewDInt(tree.DInt(*job.ID())),
 		}
@@ -192,8 +487,111 @@ func changefeedPlanHook(
 	return fn, header, nil, nil
 }
 
-func changefeedJobDescription(changefeed *tree.CreateChangefeed) string {
-	return tree.AsStringWithFlags(changefeed, tree.FmtAlwaysQualifyTableNames)
+// createPausedChangefeedJob creates the job of a changefeed with
+// `initial_state='paused'`. Everything that would fail the feed when it
+// starts, including connecting to the sink, is checked first, but the job is
+// created paused and the feed only starts once it's resumed with RESUME JOB.
+// So a feed can be set up ahead of time and started at the exact moment an
+// orchestrator wants it to. Unless it has a cursor, its initial scan is as of
+// when it's resumed.
+func createPausedChangefeedJob(
+	ctx context.Context, execCfg *sql.ExecutorConfig, record jobs.Record,
+) (*jobs.Job, error) {
+	details := record.Details.(jobspb.ChangefeedDetails)
+	encoder, err := getEncoder(details)
+	if err != nil {
+		return nil, err
+	}
+	// getSink signals on the results channel once it's set up, see the
+	// comment there.
+	sink, err := getSink(
+		ctx, execCfg, details.SinkURI, details.Opts, encoder, make(chan tree.Datums, 1))
+	if err != nil {
+		return nil, err
+	}
+	if err := sink.Close(); err != nil {
+		return nil, err
+	}
+
+	job := execCfg.JobRegistry.NewJob(record)
+	if err := job.Created(ctx); err != nil {
+		return nil, err
+	}
+	if err := execCfg.JobRegistry.Pause(ctx, nil /* txn */, *job.ID()); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// changefeedJobDescription renders the canonical CREATE CHANGEFEED statement
+// for a changefeed with the given (validated) details. The options are the
+// normalized ones, with defaults filled in, sorted by name, and the query
+// parameters of the sink URI are sorted by key. So two changefeeds with the
+// same configuration have the same description, however their statements were
+// written, and tools that detect configuration drift can diff descriptions.
+// The password of the schema registry, if any, is redacted.
+func changefeedJobDescription(
+	changefeed *tree.CreateChangefeed, details jobspb.ChangefeedDetails,
+) (string, error) {
+	c := &tree.CreateChangefeed{
+		Targets:   changefeed.Targets,
+		AllTables: changefeed.AllTables,
+	}
+	if details.Recurrence != `` {
+		c.Recurrence = tree.NewDString(details.Recurrence)
+	}
+
+	sinkURI, err := redactSinkURI(details.SinkURI)
+	if err != nil {
+		return ``, err
+	}
+	c.SinkURI = tree.NewDString(sinkURI)
+
+	names := make([]string, 0, len(details.Opts))
+	for name := range details.Opts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		opt := tree.KVOption{Key: tree.Name(name)}
+		if changefeedOptionExpectValues[name] {
+			value := details.Opts[name]
+			if name == optConfluentSchemaRegistry {
+				value = redactSchemaRegistryURI(value)
+			}
+			opt.Value = tree.NewDString(value)
+		}
+		c.Options = append(c.Options, opt)
+	}
+	return tree.AsStringWithFlags(c, tree.FmtAlwaysQualifyTableNames), nil
+}
+
+// redactSinkURI replaces the passwords and keys in a sink URI, like the
+// password of a webhook sink's basic auth, so it can be shown by SHOW JOBS and
+// logged. Its query parameters are sorted by key.
+func redactSinkURI(sinkURI string) (string, error) {
+	u, err := url.Parse(sinkURI)
+	if err != nil {
+		return ``, err
+	}
+	if isCloudStorageSinkScheme(u.Scheme) {
+		// The credentials of cloud storage are in query parameters named by
+		// each provider, and there are no others, so they're all left out.
+		return storageccl.SanitizeExportStorageURI(sinkURI)
+	}
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), `redacted`)
+		}
+	}
+	params := u.Query()
+	for _, param := range []string{sinkParamSASLPassword, sinkParamClientKey} {
+		if params.Get(param) != `` {
+			params.Set(param, `redacted`)
+		}
+	}
+	u.RawQuery = params.Encode()
+	return u.String(), nil
 }
 
 func validateChangefeed(details jobspb.ChangefeedDetails) (jobspb.ChangefeedDetails, error) {
@@ -204,39 +602,521 @@ func validateChangefeed(details jobspb.ChangefeedDetails) (jobspb.ChangefeedDeta
 		details.Opts = map[string]string{}
 	}
 
+	// The values of the options that pick from a fixed set are case
+	// insensitive. Normalize them so the job has the same options, and
+	// description, however they were written.
+	for _, opt := range append([]string{
+		optCompression, optDroppedColumns, optEnvelope, optFormat, optInitialScan,
+		optInitialState, optLagAlertPolicy, optOnError, optSchemaChangeEvents,
+		optSchemaChangePolicy, optSchemaCompatibility, optSchemaRegistryOutage,
+	}, jsonTypeEncodingOpts...) {
+		if value, ok := details.Opts[opt]; ok {
+			details.Opts[opt] = strings.ToLower(value)
+		}
+	}
+
 	switch envelopeType(details.Opts[optEnvelope]) {
 	case ``, optEnvelopeRow:
 		details.Opts[optEnvelope] = string(optEnvelopeRow)
-	case optEnvelopeKeyOnly:
-		details.Opts[optEnvelope] = string(optEnvelopeKeyOnly)
+	case optEnvelopeBare, optEnvelopeKeyOnly, optEnvelopeWrapped:
 	default:
 		return jobspb.ChangefeedDetails{}, errors.Errorf(
 			`unknown %s: %s`, optEnvelope, details.Opts[optEnvelope])
 	}
 
-	for _, tableDesc := range details.TableDescs {
-		if len(tableDesc.Families) != 1 {
+	format := formatType(details.Opts[optFormat])
+	switch format {
+	case ``:
+		format = optFormatJSON
+	case optFormatJSON:
+	case optFormatAvro:
+		if details.Opts[optConfluentSchemaRegistry] == `` {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`WITH option %s is required for %s=%s`,
+				optConfluentSchemaRegistry, optFormat, optFormatAvro)
+		}
+		if _, err := makeSchemaRegistryConn(details.Opts[optConfluentSchemaRegistry]); err != nil {
+			return jobspb.ChangefeedDetails{}, errors.Wrapf(
+				err, `invalid %s`, optConfluentSchemaRegistry)
+		}
+	case optFormatProtobuf, optFormatParquet:
+	case optFormatCSV:
+		if d, ok := details.Opts[optDelimiter]; ok {
+			if _, err := util.GetSingleRune(d); err != nil {
+				return jobspb.ChangefeedDetails{}, errors.Wrapf(err, `invalid %s`, optDelimiter)
+			}
+		}
+	default:
+		return jobspb.ChangefeedDetails{}, errors.Errorf(
+			`unknown %s: %s`, optFormat, details.Opts[optFormat])
+	}
+	details.Opts[optFormat] = string(format)
+	envelope := envelopeType(details.Opts[optEnvelope])
+	if (envelope == optEnvelopeWrapped || envelope == optEnvelopeBare) && format != optFormatJSON {
+		return jobspb.ChangefeedDetails{}, errors.Errorf(
+			`%s=%s is only supported with %s=%s`, optEnvelope, envelope, optFormat, optFormatJSON)
+	}
+	for _, opt := range append([]string{
+		optChangedColumns, optFullDeletes, optKafkaConnectJSONSchema, optMVCCTimestamps,
+		optKeyInValue, optSplitColumnFamilies, optTopicInValue,
+	}, jsonTypeEncodingOpts...) {
+		if _, ok := details.Opts[opt]; ok && format != optFormatJSON {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`WITH option %s is only supported with %s=%s`, opt, optFormat, optFormatJSON)
+		}
+	}
+	switch outage := schemaRegistryOutageType(details.Opts[optSchemaRegistryOutage]); outage {
+	case ``:
+	case optSchemaRegistryOutageFail, optSchemaRegistryOutageRetry, optSchemaRegistryOutagePause:
+		if format != optFormatAvro {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`WITH option %s is only supported with %s=%s`,
+				optSchemaRegistryOutage, optFormat, optFormatAvro)
+		}
+		// Sinkless feeds don't have a job to pause.
+		if outage == optSchemaRegistryOutagePause && details.SinkURI == `` {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s='%s' is not supported without a sink`, optSchemaRegistryOutage, outage)
+		}
+	default:
+		return jobspb.ChangefeedDetails{}, errors.Errorf(
+			`unknown %s: %s`, optSchemaRegistryOutage, details.Opts[optSchemaRegistryOutage])
+	}
+	switch policy := schemaChangePolicyType(details.Opts[optSchemaChangePolicy]); policy {
+	case ``, optSchemaChangePolicyBackfill, optSchemaChangePolicyNoBackfill,
+		optSchemaChangePolicyStop:
+	case optSchemaChangePolicyPause:
+		// Sinkless feeds don't have a job to pause.
+		if details.SinkURI == `` {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s='%s' is not supported without a sink`, optSchemaChangePolicy, policy)
+		}
+	default:
+		return jobspb.ChangefeedDetails{}, errors.Errorf(
+			`unknown %s: %s`, optSchemaChangePolicy, details.Opts[optSchemaChangePolicy])
+	}
+	switch schemaChangeEventsType(details.Opts[optSchemaChangeEvents]) {
+	case ``, optSchemaChangeEventsDefault, optSchemaChangeEventsColumnChanges:
+	default:
+		return jobspb.ChangefeedDetails{}, errors.Errorf(
+			`unknown %s: %s`, optSchemaChangeEvents, details.Opts[optSchemaChangeEvents])
+	}
+	if _, err := makeJSONTypeEncodings(details.Opts); err != nil {
+		return jobspb.ChangefeedDetails{}, err
+	}
+	scan := initialScanType(details.Opts[optInitialScan])
+	switch scan {
+	case ``, optInitialScanYes, optInitialScanNo, optInitialScanOnly:
+	default:
+		return jobspb.ChangefeedDetails{}, errors.Errorf(
+			`unknown %s: %s`, optInitialScan, details.Opts[optInitialScan])
+	}
+	if _, ok := details.Opts[optCursor]; ok {
+		if _, ok := details.Opts[optInconsistentInitialScan]; ok {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`WITH option %s is not supported with %s, which skips the initial scan`,
+				optInconsistentInitialScan, optCursor)
+		}
+		if scan == optInitialScanYes || scan == optInitialScanOnly {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s='%s' is not supported with %s, which skips the initial scan`,
+				optInitialScan, scan, optCursor)
+		}
+	}
+	if _, ok := details.Opts[optInconsistentInitialScan]; ok && scan == optInitialScanNo {
+		return jobspb.ChangefeedDetails{}, errors.Errorf(
+			`WITH option %s is not supported with %s='%s'`,
+			optInconsistentInitialScan, optInitialScan, scan)
+	}
+	for _, opt := range []string{optChangedColumns, optFullDeletes} {
+		if _, ok := details.Opts[opt]; ok && envelope == optEnvelopeKeyOnly {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`WITH option %s is not supported with %s=%s`, opt, optEnvelope, envelope)
+		}
+	}
+	if _, ok := details.Opts[optDiff]; ok && envelope != optEnvelopeWrapped && envelope != optEnvelopeBare {
+		return jobspb.ChangefeedDetails{}, errors.Errorf(
+			`WITH option %s is only supported with %s=%s or %s=%s`,
+			optDiff, optEnvelope, optEnvelopeWrapped, optEnvelope, optEnvelopeBare)
+	}
+	if format != optFormatCSV {
+		for _, opt := range []string{optDelimiter, optHeader, optNullAs} {
+			if _, ok := details.Opts[opt]; ok {
+				return jobspb.ChangefeedDetails{}, errors.Errorf(
+					`WITH option %s is only supported with %s=%s`, opt, optFormat, optFormatCSV)
+			}
+		}
+	}
+
+	switch compression := compressionType(details.Opts[optCompression]); compression {
+	case ``, optCompressionGzip:
+	case optCompressionZstd:
+		// TODO: Support zstd once a zstd library is vendored.
+		return jobspb.ChangefeedDetails{}, errors.Errorf(
+			`%s=%s is not yet supported`, optCompression, compression)
+	default:
+		return jobspb.ChangefeedDetails{}, errors.Errorf(
+			`unknown %s: %s`, optCompression, details.Opts[optCompression])
+	}
+
+	switch state := initialStateType(details.Opts[optInitialState]); state {
+	case ``, optInitialStateRunning, optInitialStatePaused:
+	default:
+		return jobspb.ChangefeedDetails{}, errors.Errorf(
+			`unknown %s: %s`, optInitialState, details.Opts[optInitialState])
+	}
+	if _, ok := details.Opts[optExecutionLocality]; ok {
+		// Sinkless feeds are run by the node they're created on.
+		if details.SinkURI == `` {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s is not supported without a sink`, optExecutionLocality)
+		}
+		if _, err := executionLocality(details.Opts); err != nil {
+			return jobspb.ChangefeedDetails{}, err
+		}
+	}
+	switch onError := onErrorType(details.Opts[optOnError]); onError {
+	case ``, optOnErrorFail:
+	case optOnErrorPause:
+		// Sinkless feeds don't have a job to pause.
+		if details.SinkURI == `` {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s='%s' is not supported without a sink`, optOnError, onError)
+		}
+	default:
+		return jobspb.ChangefeedDetails{}, errors.Errorf(
+			`unknown %s: %s`, optOnError, details.Opts[optOnError])
+	}
+	if _, _, err := changefeedRecurrence(details.Recurrence); err != nil {
+		return jobspb.ChangefeedDetails{}, err
+	}
+	for _, opt := range []string{optBackfillMaxRate, optSinkMaxRate} {
+		if value, ok := details.Opts[opt]; ok {
+			if _, err := parseMaxRate(opt, value); err != nil {
+				return jobspb.ChangefeedDetails{}, err
+			}
+		}
+	}
+	if config, ok := details.Opts[optKafkaSinkConfig]; ok {
+		// An external connection is only resolved when the changefeed runs, so
+		// getSink checks the scheme it resolves to.
+		if !strings.HasPrefix(details.SinkURI, sinkSchemeKafka+`:`) &&
+			!strings.HasPrefix(details.SinkURI, sinkSchemeExternal+`:`) {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s is only supported with kafka sinks`, optKafkaSinkConfig)
+		}
+		if err := validateKafkaSinkConfig(config); err != nil {
+			return jobspb.ChangefeedDetails{}, err
+		}
+		column, err := kafkaPartitionColumn(details.Opts)
+		if err != nil {
+			return jobspb.ChangefeedDetails{}, err
+		}
+		for i := range details.TableDescs {
+			if column == `` {
+				break
+			}
+			desc := &details.TableDescs[i]
+			if _, _, err := desc.FindColumnByName(tree.Name(column)); err != nil {
+				return jobspb.ChangefeedDetails{}, errors.Wrapf(
+					err, `invalid %s PartitionColumn`, optKafkaSinkConfig)
+			}
+		}
+	}
+	if config, ok := details.Opts[optWebhookSinkConfig]; ok {
+		if !strings.HasPrefix(details.SinkURI, sinkSchemeWebhookHTTPS+`:`) &&
+			!strings.HasPrefix(details.SinkURI, sinkSchemeExternal+`:`) {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s is only supported with webhook sinks`, optWebhookSinkConfig)
+		}
+		if _, err := parseWebhookSinkConfig(config); err != nil {
+			return jobspb.ChangefeedDetails{}, err
+		}
+	}
+
+	compat := schemaCompatibilityType(details.Opts[optSchemaCompatibility])
+	switch compat {
+	case ``:
+		compat = optSchemaCompatibilityNone
+	case optSchemaCompatibilityNone, optSchemaCompatibilityBackward,
+		optSchemaCompatibilityForward, optSchemaCompatibilityFull:
+	default:
+		return jobspb.ChangefeedDetails{}, errors.Errorf(
+			`unknown %s: %s`, optSchemaCompatibility, details.Opts[optSchemaCompatibility])
+	}
+	details.Opts[optSchemaCompatibility] = string(compat)
+
+	// Consumers with a schema from before a column was dropped can only read
+	// newer messages (forward compatibility) if the column is still there. Avro
+	// schemas don't need this because every field has a null default, which
+	// already makes dropping a column a forward compatible change. The protobuf
+	// envelope doesn't depend on the table's columns at all.
+	retainDropped := compat == optSchemaCompatibilityForward || compat == optSchemaCompatibilityFull
+	// Values aren't emitted at all with envelope=key_only, so there's nothing
+	// to retain dropped columns in.
+	keyOnly := envelope == optEnvelopeKeyOnly
+	retainDropped = retainDropped && format == optFormatJSON && !keyOnly
+	switch dropped := droppedColumnsType(details.Opts[optDroppedColumns]); dropped {
+	case ``:
+		if retainDropped {
+			details.Opts[optDroppedColumns] = string(optDroppedColumnsNull)
+		} else {
+			details.Opts[optDroppedColumns] = string(optDroppedColumnsOmit)
+		}
+	case optDroppedColumnsOmit:
+		if retainDropped {
 			return jobspb.ChangefeedDetails{}, errors.Errorf(
-				`only tables with 1 column family are currently supported: %s has %d`,
-				tableDesc.Name, len(tableDesc.Families))
+				`%s='%s' is incompatible with %s='%s'`,
+				optDroppedColumns, dropped, optSchemaCompatibility, compat)
+		}
+	case optDroppedColumnsNull, optDroppedColumnsLastKnown:
+		if format != optFormatJSON {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s='%s' is not supported with %s=%s`, optDroppedColumns, dropped, optFormat, format)
+		}
+		if keyOnly {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s='%s' is not supported with %s=%s`,
+				optDroppedColumns, dropped, optEnvelope, optEnvelopeKeyOnly)
+		}
+	default:
+		return jobspb.ChangefeedDetails{}, errors.Errorf(
+			`unknown %s: %s`, optDroppedColumns, details.Opts[optDroppedColumns])
+	}
+	// The columns of the other families would look dropped to the messages of
+	// a family.
+	if _, ok := details.Opts[optSplitColumnFamilies]; ok &&
+		droppedColumnsType(details.Opts[optDroppedColumns]) != optDroppedColumnsOmit {
+		return jobspb.ChangefeedDetails{}, errors.Errorf(
+			`WITH option %s is only supported with %s='%s'`,
+			optSplitColumnFamilies, optDroppedColumns, optDroppedColumnsOmit)
+	}
+
+	// The label names the group of changefeeds whose minimum resolved timestamp
+	// crdb_internal.changefeed_resolved_groups shows.
+	if label, ok := details.Opts[optLabel]; ok && label == `` {
+		return jobspb.ChangefeedDetails{}, errors.Errorf(`%s must not be empty`, optLabel)
+	}
+	// The metrics label scopes the emit metrics of a changefeed, see Metrics.
+	if label, ok := details.Opts[optMetricsLabel]; ok && label == `` {
+		return jobspb.ChangefeedDetails{}, errors.Errorf(`%s must not be empty`, optMetricsLabel)
+	}
+
+	watch := watchedDatabases(details)
+	query, err := makeCDCQuery(details.Opts)
+	if err != nil {
+		return jobspb.ChangefeedDetails{}, err
+	}
+	if query != nil {
+		if len(details.TableDescs) != 1 || watch != nil {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`WITH options %s and %s are only supported with a single target table`,
+				optColumns, optFilter)
+		}
+		if err := query.validate(&details.TableDescs[0]); err != nil {
+			return jobspb.ChangefeedDetails{}, err
+		}
+		// The projected columns would look dropped to the messages, and
+		// the families already project the columns of a row.
+		if _, ok := details.Opts[optColumns]; ok {
+			if _, ok := details.Opts[optSplitColumnFamilies]; ok {
+				return jobspb.ChangefeedDetails{}, errors.Errorf(
+					`%s is incompatible with %s`, optColumns, optSplitColumnFamilies)
+			}
+			if droppedColumnsType(details.Opts[optDroppedColumns]) != optDroppedColumnsOmit {
+				return jobspb.ChangefeedDetails{}, errors.Errorf(
+					`WITH option %s is only supported with %s='%s'`,
+					optColumns, optDroppedColumns, optDroppedColumnsOmit)
+			}
+		}
+	}
+
+	if projection, ok := details.Opts[optJSONProjection]; ok {
+		projections, err := parseJSONProjections(projection)
+		if err == nil {
+			err = projections.validate(details.TableDescs)
+		}
+		if err != nil {
+			return jobspb.ChangefeedDetails{}, errors.Wrapf(err, `invalid %s`, optJSONProjection)
+		}
+	}
+
+	if interval, ok := details.Opts[optCoalesceInterval]; ok {
+		if d, err := time.ParseDuration(interval); err != nil {
+			return jobspb.ChangefeedDetails{}, errors.Wrapf(err, `invalid %s`, optCoalesceInterval)
+		} else if d < 0 {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`invalid %s: must not be negative`, optCoalesceInterval)
+		}
+	}
+
+	if interval, ok := details.Opts[optResolvedTimestamps]; ok {
+		if d, err := time.ParseDuration(interval); err != nil {
+			return jobspb.ChangefeedDetails{}, errors.Wrapf(err, `invalid %s`, optResolvedTimestamps)
+		} else if d < 0 {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`invalid %s: must not be negative`, optResolvedTimestamps)
+		}
+	}
+
+	if frequency, ok := details.Opts[optMinCheckpointFrequency]; ok {
+		if d, err := time.ParseDuration(frequency); err != nil {
+			return jobspb.ChangefeedDetails{}, errors.Wrapf(err, `invalid %s`, optMinCheckpointFrequency)
+		} else if d < 0 {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`invalid %s: must not be negative`, optMinCheckpointFrequency)
+		}
+	}
+
+	if expiresAfter, ok := details.Opts[optGCProtectExpiresAfter]; ok {
+		if d, err := time.ParseDuration(expiresAfter); err != nil {
+			return jobspb.ChangefeedDetails{}, errors.Wrapf(err, `invalid %s`, optGCProtectExpiresAfter)
+		} else if d <= 0 {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`invalid %s: must be positive`, optGCProtectExpiresAfter)
+		}
+	}
+
+	if bound, ok := details.Opts[optLagAlert]; ok {
+		if d, err := time.ParseDuration(bound); err != nil {
+			return jobspb.ChangefeedDetails{}, errors.Wrapf(err, `invalid %s`, optLagAlert)
+		} else if d <= 0 {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`invalid %s: must be positive`, optLagAlert)
+		}
+	}
+	switch policy := lagAlertPolicyType(details.Opts[optLagAlertPolicy]); policy {
+	case ``:
+		if _, ok := details.Opts[optLagAlert]; ok {
+			details.Opts[optLagAlertPolicy] = string(optLagAlertPolicyAlert)
+		}
+	case optLagAlertPolicyAlert, optLagAlertPolicyPause, optLagAlertPolicyFail:
+		if _, ok := details.Opts[optLagAlert]; !ok {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`WITH option %s requires %s`, optLagAlertPolicy, optLagAlert)
+		}
+		if policy == optLagAlertPolicyPause && details.SinkURI == `` {
+			return jobspb.ChangefeedDetails{}, errors.Errorf(
+				`%s=%s is not supported for changefeeds without a sink`,
+				optLagAlertPolicy, optLagAlertPolicyPause)
+		}
+	default:
+		return jobspb.ChangefeedDetails{}, errors.Errorf(
+			`unknown %s: %s`, optLagAlertPolicy, details.Opts[optLagAlertPolicy])
+	}
+
+	if priority, ok := details.Opts[optInitialScanPriority]; ok {
+		var err error
+		details.TableDescs, err = prioritizeTables(details.TableDescs, priority)
+		if err != nil {
+			return jobspb.ChangefeedDetails{}, err
 		}
 	}
 
 	return details, nil
 }
 
-type changefeedResumer struct{}
+// prioritizeTables reorders tableDescs so that the tables named in the
+// comma-separated `priority` list come first, in the order given. The
+// remaining tables keep their relative order. Spans are scanned in this order,
+// so downstream consumers can get small reference tables before large ones.
+func prioritizeTables(
+	tableDescs []sqlbase.TableDescriptor, priority string,
+) ([]sqlbase.TableDescriptor, error) {
+	ranks := make(map[string]int)
+	for _, name := range strings.Split(priority, `,`) {
+		name = strings.TrimSpace(name)
+		if name == `` {
+			continue
+		}
+		if _, ok := ranks[name]; ok {
+			return nil, errors.Errorf(`table %s is listed more than once in %s`,
+				name, optInitialScanPriority)
+		}
+		ranks[name] = len(ranks)
+	}
+	for name := range ranks {
+		found := false
+		for _, tableDesc := range tableDescs {
+			found = found || tableDesc.Name == name
+		}
+		if !found {
+			return nil, errors.Errorf(`table %s in %s is not watched by this changefeed`,
+				name, optInitialScanPriority)
+		}
+	}
+
+	prioritized := append([]sqlbase.TableDescriptor(nil), tableDescs...)
+	sort.SliceStable(prioritized, func(i, j int) bool {
+		rankI, okI := ranks[prioritized[i].Name]
+		rankJ, okJ := ranks[prioritized[j].Name]
+		if okI && okJ {
+			return rankI < rankJ
+		}
+		return okI && !okJ
+	})
+	return prioritized, nil
+}
+
+type changefeedResumer struct {
+	status *changefeedStatus
+
+	// execCfg and err are set by Resume, with the error it returned, so that
+	// OnFailOrCancel can record why the changefeed failed. They're unset when
+	// the job is canceled, since that's done with a fresh changefeedResumer.
+	execCfg *sql.ExecutorConfig
+	err     error
+}
 
 func (b *changefeedResumer) Resume(
 	ctx context.Context, job *jobs.Job, planHookState interface{}, startedCh chan<- tree.Datums,
 ) error {
-	execCfg := planHookState.(sql.PlanHookState).ExecCfg()
-	details := job.Details().(jobspb.ChangefeedDetails)
-	progress := job.Progress().Details.(*jobspb.Progress_Changefeed).Changefeed
-	return runChangefeedFlow(ctx, execCfg, details, *progress, startedCh, job.Progressed)
+	b.execCfg = planHookState.(sql.PlanHookState).ExecCfg()
+	b.err = b.resume(ctx, job, startedCh)
+	return b.err
+}
+
+func (b *changefeedResumer) resume(
+	ctx context.Context, job *jobs.Job, startedCh chan<- tree.Datums,
+) error {
+	execCfg := b.execCfg
+	for {
+		details := job.Details().(jobspb.ChangefeedDetails)
+		every, scheduled, err := changefeedRecurrence(details.Recurrence)
+		if err != nil {
+			return err
+		}
+		if err := runChangefeedFlowWithRetry(
+			ctx, execCfg, job, b.status, startedCh,
+		); err != nil || !scheduled {
+			return err
+		}
+		// A scheduled changefeed waits for its next run instead of
+		// succeeding, see scheduled_changefeed.go. Only its first run
+		// signals that the job started.
+		startedCh = make(chan tree.Datums, 1)
+		if err := waitForNextRun(ctx, execCfg, job, nextRun(every, timeutil.Now())); err != nil {
+			return err
+		}
+	}
+}
+func (b *changefeedResumer) OnFailOrCancel(ctx context.Context, txn *client.Txn, job *jobs.Job) error {
+	if b.err != nil {
+		failed := makeChangefeedLogEvent(
+			changefeedLogEventFailed, *job.ID(), job.Details().(jobspb.ChangefeedDetails))
+		failed.Error = b.err.Error()
+		failed.log(ctx)
+		if err := logChangefeedEvent(
+			ctx, b.execCfg, txn, sql.EventLogChangefeedFailed, *job.ID(), b.err.Error(),
+		); err != nil {
+			return err
+		}
+	}
+	return releaseChangefeedData(ctx, txn, *job.ID())
+}
+func (b *changefeedResumer) OnSuccess(ctx context.Context, txn *client.Txn, job *jobs.Job) error {
+	return releaseChangefeedData(ctx, txn, *job.ID())
 }
-func (b *changefeedResumer) OnFailOrCancel(context.Context, *client.Txn, *jobs.Job) error { return nil }
-func (b *changefeedResumer) OnSuccess(context.Context, *client.Txn, *jobs.Job) error      { return nil }
 func (b *changefeedResumer) OnTerminal(
 	context.Context, *jobs.Job, jobs.Status, chan<- tree.Datums,
 ) {
@@ -246,5 +1126,5 @@ func changefeedResumeHook(typ jobspb.Type, _ *cluster.Settings) jobs.Resumer {
 	if typ != jobspb.TypeChangefeed {
 		return nil
 	}
-	return &changefeedResumer{}
+	return &changefeedResumer{status: &changefeedStatus{}}
 }
@@ -0,0 +1,103 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/bufalloc"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+)
+
+// rowCoalescer implements the `coalesce_interval` option. It buffers the rows
+// of a window and collapses all the rows with the same topic and key into the
+// latest one, trading away the intermediate updates to hot rows for a smaller
+// volume of emitted messages.
+//
+// Rows are emitted in the order their keys were first seen in the window. A
+// resolved timestamp can't be emitted while rows below it are still buffered,
+// so one that arrives mid-window is held back and emitted when the window is
+// flushed.
+type rowCoalescer struct {
+	interval time.Duration
+	// fresh is set when the flushed rows outlive the window they were
+	// buffered in, like when they're handed to an asyncSink, which only
+	// releases them once it's flushed. Every window then copies its rows to
+	// fresh storage, instead of reusing the storage of the previous one.
+	fresh bool
+
+	windowStart time.Time
+	rows        []SinkRow
+	idxByKey    map[string]int
+	alloc       bufalloc.ByteAllocator
+
+	// held is the latest resolved timestamp seen while rows were buffered.
+	held hlc.Timestamp
+}
+
+func makeRowCoalescer(interval time.Duration, fresh bool) *rowCoalescer {
+	return &rowCoalescer{
+		interval: interval,
+		fresh:    fresh,
+		idxByKey: make(map[string]int),
+	}
+}
+
+// add buffers a row, replacing any earlier row in the window with the same
+// topic and key. The row's bytes are copied.
+func (c *rowCoalescer) add(row SinkRow, now time.Time) {
+	if len(c.rows) == 0 {
+		c.windowStart = now
+		if c.fresh {
+			c.alloc = nil
+		} else {
+			c.alloc = c.alloc[:0]
+		}
+	}
+	c.alloc, row.Key = c.alloc.Copy(row.Key, 0 /* extraCap */)
+	c.alloc, row.Value = c.alloc.Copy(row.Value, 0 /* extraCap */)
+
+	key := row.Topic + "\x00" + string(row.Key)
+	if idx, ok := c.idxByKey[key]; ok {
+		c.rows[idx] = row
+		return
+	}
+	c.idxByKey[key] = len(c.rows)
+	c.rows = append(c.rows, row)
+}
+
+// holdResolved returns true if the resolved timestamp must be held back
+// because rows are buffered and the window isn't over yet. The latest held
+// timestamp is returned by the next flush.
+func (c *rowCoalescer) holdResolved(resolved hlc.Timestamp, now time.Time) bool {
+	if len(c.rows) == 0 || c.ready(now) {
+		return false
+	}
+	c.held.Forward(resolved)
+	return true
+}
+
+// ready returns whether the window is over and the buffered rows should be
+// flushed.
+func (c *rowCoalescer) ready(now time.Time) bool {
+	return len(c.rows) > 0 && now.Sub(c.windowStart) >= c.interval
+}
+
+// flush returns the buffered rows and any resolved timestamp that was held
+// back, which must be emitted after them. Unless the coalescer is fresh, the
+// returned rows are only valid until the next call to add.
+func (c *rowCoalescer) flush() ([]SinkRow, hlc.Timestamp) {
+	rows, held := c.rows, c.held
+	c.rows = c.rows[:0]
+	c.held = hlc.Timestamp{}
+	for k := range c.idxByKey {
+		delete(c.idxByKey, k)
+	}
+	return rows, held
+}
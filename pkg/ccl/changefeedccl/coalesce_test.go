@@ -0,0 +1,126 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+)
+
+func TestRowCoalescer(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	row := func(topic, key, value string) SinkRow {
+		return SinkRow{Topic: topic, Key: []byte(key), Value: []byte(value)}
+	}
+	rowStrings := func(rows []SinkRow) []string {
+		var s []string
+		for _, r := range rows {
+			s = append(s, fmt.Sprintf(`%s: %s->%s`, r.Topic, r.Key, r.Value))
+		}
+		return s
+	}
+	ts := func(wallTime int64) hlc.Timestamp { return hlc.Timestamp{WallTime: wallTime} }
+
+	start := time.Unix(0, 0)
+	c := makeRowCoalescer(time.Second, false /* fresh */)
+
+	c.add(row(`foo`, `[1]`, `a`), start)
+	c.add(row(`foo`, `[2]`, `b`), start)
+	c.add(row(`bar`, `[1]`, `c`), start)
+	// The latest image replaces the first one, but keeps its position.
+	c.add(row(`foo`, `[1]`, `d`), start.Add(100*time.Millisecond))
+
+	if c.ready(start.Add(999 * time.Millisecond)) {
+		t.Fatal(`expected the window to still be open`)
+	}
+	if !c.holdResolved(ts(1), start.Add(500*time.Millisecond)) {
+		t.Fatal(`expected the resolved timestamp to be held back mid-window`)
+	}
+	if !c.holdResolved(ts(2), start.Add(600*time.Millisecond)) {
+		t.Fatal(`expected the resolved timestamp to be held back mid-window`)
+	}
+	if !c.ready(start.Add(time.Second)) {
+		t.Fatal(`expected the window to be over`)
+	}
+
+	rows, held := c.flush()
+	expected := []string{`foo: [1]->d`, `foo: [2]->b`, `bar: [1]->c`}
+	if actual := rowStrings(rows); !reflect.DeepEqual(expected, actual) {
+		t.Errorf(`expected %v got %v`, expected, actual)
+	}
+	if held != ts(2) {
+		t.Errorf(`expected held resolved timestamp %s got %s`, ts(2), held)
+	}
+
+	// Nothing is buffered, so resolved timestamps go straight through.
+	if c.holdResolved(ts(3), start.Add(1100*time.Millisecond)) {
+		t.Error(`expected the resolved timestamp to not be held with nothing buffered`)
+	}
+
+	// A new window starts with the next row.
+	c.add(row(`foo`, `[1]`, `e`), start.Add(2*time.Second))
+	if c.ready(start.Add(2500 * time.Millisecond)) {
+		t.Fatal(`expected the new window to still be open`)
+	}
+	rows, held = c.flush()
+	if expected, actual := []string{`foo: [1]->e`}, rowStrings(rows); !reflect.DeepEqual(expected, actual) {
+		t.Errorf(`expected %v got %v`, expected, actual)
+	}
+	if held != (hlc.Timestamp{}) {
+		t.Errorf(`expected no held resolved timestamp got %s`, held)
+	}
+}
+
+func TestRowCoalescerAsyncSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	// The messages are acknowledged long after the second window starts.
+	producer := makeFakeAsyncProducer(1 /* numPartitions */, 50*time.Millisecond /* latency */)
+	sink := makeKafkaSink(st, nil /* client */, producer, url.Values{})
+	e := makeAsyncEmitter(sink, MakeMetrics(st, metric.TestSampleInterval).(*Metrics))
+
+	start := time.Unix(0, 0)
+	c := makeRowCoalescer(time.Second, true /* fresh */)
+	for i, value := range []string{`a`, `b`} {
+		now := start.Add(time.Duration(i) * 2 * time.Second)
+		c.add(SinkRow{Topic: `foo`, Key: []byte(`[1]`), Value: []byte(value)}, now)
+		rows, _ := c.flush()
+		if err := e.emit(ctx, rows); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var sent []string
+	for _, m := range producer.sent {
+		key, _ := m.Key.Encode()
+		value, _ := m.Value.Encode()
+		sent = append(sent, fmt.Sprintf(`%s->%s`, key, value))
+	}
+	if expected := []string{`[1]->a`, `[1]->b`}; !reflect.DeepEqual(expected, sent) {
+		t.Errorf(`expected %v got %v`, expected, sent)
+	}
+}
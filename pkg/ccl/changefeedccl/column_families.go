@@ -0,0 +1,111 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/keys"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+	"github.com/cockroachdb/cockroach/pkg/util/encoding"
+)
+
+// Each column family of a row is stored in its own kv, and only the families
+// that changed are written, so a change to a table with several column
+// families only touches some of the kvs of a row. By default, every change is
+// emitted as the whole row: it's read back from kv as of the change. The
+// families of a row changed together (at the same timestamp) are emitted once.
+//
+// With the `split_column_families` option, every changed family is emitted as
+// its own message instead, with the primary key columns and the columns of
+// the family, to a `table.family` topic. This keeps the messages of wide
+// tables small when only some of their families change often.
+
+// decodeRowFamilyKey returns the row prefix of a key of a row, which all the
+// keys of the row share, and the ID of the key's column family.
+func decodeRowFamilyKey(key roachpb.Key) (roachpb.Key, sqlbase.FamilyID, error) {
+	prefixLen, err := keys.GetRowPrefixLength(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	_, familyID, err := encoding.DecodeUvarintAscending(key[prefixLen:])
+	if err != nil {
+		return nil, 0, err
+	}
+	return key[:prefixLen], sqlbase.FamilyID(familyID), nil
+}
+
+type tableFamily struct {
+	tableDesc *sqlbase.TableDescriptor
+	familyID  sqlbase.FamilyID
+}
+
+// familyProjector turns whole rows into the rows of one of their column
+// families for the `split_column_families` option.
+type familyProjector struct {
+	// descs is the descriptor of every family of every table seen, see
+	// familyDesc.
+	descs map[tableFamily]*sqlbase.TableDescriptor
+	// colIdxs are the indexes, in the columns of the table, of the columns of
+	// every family descriptor.
+	colIdxs map[*sqlbase.TableDescriptor][]int
+}
+
+func makeFamilyProjector() *familyProjector {
+	return &familyProjector{
+		descs:   make(map[tableFamily]*sqlbase.TableDescriptor),
+		colIdxs: make(map[*sqlbase.TableDescriptor][]int),
+	}
+}
+
+// familyDesc returns a descriptor for the rows of one family of a table. It
+// only has the primary key columns and the columns of the family, and its name
+// is `table.family`, so that the encoders and the topic of the rows follow.
+func (p *familyProjector) familyDesc(
+	tableDesc *sqlbase.TableDescriptor, family *sqlbase.ColumnFamilyDescriptor,
+) *sqlbase.TableDescriptor {
+	tf := tableFamily{tableDesc: tableDesc, familyID: family.ID}
+	if desc, ok := p.descs[tf]; ok {
+		return desc
+	}
+	include := make(map[sqlbase.ColumnID]struct{})
+	for _, colID := range tableDesc.PrimaryIndex.ColumnIDs {
+		include[colID] = struct{}{}
+	}
+	for _, colID := range family.ColumnIDs {
+		include[colID] = struct{}{}
+	}
+	desc := *tableDesc
+	desc.Name = tableDesc.Name + `.` + family.Name
+	desc.Columns = nil
+	desc.Families = []sqlbase.ColumnFamilyDescriptor{*family}
+	var colIdxs []int
+	for i, col := range tableDesc.Columns {
+		if _, ok := include[col.ID]; ok {
+			desc.Columns = append(desc.Columns, col)
+			colIdxs = append(colIdxs, i)
+		}
+	}
+	p.descs[tf] = &desc
+	p.colIdxs[&desc] = colIdxs
+	return &desc
+}
+
+// project returns the datums of the columns of familyDesc, as returned by
+// familyDesc, from the datums of a whole row.
+func (p *familyProjector) project(
+	familyDesc *sqlbase.TableDescriptor, datums tree.Datums,
+) tree.Datums {
+	colIdxs := p.colIdxs[familyDesc]
+	projected := make(tree.Datums, len(colIdxs))
+	for i, colIdx := range colIdxs {
+		projected[i] = datums[colIdx]
+	}
+	return projected
+}
@@ -0,0 +1,261 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"encoding/binary"
+	gojson "encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/pkg/errors"
+)
+
+// ConsumeOptions controls what ConsumeKafka reads.
+type ConsumeOptions struct {
+	// Topics are the topics to read, as named by the changefeed. The
+	// `topic_prefix` of the sink URI, if any, is added to them.
+	Topics []string
+	// FromBeginning reads the topics from their oldest messages instead of only
+	// the ones produced from now on.
+	FromBeginning bool
+	// SchemaRegistry is the `confluent_schema_registry` of the changefeed, to
+	// decode messages in `format=experimental_avro`.
+	SchemaRegistry string
+	// MaxMessages stops after this many messages, if positive.
+	MaxMessages int
+}
+
+// ConsumeKafka reads changefeed messages from the kafka sink at sinkURI, with
+// the same sink parameters as the changefeed, and writes them to w, one per
+// line, until ctx is done or opts.MaxMessages have been read. It's intended to
+// check that a changefeed works end-to-end, not for production use: messages
+// of different partitions are interleaved in the order they arrive.
+func ConsumeKafka(ctx context.Context, sinkURI string, opts ConsumeOptions, w io.Writer) error {
+	u, err := url.Parse(sinkURI)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != sinkSchemeKafka {
+		return errors.Errorf(`unsupported sink: %s`, u.Scheme)
+	}
+	if len(opts.Topics) == 0 {
+		return errors.New(`at least one topic is required`)
+	}
+	config, err := makeKafkaConfig(u.Query())
+	if err != nil {
+		return err
+	}
+	formatter, err := makeMessageFormatter(opts.SchemaRegistry)
+	if err != nil {
+		return err
+	}
+
+	client, err := sarama.NewClient(strings.Split(u.Host, `,`), config)
+	if err != nil {
+		return errors.Wrapf(err, `connecting to kafka: %s`, u.Host)
+	}
+	defer func() { _ = client.Close() }()
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return errors.Wrapf(err, `connecting to kafka: %s`, u.Host)
+	}
+	defer func() { _ = consumer.Close() }()
+
+	offset := sarama.OffsetNewest
+	if opts.FromBeginning {
+		offset = sarama.OffsetOldest
+	}
+	done := make(chan struct{})
+	defer close(done)
+	messages := make(chan *sarama.ConsumerMessage)
+	for _, topic := range opts.Topics {
+		topic = u.Query().Get(sinkParamTopicPrefix) + topic
+		partitions, err := consumer.Partitions(topic)
+		if err != nil {
+			return errors.Wrapf(err, `reading partitions of %s`, topic)
+		}
+		for _, partition := range partitions {
+			pc, err := consumer.ConsumePartition(topic, partition, offset)
+			if err != nil {
+				return errors.Wrapf(err, `consuming partition %d of %s`, partition, topic)
+			}
+			defer func() { _ = pc.Close() }()
+			go func() {
+				for m := range pc.Messages() {
+					select {
+					case messages <- m:
+					case <-done:
+						return
+					}
+				}
+			}()
+		}
+	}
+
+	for n := 0; opts.MaxMessages <= 0 || n < opts.MaxMessages; n++ {
+		var m *sarama.ConsumerMessage
+		select {
+		case <-ctx.Done():
+			return nil
+		case m = <-messages:
+		}
+		key, err := formatter.format(ctx, m.Key)
+		if err != nil {
+			return errors.Wrapf(err, `decoding key at offset %d of partition %d of %s`,
+				m.Offset, m.Partition, m.Topic)
+		}
+		value, err := formatter.format(ctx, m.Value)
+		if err != nil {
+			return errors.Wrapf(err, `decoding value at offset %d of partition %d of %s`,
+				m.Offset, m.Partition, m.Topic)
+		}
+		if _, err := fmt.Fprintf(w, "%s[%d]@%d: %s -> %s\n",
+			m.Topic, m.Partition, m.Offset, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// messageFormatter turns the keys and values of changefeed messages into
+// readable text. JSON is passed through, confluent avro is decoded with the
+// schema it was written with into the JSON it mirrors, and anything else is
+// quoted.
+type messageFormatter struct {
+	registry *schemaRegistryConn
+	// schemas are the parsed avro schemas read from the registry by ID.
+	schemas map[int32]interface{}
+}
+
+func makeMessageFormatter(registryURI string) (*messageFormatter, error) {
+	f := &messageFormatter{schemas: make(map[int32]interface{})}
+	if registryURI != `` {
+		var err error
+		if f.registry, err = makeSchemaRegistryConn(registryURI); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (f *messageFormatter) format(ctx context.Context, b []byte) (string, error) {
+	if b == nil {
+		return `null`, nil
+	}
+	if f.registry != nil && len(b) >= 5 && b[0] == confluentAvroWireFormatMagic {
+		id := int32(binary.BigEndian.Uint32(b[1:5]))
+		schema, ok := f.schemas[id]
+		if !ok {
+			var res struct {
+				Schema string `json:"schema"`
+			}
+			if err := f.registry.do(
+				ctx, `GET`, fmt.Sprintf(`schemas/ids/%d`, id), nil, &res,
+			); err != nil {
+				return ``, errors.Wrapf(err, `fetching schema %d`, id)
+			}
+			if err := gojson.Unmarshal([]byte(res.Schema), &schema); err != nil {
+				return ``, errors.Wrapf(err, `parsing schema %d`, id)
+			}
+			f.schemas[id] = schema
+		}
+		v, rest, err := avroDecodeGeneric(schema, b[5:])
+		if err != nil {
+			return ``, err
+		}
+		if len(rest) != 0 {
+			return ``, errors.Errorf(`%d trailing bytes after avro record`, len(rest))
+		}
+		j, err := gojson.Marshal(v)
+		return string(j), err
+	}
+	if gojson.Valid(b) {
+		return string(b), nil
+	}
+	return strconv.Quote(string(b)), nil
+}
+
+// avroDecodeGeneric decodes the avro binary encoding of a value with the given
+// schema, as parsed from its JSON, into the value that its JSON form would
+// decode to. Dates and timestamps are formatted as strings. It only supports
+// the schemas that changefeeds write.
+func avroDecodeGeneric(schema interface{}, buf []byte) (interface{}, []byte, error) {
+	switch s := schema.(type) {
+	case []interface{}:
+		branch, buf, err := avroReadLong(buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		if branch < 0 || branch >= int64(len(s)) {
+			return nil, nil, errors.Errorf(`unknown union branch %d`, branch)
+		}
+		return avroDecodeGeneric(s[branch], buf)
+	case map[string]interface{}:
+		if s[`type`] == avroSchemaRecord {
+			fields, _ := s[`fields`].([]interface{})
+			record := make(map[string]interface{}, len(fields))
+			for _, field := range fields {
+				field, _ := field.(map[string]interface{})
+				name, _ := field[`name`].(string)
+				var err error
+				if record[name], buf, err = avroDecodeGeneric(field[`type`], buf); err != nil {
+					return nil, nil, errors.Wrapf(err, `field %s`, name)
+				}
+			}
+			return record, buf, nil
+		}
+		v, buf, err := avroDecodeGeneric(s[`type`], buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch s[`logicalType`] {
+		case `date`:
+			if days, ok := v.(int64); ok {
+				return timeutil.Unix(days*24*60*60, 0).Format(`2006-01-02`), buf, nil
+			}
+		case `timestamp-micros`:
+			if micros, ok := v.(int64); ok {
+				t := timeutil.Unix(0, micros*int64(time.Microsecond))
+				return t.Format(time.RFC3339Nano), buf, nil
+			}
+		}
+		return v, buf, nil
+	case string:
+		switch s {
+		case avroSchemaNull:
+			return nil, buf, nil
+		case avroSchemaBoolean:
+			if len(buf) < 1 {
+				return nil, nil, errors.New(`unexpected end of avro boolean`)
+			}
+			return buf[0] != 0, buf[1:], nil
+		case avroSchemaInt, avroSchemaLong:
+			i, buf, err := avroReadLong(buf)
+			return i, buf, err
+		case avroSchemaDouble:
+			if len(buf) < 8 {
+				return nil, nil, errors.New(`unexpected end of avro double`)
+			}
+			return math.Float64frombits(binary.LittleEndian.Uint64(buf[:8])), buf[8:], nil
+		case avroSchemaString, avroSchemaBytes:
+			b, buf, err := avroReadBytes(buf)
+			return string(b), buf, err
+		}
+	}
+	return nil, nil, errors.Errorf(`unsupported avro schema: %v`, schema)
+}
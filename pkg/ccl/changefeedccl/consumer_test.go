@@ -0,0 +1,87 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	gojson "encoding/json"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+)
+
+func TestAvroDecodeGeneric(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	tableDesc := &sqlbase.TableDescriptor{
+		Name: `foo`,
+		Columns: []sqlbase.ColumnDescriptor{
+			{ID: 1, Name: `a`, Type: sqlbase.ColumnType{SemanticType: sqlbase.ColumnType_INT}},
+			{ID: 2, Name: `b`, Type: sqlbase.ColumnType{SemanticType: sqlbase.ColumnType_STRING}},
+			{ID: 3, Name: `c`, Type: sqlbase.ColumnType{SemanticType: sqlbase.ColumnType_DATE}},
+			{ID: 4, Name: `d`, Type: sqlbase.ColumnType{SemanticType: sqlbase.ColumnType_BOOL}},
+		},
+		PrimaryIndex: sqlbase.IndexDescriptor{ColumnIDs: []sqlbase.ColumnID{1}},
+	}
+	record, err := tableToAvroSchema(tableDesc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record.appendUpdatedField()
+	schemaJSON, err := record.schemaJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema interface{}
+	if err := gojson.Unmarshal([]byte(schemaJSON), &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	row := tree.Datums{tree.NewDInt(1), tree.NewDString(`x`), tree.NewDDate(1), tree.DNull}
+	buf, err := record.BinaryFromRow(nil, row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf = record.BinaryFromUpdated(buf, hlc.Timestamp{WallTime: 2})
+
+	v, rest, err := avroDecodeGeneric(schema, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 0 {
+		t.Fatalf(`expected no trailing bytes got %d`, len(rest))
+	}
+	actual, err := gojson.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"__crdb__":{"updated":"2.0000000000"},"a":1,"b":"x","c":"1970-01-02","d":null}`
+	if string(actual) != expected {
+		t.Errorf("expected\n  %s\ngot\n  %s", expected, actual)
+	}
+
+	// Without a registry, JSON is passed through and anything else is quoted.
+	f, err := makeMessageFormatter(``)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for input, expected := range map[string]string{
+		`{"a": 1}`: `{"a": 1}`,
+		"\x00abc":  `"\x00abc"`,
+	} {
+		if actual, err := f.format(context.Background(), []byte(input)); err != nil {
+			t.Fatal(err)
+		} else if actual != expected {
+			t.Errorf(`expected %s got %s`, expected, actual)
+		}
+	}
+}
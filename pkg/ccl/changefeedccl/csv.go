@@ -0,0 +1,192 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/pkg/errors"
+)
+
+// csvUpdatedColumn is the name of the extra column holding the updated
+// timestamp of each row when the `updated` or `timestamps` option is set.
+const csvUpdatedColumn = jsonMetaSentinel + `updated`
+
+// fileFormatEncoder is implemented by Encoders that need something other than
+// the default newline delimited json layout when written to files by the cloud
+// storage sink.
+type fileFormatEncoder interface {
+	// FileExtension is the extension, without the dot, of files of rows.
+	FileExtension() string
+	// FileHeader returns what's written at the start of every file of rows for
+	// the given topic. It may be nil.
+	FileHeader(topic string) []byte
+}
+
+// csvEncoder encodes changefeed entries as CSV records, for export-style
+// changefeeds into cloud storage. Keys are the primary key columns. Values are
+// every column, followed by the updated timestamp if the `updated` or
+// `timestamps` option is set. Deletions, which have no value, are written to files as their key.
+// Resolved timestamp payloads are the bare timestamp.
+//
+// The `delimiter` and `nullas` options work as they do for EXPORT. With the
+// `header` option, every file of rows starts with the column names of the
+// table it holds, or of its primary key with `envelope=key_only`.
+type csvEncoder struct {
+	nullAs       string
+	header       bool
+	updatedField bool
+	keyOnly      bool
+
+	// headers is the header for each topic, as of the most recently encoded
+	// row, and headerVersions the table descriptor it was computed from.
+	//
+	// TODO: A schema change in the middle of a file could result in rows
+	// that don't match the header of that file. Start a new file instead.
+	headers        map[string][]byte
+	headerVersions map[string]tableIDAndVersion
+
+	buf    bytes.Buffer
+	w      *csv.Writer
+	record []string
+}
+
+var _ Encoder = &csvEncoder{}
+var _ fileFormatEncoder = &csvEncoder{}
+
+func makeCSVEncoder(details jobspb.ChangefeedDetails) (*csvEncoder, error) {
+	_, header := details.Opts[optHeader]
+	updatedField := hasUpdatedField(details.Opts)
+	e := &csvEncoder{
+		nullAs:         details.Opts[optNullAs],
+		header:         header,
+		updatedField:   updatedField,
+		keyOnly:        envelopeType(details.Opts[optEnvelope]) == optEnvelopeKeyOnly,
+		headers:        make(map[string][]byte),
+		headerVersions: make(map[string]tableIDAndVersion),
+	}
+	e.w = csv.NewWriter(&e.buf)
+	if d, ok := details.Opts[optDelimiter]; ok {
+		var err error
+		if e.w.Comma, err = util.GetSingleRune(d); err != nil {
+			return nil, errors.Wrapf(err, `invalid %s`, optDelimiter)
+		}
+	}
+	return e, nil
+}
+
+// EncodeKey implements the Encoder interface.
+func (e *csvEncoder) EncodeKey(_ context.Context, row encodeRow) ([]byte, error) {
+	if e.header && e.keyOnly {
+		if err := e.updateHeader(row); err != nil {
+			return nil, err
+		}
+	}
+	colIdxByID := row.tableDesc.ColumnIdxMap()
+	e.record = e.record[:0]
+	for _, colID := range row.tableDesc.PrimaryIndex.ColumnIDs {
+		idx, ok := colIdxByID[colID]
+		if !ok {
+			return nil, errors.Errorf(`unknown column id: %d`, colID)
+		}
+		e.record = append(e.record, e.formatDatum(row.datums[idx]))
+	}
+	return e.encodeRecord()
+}
+
+// EncodeValue implements the Encoder interface.
+func (e *csvEncoder) EncodeValue(_ context.Context, row encodeRow) ([]byte, error) {
+	if row.deleted {
+		return nil, nil
+	}
+	if e.header {
+		if err := e.updateHeader(row); err != nil {
+			return nil, err
+		}
+	}
+
+	e.record = e.record[:0]
+	for _, d := range row.datums {
+		e.record = append(e.record, e.formatDatum(d))
+	}
+	if e.updatedField {
+		e.record = append(e.record, tree.TimestampToDecimal(row.updated).Decimal.String())
+	}
+	return e.encodeRecord()
+}
+
+// EncodeResolvedTimestamp implements the Encoder interface.
+func (e *csvEncoder) EncodeResolvedTimestamp(
+	_ context.Context, resolved hlc.Timestamp,
+) ([]byte, error) {
+	return []byte(tree.TimestampToDecimal(resolved).Decimal.String()), nil
+}
+
+func (e *csvEncoder) updateHeader(row encodeRow) error {
+	topic := row.topic
+	version := tableIDAndVersion{id: row.tableDesc.ID, version: row.tableDesc.Version}
+	if prev, ok := e.headerVersions[topic]; ok && prev == version {
+		return nil
+	}
+	e.record = e.record[:0]
+	if e.keyOnly {
+		e.record = append(e.record, row.tableDesc.PrimaryIndex.ColumnNames...)
+	} else {
+		for _, col := range row.tableDesc.Columns {
+			e.record = append(e.record, col.Name)
+		}
+		if e.updatedField {
+			e.record = append(e.record, csvUpdatedColumn)
+		}
+	}
+	header, err := e.encodeRecord()
+	if err != nil {
+		return err
+	}
+	e.headers[topic] = append(append([]byte(nil), header...), '\n')
+	e.headerVersions[topic] = version
+	return nil
+}
+
+// FileExtension implements the fileFormatEncoder interface.
+func (e *csvEncoder) FileExtension() string {
+	return `csv`
+}
+
+// FileHeader implements the fileFormatEncoder interface.
+func (e *csvEncoder) FileHeader(topic string) []byte {
+	return e.headers[topic]
+}
+
+func (e *csvEncoder) formatDatum(d tree.Datum) string {
+	if d == tree.DNull {
+		return e.nullAs
+	}
+	return tree.AsStringWithFlags(d, tree.FmtParseDatums)
+}
+
+// encodeRecord returns e.record as a CSV record without the trailing newline,
+// which the sink adds.
+func (e *csvEncoder) encodeRecord() ([]byte, error) {
+	e.buf.Reset()
+	if err := e.w.Write(e.record); err != nil {
+		return nil, err
+	}
+	e.w.Flush()
+	if err := e.w.Error(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(e.buf.Bytes(), []byte{'\n'}), nil
+}
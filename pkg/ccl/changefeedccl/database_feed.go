@@ -0,0 +1,190 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/cockroachdb/cockroach/pkg/internal/client"
+	"github.com/cockroachdb/cockroach/pkg/keys"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+)
+
+// A changefeed `FOR DATABASE d` watches every table in the database, including
+// the ones created after the changefeed, instead of only the ones that exist
+// when it's created. Each table is emitted to its own topic, as usual. A
+// changefeed `FOR ALL TABLES` likewise watches every table of every database
+// other than the system one, including databases created later, for
+// replicating a whole cluster downstream. Its topics are the fully qualified
+// names of the tables, as with `full_table_name`, since tables in different
+// databases may have the same name. The watched databases are kept in the
+// changefeed's details.
+//
+// The set of tables is looked up as of the high-water mark whenever the
+// changefeed starts. While it runs, every poll checks whether a table was
+// created in (or renamed into) a watched database, in which case the poll ends
+// right when it appeared and the changefeed restarts with the new set of
+// tables, from there. Tables that are dropped from (or renamed out of) a
+// watched database are likewise left out once every change to them has been
+// emitted.
+//
+// TODO: The rows written by the transaction that creates a table, like
+// with CREATE TABLE AS, have the same timestamp the table appears at, so
+// they're not emitted.
+
+// targetsChangedError is returned by the poll of a changefeed over whole
+// databases once it has emitted every change up to ts, the first time the set
+// of tables in the databases changed.
+type targetsChangedError struct {
+	ts hlc.Timestamp
+}
+
+func (e *targetsChangedError) Error() string {
+	return fmt.Sprintf(`the tables of the watched databases changed at %s`, e.ts)
+}
+
+// databaseWatch is the set of databases a changefeed watches every table of.
+type databaseWatch struct {
+	// all is set for a changefeed over every user table, in which case ids is
+	// nil.
+	all bool
+	ids map[sqlbase.ID]struct{}
+}
+
+// watchedDatabases returns the databases watched by a changefeed, or nil if it
+// doesn't watch whole databases.
+func watchedDatabases(details jobspb.ChangefeedDetails) *databaseWatch {
+	if details.WatchAllDatabases {
+		return &databaseWatch{all: true}
+	}
+	if len(details.WatchedDatabaseIDs) == 0 {
+		return nil
+	}
+	w := &databaseWatch{ids: make(map[sqlbase.ID]struct{}, len(details.WatchedDatabaseIDs))}
+	for _, id := range details.WatchedDatabaseIDs {
+		w.ids[id] = struct{}{}
+	}
+	return w
+}
+
+// setDetails is the inverse of watchedDatabases.
+func (w *databaseWatch) setDetails(details *jobspb.ChangefeedDetails) {
+	details.WatchAllDatabases = w.all
+	details.WatchedDatabaseIDs = nil
+	for id := range w.ids {
+		details.WatchedDatabaseIDs = append(details.WatchedDatabaseIDs, id)
+	}
+	sort.Slice(details.WatchedDatabaseIDs, func(i, j int) bool {
+		return details.WatchedDatabaseIDs[i] < details.WatchedDatabaseIDs[j]
+	})
+}
+
+// watches returns whether the tables of a database are watched.
+func (w *databaseWatch) watches(dbID sqlbase.ID) bool {
+	if w.all {
+		return dbID != keys.SystemDatabaseID
+	}
+	_, ok := w.ids[dbID]
+	return ok
+}
+
+// tablesInDatabases returns the descriptors, as of ts, of the tables that a
+// changefeed over the given databases watches. Views and sequences, which
+// don't have rows of their own, aren't watched.
+func tablesInDatabases(
+	ctx context.Context, db *client.DB, ts hlc.Timestamp, w *databaseWatch,
+) ([]sqlbase.TableDescriptor, error) {
+	var tableDescs []sqlbase.TableDescriptor
+	if err := db.Txn(ctx, func(ctx context.Context, txn *client.Txn) error {
+		tableDescs = nil
+		txn.SetFixedTimestamp(ctx, ts)
+		descs, err := sql.GetAllDescriptors(ctx, txn)
+		if err != nil {
+			return err
+		}
+		for _, desc := range descs {
+			tableDesc, ok := desc.(*sqlbase.TableDescriptor)
+			if !ok || !tableDesc.IsTable() || tableDesc.Dropped() {
+				continue
+			}
+			if w.watches(tableDesc.ParentID) {
+				tableDescs = append(tableDescs, *tableDesc)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(tableDescs, func(i, j int) bool { return tableDescs[i].ID < tableDescs[j].ID })
+	return tableDescs, nil
+}
+
+// nextTargetsChange returns the first change, in (start, end], to the set of
+// tables in the given databases, which were tableDescs as of start, or nil if
+// there's none. A table that's left the databases doesn't need the poll to end
+// early, since it has no changes after it left that would be missed, so it
+// only ends the poll at end. A table that's appeared ends the poll when it
+// appeared, so that the changefeed restarts in time to emit all of its
+// changes.
+func nextTargetsChange(
+	ctx context.Context,
+	execCfg *sql.ExecutorConfig,
+	w *databaseWatch,
+	tableDescs []sqlbase.TableDescriptor,
+	start, end hlc.Timestamp,
+) (*targetsChangedError, error) {
+	cur, err := tablesInDatabases(ctx, execCfg.DB, end, w)
+	if err != nil {
+		return nil, err
+	}
+	watched := make(map[sqlbase.ID]struct{}, len(tableDescs))
+	for i := range tableDescs {
+		watched[tableDescs[i].ID] = struct{}{}
+	}
+
+	var first *targetsChangedError
+	for i := range cur {
+		if _, ok := watched[cur[i].ID]; ok {
+			delete(watched, cur[i].ID)
+			continue
+		}
+		// Walk back through the versions of the table to when it appeared in
+		// the databases.
+		appeared, err := tableDescAt(ctx, execCfg.LeaseManager, end, cur[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		for appeared.Version > 1 && start.Less(appeared.ModificationTime) {
+			prev, err := tableDescAt(ctx, execCfg.LeaseManager, appeared.ModificationTime.Prev(), cur[i].ID)
+			if err != nil {
+				return nil, err
+			}
+			if !w.watches(prev.ParentID) || prev.Dropped() {
+				break
+			}
+			appeared = prev
+		}
+		ts := appeared.ModificationTime
+		if ts.Less(start) {
+			ts = start
+		}
+		if first == nil || ts.Less(first.ts) {
+			first = &targetsChangedError{ts: ts}
+		}
+	}
+	if first == nil && len(watched) > 0 {
+		first = &targetsChangedError{ts: end}
+	}
+	return first, nil
+}
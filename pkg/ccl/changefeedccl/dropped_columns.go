@@ -0,0 +1,196 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+	"github.com/cockroachdb/cockroach/pkg/util/mon"
+)
+
+// maxLastKnownBytes is the most memory that `dropped_columns='last_known'`
+// remembers the values of rows in. Its memory is accounted for in the
+// changefeed's monitor, see changefeedBuffer, so this leaves most of the
+// monitor's limit for the buffer.
+const maxLastKnownBytes = 16 << 20 // 16 MiB
+
+// lastKnownColumnOverhead is the memory that a value in lastKnown is
+// accounted for, besides the bytes of a string value.
+const lastKnownColumnOverhead = 64
+
+// droppedColumns fills in columns that were present in a table when the
+// changefeed was created but have since been dropped. Without it, a consumer
+// holding a schema from before the drop would see the column vanish, which
+// breaks forward compatibility in schema registries.
+//
+// With `dropped_columns='last_known'`, the last values a changefeed emitted
+// for the rows are only kept in memory, up to maxLastKnownBytes. They're lost
+// whenever the changefeed restarts, including when it's retried after a
+// transient error, and the rows that aren't remembered, because they weren't
+// emitted since the changefeed last started or because the memory ran out,
+// have their dropped columns emitted as nulls, like with
+// `dropped_columns='null'`.
+type droppedColumns struct {
+	typ droppedColumnsType
+	// tableDescs are the descriptors that the changefeed was created with.
+	tableDescs map[sqlbase.ID]*sqlbase.TableDescriptor
+	// lastKnown is the most recently emitted value of each column for each
+	// key, indexed by table and then by the encoded key. It's only populated
+	// for `dropped_columns='last_known'`.
+	lastKnown map[sqlbase.ID]map[string]*lastKnownRow
+	// bytes is the memory that lastKnown is accounted for, which is also
+	// accounted for in acc, if it's set.
+	bytes int64
+	acc   *mon.BoundAccount
+}
+
+// lastKnownRow is the most recently emitted value of each column of a row.
+type lastKnownRow struct {
+	values map[sqlbase.ColumnID]interface{}
+	// size is the memory the row is accounted for.
+	size int64
+}
+
+func makeDroppedColumns(
+	typ droppedColumnsType, tableDescs []sqlbase.TableDescriptor,
+) *droppedColumns {
+	d := &droppedColumns{
+		typ:        typ,
+		tableDescs: make(map[sqlbase.ID]*sqlbase.TableDescriptor, len(tableDescs)),
+	}
+	for i := range tableDescs {
+		d.tableDescs[tableDescs[i].ID] = &tableDescs[i]
+	}
+	if typ == optDroppedColumnsLastKnown {
+		d.lastKnown = make(map[sqlbase.ID]map[string]*lastKnownRow)
+	}
+	return d
+}
+
+// setMemoryAccount accounts for the memory of the remembered rows in acc.
+func (d *droppedColumns) setMemoryAccount(acc *mon.BoundAccount) {
+	d.acc = acc
+}
+
+// fill adds any dropped columns to jsonValueRaw, which must contain the json
+// value of every column in `tableDesc` (the descriptor that was valid at the
+// row's timestamp). `key` is the encoded key of the row.
+func (d *droppedColumns) fill(
+	ctx context.Context,
+	tableDesc *sqlbase.TableDescriptor,
+	key []byte,
+	deleted bool,
+	jsonValueRaw map[string]interface{},
+) {
+	if d.typ == optDroppedColumnsOmit {
+		return
+	}
+	origDesc, ok := d.tableDescs[tableDesc.ID]
+	if !ok {
+		return
+	}
+
+	var lastKnown *lastKnownRow
+	var byKey map[string]*lastKnownRow
+	if d.typ == optDroppedColumnsLastKnown {
+		byKey, ok = d.lastKnown[tableDesc.ID]
+		if !ok {
+			byKey = make(map[string]*lastKnownRow)
+			d.lastKnown[tableDesc.ID] = byKey
+		}
+		lastKnown = byKey[string(key)]
+		if deleted {
+			if lastKnown != nil {
+				d.forget(ctx, byKey, key, lastKnown)
+			}
+			return
+		}
+	}
+
+	// values are the values of the row to remember, if it's remembered.
+	var values map[sqlbase.ColumnID]interface{}
+	if byKey != nil {
+		values = make(map[sqlbase.ColumnID]interface{}, len(origDesc.Columns))
+	}
+	for i := range origDesc.Columns {
+		col := &origDesc.Columns[i]
+		if current, err := tableDesc.FindActiveColumnByID(col.ID); err == nil {
+			// The column still exists, though possibly under a new name.
+			if values != nil {
+				values[col.ID] = jsonValueRaw[current.Name]
+			}
+			continue
+		}
+		var value interface{}
+		if lastKnown != nil {
+			value = lastKnown.values[col.ID]
+		}
+		if values != nil {
+			values[col.ID] = value
+		}
+		if _, ok := jsonValueRaw[col.Name]; ok {
+			// A new column has since been added with the same name as the
+			// dropped one. Prefer the live column.
+			continue
+		}
+		// NB: This is nil (and so emitted as a json null) if there is no
+		// last known value.
+		jsonValueRaw[col.Name] = value
+	}
+	if byKey != nil {
+		d.remember(ctx, byKey, key, lastKnown, values)
+	}
+}
+
+// remember replaces the remembered values of the row with the given key,
+// which were prev, if any. The row is forgotten instead if there's no memory
+// left for it.
+func (d *droppedColumns) remember(
+	ctx context.Context,
+	byKey map[string]*lastKnownRow,
+	key []byte,
+	prev *lastKnownRow,
+	values map[sqlbase.ColumnID]interface{},
+) {
+	size := int64(len(key))
+	for _, value := range values {
+		size += lastKnownColumnOverhead
+		if s, ok := value.(string); ok {
+			size += int64(len(s))
+		}
+	}
+	var prevSize int64
+	if prev != nil {
+		prevSize = prev.size
+	}
+	if delta := size - prevSize; delta > 0 {
+		if d.bytes+delta > maxLastKnownBytes || (d.acc != nil && d.acc.Grow(ctx, delta) != nil) {
+			if prev != nil {
+				d.forget(ctx, byKey, key, prev)
+			}
+			return
+		}
+	} else if d.acc != nil {
+		d.acc.Shrink(ctx, -delta)
+	}
+	d.bytes += size - prevSize
+	byKey[string(key)] = &lastKnownRow{values: values, size: size}
+}
+
+// forget forgets the remembered values of the row with the given key.
+func (d *droppedColumns) forget(
+	ctx context.Context, byKey map[string]*lastKnownRow, key []byte, row *lastKnownRow,
+) {
+	delete(byKey, string(key))
+	d.bytes -= row.size
+	if d.acc != nil {
+		d.acc.Shrink(ctx, row.size)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+)
+
+func TestDroppedColumns(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	before := sqlbase.TableDescriptor{
+		ID: 52,
+		Columns: []sqlbase.ColumnDescriptor{
+			{ID: 1, Name: `a`}, {ID: 2, Name: `b`}, {ID: 3, Name: `c`},
+		},
+	}
+	// `b` was dropped and `c` was renamed to `d`.
+	after := sqlbase.TableDescriptor{
+		ID: 52,
+		Columns: []sqlbase.ColumnDescriptor{
+			{ID: 1, Name: `a`}, {ID: 3, Name: `d`},
+		},
+	}
+
+	type kv = map[string]interface{}
+	fill := func(
+		d *droppedColumns, desc *sqlbase.TableDescriptor, key string, deleted bool, value kv,
+	) kv {
+		d.fill(context.Background(), desc, []byte(key), deleted, value)
+		return value
+	}
+	assertValue := func(t *testing.T, expected, actual kv) {
+		t.Helper()
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf(`expected %v got %v`, expected, actual)
+		}
+	}
+
+	t.Run(`omit`, func(t *testing.T) {
+		d := makeDroppedColumns(optDroppedColumnsOmit, []sqlbase.TableDescriptor{before})
+		fill(d, &before, `1`, false, kv{`a`: 1, `b`: 2, `c`: 3})
+		assertValue(t, kv{`a`: 1, `d`: 3}, fill(d, &after, `1`, false, kv{`a`: 1, `d`: 3}))
+	})
+	t.Run(`null`, func(t *testing.T) {
+		d := makeDroppedColumns(optDroppedColumnsNull, []sqlbase.TableDescriptor{before})
+		assertValue(t, kv{`a`: 1, `b`: 2, `c`: 3},
+			fill(d, &before, `1`, false, kv{`a`: 1, `b`: 2, `c`: 3}))
+		assertValue(t, kv{`a`: 1, `b`: nil, `d`: 3},
+			fill(d, &after, `1`, false, kv{`a`: 1, `d`: 3}))
+	})
+	t.Run(`last_known`, func(t *testing.T) {
+		d := makeDroppedColumns(optDroppedColumnsLastKnown, []sqlbase.TableDescriptor{before})
+		fill(d, &before, `1`, false, kv{`a`: 1, `b`: 2, `c`: 3})
+		fill(d, &before, `2`, false, kv{`a`: 2, `b`: 4, `c`: 5})
+		assertValue(t, kv{`a`: 1, `b`: 2, `d`: 6},
+			fill(d, &after, `1`, false, kv{`a`: 1, `d`: 6}))
+		// A key that was never seen before the drop has no last known value.
+		assertValue(t, kv{`a`: 3, `b`: nil, `d`: 7},
+			fill(d, &after, `3`, false, kv{`a`: 3, `d`: 7}))
+		// Deletes forget the last known value.
+		fill(d, &after, `2`, true, kv{`a`: 2})
+		assertValue(t, kv{`a`: 2, `b`: nil, `d`: 8},
+			fill(d, &after, `2`, false, kv{`a`: 2, `d`: 8}))
+	})
+	t.Run(`last_known memory`, func(t *testing.T) {
+		d := makeDroppedColumns(optDroppedColumnsLastKnown, []sqlbase.TableDescriptor{before})
+		big := strings.Repeat(`x`, maxLastKnownBytes/4)
+		for i := 0; i < 4; i++ {
+			fill(d, &before, strconv.Itoa(i), false, kv{`a`: i, `b`: big, `c`: i})
+		}
+		if d.bytes > maxLastKnownBytes {
+			t.Fatalf(`expected at most %d bytes remembered got %d`, maxLastKnownBytes, d.bytes)
+		}
+		// The row that didn't fit isn't remembered, as if it had never been
+		// emitted.
+		assertValue(t, kv{`a`: 0, `b`: big, `d`: 1}, fill(d, &after, `0`, false, kv{`a`: 0, `d`: 1}))
+		assertValue(t, kv{`a`: 3, `b`: nil, `d`: 1}, fill(d, &after, `3`, false, kv{`a`: 3, `d`: 1}))
+		// Deletes release the memory of the rows they forget.
+		for i := 0; i < 4; i++ {
+			fill(d, &after, strconv.Itoa(i), true, kv{`a`: i})
+		}
+		if d.bytes != 0 {
+			t.Errorf(`expected no bytes remembered got %d`, d.bytes)
+		}
+	})
+}
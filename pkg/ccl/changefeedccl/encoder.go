@@ -0,0 +1,778 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	gojson "encoding/json"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/json"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/mon"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/pkg/errors"
+)
+
+// encodeRow holds all the pieces necessary to encode a row change into a key
+// or value.
+type encodeRow struct {
+	// topic is the name of the topic the row goes to, without the sink's
+	// `topic_prefix`. tableName is the name of the row's table, which is
+	// usually the same, see topicNamer.
+	topic, tableName string
+	// datums is the new value of a changed table row.
+	datums tree.Datums
+	// updated is the mvcc timestamp corresponding to the latest update in
+	// `datums`.
+	updated hlc.Timestamp
+	// mvccTimestamp is the mvcc timestamp of the version of the row that
+	// `datums` was read from. Every row is currently read at the version it
+	// was written, so this is the same as `updated`, but the `mvcc_timestamp`
+	// option promises the version's timestamp specifically, even if (for
+	// example) initial scans start emitting their rows at the scan timestamp.
+	mvccTimestamp hlc.Timestamp
+	// deleted is true if row is a deletion. In this case, only the primary key
+	// columns are guaranteed to be set in `datums`.
+	deleted bool
+	// tableDesc is a TableDescriptor for the table containing `datums`. It's
+	// valid for interpreting the row at `updated`.
+	tableDesc *sqlbase.TableDescriptor
+	// prevDatums, if non-nil, is the value of the row just before `updated`,
+	// and prevTableDesc is valid for interpreting it. They're only set with
+	// the `diff` option, and nil if the row didn't exist before.
+	prevDatums    tree.Datums
+	prevTableDesc *sqlbase.TableDescriptor
+}
+
+// Encoder turns a row into a serialized changefeed key, value, or resolved
+// timestamp. It represents one of the `format=` changefeed options.
+type Encoder interface {
+	// EncodeKey encodes the primary key of the given row. The columns of the
+	// datums are expected to match 1:1 with the `Columns` field of the
+	// `TableDescriptor`, but only the primary key fields will be used.
+	EncodeKey(context.Context, encodeRow) ([]byte, error)
+	// EncodeValue encodes the given row. The columns of the datums are expected
+	// to match 1:1 with the `Columns` field of the `TableDescriptor`. It
+	// returns nil for deletions (except with `envelope=wrapped`), but must
+	// still be called for them so that any
+	// state kept about the row can be updated. It's never called with
+	// `envelope=key_only`, so encoders must not rely on it for state that
+	// EncodeKey needs.
+	EncodeValue(context.Context, encodeRow) ([]byte, error)
+	// EncodeResolvedTimestamp encodes a resolved timestamp payload.
+	EncodeResolvedTimestamp(context.Context, hlc.Timestamp) ([]byte, error)
+}
+
+// markerEncoder is implemented by the Encoders that can encode the markers
+// recorded with crdb_internal.changefeed_emit_marker. Feeds with other formats
+// don't emit markers.
+type markerEncoder interface {
+	// EncodeMarker encodes a marker payload, which is emitted like a resolved
+	// timestamp.
+	EncodeMarker(ctx context.Context, marker string, ts hlc.Timestamp) ([]byte, error)
+}
+
+// memoryAccountedEncoder is implemented by the Encoders that hold on to
+// memory across rows, so that it's accounted for with the changefeed's.
+type memoryAccountedEncoder interface {
+	// setMemoryAccount accounts for the memory held across rows in acc, which
+	// is only used by the goroutine that encodes the rows.
+	setMemoryAccount(acc *mon.BoundAccount)
+}
+
+// hasUpdatedField returns whether the `updated` timestamp is included in every
+// row, which is either requested explicitly or as part of `timestamps`.
+func hasUpdatedField(opts map[string]string) bool {
+	_, updated := opts[optUpdatedTimestamps]
+	_, timestamps := opts[optTimestamps]
+	return updated || timestamps
+}
+
+func getEncoder(details jobspb.ChangefeedDetails) (Encoder, error) {
+	switch formatType(details.Opts[optFormat]) {
+	case ``, optFormatJSON:
+		return makeJSONEncoder(details)
+	case optFormatAvro:
+		return newConfluentAvroEncoder(details)
+	case optFormatProtobuf:
+		return makeProtobufEncoder(details), nil
+	case optFormatCSV:
+		return makeCSVEncoder(details)
+	case optFormatParquet:
+		return makeParquetEncoder(details), nil
+	default:
+		return nil, errors.Errorf(`unknown %s: %s`, optFormat, details.Opts[optFormat])
+	}
+}
+
+// jsonEncoder encodes changefeed entries as JSON. Keys are the primary key
+// columns in a JSON array. Values are a JSON object mapping every column name
+// to its value. Updated timestamps in rows and resolved timestamp payloads are
+// stored in a sub-object under the `__crdb__` key in the top-level JSON object.
+//
+// With `envelope=wrapped`, values instead wrap the row under an `after` key,
+// which is null for deletions (so they have a value), and the updated
+// timestamp is under an `updated` key. The `diff` option adds the previous
+// value of the row under a `before` key, null if the row didn't exist.
+//
+// `envelope=bare` is the layout most off-the-shelf connectors expect: the row
+// itself as the top-level object, with every piece of changefeed metadata
+// that's enabled by an option (the updated timestamp, and the previous value
+// of the row with `diff`) nested under `__crdb__`. Deletions have no value, as
+// with the default envelope, so their previous value is only available with
+// `envelope=wrapped`.
+//
+// The `full_deletes` option gives deletions the last value of the deleted row,
+// so consumers don't need to keep their own copy of every row to process them.
+// With the default and bare envelopes, it's the value of the message, marked by
+// `"deleted": true` under `__crdb__`. With `envelope=wrapped`, it's under
+// `before`, as with `diff`. Deletions of rows that didn't exist still have no
+// value.
+//
+// The `changed_columns` option adds the names of the columns whose values an
+// update changed, as an array in the order of the table's columns, next to
+// where the updated timestamp goes, under a `changed_columns` key. It's
+// computed from the previous value of the row, so inserts and deletions, which
+// have none, don't have it.
+//
+// The `mvcc_timestamp` option adds the mvcc timestamp of the row next to where
+// the updated timestamp goes, under an `mvcc_timestamp` key. Similarly, the
+// `key_in_value` and `topic_in_value` options add the primary key, as an
+// array, and the topic under `key` and `topic` keys, for sinks that only
+// deliver the message value.
+//
+// With the `topic_name` sink parameter, the rows of every table go to the same
+// topic, so keys are objects with the table name under `table` and the primary
+// key under `key`.
+//
+// How the values of some column types are rendered is picked by options, see
+// jsonTypeEncodings.
+//
+// The `kafka_connect_json_schema` option wraps every message in the envelope
+// Kafka Connect's JsonConverter expects, see kafka_connect.go.
+type jsonEncoder struct {
+	updatedField       bool
+	mvccTimestampField bool
+	keyField           bool
+	topicField         bool
+	topicPrefix        string
+	singleTopic        bool
+	wrapped            bool
+	beforeField        bool
+	fullDeletes        bool
+	changedColumns     bool
+	dropped            *droppedColumns
+	connectSchema      bool
+	types              jsonTypeEncodings
+
+	buf bytes.Buffer
+}
+
+var _ Encoder = &jsonEncoder{}
+var _ markerEncoder = &jsonEncoder{}
+
+func makeJSONEncoder(details jobspb.ChangefeedDetails) (*jsonEncoder, error) {
+	updatedField := hasUpdatedField(details.Opts)
+	_, mvccTimestampField := details.Opts[optMVCCTimestamps]
+	_, keyField := details.Opts[optKeyInValue]
+	_, topicField := details.Opts[optTopicInValue]
+	_, beforeField := details.Opts[optDiff]
+	_, fullDeletes := details.Opts[optFullDeletes]
+	_, changedColumns := details.Opts[optChangedColumns]
+	_, connectSchema := details.Opts[optKafkaConnectJSONSchema]
+	e := &jsonEncoder{
+		updatedField:       updatedField,
+		mvccTimestampField: mvccTimestampField,
+		keyField:           keyField,
+		topicField:         topicField,
+		wrapped:            envelopeType(details.Opts[optEnvelope]) == optEnvelopeWrapped,
+		beforeField:        beforeField,
+		fullDeletes:        fullDeletes,
+		changedColumns:     changedColumns,
+		dropped: makeDroppedColumns(
+			droppedColumnsType(details.Opts[optDroppedColumns]), details.TableDescs),
+		connectSchema: connectSchema,
+	}
+	var err error
+	if e.types, err = makeJSONTypeEncodings(details.Opts); err != nil {
+		return nil, err
+	}
+	if sinkURI, err := url.Parse(details.SinkURI); err == nil {
+		e.topicPrefix = sinkURI.Query().Get(sinkParamTopicPrefix)
+		e.singleTopic = sinkURI.Query().Get(sinkParamTopicName) != ``
+	}
+	return e, nil
+}
+
+// EncodeKey implements the Encoder interface.
+func (e *jsonEncoder) EncodeKey(_ context.Context, row encodeRow) ([]byte, error) {
+	var key interface{}
+	if e.connectSchema {
+		jsonEntries, err := keyAsJSONObject(row, e.types)
+		if err != nil {
+			return nil, err
+		}
+		schema := connectStructSchema(``, false /* optional */, connectKeyFields(row.tableDesc, e.types))
+		key = connectEnvelope(schema, jsonEntries)
+		if e.singleTopic {
+			schema[`field`] = `key`
+			key = connectEnvelope(connectStructSchema(``, false /* optional */, []interface{}{
+				map[string]interface{}{`type`: `string`, `field`: `table`, `optional`: false},
+				schema,
+			}), map[string]interface{}{`table`: row.tableName, `key`: jsonEntries})
+		}
+	} else {
+		jsonEntries, err := keyAsJSONEntries(row, e.types)
+		if err != nil {
+			return nil, err
+		}
+		key = jsonEntries
+		if e.singleTopic {
+			key = map[string]interface{}{`table`: row.tableName, `key`: jsonEntries}
+		}
+	}
+	j, err := json.MakeJSON(key)
+	if err != nil {
+		return nil, err
+	}
+	e.buf.Reset()
+	j.Format(&e.buf)
+	return e.buf.Bytes(), nil
+}
+
+// keyAsJSONEntries returns the json value of every primary key column of the
+// row, in order.
+func keyAsJSONEntries(row encodeRow, types jsonTypeEncodings) ([]interface{}, error) {
+	colIdxByID := row.tableDesc.ColumnIdxMap()
+	jsonEntries := make([]interface{}, len(row.tableDesc.PrimaryIndex.ColumnIDs))
+	for i, colID := range row.tableDesc.PrimaryIndex.ColumnIDs {
+		idx, ok := colIdxByID[colID]
+		if !ok {
+			return nil, errors.Errorf(`unknown column id: %d`, colID)
+		}
+		var err error
+		jsonEntries[i], err = types.asJSON(row.datums[idx])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return jsonEntries, nil
+}
+
+// keyAsJSONObject returns a map of the name of every primary key column of the
+// row to its json value.
+func keyAsJSONObject(row encodeRow, types jsonTypeEncodings) (map[string]interface{}, error) {
+	jsonEntries, err := keyAsJSONEntries(row, types)
+	if err != nil {
+		return nil, err
+	}
+	key := make(map[string]interface{}, len(jsonEntries))
+	for i, entry := range jsonEntries {
+		key[row.tableDesc.PrimaryIndex.ColumnNames[i]] = entry
+	}
+	return key, nil
+}
+
+// EncodeValue implements the Encoder interface.
+func (e *jsonEncoder) EncodeValue(ctx context.Context, row encodeRow) ([]byte, error) {
+	if row.deleted {
+		if e.dropped.typ == optDroppedColumnsLastKnown {
+			key, err := e.EncodeKey(ctx, row)
+			if err != nil {
+				return nil, err
+			}
+			e.dropped.fill(ctx, row.tableDesc, key, true /* deleted */, nil /* jsonValueRaw */)
+		}
+		if !e.wrapped && !e.fullDelete(row) {
+			return nil, nil
+		}
+	}
+
+	// valueDesc describes the row in the value, which is the last value of
+	// the row for full deletes.
+	valueDesc := row.tableDesc
+	var after map[string]interface{}
+	if !e.wrapped && e.fullDelete(row) {
+		valueDesc = row.prevTableDesc
+		var err error
+		if after, err = rowAsJSONEntries(row.prevTableDesc, row.prevDatums, e.types); err != nil {
+			return nil, err
+		}
+	} else if !row.deleted {
+		var err error
+		if after, err = rowAsJSONEntries(row.tableDesc, row.datums, e.types); err != nil {
+			return nil, err
+		}
+		if e.dropped.typ != optDroppedColumnsOmit {
+			key, err := e.EncodeKey(ctx, row)
+			if err != nil {
+				return nil, err
+			}
+			e.dropped.fill(ctx, row.tableDesc, key, false /* deleted */, after)
+		}
+	}
+
+	// The key and topic go next to the updated timestamp.
+	meta := make(map[string]interface{})
+	if e.keyField {
+		var key interface{}
+		var err error
+		if e.connectSchema {
+			key, err = keyAsJSONObject(row, e.types)
+		} else {
+			key, err = keyAsJSONEntries(row, e.types)
+		}
+		if err != nil {
+			return nil, err
+		}
+		meta[`key`] = key
+	}
+	if e.topicField {
+		meta[`topic`] = e.topicPrefix + row.topic
+	}
+	if e.changedColumns && !row.deleted && row.prevDatums != nil {
+		changed, err := changedColumnNames(row, after, e.types)
+		if err != nil {
+			return nil, err
+		}
+		meta[`changed_columns`] = changed
+	}
+
+	var jsonEntries map[string]interface{}
+	var err error
+	if e.wrapped {
+		// A nil map would be encoded as an empty object, so use an untyped nil
+		// for null.
+		jsonEntries = meta
+		jsonEntries[`after`] = nil
+		if after != nil {
+			jsonEntries[`after`] = after
+		}
+		if e.beforeField || e.fullDelete(row) {
+			if jsonEntries[`before`], err = beforeAsJSON(row, e.types); err != nil {
+				return nil, err
+			}
+		}
+		if e.updatedField {
+			jsonEntries[`updated`] = tree.TimestampToDecimal(row.updated).Decimal.String()
+		}
+		if e.mvccTimestampField {
+			jsonEntries[`mvcc_timestamp`] = tree.TimestampToDecimal(row.mvccTimestamp).Decimal.String()
+		}
+	} else {
+		jsonEntries = after
+		if e.updatedField {
+			meta[`updated`] = tree.TimestampToDecimal(row.updated).Decimal.String()
+		}
+		if e.mvccTimestampField {
+			meta[`mvcc_timestamp`] = tree.TimestampToDecimal(row.mvccTimestamp).Decimal.String()
+		}
+		if e.beforeField {
+			if meta[`before`], err = beforeAsJSON(row, e.types); err != nil {
+				return nil, err
+			}
+		}
+		if e.fullDelete(row) {
+			meta[`deleted`] = true
+		}
+		if len(meta) > 0 {
+			jsonEntries[jsonMetaSentinel] = meta
+		}
+	}
+	var value interface{} = jsonEntries
+	if e.connectSchema {
+		var fields []interface{}
+		if e.wrapped {
+			fields = connectMetaFields(row, jsonEntries, e.types)
+		} else {
+			connectRowPayload(valueDesc, jsonEntries)
+			fields = connectRowFields(valueDesc, e.types)
+			if len(meta) > 0 {
+				fields = append(fields, connectStructSchema(
+					jsonMetaSentinel, true /* optional */, connectMetaFields(row, meta, e.types)))
+			}
+		}
+		value = connectEnvelope(connectStructSchema(``, false /* optional */, fields), jsonEntries)
+	}
+	j, err := json.MakeJSON(value)
+	if err != nil {
+		return nil, err
+	}
+	e.buf.Reset()
+	j.Format(&e.buf)
+	return e.buf.Bytes(), nil
+}
+
+// fullDelete returns whether the row is a deletion that's emitted with the
+// last value of the row for the `full_deletes` option.
+func (e *jsonEncoder) fullDelete(row encodeRow) bool {
+	return e.fullDeletes && row.deleted && row.prevDatums != nil
+}
+
+// beforeAsJSON returns the previous value of the row for the `diff` option,
+// which is an untyped nil (JSON null) if the row didn't exist.
+func beforeAsJSON(row encodeRow, types jsonTypeEncodings) (interface{}, error) {
+	if row.prevDatums == nil {
+		return nil, nil
+	}
+	return rowAsJSONEntries(row.prevTableDesc, row.prevDatums, types)
+}
+
+// changedColumnNames returns the names of the columns of an update, in order,
+// whose json value in after, the row as returned by rowAsJSONEntries, is
+// different from their previous value. Columns that were added since the
+// previous value are changed.
+func changedColumnNames(
+	row encodeRow, after map[string]interface{}, types jsonTypeEncodings,
+) ([]interface{}, error) {
+	before, err := rowAsJSONEntries(row.prevTableDesc, row.prevDatums, types)
+	if err != nil {
+		return nil, err
+	}
+	changed := make([]interface{}, 0, len(row.tableDesc.Columns))
+	for _, col := range row.tableDesc.Columns {
+		prev, ok := before[col.Name].(json.JSON)
+		if !ok {
+			changed = append(changed, col.Name)
+			continue
+		}
+		cur, _ := after[col.Name].(json.JSON)
+		if cur == nil {
+			return nil, errors.Errorf(`unknown column: %s`, col.Name)
+		}
+		if c, err := cur.Compare(prev); err != nil {
+			return nil, err
+		} else if c != 0 {
+			changed = append(changed, col.Name)
+		}
+	}
+	return changed, nil
+}
+
+// rowAsJSONEntries returns a map of every column name in tableDesc to the json
+// value of the corresponding datum.
+func rowAsJSONEntries(
+	tableDesc *sqlbase.TableDescriptor, datums tree.Datums, types jsonTypeEncodings,
+) (map[string]interface{}, error) {
+	jsonEntries := make(map[string]interface{}, len(tableDesc.Columns))
+	for i := range tableDesc.Columns {
+		var err error
+		jsonEntries[tableDesc.Columns[i].Name], err = types.asJSON(datums[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return jsonEntries, nil
+}
+
+// EncodeResolvedTimestamp implements the Encoder interface.
+func (e *jsonEncoder) EncodeResolvedTimestamp(
+	_ context.Context, resolved hlc.Timestamp,
+) ([]byte, error) {
+	meta := map[string]interface{}{
+		jsonMetaSentinel: map[string]interface{}{
+			`resolved`: tree.TimestampToDecimal(resolved).Decimal.String(),
+		},
+	}
+	if e.connectSchema {
+		return gojson.Marshal(connectEnvelope(connectStringsSchema(``, meta), meta))
+	}
+	return gojson.Marshal(meta)
+}
+
+// EncodeMarker implements the markerEncoder interface.
+func (e *jsonEncoder) EncodeMarker(
+	_ context.Context, marker string, ts hlc.Timestamp,
+) ([]byte, error) {
+	meta := map[string]interface{}{
+		jsonMetaSentinel: map[string]interface{}{
+			`marker`:  marker,
+			`updated`: tree.TimestampToDecimal(ts).Decimal.String(),
+		},
+	}
+	if e.connectSchema {
+		return gojson.Marshal(connectEnvelope(connectStringsSchema(``, meta), meta))
+	}
+	return gojson.Marshal(meta)
+}
+
+// setMemoryAccount implements the memoryAccountedEncoder interface.
+func (e *jsonEncoder) setMemoryAccount(acc *mon.BoundAccount) {
+	e.dropped.setMemoryAccount(acc)
+}
+
+// confluentAvroEncoder encodes changefeed entries in Avro's binary format. Keys are the primary key columns in a record. Values are all
+// columns in a record.
+//
+// Schemas are registered with a Confluent schema registry and the encoded
+// bytes are prefixed with the Confluent wire format header: a zero magic byte
+// and the 4 byte big-endian id of the registered schema.
+//
+// By default, the feed fails if the registry is unavailable. With
+// `schema_registry_outage='retry'`, requests to it are retried for a while
+// first, and with `schema_registry_outage='pause'`, the feed's job is paused
+// instead, see runChangefeedFlow.
+//
+// TODO: A fallback to messages with the schema embedded was requested as
+// another option, but consumers that expect the Confluent wire format can't
+// read those, so it isn't supported.
+type confluentAvroEncoder struct {
+	registry     *schemaRegistryConn
+	retryOutages bool
+	topicPrefix  string
+	updatedField bool
+	// compatibility, if not empty, is set as the compatibility level of every
+	// subject this encoder registers schemas under.
+	compatibility string
+
+	keyCache      map[tableIDAndVersion]confluentRegisteredKeySchema
+	valueCache    map[tableIDAndVersion]confluentRegisteredValueSchema
+	resolvedCache map[string]confluentRegisteredResolvedSchema
+	subjectsSeen  map[string]struct{}
+}
+
+type tableIDAndVersion struct {
+	id      sqlbase.ID
+	version sqlbase.DescriptorVersion
+}
+
+type confluentRegisteredKeySchema struct {
+	schema     *avroDataRecord
+	registryID int32
+}
+
+type confluentRegisteredValueSchema struct {
+	schema     *avroDataRecord
+	registryID int32
+}
+
+type confluentRegisteredResolvedSchema struct {
+	schema     *avroMetadataRecord
+	registryID int32
+}
+
+var _ Encoder = &confluentAvroEncoder{}
+
+// confluentAvroResolvedSubject is the subject that the schema of resolved
+// timestamp payloads is registered under. The same payload is sent to every
+// topic, so it doesn't follow the `<topic>-value` naming of the other subjects.
+const confluentAvroResolvedSubject = jsonMetaSentinel + `resolved-value`
+
+func newConfluentAvroEncoder(details jobspb.ChangefeedDetails) (*confluentAvroEncoder, error) {
+	registry, err := makeSchemaRegistryConn(details.Opts[optConfluentSchemaRegistry])
+	if err != nil {
+		return nil, err
+	}
+	e := &confluentAvroEncoder{
+		registry:      registry,
+		keyCache:      make(map[tableIDAndVersion]confluentRegisteredKeySchema),
+		valueCache:    make(map[tableIDAndVersion]confluentRegisteredValueSchema),
+		resolvedCache: make(map[string]confluentRegisteredResolvedSchema),
+		subjectsSeen:  make(map[string]struct{}),
+	}
+	e.updatedField = hasUpdatedField(details.Opts)
+	e.retryOutages = schemaRegistryOutageType(details.Opts[optSchemaRegistryOutage]) ==
+		optSchemaRegistryOutageRetry
+	switch schemaCompatibilityType(details.Opts[optSchemaCompatibility]) {
+	case optSchemaCompatibilityBackward:
+		e.compatibility = `BACKWARD`
+	case optSchemaCompatibilityForward:
+		e.compatibility = `FORWARD`
+	case optSchemaCompatibilityFull:
+		e.compatibility = `FULL`
+	}
+	if sinkURI, err := url.Parse(details.SinkURI); err == nil {
+		e.topicPrefix = sinkURI.Query().Get(sinkParamTopicPrefix)
+	}
+	return e, nil
+}
+
+// EncodeKey implements the Encoder interface.
+func (e *confluentAvroEncoder) EncodeKey(ctx context.Context, row encodeRow) ([]byte, error) {
+	cacheKey := tableIDAndVersion{id: row.tableDesc.ID, version: row.tableDesc.Version}
+	registered, ok := e.keyCache[cacheKey]
+	if !ok {
+		var err error
+		registered.schema, err = indexToAvroSchema(row.tableDesc, &row.tableDesc.PrimaryIndex)
+		if err != nil {
+			return nil, err
+		}
+
+		// The subjects follow the registry's default TopicNameStrategy, so they
+		// have to match the kafka topic.
+		subject := e.topicPrefix + row.topic + confluentSubjectSuffixKey
+		registered.registryID, err = e.register(ctx, registered.schema, subject)
+		if err != nil {
+			return nil, err
+		}
+		e.keyCache[cacheKey] = registered
+	}
+
+	// https://docs.confluent.io/current/schema-registry/docs/serializer-formatter.html#wire-format
+	header := []byte{
+		confluentAvroWireFormatMagic,
+		0, 0, 0, 0, // Placeholder for the ID.
+	}
+	binary.BigEndian.PutUint32(header[1:5], uint32(registered.registryID))
+	return registered.schema.BinaryFromRow(header, row.datums)
+}
+
+// EncodeValue implements the Encoder interface.
+func (e *confluentAvroEncoder) EncodeValue(ctx context.Context, row encodeRow) ([]byte, error) {
+	if row.deleted {
+		return nil, nil
+	}
+
+	cacheKey := tableIDAndVersion{id: row.tableDesc.ID, version: row.tableDesc.Version}
+	registered, ok := e.valueCache[cacheKey]
+	if !ok {
+		var err error
+		registered.schema, err = tableToAvroSchema(row.tableDesc)
+		if err != nil {
+			return nil, err
+		}
+		if e.updatedField {
+			registered.schema.appendUpdatedField()
+		}
+
+		subject := e.topicPrefix + row.topic + confluentSubjectSuffixValue
+		registered.registryID, err = e.register(ctx, registered.schema, subject)
+		if err != nil {
+			return nil, err
+		}
+		e.valueCache[cacheKey] = registered
+	}
+
+	header := []byte{
+		confluentAvroWireFormatMagic,
+		0, 0, 0, 0, // Placeholder for the ID.
+	}
+	binary.BigEndian.PutUint32(header[1:5], uint32(registered.registryID))
+	buf, err := registered.schema.BinaryFromRow(header, row.datums)
+	if err != nil {
+		return nil, err
+	}
+	if e.updatedField {
+		buf = registered.schema.BinaryFromUpdated(buf, row.updated)
+	}
+	return buf, nil
+}
+
+// EncodeResolvedTimestamp implements the Encoder interface.
+func (e *confluentAvroEncoder) EncodeResolvedTimestamp(
+	ctx context.Context, resolved hlc.Timestamp,
+) ([]byte, error) {
+	registered, ok := e.resolvedCache[confluentAvroResolvedSubject]
+	if !ok {
+		registered.schema = resolvedToAvroSchema()
+		var err error
+		registered.registryID, err = e.register(ctx, registered.schema, confluentAvroResolvedSubject)
+		if err != nil {
+			return nil, err
+		}
+		e.resolvedCache[confluentAvroResolvedSubject] = registered
+	}
+
+	header := []byte{
+		confluentAvroWireFormatMagic,
+		0, 0, 0, 0, // Placeholder for the ID.
+	}
+	binary.BigEndian.PutUint32(header[1:5], uint32(registered.registryID))
+	return registered.schema.BinaryFromResolved(header, resolved), nil
+}
+
+const (
+	confluentAvroWireFormatMagic = byte(0)
+	confluentSubjectSuffixKey    = `-key`
+	confluentSubjectSuffixValue  = `-value`
+)
+
+type avroSchema interface {
+	schemaJSON() (string, error)
+}
+
+func (e *confluentAvroEncoder) register(
+	ctx context.Context, schema avroSchema, subject string,
+) (int32, error) {
+	if e.compatibility != `` {
+		if _, ok := e.subjectsSeen[subject]; !ok {
+			if err := e.setCompatibility(ctx, subject); err != nil {
+				return 0, err
+			}
+			e.subjectsSeen[subject] = struct{}{}
+		}
+	}
+
+	schemaStr, err := schema.schemaJSON()
+	if err != nil {
+		return 0, err
+	}
+	type confluentSchemaVersionRequest struct {
+		Schema string `json:"schema"`
+	}
+	var req confluentSchemaVersionRequest
+	req.Schema = schemaStr
+	var res struct {
+		ID int32 `json:"id"`
+	}
+	// https://docs.confluent.io/current/schema-registry/docs/api.html#post--subjects-(string-%20subject)-versions
+	if err := e.registryDo(
+		ctx, http.MethodPost, path.Join(`subjects`, subject, `versions`), req, &res,
+	); err != nil {
+		return 0, errors.Wrapf(err, `registering schema for subject %s`, subject)
+	}
+	return res.ID, nil
+}
+
+func (e *confluentAvroEncoder) setCompatibility(ctx context.Context, subject string) error {
+	req := struct {
+		Compatibility string `json:"compatibility"`
+	}{Compatibility: e.compatibility}
+	// https://docs.confluent.io/current/schema-registry/docs/api.html#put--config-(string-%20subject)
+	if err := e.registryDo(
+		ctx, http.MethodPut, path.Join(`config`, subject), req, nil, /* res */
+	); err != nil {
+		return errors.Wrapf(err, `setting compatibility for subject %s`, subject)
+	}
+	return nil
+}
+
+// registryDo makes a request to the schema registry, retrying it while the
+// registry is unavailable if the encoder retries outages.
+func (e *confluentAvroEncoder) registryDo(
+	ctx context.Context, method string, relPath string, req interface{}, res interface{},
+) error {
+	if !e.retryOutages {
+		return e.registry.do(ctx, method, relPath, req, res)
+	}
+	var err error
+	start := timeutil.Now()
+	for r := retry.StartWithCtx(ctx, schemaRegistryOutageRetryOpts); r.Next(); {
+		err = e.registry.do(ctx, method, relPath, req, res)
+		if !isSchemaRegistryUnavailableError(err) ||
+			timeutil.Since(start) >= schemaRegistryOutageRetryTimeout {
+			return err
+		}
+		log.Warningf(ctx, `retrying schema registry request: %s`, err)
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
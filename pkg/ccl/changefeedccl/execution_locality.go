@@ -0,0 +1,53 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs"
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs/jobspb"
+	"github.com/pkg/errors"
+)
+
+// The `execution_locality` option restricts the nodes that run a changefeed
+// to the ones whose localities have every one of its tiers, like
+// `region=us-east1`, so that the changefeed's egress to its sink stays within
+// a region or a set of nodes that are allowed to reach the sink. A changefeed
+// is run by the node that holds its job's lease, so the option is enforced by
+// the job registry, which only adopts the jobs a node may run, see
+// jobs.ExecutionLocalityHook. A changefeed created on a node that may not run
+// it is left for one that may to adopt.
+//
+// Every span of a changefeed is read by the node that runs it, from the
+// leaseholders of its ranges, which can be anywhere.
+func init() {
+	jobs.ExecutionLocalityHook = func(payload *jobspb.Payload) []roachpb.Tier {
+		details := payload.GetChangefeed()
+		if details == nil {
+			return nil
+		}
+		// The option was validated when the changefeed was created.
+		tiers, _ := executionLocality(details.Opts)
+		return tiers
+	}
+}
+
+// executionLocality returns the tiers of the `execution_locality` option, or
+// nil if it isn't set.
+func executionLocality(opts map[string]string) ([]roachpb.Tier, error) {
+	value, ok := opts[optExecutionLocality]
+	if !ok {
+		return nil, nil
+	}
+	var locality roachpb.Locality
+	if err := locality.Set(value); err != nil {
+		return nil, errors.Wrapf(err, `invalid %s`, optExecutionLocality)
+	}
+	return locality.Tiers, nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/keys"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+)
+
+// explainChangefeedHeader is the header of the results of EXPLAIN CREATE
+// CHANGEFEED.
+var explainChangefeedHeader = sqlbase.ResultColumns{
+	{Name: "field", Typ: types.String},
+	{Name: "description", Typ: types.String},
+}
+
+// explainChangefeed implements EXPLAIN CREATE CHANGEFEED. It shows what a
+// changefeed with the given (validated) details, which would start from
+// highwater, would do without starting it: the sink and encoder it would use,
+// the tables it would watch, whether it would do an initial scan, and the
+// nodes that hold the leases of the ranges of its spans, which serve its
+// reads.
+func explainChangefeed(
+	ctx context.Context,
+	p sql.PlanHookState,
+	details jobspb.ChangefeedDetails,
+	highwater hlc.Timestamp,
+	resultsCh chan<- tree.Datums,
+) error {
+	if _, err := getEncoder(details); err != nil {
+		return err
+	}
+	var rows [][2]string
+	add := func(field, description string) {
+		rows = append(rows, [2]string{field, description})
+	}
+
+	if details.SinkURI == `` {
+		add(`sink`, `sinkless, the changes are returned as the results of the statement`)
+	} else {
+		sinkURI, err := url.Parse(details.SinkURI)
+		if err != nil {
+			return err
+		}
+		add(`sink`, sinkURI.Scheme)
+	}
+	add(`format`, details.Opts[optFormat])
+	add(`envelope`, details.Opts[optEnvelope])
+
+	var spans roachpb.Spans
+	for i := range details.TableDescs {
+		add(`table`, details.TableDescs[i].Name)
+		spans = append(spans, details.TableDescs[i].PrimaryIndexSpan())
+	}
+	if watchedDatabases(details) != nil {
+		add(`table`, `and every table created later in the watched databases`)
+	}
+
+	switch {
+	case highwater != (hlc.Timestamp{}):
+		add(`initial scan`, fmt.Sprintf(`no, starting from %s`, highwater))
+	case initialScanType(details.Opts[optInitialScan]) == optInitialScanOnly:
+		add(`initial scan`, `only, the changefeed stops once it's done`)
+	default:
+		add(`initial scan`, `yes`)
+	}
+
+	if len(spans) > 0 {
+		byNode, err := p.DistSQLPlanner().PartitionSpans(ctx, p.ExtendedEvalContext(), nil /* txn */, spans)
+		if err != nil {
+			return err
+		}
+		nodeIDs := make([]roachpb.NodeID, 0, len(byNode))
+		for nodeID := range byNode {
+			nodeIDs = append(nodeIDs, nodeID)
+		}
+		sort.Slice(nodeIDs, func(i, j int) bool { return nodeIDs[i] < nodeIDs[j] })
+		for _, nodeID := range nodeIDs {
+			var pretty []string
+			for _, span := range byNode[nodeID] {
+				pretty = append(pretty, fmt.Sprintf(`[%s,%s)`,
+					keys.PrettyPrint(nil /* valDirs */, span.Key),
+					keys.PrettyPrint(nil /* valDirs */, span.EndKey)))
+			}
+			add(fmt.Sprintf(`spans on node %d`, nodeID), strings.Join(pretty, `, `))
+		}
+	}
+
+	for _, row := range rows {
+		resultsCh <- tree.Datums{tree.NewDString(row[0]), tree.NewDString(row[1])}
+	}
+	return nil
+}
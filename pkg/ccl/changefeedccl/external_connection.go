@@ -0,0 +1,192 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/lex"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/pkg/errors"
+)
+
+// sinkSchemeExternal is a reference to a named external connection. The
+// connection's URI (which may include credentials) lives in
+// system.external_connections and is only looked up when the changefeed
+// connects to its sink, so it's never persisted in the job record.
+const sinkSchemeExternal = `external`
+
+func init() {
+	sql.AddWrappedPlanHook(createExternalConnectionPlanHook)
+	sql.AddWrappedPlanHook(dropExternalConnectionPlanHook)
+}
+
+func createExternalConnectionPlanHook(
+	ctx context.Context, stmt tree.Statement, p sql.PlanHookState,
+) (sql.PlanNode, error) {
+	createStmt, ok := stmt.(*tree.CreateExternalConnection)
+	if !ok {
+		return nil, nil
+	}
+
+	uriFn, err := p.TypeAsString(createStmt.URI, `CREATE EXTERNAL CONNECTION`)
+	if err != nil {
+		return nil, err
+	}
+	uri, err := uriFn()
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme == `` {
+		return nil, errors.Errorf(`external connection URI must include a scheme: %s`, uri)
+	}
+	if parsed.Scheme == sinkSchemeExternal {
+		return nil, errors.New(`external connections cannot reference other external connections`)
+	}
+	// A userfile connection writes files as the user that created it, like a
+	// changefeed with a userfile URI does.
+	if uri, err = normalizeUserFileURI(p.User(), uri); err != nil {
+		return nil, err
+	}
+
+	name := string(createStmt.Name)
+	if _, found, err := externalConnectionOwner(ctx, p, name); err != nil {
+		return nil, err
+	} else if found {
+		return nil, errors.Errorf(`external connection %q already exists`, name)
+	}
+	if _, err := p.ExecCfg().InternalExecutor.Exec(
+		ctx, `create-external-connection`, p.Txn(),
+		`INSERT INTO system.external_connections (name, uri, owner) VALUES ($1, $2, $3)`,
+		name, uri, p.User(),
+	); err != nil {
+		return nil, err
+	}
+	return sql.NewZeroNode(nil /* columns */), nil
+}
+
+func dropExternalConnectionPlanHook(
+	ctx context.Context, stmt tree.Statement, p sql.PlanHookState,
+) (sql.PlanNode, error) {
+	dropStmt, ok := stmt.(*tree.DropExternalConnection)
+	if !ok {
+		return nil, nil
+	}
+
+	name := string(dropStmt.Name)
+	owner, found, err := externalConnectionOwner(ctx, p, name)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		if dropStmt.IfExists {
+			return sql.NewZeroNode(nil /* columns */), nil
+		}
+		return nil, errors.Errorf(`external connection %q does not exist`, name)
+	}
+	if err := checkExternalConnectionAccess(ctx, p, name, owner); err != nil {
+		return nil, err
+	}
+
+	if _, err := p.ExecCfg().InternalExecutor.Exec(
+		ctx, `drop-external-connection`, p.Txn(),
+		`DELETE FROM system.external_connections WHERE name = $1`, name,
+	); err != nil {
+		return nil, err
+	}
+	return sql.NewZeroNode(nil /* columns */), nil
+}
+
+// externalConnectionOwner returns the user that created the named external
+// connection.
+func externalConnectionOwner(
+	ctx context.Context, p sql.PlanHookState, name string,
+) (owner string, found bool, err error) {
+	row, err := p.ExecCfg().InternalExecutor.QueryRow(
+		ctx, `external-connection-owner`, p.Txn(),
+		`SELECT owner FROM system.external_connections WHERE name = $1`, name,
+	)
+	if err != nil || row == nil {
+		return ``, false, err
+	}
+	return string(tree.MustBeDString(row[0])), true, nil
+}
+
+// checkExternalConnectionAccess returns an error unless the session user is
+// either the owner of the external connection or a superuser.
+func checkExternalConnectionAccess(
+	ctx context.Context, p sql.PlanHookState, name, owner string,
+) error {
+	if p.User() == owner {
+		return nil
+	}
+	return p.RequireSuperUser(ctx, `use external connection `+name)
+}
+
+// externalConnectionName returns the name of the external connection that an
+// `external://` URI references. Connection names are identifiers, which are
+// stored normalized, so the host is normalized the same way.
+func externalConnectionName(parsed *url.URL) string {
+	return lex.NormalizeName(parsed.Host)
+}
+
+// checkSinkURIAccess verifies that the session user may use the given sink. It
+// is a no-op for anything except external connections.
+func checkSinkURIAccess(ctx context.Context, p sql.PlanHookState, sinkURI string) error {
+	parsed, err := url.Parse(sinkURI)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != sinkSchemeExternal {
+		return nil
+	}
+	name := externalConnectionName(parsed)
+	owner, found, err := externalConnectionOwner(ctx, p, name)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.Errorf(`external connection %q does not exist`, name)
+	}
+	return checkExternalConnectionAccess(ctx, p, name, owner)
+}
+
+// resolveSinkURI replaces a reference to an external connection with the URI
+// stored for it. Any other sink URI is returned unchanged. A userfile URI
+// resolved from a connection always writes files as the connection's owner,
+// whatever user it was stored with, so that a connection can't be used to
+// write as someone else.
+func resolveSinkURI(ctx context.Context, execCfg *sql.ExecutorConfig, sinkURI string) (string, error) {
+	parsed, err := url.Parse(sinkURI)
+	if err != nil {
+		return ``, err
+	}
+	if parsed.Scheme != sinkSchemeExternal {
+		return sinkURI, nil
+	}
+	name := externalConnectionName(parsed)
+	row, err := execCfg.InternalExecutor.QueryRow(
+		ctx, `resolve-external-connection`, nil, /* txn */
+		`SELECT uri, owner FROM system.external_connections WHERE name = $1`, name,
+	)
+	if err != nil {
+		return ``, err
+	}
+	if row == nil {
+		return ``, errors.Errorf(`external connection %q does not exist`, name)
+	}
+	uri, owner := string(tree.MustBeDString(row[0])), string(tree.MustBeDString(row[1]))
+	return normalizeUserFileURI(owner, uri)
+}
@@ -12,8 +12,13 @@ import (
 	"bytes"
 	"context"
 	gosql "database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"sync"
 	"time"
 
@@ -25,6 +30,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 
 	"github.com/cockroachdb/cockroach/pkg/testutils/serverutils"
 )
@@ -91,7 +97,8 @@ func createBenchmarkChangefeed(
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		errCh <- runChangefeedFlow(ctx, execCfg, details, progress, resultsCh, nil)
+		errCh <- runChangefeedFlow(
+			ctx, execCfg, 0 /* jobID */, details, progress, resultsCh, nil, nil, nil)
 	}()
 	return func() error {
 		select {
@@ -167,3 +174,146 @@ func loadWorkloadBatches(sqlDB *gosql.DB, table workload.Table) ([]time.Time, in
 
 	return timestamps, benchBytes, nil
 }
+
+// testSchemaRegistry is an in-memory stand-in for the parts of the Confluent
+// schema registry http api used by changefeeds.
+type testSchemaRegistry struct {
+	server *httptest.Server
+	// username and password, if set, are the basic auth credentials that every
+	// request must have.
+	username, password string
+	mu                 struct {
+		syncutil.Mutex
+		idAlloc       int32
+		schemas       map[int32]string
+		subjects      map[string][]int32
+		compatibility map[string]string
+		// unavailable is the number of requests that fail with a server
+		// error before the registry is back, or negative if it never is.
+		unavailable int
+	}
+}
+
+func makeTestSchemaRegistry() *testSchemaRegistry {
+	r := newTestSchemaRegistry()
+	r.server = httptest.NewServer(http.HandlerFunc(r.handle))
+	return r
+}
+
+// makeTestSecureSchemaRegistry returns a testSchemaRegistry served over https,
+// with a self-signed certificate, that requires basic auth with the given
+// credentials.
+func makeTestSecureSchemaRegistry(username, password string) *testSchemaRegistry {
+	r := newTestSchemaRegistry()
+	r.username, r.password = username, password
+	r.server = httptest.NewTLSServer(http.HandlerFunc(r.handle))
+	return r
+}
+
+func newTestSchemaRegistry() *testSchemaRegistry {
+	r := &testSchemaRegistry{}
+	r.mu.schemas = make(map[int32]string)
+	r.mu.subjects = make(map[string][]int32)
+	r.mu.compatibility = make(map[string]string)
+	return r
+}
+
+// Close shuts down the registry's http server.
+func (r *testSchemaRegistry) Close() {
+	r.server.Close()
+}
+
+// URL returns the address of the registry.
+func (r *testSchemaRegistry) URL() string {
+	return r.server.URL
+}
+
+// CACert returns the certificate of the registry's server, base64-encoded PEM
+// as the `ca_cert` parameter expects.
+func (r *testSchemaRegistry) CACert() string {
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: `CERTIFICATE`, Bytes: r.server.Certificate().Raw})
+	return base64.StdEncoding.EncodeToString(certPEM)
+}
+
+// Subject returns the ids of the schemas registered under the given subject.
+func (r *testSchemaRegistry) Subject(subject string) []int32 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int32(nil), r.mu.subjects[subject]...)
+}
+
+// Compatibility returns the compatibility level set for the given subject.
+func (r *testSchemaRegistry) Compatibility(subject string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.mu.compatibility[subject]
+}
+
+// SetUnavailable makes the next n requests to the registry fail with a server
+// error, or every request if n is negative.
+func (r *testSchemaRegistry) SetUnavailable(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mu.unavailable = n
+}
+
+func (r *testSchemaRegistry) handle(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	unavailable := r.mu.unavailable != 0
+	if r.mu.unavailable > 0 {
+		r.mu.unavailable--
+	}
+	r.mu.Unlock()
+	if unavailable {
+		http.Error(w, `unavailable`, http.StatusServiceUnavailable)
+		return
+	}
+	if r.username != `` || r.password != `` {
+		if username, password, ok := req.BasicAuth(); !ok ||
+			username != r.username || password != r.password {
+			http.Error(w, `unauthorized`, http.StatusUnauthorized)
+			return
+		}
+	}
+	parts := strings.Split(strings.Trim(req.URL.Path, `/`), `/`)
+	switch {
+	case req.Method == http.MethodPost && len(parts) == 3 &&
+		parts[0] == `subjects` && parts[2] == `versions`:
+		var body struct {
+			Schema string `json:"schema"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.mu.Lock()
+		var id int32
+		for existingID, schema := range r.mu.schemas {
+			if schema == body.Schema {
+				id = existingID
+			}
+		}
+		if id == 0 {
+			r.mu.idAlloc++
+			id = r.mu.idAlloc
+			r.mu.schemas[id] = body.Schema
+		}
+		r.mu.subjects[parts[1]] = append(r.mu.subjects[parts[1]], id)
+		r.mu.Unlock()
+		fmt.Fprintf(w, `{"id":%d}`, id)
+	case req.Method == http.MethodPut && len(parts) == 2 && parts[0] == `config`:
+		var body struct {
+			Compatibility string `json:"compatibility"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.mu.Lock()
+		r.mu.compatibility[parts[1]] = body.Compatibility
+		r.mu.Unlock()
+		fmt.Fprintf(w, `{"compatibility":%q}`, body.Compatibility)
+	default:
+		http.NotFound(w, req)
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/keys"
+	"github.com/cockroachdb/cockroach/pkg/kv"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/server/serverpb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/pkg/errors"
+)
+
+var initialScanChunkRanges = settings.RegisterIntSetting(
+	"changefeed.initial_scan_chunk_ranges",
+	"number of ranges read by each chunk of a changefeed's initial scan, or 0 to read each "+
+		"table in one chunk",
+	16,
+)
+
+var inconsistentInitialScanInterval = settings.RegisterNonNegativeDurationSetting(
+	"changefeed.inconsistent_initial_scan_interval",
+	"how often the initial scan of a changefeed with the inconsistent_initial_scan option "+
+		"moves on to a newer timestamp",
+	10*time.Minute,
+)
+
+var maxInitialScanSize = settings.RegisterByteSizeSetting(
+	"changefeed.max_initial_scan_size",
+	"estimated size of the watched tables above which a changefeed's initial scan has to be "+
+		"confirmed with the allow_large_initial_scan option, or 0 to allow any size",
+	1<<40, // 1 TiB
+)
+
+// checkInitialScanSize refuses an initial scan of the given tables if they're
+// estimated to be bigger than changefeed.max_initial_scan_size, which protects
+// the cluster from a multi-TB scan caused by a typo'd target list. The
+// `allow_large_initial_scan` option skips the check.
+func checkInitialScanSize(
+	ctx context.Context, execCfg *sql.ExecutorConfig, tableDescs []sqlbase.TableDescriptor,
+) error {
+	limit := maxInitialScanSize.Get(&execCfg.Settings.SV)
+	if limit <= 0 {
+		return nil
+	}
+	var spans []roachpb.Span
+	for i := range tableDescs {
+		spans = append(spans, tableDescs[i].PrimaryIndexSpan())
+	}
+	size, err := estimateSpansSize(ctx, execCfg, spans)
+	if err != nil {
+		return errors.Wrap(err, `estimating the size of the initial scan`)
+	}
+	if size > limit {
+		return errors.Errorf(
+			`the initial scan would read about %s, more than changefeed.max_initial_scan_size (%s); `+
+				`use WITH %s to start the changefeed anyway`,
+			humanizeutil.IBytes(size), humanizeutil.IBytes(limit), optAllowLargeInitialScan)
+	}
+	return nil
+}
+
+// estimateSpansSize estimates the logical size of the data in the given spans
+// from the MVCC stats of their ranges. Every node with a replica of the spans
+// reports the stats of its replicas, and their average is multiplied by the
+// number of ranges, so that replication doesn't count. Ranges that extend past
+// the spans are counted whole, which overestimates small tables.
+func estimateSpansSize(
+	ctx context.Context, execCfg *sql.ExecutorConfig, spans []roachpb.Span,
+) (int64, error) {
+	var ranges, replicas, total int64
+	for _, span := range spans {
+		var rspan roachpb.RSpan
+		var err error
+		if rspan.Key, err = keys.Addr(span.Key); err != nil {
+			return 0, err
+		}
+		if rspan.EndKey, err = keys.AddrUpperBound(span.EndKey); err != nil {
+			return 0, err
+		}
+		nodeIDs := make(map[roachpb.NodeID]struct{})
+		ri := kv.NewRangeIterator(execCfg.DistSender)
+		for ri.Seek(ctx, rspan.Key, kv.Ascending); ; ri.Next(ctx) {
+			if !ri.Valid() {
+				return 0, ri.Error().GoError()
+			}
+			ranges++
+			for _, repl := range ri.Desc().Replicas {
+				nodeIDs[repl.NodeID] = struct{}{}
+			}
+			if !ri.NeedAnother(rspan) {
+				break
+			}
+		}
+		for nodeID := range nodeIDs {
+			resp, err := execCfg.StatusServer.SpanStats(ctx, &serverpb.SpanStatsRequest{
+				NodeID:   nodeID.String(),
+				StartKey: rspan.Key,
+				EndKey:   rspan.EndKey,
+			})
+			if err != nil {
+				return 0, err
+			}
+			replicas += int64(resp.RangeCount)
+			total += resp.TotalStats.Total()
+		}
+	}
+	if replicas == 0 {
+		return 0, nil
+	}
+	return total / replicas * ranges, nil
+}
+
+// timestampedSpan is a span along with the timestamp that it's been emitted
+// up to.
+type timestampedSpan struct {
+	span roachpb.Span
+	ts   hlc.Timestamp
+}
+
+// initialScan reads the initial scan of a changefeed in chunks of a few ranges
+// each, so that a changefeed emits the rows of a huge table as it reads them
+// instead of only once it's read the whole table.
+//
+// By default, every chunk is read as of the same timestamp, so the scan is a
+// consistent snapshot of the watched tables. Scans of multi-TB tables can take
+// long enough that the MVCC history at that timestamp gets garbage collected
+// from under them, though. With the `inconsistent_initial_scan` option, the
+// scan instead moves on to the present every
+// changefeed.inconsistent_initial_scan_interval. Every chunk is still a
+// snapshot, but rows from different chunks can be from different times. The
+// first poll after the scan then picks up each chunk from the timestamp it was
+// read at, so that no change is missed, and the changefeed's first resolved
+// timestamp is only emitted after it.
+type initialScan struct {
+	execCfg      *sql.ExecutorConfig
+	inconsistent bool
+
+	// remaining is what's left to read of the watched spans.
+	remaining []roachpb.Span
+	// ts is the timestamp of the chunks being read, and tsStart is when it
+	// was picked.
+	ts      hlc.Timestamp
+	tsStart time.Time
+	// chunks is every chunk read so far, with the timestamp it was read at.
+	// It's only kept for inconsistent scans.
+	chunks []timestampedSpan
+}
+
+func makeInitialScan(
+	execCfg *sql.ExecutorConfig, inconsistent bool, spans []roachpb.Span,
+) *initialScan {
+	return &initialScan{
+		execCfg:      execCfg,
+		inconsistent: inconsistent,
+		remaining:    append([]roachpb.Span(nil), spans...),
+		ts:           execCfg.Clock.Now(),
+		tsStart:      timeutil.Now(),
+	}
+}
+
+// restore makes the scan carry on from a checkpoint, see span_checkpoint.go.
+// The spans of the checkpoint have already been read, with the timestamps
+// they were read at, and the scan was made with the rest. The remaining
+// chunks of a consistent scan are read at the same timestamp as the ones
+// already read.
+func (s *initialScan) restore(done []timestampedSpan) {
+	if len(done) == 0 {
+		return
+	}
+	if s.inconsistent {
+		s.chunks = append(done, s.chunks...)
+	} else {
+		s.ts = done[0].ts
+	}
+}
+
+// done returns whether every chunk of the scan has been read.
+func (s *initialScan) done() bool {
+	return len(s.remaining) == 0
+}
+
+// nextChunk returns the span of the next chunk to read and the timestamp to
+// read it at. It must not be called once the scan is done.
+func (s *initialScan) nextChunk(ctx context.Context) (timestampedSpan, error) {
+	if s.inconsistent &&
+		timeutil.Since(s.tsStart) >= inconsistentInitialScanInterval.Get(&s.execCfg.Settings.SV) {
+		s.ts, s.tsStart = s.execCfg.Clock.Now(), timeutil.Now()
+	}
+
+	span := s.remaining[0]
+	chunkRanges := initialScanChunkRanges.Get(&s.execCfg.Settings.SV)
+	if chunkRanges > 0 {
+		var rspan roachpb.RSpan
+		var err error
+		if rspan.Key, err = keys.Addr(span.Key); err != nil {
+			return timestampedSpan{}, err
+		}
+		if rspan.EndKey, err = keys.AddrUpperBound(span.EndKey); err != nil {
+			return timestampedSpan{}, err
+		}
+		ri := kv.NewRangeIterator(s.execCfg.DistSender)
+		ri.Seek(ctx, rspan.Key, kv.Ascending)
+		for n := int64(1); ; n++ {
+			if !ri.Valid() {
+				return timestampedSpan{}, ri.Error().GoError()
+			}
+			if !ri.NeedAnother(rspan) {
+				break
+			}
+			if n >= chunkRanges {
+				span.EndKey = ri.Desc().EndKey.AsRawKey()
+				break
+			}
+			ri.Next(ctx)
+		}
+	}
+
+	if span.EndKey.Equal(s.remaining[0].EndKey) {
+		s.remaining = s.remaining[1:]
+	} else {
+		s.remaining[0].Key = span.EndKey
+	}
+	chunk := timestampedSpan{span: span, ts: s.ts}
+	if s.inconsistent {
+		s.chunks = append(s.chunks, chunk)
+	}
+	return chunk, nil
+}
+
+// finish returns, once the scan is done, the timestamp that the changefeed has
+// been emitted up to. For inconsistent scans, that's the timestamp of the
+// first chunk, which is the earliest, and it also returns the chunks, which
+// the first poll after the scan has to start from the timestamps they were
+// read at.
+func (s *initialScan) finish() (hlc.Timestamp, []timestampedSpan) {
+	if !s.inconsistent || len(s.chunks) == 0 {
+		return s.ts, nil
+	}
+	return s.chunks[0].ts, s.chunks
+}
@@ -0,0 +1,133 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs"
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/grpcutil"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/pkg/errors"
+)
+
+// changefeedRetryOpts controls how the changefeed of a job is restarted after
+// it fails with a transient error. There's no limit to the number of retries,
+// since a changefeed is meant to outlast whatever outage it's waiting out, but
+// the backoff starts over whenever the changefeed makes progress in between.
+var changefeedRetryOpts = retry.Options{
+	InitialBackoff: time.Second,
+	MaxBackoff:     time.Minute,
+	Multiplier:     2,
+}
+
+// isRetryableChangefeedError returns whether a changefeed that failed with err
+// may be restarted from its high-water mark, rather than failing its job.
+// Those are the errors that a sink or the cluster return while they're
+// temporarily unavailable, like when a kafka broker or a node restarts. The
+// emissions that failed with an error marked by MarkRetryableSinkError were
+// already retried for a while, see emitWithRetry, but the sink may come back
+// later. Everything else, like a sink that rejects the messages or a table
+// that was dropped, fails the job as before.
+func isRetryableChangefeedError(err error) bool {
+	if isRetryableSinkError(err) {
+		return true
+	}
+	switch cause := errors.Cause(err); cause {
+	case sarama.ErrOutOfBrokers, sarama.ErrNotConnected, sarama.ErrBrokerNotAvailable,
+		sarama.ErrLeaderNotAvailable, sarama.ErrNotLeaderForPartition, sarama.ErrRequestTimedOut,
+		sarama.ErrReplicaNotAvailable, sarama.ErrNotEnoughReplicas,
+		sarama.ErrNotEnoughReplicasAfterAppend:
+		return true
+	default:
+		switch cause.(type) {
+		case *roachpb.NodeUnavailableError, *roachpb.SendError, *roachpb.AmbiguousResultError,
+			net.Error:
+			return true
+		}
+	}
+	return grpcutil.IsClosedConnection(err)
+}
+
+// runChangefeedFlowWithRetry runs the changefeed of a job from its high-water
+// mark, restarting it with backoff whenever it fails with an error that
+// isRetryableChangefeedError, instead of failing the job. Every run signals
+// once its sink is set up, see getSink, but only the first signal is passed on
+// to startedCh, which CREATE CHANGEFEED waits for once.
+func runChangefeedFlowWithRetry(
+	ctx context.Context,
+	execCfg *sql.ExecutorConfig,
+	job *jobs.Job,
+	status *changefeedStatus,
+	startedCh chan<- tree.Datums,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	runStartedCh := make(chan tree.Datums)
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		for first := true; ; first = false {
+			select {
+			case d := <-runStartedCh:
+				if !first {
+					continue
+				}
+				select {
+				case startedCh <- d:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	defer func() {
+		cancel()
+		<-forwarded
+	}()
+
+	label := job.Details().(jobspb.ChangefeedDetails).Opts[optMetricsLabel]
+	metrics := getMetrics(execCfg).withLabel(label)
+	highwater := func() hlc.Timestamp {
+		return job.Progress().Details.(*jobspb.Progress_Changefeed).Changefeed.Highwater
+	}
+	var err error
+	for r := retry.StartWithCtx(ctx, changefeedRetryOpts); r.Next(); {
+		details := job.Details().(jobspb.ChangefeedDetails)
+		progress := job.Progress().Details.(*jobspb.Progress_Changefeed).Changefeed
+		start := progress.Highwater
+		err = runChangefeedFlow(
+			ctx, execCfg, *job.ID(), details, *progress, runStartedCh, job.Progressed, status,
+		)
+		if err == nil || ctx.Err() != nil || !isRetryableChangefeedError(err) {
+			return err
+		}
+		if highwater() != start {
+			r.Reset()
+		}
+		metrics.recordErrorRetry()
+		status.retried(err)
+		retried := makeChangefeedLogEvent(changefeedLogEventRetry, *job.ID(), details)
+		retried.Error = err.Error()
+		retried.log(ctx)
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
@@ -0,0 +1,136 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+// changefeedStatus keeps track of where the changefeed of a job is and what
+// its sink has been up to, so that it can be included in the trace of the job,
+// see `cockroach debug job-trace`. A stuck changefeed is either not advancing
+// its frontier or not getting anything through to its sink, and this tells
+// which. Its methods may be called on a nil changefeedStatus, for changefeeds
+// without a job.
+type changefeedStatus struct {
+	mu struct {
+		syncutil.Mutex
+		changefeedState
+	}
+}
+
+// changefeedState is the JSON state reported by a changefeedStatus.
+type changefeedState struct {
+	// Resolved is the latest resolved timestamp the changefeed has seen, and
+	// Highwater the latest one it has checkpointed.
+	Resolved  string `json:"resolved"`
+	Highwater string `json:"highwater"`
+	// CheckpointSpans is how many spans past the high-water mark were in the
+	// last span checkpoint, and CheckpointedAt when it was written.
+	CheckpointSpans int       `json:"checkpoint_spans"`
+	CheckpointedAt  time.Time `json:"checkpointed_at"`
+	Sink            sinkState `json:"sink"`
+	// Retries is how many times the changefeed was restarted after a
+	// transient error, and LastRetryError the error of the last restart.
+	Retries        int    `json:"retries"`
+	LastRetryError string `json:"last_retry_error,omitempty"`
+}
+
+// sinkState is the part of changefeedState about the sink.
+type sinkState struct {
+	Type          string    `json:"type"`
+	EmittedRows   int64     `json:"emitted_rows"`
+	EmittedBytes  int64     `json:"emitted_bytes"`
+	LastEmittedAt time.Time `json:"last_emitted_at"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorAt   time.Time `json:"last_error_at"`
+}
+
+func (s *changefeedStatus) sinkOpened(sink Sink) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.Sink.Type = fmt.Sprintf(`%T`, sink)
+}
+
+func (s *changefeedStatus) emitted(rows int, bytes int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.Sink.EmittedRows += int64(rows)
+	s.mu.Sink.EmittedBytes += bytes
+	s.mu.Sink.LastEmittedAt = timeutil.Now()
+}
+
+func (s *changefeedStatus) sinkFailed(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.Sink.LastError = err.Error()
+	s.mu.Sink.LastErrorAt = timeutil.Now()
+}
+
+func (s *changefeedStatus) resolved(ts hlc.Timestamp) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.Resolved = ts.String()
+}
+
+func (s *changefeedStatus) checkpointed(highwater hlc.Timestamp) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.Highwater = highwater.String()
+}
+
+func (s *changefeedStatus) spansCheckpointed(spans []timestampedSpan) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.CheckpointSpans = len(spans)
+	s.mu.CheckpointedAt = timeutil.Now()
+}
+
+func (s *changefeedStatus) retried(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.Retries++
+	s.mu.LastRetryError = err.Error()
+}
+
+var _ jobs.StateReporter = &changefeedResumer{}
+
+// ReportState implements the jobs.StateReporter interface.
+func (b *changefeedResumer) ReportState() interface{} {
+	b.status.mu.Lock()
+	defer b.status.mu.Unlock()
+	return b.status.mu.changefeedState
+}
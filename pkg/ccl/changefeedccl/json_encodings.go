@@ -0,0 +1,183 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"encoding/base64"
+	"time"
+
+	"github.com/cockroachdb/apd"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/json"
+	"github.com/pkg/errors"
+)
+
+type arrayEncodingType string
+type bytesEncodingType string
+type decimalEncodingType string
+type intervalEncodingType string
+type timestampEncodingType string
+
+const (
+	optArrayEncodingArray arrayEncodingType = `array`
+	optArrayEncodingSQL   arrayEncodingType = `sql`
+
+	optBytesEncodingHex    bytesEncodingType = `hex`
+	optBytesEncodingBase64 bytesEncodingType = `base64`
+
+	optDecimalEncodingNumber decimalEncodingType = `number`
+	optDecimalEncodingString decimalEncodingType = `string`
+
+	optIntervalEncodingSQL     intervalEncodingType = `sql`
+	optIntervalEncodingSeconds intervalEncodingType = `seconds`
+
+	optTimestampEncodingSQL         timestampEncodingType = `sql`
+	optTimestampEncodingRFC3339     timestampEncodingType = `rfc3339`
+	optTimestampEncodingEpochMillis timestampEncodingType = `epoch_millis`
+)
+
+// jsonTypeEncodings controls how the values of the column types that
+// downstream parsers disagree about are rendered in JSON. Each is picked by an
+// option, and the zero value of each is the default.
+//
+// With `array_encoding=array` (the default), ARRAYs are JSON arrays of their
+// elements, with `sql` they're a string of their SQL text. With
+// `bytes_encoding=hex` (the default), BYTES are a string of their escaped hex
+// form (`\x...`), with `base64` they're a string of their standard base64
+// encoding. With `decimal_encoding=number` (the default), DECIMALs are JSON
+// numbers, which many parsers read into a float, with `string` they're a
+// string of their exact value. With `interval_encoding=sql` (the default),
+// INTERVALs are a string of their SQL form, with `seconds` they're a JSON
+// number of seconds, where a month is 30 days. With `timestamp_encoding=sql`
+// (the default), TIMESTAMPs and TIMESTAMPTZs are a string of their SQL form,
+// with `rfc3339` they're an RFC 3339 string in UTC, and with `epoch_millis`
+// they're a JSON number of milliseconds since the unix epoch.
+//
+// The encodings apply to keys as well as values, and to the elements of
+// arrays.
+//
+// TODO: There are no spatial types yet. Once there are GEOMETRY and
+// GEOGRAPHY columns, add a `geo_encoding` with `geojson` and `wkt` next to
+// the default, so GIS consumers don't have to decode EWKB hex.
+type jsonTypeEncodings struct {
+	array     arrayEncodingType
+	bytes     bytesEncodingType
+	decimal   decimalEncodingType
+	interval  intervalEncodingType
+	timestamp timestampEncodingType
+}
+
+// jsonTypeEncodingOpts are the options that pick a jsonTypeEncodings.
+var jsonTypeEncodingOpts = []string{
+	optArrayEncoding, optBytesEncoding, optDecimalEncoding, optIntervalEncoding,
+	optTimestampEncoding,
+}
+
+// makeJSONTypeEncodings returns the encodings picked by the (normalized)
+// options, or an error if any of them are unknown.
+func makeJSONTypeEncodings(opts map[string]string) (jsonTypeEncodings, error) {
+	e := jsonTypeEncodings{
+		array:     arrayEncodingType(opts[optArrayEncoding]),
+		bytes:     bytesEncodingType(opts[optBytesEncoding]),
+		decimal:   decimalEncodingType(opts[optDecimalEncoding]),
+		interval:  intervalEncodingType(opts[optIntervalEncoding]),
+		timestamp: timestampEncodingType(opts[optTimestampEncoding]),
+	}
+	switch e.array {
+	case ``, optArrayEncodingArray:
+		e.array = ``
+	case optArrayEncodingSQL:
+	default:
+		return jsonTypeEncodings{}, errors.Errorf(`unknown %s: %s`, optArrayEncoding, e.array)
+	}
+	switch e.bytes {
+	case ``, optBytesEncodingHex:
+		e.bytes = ``
+	case optBytesEncodingBase64:
+	default:
+		return jsonTypeEncodings{}, errors.Errorf(`unknown %s: %s`, optBytesEncoding, e.bytes)
+	}
+	switch e.decimal {
+	case ``, optDecimalEncodingNumber:
+		e.decimal = ``
+	case optDecimalEncodingString:
+	default:
+		return jsonTypeEncodings{}, errors.Errorf(`unknown %s: %s`, optDecimalEncoding, e.decimal)
+	}
+	switch e.interval {
+	case ``, optIntervalEncodingSQL:
+		e.interval = ``
+	case optIntervalEncodingSeconds:
+	default:
+		return jsonTypeEncodings{}, errors.Errorf(`unknown %s: %s`, optIntervalEncoding, e.interval)
+	}
+	switch e.timestamp {
+	case ``, optTimestampEncodingSQL:
+		e.timestamp = ``
+	case optTimestampEncodingRFC3339, optTimestampEncodingEpochMillis:
+	default:
+		return jsonTypeEncodings{}, errors.Errorf(
+			`unknown %s: %s`, optTimestampEncoding, e.timestamp)
+	}
+	return e, nil
+}
+
+// asJSON is tree.AsJSON with the encodings applied.
+func (e jsonTypeEncodings) asJSON(d tree.Datum) (json.JSON, error) {
+	if e == (jsonTypeEncodings{}) {
+		return tree.AsJSON(d)
+	}
+	switch t := d.(type) {
+	case *tree.DArray:
+		if e.array == optArrayEncodingSQL {
+			return json.FromString(tree.AsStringWithFlags(t, tree.FmtParseDatums)), nil
+		}
+		builder := json.NewArrayBuilder(t.Len())
+		for _, elem := range t.Array {
+			j, err := e.asJSON(elem)
+			if err != nil {
+				return nil, err
+			}
+			builder.Add(j)
+		}
+		return builder.Build(), nil
+	case *tree.DBytes:
+		if e.bytes == optBytesEncodingBase64 {
+			return json.FromString(base64.StdEncoding.EncodeToString([]byte(*t))), nil
+		}
+	case *tree.DDecimal:
+		if e.decimal == optDecimalEncodingString {
+			return json.FromString(t.Decimal.String()), nil
+		}
+	case *tree.DInterval:
+		if e.interval == optIntervalEncodingSeconds {
+			return json.FromFloat64(t.Duration.AsFloat64())
+		}
+	case *tree.DTimestamp:
+		return e.timestampAsJSON(t, t.Time)
+	case *tree.DTimestampTZ:
+		return e.timestampAsJSON(t, t.Time)
+	}
+	return tree.AsJSON(d)
+}
+
+func (e jsonTypeEncodings) timestampAsJSON(d tree.Datum, t time.Time) (json.JSON, error) {
+	switch e.timestamp {
+	case optTimestampEncodingRFC3339:
+		return json.FromString(t.UTC().Format(time.RFC3339Nano)), nil
+	case optTimestampEncodingEpochMillis:
+		// Timestamps have microsecond precision, which is kept in a fraction.
+		micros := t.UnixNano() / int64(time.Microsecond)
+		if micros%1000 == 0 {
+			return json.FromInt64(micros / 1000), nil
+		}
+		return json.FromDecimal(*apd.New(micros, -3)), nil
+	}
+	return tree.AsJSON(d)
+}
@@ -0,0 +1,183 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+	"github.com/cockroachdb/cockroach/pkg/util/json"
+	"github.com/pkg/errors"
+)
+
+// jsonProjection is a path into a JSONB column, parsed from the
+// `json_projection` option. With it, only the subdocument at the path is
+// emitted in place of the whole column, which keeps feeds over big JSON blobs
+// down to the fields downstream systems need.
+//
+// The syntax is the same as a chain of `->` operators: a column name followed
+// by string keys in single quotes and integer array indexes, like
+// `payload->'status'` or `payload->'items'->0`. Several projections are
+// separated by commas. The projection of a document without the path is null.
+type jsonProjection struct {
+	column string
+	// path is the keys and indexes to fetch, in order. Each element is either
+	// a string or an int.
+	path []interface{}
+}
+
+// jsonProjections are the projections of a changefeed, indexed by column name.
+type jsonProjections map[string]jsonProjection
+
+// parseJSONProjections parses the value of the `json_projection` option.
+func parseJSONProjections(s string) (jsonProjections, error) {
+	projections := make(jsonProjections)
+	for len(s) > 0 {
+		p, rest, err := parseJSONProjection(s)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := projections[p.column]; ok {
+			return nil, errors.Errorf(`column %s is projected more than once`, p.column)
+		}
+		projections[p.column] = p
+		s = strings.TrimSpace(rest)
+		if len(s) > 0 {
+			if s[0] != ',' {
+				return nil, errors.Errorf(`expected , got: %s`, s)
+			}
+			s = strings.TrimSpace(s[1:])
+		}
+	}
+	if len(projections) == 0 {
+		return nil, errors.New(`expected at least one projection`)
+	}
+	return projections, nil
+}
+
+// parseJSONProjection parses one projection from the start of s and returns
+// the rest.
+func parseJSONProjection(s string) (jsonProjection, string, error) {
+	s = strings.TrimSpace(s)
+	end := strings.Index(s, `->`)
+	if end < 0 {
+		return jsonProjection{}, ``, errors.Errorf(`expected column->path got: %s`, s)
+	}
+	p := jsonProjection{column: strings.TrimSpace(s[:end])}
+	if p.column == `` {
+		return jsonProjection{}, ``, errors.Errorf(`expected a column name in: %s`, s)
+	}
+	s = s[end:]
+	for strings.HasPrefix(s, `->`) {
+		s = strings.TrimSpace(s[len(`->`):])
+		if strings.HasPrefix(s, `'`) {
+			key, rest, err := parseJSONProjectionKey(s)
+			if err != nil {
+				return jsonProjection{}, ``, err
+			}
+			p.path = append(p.path, key)
+			s = rest
+		} else {
+			end := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+			if end < 0 {
+				end = len(s)
+			}
+			idx, err := strconv.Atoi(s[:end])
+			if err != nil {
+				return jsonProjection{}, ``, errors.Errorf(
+					`expected a quoted key or an array index got: %s`, s)
+			}
+			p.path = append(p.path, idx)
+			s = s[end:]
+		}
+		s = strings.TrimSpace(s)
+	}
+	return p, s, nil
+}
+
+// parseJSONProjectionKey parses a single quoted string, in which a quote is
+// escaped by doubling it, from the start of s.
+func parseJSONProjectionKey(s string) (string, string, error) {
+	var key strings.Builder
+	for i := 1; i < len(s); i++ {
+		if s[i] != '\'' {
+			key.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '\'' {
+			key.WriteByte('\'')
+			i++
+			continue
+		}
+		return key.String(), s[i+1:], nil
+	}
+	return ``, ``, errors.Errorf(`unterminated key: %s`, s)
+}
+
+// validate checks that every projected column is a JSONB column of at least
+// one of the given tables.
+func (ps jsonProjections) validate(tableDescs []sqlbase.TableDescriptor) error {
+	for column := range ps {
+		found := false
+		for i := range tableDescs {
+			for _, col := range tableDescs[i].Columns {
+				if col.Name == column && col.Type.SemanticType == sqlbase.ColumnType_JSON {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return errors.Errorf(`column %s is not a JSONB column of any watched table`, column)
+		}
+	}
+	return nil
+}
+
+// project replaces the value of every projected column in datums, which must
+// match 1:1 with the columns of tableDesc, with its projection.
+func (ps jsonProjections) project(tableDesc *sqlbase.TableDescriptor, datums tree.Datums) error {
+	for i := range tableDesc.Columns {
+		p, ok := ps[tableDesc.Columns[i].Name]
+		if !ok {
+			continue
+		}
+		d, ok := datums[i].(*tree.DJSON)
+		if !ok {
+			// NULLs and the unset columns of deletions are left alone.
+			continue
+		}
+		j, err := p.apply(d.JSON)
+		if err != nil {
+			return err
+		}
+		datums[i] = tree.NewDJSON(j)
+	}
+	return nil
+}
+
+func (p jsonProjection) apply(j json.JSON) (json.JSON, error) {
+	for _, elem := range p.path {
+		var err error
+		switch elem := elem.(type) {
+		case string:
+			j, err = j.FetchValKey(elem)
+		case int:
+			j, err = j.FetchValIdx(elem)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if j == nil {
+			return json.NullJSONValue, nil
+		}
+	}
+	return j, nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/json"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+)
+
+func TestJSONProjections(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	tableDesc := &sqlbase.TableDescriptor{
+		Name: `foo`,
+		Columns: []sqlbase.ColumnDescriptor{
+			{ID: 1, Name: `a`, Type: sqlbase.ColumnType{SemanticType: sqlbase.ColumnType_INT}},
+			{ID: 2, Name: `payload`, Type: sqlbase.ColumnType{SemanticType: sqlbase.ColumnType_JSON}},
+			{ID: 3, Name: `it's`, Type: sqlbase.ColumnType{SemanticType: sqlbase.ColumnType_JSON}},
+		},
+	}
+	doc, err := json.ParseJSON(`{"status": "ok", "items": [{"id": 1}, {"id": 2}], "it's": true}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		projection string
+		expected   string
+		err        string
+	}{
+		{projection: `payload->'status'`, expected: `"ok"`},
+		{projection: ` payload -> 'items' -> 1 -> 'id' `, expected: `2`},
+		{projection: `payload->'it''s'`, expected: `true`},
+		{projection: `payload->'missing'->'deeper'`, expected: `null`},
+		{projection: `payload->'items'->7`, expected: `null`},
+		{projection: `payload->'status', it's->'status'`, expected: `"ok"`},
+		{projection: `payload`, err: `expected column->path got: payload`},
+		{projection: `->'status'`, err: `expected a column name`},
+		{projection: `payload->status`, err: `expected a quoted key or an array index`},
+		{projection: `payload->'status`, err: `unterminated key`},
+		{projection: `payload->'a' payload->'b'`, err: `expected , got`},
+		{projection: `payload->'a', payload->'b'`, err: `column payload is projected more than once`},
+		{projection: `a->'b'`, err: `column a is not a JSONB column of any watched table`},
+	}
+	for _, test := range tests {
+		t.Run(test.projection, func(t *testing.T) {
+			projections, err := parseJSONProjections(test.projection)
+			if err == nil {
+				err = projections.validate([]sqlbase.TableDescriptor{*tableDesc})
+			}
+			if test.err != `` {
+				if !testutils.IsError(err, test.err) {
+					t.Fatalf(`expected %q error got: %v`, test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			datums := tree.Datums{tree.NewDInt(1), tree.NewDJSON(doc), tree.DNull}
+			if err := projections.project(tableDesc, datums); err != nil {
+				t.Fatal(err)
+			}
+			if actual := datums[1].(*tree.DJSON).JSON.String(); actual != test.expected {
+				t.Errorf(`expected %s got %s`, test.expected, actual)
+			}
+			if datums[2] != tree.DNull {
+				t.Errorf(`expected NULL to be left alone got %s`, datums[2])
+			}
+		})
+	}
+}
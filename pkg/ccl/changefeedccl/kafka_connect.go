@@ -0,0 +1,208 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"sort"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+	"github.com/cockroachdb/cockroach/pkg/util/json"
+)
+
+// The `kafka_connect_json_schema` option wraps every JSON key, value, and
+// resolved timestamp payload in the envelope that Kafka Connect's
+// JsonConverter expects with `schemas.enable=true`: an object with the Kafka
+// Connect schema of the message under `schema` and the message itself under
+// `payload`. With it, sink connectors (JDBC, Elasticsearch, ...) can consume a
+// changefeed without custom transforms.
+//
+// Schemas are derived from the column types. INT is int64, FLOAT and DECIMAL
+// are float64 (DECIMALs are JSON numbers either way), BOOL is boolean, arrays
+// are arrays of their element type, and everything else is a string formatted
+// as it is without the envelope. The types that jsonTypeEncodings renders
+// differently get a schema to match. A JSONB column can't be described by a
+// schema, so its document is emitted as a string. Keys are structs of the
+// primary key columns instead of arrays, whose elements all need the same
+// type, and the changefeed metadata (the updated timestamp, topic, and so on)
+// is optional string fields.
+
+// connectEnvelope returns the Kafka Connect envelope of a message.
+func connectEnvelope(schema map[string]interface{}, payload interface{}) map[string]interface{} {
+	return map[string]interface{}{`schema`: schema, `payload`: payload}
+}
+
+// connectStructSchema returns the schema of a struct with the given fields.
+// field is its name in the enclosing struct, if any.
+func connectStructSchema(
+	field string, optional bool, fields []interface{},
+) map[string]interface{} {
+	schema := map[string]interface{}{`type`: `struct`, `optional`: optional, `fields`: fields}
+	if field != `` {
+		schema[`field`] = field
+	}
+	return schema
+}
+
+// connectTypeSchema returns the schema of the values of a column type.
+func connectTypeSchema(typ sqlbase.ColumnType, types jsonTypeEncodings) map[string]interface{} {
+	number := map[string]interface{}{`type`: `float64`}
+	switch typ.SemanticType {
+	case sqlbase.ColumnType_INT:
+		return map[string]interface{}{`type`: `int64`}
+	case sqlbase.ColumnType_FLOAT:
+		return number
+	case sqlbase.ColumnType_DECIMAL:
+		if types.decimal != optDecimalEncodingString {
+			return number
+		}
+	case sqlbase.ColumnType_INTERVAL:
+		if types.interval == optIntervalEncodingSeconds {
+			return number
+		}
+	case sqlbase.ColumnType_TIMESTAMP, sqlbase.ColumnType_TIMESTAMPTZ:
+		if types.timestamp == optTimestampEncodingEpochMillis {
+			return number
+		}
+	case sqlbase.ColumnType_BOOL:
+		return map[string]interface{}{`type`: `boolean`}
+	case sqlbase.ColumnType_ARRAY:
+		if types.array == optArrayEncodingSQL {
+			break
+		}
+		items := map[string]interface{}{`type`: `string`}
+		if typ.ArrayContents != nil {
+			items = connectTypeSchema(sqlbase.ColumnType{SemanticType: *typ.ArrayContents}, types)
+		}
+		items[`optional`] = true
+		return map[string]interface{}{`type`: `array`, `items`: items}
+	}
+	return map[string]interface{}{`type`: `string`}
+}
+
+// connectColumnSchema returns the schema of a column as a field of a struct.
+func connectColumnSchema(
+	col sqlbase.ColumnDescriptor, optional bool, types jsonTypeEncodings,
+) map[string]interface{} {
+	schema := connectTypeSchema(col.Type, types)
+	schema[`field`] = col.Name
+	schema[`optional`] = optional
+	return schema
+}
+
+// connectRowFields returns the fields of the struct of a row of a table.
+func connectRowFields(
+	tableDesc *sqlbase.TableDescriptor, types jsonTypeEncodings,
+) []interface{} {
+	fields := make([]interface{}, len(tableDesc.Columns))
+	for i, col := range tableDesc.Columns {
+		fields[i] = connectColumnSchema(col, col.Nullable, types)
+	}
+	return fields
+}
+
+// connectKeyFields returns the fields of the struct of a primary key of a
+// table.
+func connectKeyFields(
+	tableDesc *sqlbase.TableDescriptor, types jsonTypeEncodings,
+) []interface{} {
+	colIdxByID := tableDesc.ColumnIdxMap()
+	fields := make([]interface{}, 0, len(tableDesc.PrimaryIndex.ColumnIDs))
+	for _, colID := range tableDesc.PrimaryIndex.ColumnIDs {
+		if idx, ok := colIdxByID[colID]; ok {
+			fields = append(fields, connectColumnSchema(
+				tableDesc.Columns[idx], false /* optional */, types))
+		}
+	}
+	return fields
+}
+
+// connectRowPayload replaces the documents of the JSONB columns in the json
+// entries of a row, as returned by rowAsJSONEntries, with their string form.
+func connectRowPayload(tableDesc *sqlbase.TableDescriptor, jsonEntries map[string]interface{}) {
+	for _, col := range tableDesc.Columns {
+		if col.Type.SemanticType != sqlbase.ColumnType_JSON {
+			continue
+		}
+		if j, ok := jsonEntries[col.Name].(json.JSON); ok && j.Type() != json.NullJSONType {
+			jsonEntries[col.Name] = json.FromString(j.String())
+		}
+	}
+}
+
+// connectMetaFields returns the fields of the struct of the changefeed
+// metadata in meta, ordered by name. Rows (`before` and `after`) are described
+// by the given table, and the primary key (`key`) by the key of row's table.
+// The rows are converted to the payload of their schema in place.
+func connectMetaFields(
+	row encodeRow, meta map[string]interface{}, types jsonTypeEncodings,
+) []interface{} {
+	names := make([]string, 0, len(meta))
+	for name := range meta {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		switch name {
+		case `key`:
+			fields = append(fields, connectStructSchema(
+				name, true /* optional */, connectKeyFields(row.tableDesc, types)))
+		case `deleted`:
+			fields = append(fields, map[string]interface{}{
+				`type`: `boolean`, `field`: name, `optional`: true,
+			})
+		case `changed_columns`:
+			fields = append(fields, map[string]interface{}{
+				`type`:     `array`,
+				`items`:    map[string]interface{}{`type`: `string`, `optional`: false},
+				`field`:    name,
+				`optional`: true,
+			})
+		case `after`, `before`:
+			tableDesc := row.tableDesc
+			if name == `before` && row.prevTableDesc != nil {
+				tableDesc = row.prevTableDesc
+			}
+			if jsonEntries, ok := meta[name].(map[string]interface{}); ok {
+				connectRowPayload(tableDesc, jsonEntries)
+			}
+			fields = append(fields, connectStructSchema(
+				name, true /* optional */, connectRowFields(tableDesc, types)))
+		default:
+			fields = append(fields, map[string]interface{}{
+				`type`: `string`, `field`: name, `optional`: true,
+			})
+		}
+	}
+	return fields
+}
+
+// connectStringsSchema returns the schema of a value made up only of nested
+// maps and strings, like resolved timestamp payloads.
+func connectStringsSchema(field string, v interface{}) map[string]interface{} {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		schema := map[string]interface{}{`type`: `string`, `optional`: false}
+		if field != `` {
+			schema[`field`] = field
+		}
+		return schema
+	}
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fields := make([]interface{}, len(names))
+	for i, name := range names {
+		fields[i] = connectStringsSchema(name, m[name])
+	}
+	return connectStructSchema(field, false /* optional */, fields)
+}
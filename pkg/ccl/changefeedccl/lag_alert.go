@@ -0,0 +1,156 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/internal/client"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/pkg/errors"
+)
+
+type lagAlertPolicyType string
+
+const (
+	optLagAlertPolicyAlert lagAlertPolicyType = `alert`
+	optLagAlertPolicyPause lagAlertPolicyType = `pause`
+	optLagAlertPolicyFail  lagAlertPolicyType = `fail`
+)
+
+// changefeedLagAlertDetail is the json details of a changefeed_lag_alert event.
+type changefeedLagAlertDetail struct {
+	JobID    int64
+	Lag      string
+	Bound    string
+	Resolved string
+	Policy   string
+}
+
+// lagAlerter implements the `lag_alert` option. It compares the lag of every
+// resolved timestamp, how far behind the present it is, against a bound and
+// fires an alert when the feed first falls behind it: a log message, the
+// `changefeed.lag_alerts` metric, and for job-backed feeds, an event in the
+// event log. Depending on the `lag_alert_policy` option, the feed is then left
+// running, paused, or failed.
+//
+// While a feed is over the bound, it's counted in the `changefeed.lagging`
+// metric, and it has to catch back up before it alerts again.
+//
+// TODO: The lag is only checked when a resolved timestamp is emitted, so
+// a feed that's stuck altogether (or in a long catch-up scan) doesn't alert
+// until it makes progress again.
+type lagAlerter struct {
+	execCfg *sql.ExecutorConfig
+	metrics *Metrics
+	// jobID is the ID of the feed's job, or 0 for a sinkless feed.
+	jobID  int64
+	bound  time.Duration
+	policy lagAlertPolicyType
+
+	alerting bool
+}
+
+// makeLagAlerter returns a lagAlerter for the given (validated) options, or
+// nil if `lag_alert` isn't set.
+func makeLagAlerter(
+	execCfg *sql.ExecutorConfig, metrics *Metrics, jobID int64, opts map[string]string,
+) (*lagAlerter, error) {
+	bound, ok := opts[optLagAlert]
+	if !ok {
+		return nil, nil
+	}
+	a := &lagAlerter{
+		execCfg: execCfg,
+		metrics: metrics,
+		jobID:   jobID,
+		policy:  lagAlertPolicyType(opts[optLagAlertPolicy]),
+	}
+	var err error
+	if a.bound, err = time.ParseDuration(bound); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// check is called with every resolved timestamp after it's been recorded as
+// the highwater of the feed's job. progressedFn updates the highwater, it's
+// used to stop the feed once its job is paused.
+func (a *lagAlerter) check(
+	ctx context.Context,
+	resolved hlc.Timestamp,
+	progressedFn func(context.Context, hlc.Timestamp) error,
+) error {
+	lag := a.execCfg.Clock.PhysicalTime().Sub(resolved.GoTime())
+	if lag <= a.bound {
+		if a.alerting {
+			log.Infof(ctx, `changefeed lag of %s is back within %s=%s`, lag, optLagAlert, a.bound)
+			a.alerting = false
+			a.metrics.Lagging.Dec(1)
+		}
+		return nil
+	}
+	if a.alerting {
+		return nil
+	}
+	a.alerting = true
+	a.metrics.Lagging.Inc(1)
+	a.metrics.LagAlerts.Inc(1)
+	log.Warningf(ctx, `changefeed lag of %s at resolved timestamp %s exceeds %s=%s`,
+		lag, resolved, optLagAlert, a.bound)
+	if a.jobID != 0 {
+		a.logEvent(ctx, lag, resolved)
+	}
+
+	switch a.policy {
+	case optLagAlertPolicyPause:
+		reason := fmt.Sprintf(`changefeed lag of %s exceeds %s=%s`, lag, optLagAlert, a.bound)
+		if err := pauseChangefeed(ctx, a.execCfg, a.jobID, reason); err != nil {
+			return err
+		}
+		return progressedFn(ctx, resolved)
+	case optLagAlertPolicyFail:
+		return errors.Errorf(`changefeed lag of %s exceeds %s=%s`, lag, optLagAlert, a.bound)
+	}
+	return nil
+}
+
+// logEvent records the alert in the event log. Like the node decommissioning
+// events, it's best effort. Job IDs don't fit in the targetID column, so the
+// job is only in the event's info.
+func (a *lagAlerter) logEvent(ctx context.Context, lag time.Duration, resolved hlc.Timestamp) {
+	info := changefeedLagAlertDetail{
+		JobID:    a.jobID,
+		Lag:      lag.String(),
+		Bound:    a.bound.String(),
+		Resolved: resolved.AsOfSystemTime(),
+		Policy:   string(a.policy),
+	}
+	if err := a.execCfg.DB.Txn(ctx, func(ctx context.Context, txn *client.Txn) error {
+		return sql.MakeEventLogger(a.execCfg).InsertEventRecord(
+			ctx, txn, sql.EventLogChangefeedLagAlert,
+			0 /* no target */, int32(a.execCfg.NodeID.Get()), info,
+		)
+	}); err != nil {
+		log.Errorf(ctx, "unable to record %s event for job %d: %s",
+			sql.EventLogChangefeedLagAlert, a.jobID, err)
+	}
+}
+
+// close is called when the feed stops.
+func (a *lagAlerter) close() {
+	if a.alerting {
+		a.alerting = false
+		a.metrics.Lagging.Dec(1)
+	}
+}
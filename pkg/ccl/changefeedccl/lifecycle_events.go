@@ -0,0 +1,54 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/internal/client"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+)
+
+// The changes to the status of a changefeed's job are recorded in the event
+// log, in the same transaction as the change, so that audit and alerting
+// systems can follow the lifecycle of changefeeds without polling
+// system.jobs. PAUSE JOB and RESUME JOB record the pauses and resumes done by
+// users, see sql.controlJobsNode, and the changefeeds record the pauses they
+// do on their own, like with on_error='pause', and their failures, along with
+// the error they failed with.
+
+// pauseChangefeed pauses the job of a changefeed for the given reason, on the
+// changefeed's own accord. Pausing the job doesn't stop it, but it makes the
+// next update of its progress fail with an error that does.
+func pauseChangefeed(
+	ctx context.Context, execCfg *sql.ExecutorConfig, jobID int64, reason string,
+) error {
+	return execCfg.DB.Txn(ctx, func(ctx context.Context, txn *client.Txn) error {
+		if err := execCfg.JobRegistry.PauseWithReason(ctx, txn, jobID, reason); err != nil {
+			return err
+		}
+		return logChangefeedEvent(ctx, execCfg, txn, sql.EventLogChangefeedPaused, jobID, reason)
+	})
+}
+
+// logChangefeedEvent records an event of the given type about the job of a
+// changefeed, which changed status on its own for the given reason.
+func logChangefeedEvent(
+	ctx context.Context,
+	execCfg *sql.ExecutorConfig,
+	txn *client.Txn,
+	eventType sql.EventLogType,
+	jobID int64,
+	reason string,
+) error {
+	return sql.MakeEventLogger(execCfg).InsertEventRecord(
+		ctx, txn, eventType, 0 /* no target */, int32(execCfg.NodeID.Get()),
+		sql.EventLogChangefeedDetail{JobID: jobID, Reason: reason},
+	)
+}
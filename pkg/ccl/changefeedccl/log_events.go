@@ -0,0 +1,107 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	gojson "encoding/json"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+)
+
+// Changefeeds log the events of their lifecycle, which are their creation,
+// how they handle schema changes, their retries and their failures, as
+// structured events rather than free-form messages, so that log pipelines can
+// pick them out and index their fields. An event is logged as a single line
+// with changefeedLogEventPrefix followed by the event as JSON, see
+// changefeedLogEvent.
+
+// changefeedLogEventPrefix starts the log message of every structured event.
+const changefeedLogEventPrefix = `changefeed event: `
+
+// The types of the structured events.
+const (
+	changefeedLogEventCreated      = `created`
+	changefeedLogEventSchemaChange = `schema_change`
+	changefeedLogEventRetry        = `retry`
+	changefeedLogEventFailed       = `failed`
+)
+
+// changefeedLogEvent is a structured event about a changefeed. The fields that
+// don't apply to its type are left out.
+type changefeedLogEvent struct {
+	Event string `json:"event"`
+	// JobID is the ID of the changefeed's job, or 0 for a changefeed without
+	// a sink.
+	JobID int64 `json:"job_id"`
+	// Sink is the sink URI of the changefeed, redacted by redactSinkURI, or
+	// empty if it couldn't be.
+	Sink string `json:"sink,omitempty"`
+	// Tables and Options are the watched tables and the options of a created
+	// changefeed, the latter redacted by redactChangefeedOpts.
+	Tables  []string          `json:"tables,omitempty"`
+	Options map[string]string `json:"options,omitempty"`
+	// Table and Timestamp are the table and time of a schema change, and
+	// Decision is what the changefeed does about it: `stop` or `pause` for a
+	// schema change event with schema_change_policy stop or pause, and
+	// `restart` when the tables of the databases it watches change, in which
+	// case there's no Table.
+	Table     string `json:"table,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
+	Decision  string `json:"decision,omitempty"`
+	// Error is the error that the changefeed is retried after, failed with, or
+	// stopped or paused with.
+	Error string `json:"error,omitempty"`
+}
+
+// makeChangefeedLogEvent returns a structured event of the given type about
+// the changefeed with the given job ID and details.
+func makeChangefeedLogEvent(
+	event string, jobID int64, details jobspb.ChangefeedDetails,
+) changefeedLogEvent {
+	e := changefeedLogEvent{Event: event, JobID: jobID}
+	// A sink URI that can't be redacted is left out, rather than logged as
+	// is.
+	if sink, err := redactSinkURI(details.SinkURI); err == nil {
+		e.Sink = sink
+	}
+	return e
+}
+
+// log logs the event, as an error if it's a failure, as a warning if it has
+// an error otherwise, and as info if it doesn't.
+func (e changefeedLogEvent) log(ctx context.Context) {
+	msg, err := gojson.Marshal(e)
+	if err != nil {
+		log.Warningf(ctx, `unable to log %s event for changefeed %d: %s`, e.Event, e.JobID, err)
+		return
+	}
+	switch {
+	case e.Event == changefeedLogEventFailed:
+		log.Errorf(ctx, `%s%s`, changefeedLogEventPrefix, msg)
+	case e.Error != ``:
+		log.Warningf(ctx, `%s%s`, changefeedLogEventPrefix, msg)
+	default:
+		log.Infof(ctx, `%s%s`, changefeedLogEventPrefix, msg)
+	}
+}
+
+// redactChangefeedOpts returns the options of a changefeed with the password
+// of the schema registry, if any, redacted.
+func redactChangefeedOpts(opts map[string]string) map[string]string {
+	redacted := make(map[string]string, len(opts))
+	for name, value := range opts {
+		if name == optConfluentSchemaRegistry {
+			value = redactSchemaRegistryURI(value)
+		}
+		redacted[name] = value
+	}
+	return redacted
+}
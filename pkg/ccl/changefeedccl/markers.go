@@ -0,0 +1,113 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	gojson "encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/pkg/errors"
+)
+
+// changefeedMarkerDetail is the json details of a changefeed_marker event, as
+// recorded by crdb_internal.changefeed_emit_marker.
+type changefeedMarkerDetail struct {
+	JobID  int64
+	Marker string
+	// Timestamp is the commit timestamp of the transaction that recorded the
+	// event, as a decimal.
+	Timestamp string
+}
+
+// changefeedMarker is an application-defined marker to be emitted from a
+// changefeed.
+type changefeedMarker struct {
+	marker string
+	ts     hlc.Timestamp
+}
+
+// markerPoller finds the markers recorded for a changefeed job with
+// crdb_internal.changefeed_emit_marker. A marker is emitted once the feed's
+// resolved timestamp reaches the timestamp it was recorded at, after all the
+// changes at or below it and before the resolved timestamp itself, so a
+// consumer that sees it has seen everything that was committed before it.
+//
+// The markers are changefeed_marker events in the event log, which is read as
+// of each resolved timestamp for the ones recorded since the previous one.
+// Those are timestamped with their commit timestamp, so this only reads the
+// events in between.
+type markerPoller struct {
+	execCfg *sql.ExecutorConfig
+	jobID   int64
+	// polled is the resolved timestamp the event log was last read as of.
+	polled hlc.Timestamp
+}
+
+// makeMarkerPoller returns a markerPoller for the markers of a feed that's
+// emitted everything up to highwater, or nil for a sinkless feed, which can't
+// have markers.
+func makeMarkerPoller(
+	execCfg *sql.ExecutorConfig, jobID int64, highwater hlc.Timestamp,
+) *markerPoller {
+	if jobID == 0 {
+		return nil
+	}
+	return &markerPoller{execCfg: execCfg, jobID: jobID, polled: highwater}
+}
+
+// poll returns the markers recorded after the previous resolved timestamp and
+// at or before this one, ordered by timestamp.
+func (p *markerPoller) poll(ctx context.Context, resolved hlc.Timestamp) ([]changefeedMarker, error) {
+	if !p.polled.Less(resolved) {
+		return nil, nil
+	}
+	// The timestamp column only has microseconds, truncate so that the events
+	// in the same microsecond as the previous resolved timestamp are read too.
+	from := timeutil.Unix(0, p.polled.WallTime).Truncate(1000)
+	stmt := fmt.Sprintf(
+		`SELECT info FROM system.eventlog AS OF SYSTEM TIME %s `+
+			`WHERE timestamp >= $1 AND "eventType" = $2 ORDER BY timestamp`,
+		tree.TimestampToDecimal(resolved))
+	rows, _, err := p.execCfg.InternalExecutor.Query(
+		ctx, "changefeed-markers", nil /* txn */, stmt, from, string(sql.EventLogChangefeedMarker))
+	if err != nil {
+		return nil, errors.Wrap(err, `reading changefeed markers`)
+	}
+
+	var markers []changefeedMarker
+	for _, row := range rows {
+		if row[0] == tree.DNull {
+			continue
+		}
+		var info changefeedMarkerDetail
+		if err := gojson.Unmarshal([]byte(tree.MustBeDString(row[0])), &info); err != nil {
+			return nil, errors.Wrap(err, `reading changefeed markers`)
+		}
+		if info.JobID != p.jobID {
+			continue
+		}
+		ts, err := sql.ParseHLC(info.Timestamp)
+		if err != nil {
+			return nil, errors.Wrap(err, `reading changefeed markers`)
+		}
+		if !p.polled.Less(ts) || resolved.Less(ts) {
+			continue
+		}
+		markers = append(markers, changefeedMarker{marker: info.Marker, ts: ts})
+	}
+	sort.SliceStable(markers, func(i, j int) bool { return markers[i].ts.Less(markers[j].ts) })
+	p.polled = resolved
+	return markers, nil
+}
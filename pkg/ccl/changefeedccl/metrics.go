@@ -0,0 +1,523 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	prometheusgo "github.com/prometheus/client_model/go"
+	"golang.org/x/time/rate"
+)
+
+var changefeedOverloadThreshold = settings.RegisterByteSizeSetting(
+	"changefeed.node_overload_threshold",
+	"rate of bytes per second emitted by all changefeeds on a node above which the "+
+		"node reports itself as overloaded by changefeeds in its health alerts",
+	64<<20, // 64 MiB
+)
+
+var changefeedCatchupScanThreshold = settings.RegisterDurationSetting(
+	"changefeed.catchup_scan_threshold",
+	"polls by a changefeed of more than this much time, such as its initial scan or the "+
+		"first poll after it restarts or falls behind, are counted as catch-up scans",
+	time.Minute,
+)
+
+var (
+	metaChangefeedRunning = metric.Metadata{
+		Name:        "changefeed.running",
+		Help:        "Number of changefeeds currently running on this node",
+		Measurement: "Changefeeds",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedEmittedMessages = metric.Metadata{
+		Name:        "changefeed.emitted_messages",
+		Help:        "Messages emitted by all changefeeds on this node",
+		Measurement: "Messages",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedEmittedBytes = metric.Metadata{
+		Name:        "changefeed.emitted_bytes",
+		Help:        "Bytes emitted by all changefeeds on this node",
+		Measurement: "Bytes",
+		Unit:        metric.Unit_BYTES,
+	}
+	metaChangefeedOverloadFeeds = metric.Metadata{
+		Name: "changefeed.overload.feeds",
+		Help: "Number of changefeeds running on this node while changefeeds are emitting " +
+			"more than changefeed.node_overload_threshold, zero otherwise",
+		Measurement: "Changefeeds",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedOverloadBytesPerSec = metric.Metadata{
+		Name: "changefeed.overload.bytes_per_second",
+		Help: "Rate of bytes emitted by all changefeeds on this node while it's above " +
+			"changefeed.node_overload_threshold, zero otherwise",
+		Measurement: "Bytes/sec",
+		Unit:        metric.Unit_BYTES,
+	}
+	metaChangefeedCatchupScans = metric.Metadata{
+		Name:        "changefeed.catchup_scans",
+		Help:        "Catch-up scans performed by changefeeds on this node",
+		Measurement: "Scans",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedCatchupScanNanos = metric.Metadata{
+		Name:        "changefeed.catchup_scan_nanos",
+		Help:        "Total time spent in catch-up scans by changefeeds on this node",
+		Measurement: "Nanoseconds",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+	metaChangefeedCatchupScanBytes = metric.Metadata{
+		Name:        "changefeed.catchup_scan_bytes",
+		Help:        "Bytes of changes read by catch-up scans of changefeeds on this node",
+		Measurement: "Bytes",
+		Unit:        metric.Unit_BYTES,
+	}
+	metaChangefeedLagAlerts = metric.Metadata{
+		Name:        "changefeed.lag_alerts",
+		Help:        "Times changefeeds on this node fell further behind than their lag_alert option",
+		Measurement: "Alerts",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedFlushes = metric.Metadata{
+		Name:        "changefeed.flushes",
+		Help:        "Flushes of rows to their sinks by all changefeeds on this node",
+		Measurement: "Flushes",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedBufferEntries = metric.Metadata{
+		Name:        "changefeed.buffer_entries",
+		Help:        "Entries added to the buffers of all changefeeds on this node",
+		Measurement: "Entries",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedErrorRetries = metric.Metadata{
+		Name:        "changefeed.error_retries",
+		Help:        "Times changefeeds on this node were restarted after a transient error",
+		Measurement: "Retries",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedLagging = metric.Metadata{
+		Name:        "changefeed.lagging",
+		Help:        "Number of changefeeds on this node currently further behind than their lag_alert option",
+		Measurement: "Changefeeds",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaChangefeedCommitLatency = metric.Metadata{
+		Name: "changefeed.commit_latency",
+		Help: "Time between the commit of the changes emitted by changefeeds on this node " +
+			"and their acknowledgment by the sinks",
+		Measurement: "Latency",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+	metaChangefeedSinkFlushLatency = metric.Metadata{
+		Name: "changefeed.sink.flush_latency",
+		Help: "Time taken by the sinks of changefeeds on this node to acknowledge the rows " +
+			"flushed to them",
+		Measurement: "Latency",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+	metaChangefeedSinkPendingMessages = metric.Metadata{
+		Name: "changefeed.sink.pending_messages",
+		Help: "Messages handed to the sinks of changefeeds on this node that they haven't " +
+			"acknowledged yet",
+		Measurement: "Messages",
+		Unit:        metric.Unit_COUNT,
+	}
+)
+
+// commitLatencyMaxValue is the largest commit-to-emit latency that's told apart
+// from larger ones. A changefeed that's restarted or catching up emits changes
+// that are much older than that, but that's not what an SLO on the freshness
+// of its sink is about.
+const commitLatencyMaxValue = time.Hour
+
+// sinkFlushLatencyMaxValue is the largest sink flush latency that's told apart
+// from larger ones.
+const sinkFlushLatencyMaxValue = time.Minute
+
+// emittedBytesRateTimescale is the timescale of the moving average used to
+// decide whether changefeeds are overloading a node. It's long enough to not
+// report short bursts, like the end of an initial scan.
+const emittedBytesRateTimescale = time.Minute
+
+// Metrics are the metrics of the changefeeds running on a node. The overload
+// metrics are tracked by the node's health checks, which attributes the load
+// to changefeeds in the node's health alerts.
+//
+// Catch-up scans, polls of a long interval of time, are tracked separately
+// because they're the main source of spikes in the load changefeeds put on a
+// cluster, and one that's otherwise hidden.
+//
+// The lag metrics only count feeds with the `lag_alert` option.
+//
+// The commit latency is the time between the commit of a change, its mvcc
+// timestamp, and the acknowledgment of the row emitted for it by the sink,
+// which is the end-to-end freshness of a changefeed that an SLO can be put
+// on, instead of inferring it from the resolved timestamps in the sink.
+//
+// The emitted messages and bytes, flushes, buffer entries, error retries and
+// commit latencies of the feeds with the `metrics_label` option are also
+// exported to prometheus under a `label` label with its value, so that the
+// throughput and freshness of individual feeds can be graphed and alerted on.
+// Feeds that share a label share these metrics.
+//
+// The sink metrics are measured around the sinks of all types alike, and are
+// also exported under a `sink` label with the scheme of the sink, so that a
+// slow kafka broker or webhook endpoint is visible from the database side.
+type Metrics struct {
+	Running             *metric.Gauge
+	EmittedMessages     *labeledCounter
+	EmittedBytes        *labeledCounter
+	Flushes             *labeledCounter
+	BufferEntries       *labeledCounter
+	OverloadFeeds       *metric.Gauge
+	OverloadBytesPerSec *metric.Gauge
+	CatchupScans        *metric.Counter
+	CatchupScanNanos    *metric.Counter
+	CatchupScanBytes    *metric.Counter
+	LagAlerts           *metric.Counter
+	Lagging             *metric.Gauge
+	ErrorRetries        *labeledCounter
+	CommitLatency       *labeledHistogram
+	SinkFlushLatency    *labeledHistogram
+	SinkPendingMessages *labeledGauge
+
+	settings         *cluster.Settings
+	emittedBytesRate *metric.Rate
+	// backfillLimiter and sinkLimiter are shared by the changefeeds on the
+	// node, see makeBackfillLimiter and makeSinkLimiter.
+	backfillLimiter *rate.Limiter
+	sinkLimiter     *rate.Limiter
+	// label is the `metrics_label` of a feed, see withLabel.
+	label string
+	// sink is the label of the sink of a feed, see withSink.
+	sink string
+}
+
+// MetricStruct implements the metric.Struct interface.
+func (*Metrics) MetricStruct() {}
+
+// MakeMetrics makes the metrics for changefeeds run on a node.
+func MakeMetrics(st *cluster.Settings, histogramWindow time.Duration) metric.Struct {
+	m := &Metrics{
+		Running:          metric.NewGauge(metaChangefeedRunning),
+		EmittedMessages:  newLabeledCounter(metaChangefeedEmittedMessages, metricsLabel),
+		EmittedBytes:     newLabeledCounter(metaChangefeedEmittedBytes, metricsLabel),
+		Flushes:          newLabeledCounter(metaChangefeedFlushes, metricsLabel),
+		BufferEntries:    newLabeledCounter(metaChangefeedBufferEntries, metricsLabel),
+		CatchupScans:     metric.NewCounter(metaChangefeedCatchupScans),
+		CatchupScanNanos: metric.NewCounter(metaChangefeedCatchupScanNanos),
+		CatchupScanBytes: metric.NewCounter(metaChangefeedCatchupScanBytes),
+		LagAlerts:        metric.NewCounter(metaChangefeedLagAlerts),
+		Lagging:          metric.NewGauge(metaChangefeedLagging),
+		ErrorRetries:     newLabeledCounter(metaChangefeedErrorRetries, metricsLabel),
+		CommitLatency: newLabeledHistogram(
+			metaChangefeedCommitLatency, metricsLabel, histogramWindow, commitLatencyMaxValue),
+		SinkFlushLatency: newLabeledHistogram(
+			metaChangefeedSinkFlushLatency, sinkLabel, histogramWindow, sinkFlushLatencyMaxValue),
+		SinkPendingMessages: newLabeledGauge(metaChangefeedSinkPendingMessages, sinkLabel),
+		settings:            st,
+		emittedBytesRate:    metric.NewRate(emittedBytesRateTimescale),
+		backfillLimiter: rate.NewLimiter(
+			rate.Limit(changefeedBackfillRate.Get(&st.SV)), limiterBurst),
+		sinkLimiter: rate.NewLimiter(rate.Limit(changefeedSinkRate.Get(&st.SV)), limiterBurst),
+	}
+	m.OverloadFeeds = metric.NewFunctionalGauge(metaChangefeedOverloadFeeds, func() int64 {
+		if !m.overloaded() {
+			return 0
+		}
+		return m.Running.Value()
+	})
+	m.OverloadBytesPerSec = metric.NewFunctionalGauge(metaChangefeedOverloadBytesPerSec, func() int64 {
+		if !m.overloaded() {
+			return 0
+		}
+		return int64(m.emittedBytesRate.Value())
+	})
+	return m
+}
+
+func init() {
+	jobs.MakeChangefeedMetricsHook = MakeMetrics
+}
+
+// getMetrics returns the changefeed metrics of the node. Some benchmarks run
+// changefeeds without a job registry, they get metrics that aren't registered
+// anywhere.
+func getMetrics(execCfg *sql.ExecutorConfig) *Metrics {
+	if execCfg.JobRegistry != nil {
+		if m, ok := execCfg.JobRegistry.MetricsStruct().Changefeed.(*Metrics); ok {
+			return m
+		}
+	}
+	return MakeMetrics(execCfg.Settings, metric.TestSampleInterval).(*Metrics)
+}
+
+// withLabel returns the metrics for a feed with the given `metrics_label`,
+// which are the same as m except that the labeled counters are also
+// incremented under the label.
+func (m *Metrics) withLabel(label string) *Metrics {
+	if label == `` {
+		return m
+	}
+	labeled := *m
+	labeled.label = label
+	return &labeled
+}
+
+// inc increments a labeled counter, along with its child for the feed's
+// label, if any.
+func (m *Metrics) inc(c *labeledCounter, v int64) {
+	c.Inc(v)
+	if m.label != `` {
+		c.child(m.label).Inc(v)
+	}
+}
+
+// withSink returns the metrics for a feed with a sink with the given URI, which
+// are the same as m except that the sink metrics are also recorded under the
+// scheme of the sink, or `sinkless` for sinkless feeds.
+func (m *Metrics) withSink(sinkURI string) *Metrics {
+	sink := `sinkless`
+	if u, err := url.Parse(sinkURI); err == nil && u.Scheme != `` {
+		sink = u.Scheme
+	}
+	withSink := *m
+	withSink.sink = sink
+	return &withSink
+}
+
+// recordEmit is called after rows are successfully emitted to a sink.
+func (m *Metrics) recordEmit(messages int, bytes int64) {
+	m.inc(m.EmittedMessages, int64(messages))
+	m.inc(m.EmittedBytes, bytes)
+	m.emittedBytesRate.Add(float64(bytes))
+}
+
+// recordFlush is called after the rows emitted to a sink have been
+// acknowledged by it.
+func (m *Metrics) recordFlush() {
+	m.inc(m.Flushes, 1)
+}
+
+// recordBufferEntry is called when a changefeed adds an entry to its buffer.
+func (m *Metrics) recordBufferEntry() {
+	m.inc(m.BufferEntries, 1)
+}
+
+// recordErrorRetry is called when a changefeed is restarted after a transient
+// error.
+func (m *Metrics) recordErrorRetry() {
+	m.inc(m.ErrorRetries, 1)
+}
+
+// recordCommitLatency is called when the sink acknowledges a row emitted for a
+// change with the given mvcc timestamp. Rows without one, like the ones
+// emitted by some tests, aren't recorded.
+func (m *Metrics) recordCommitLatency(updated hlc.Timestamp, acked time.Time) {
+	if updated == (hlc.Timestamp{}) {
+		return
+	}
+	latency := acked.Sub(updated.GoTime()).Nanoseconds()
+	m.CommitLatency.RecordValue(latency)
+	if m.label != `` {
+		m.CommitLatency.child(m.label).RecordValue(latency)
+	}
+}
+
+// recordSinkFlush is called after a sink acknowledges the rows flushed to it,
+// or fails to.
+func (m *Metrics) recordSinkFlush(latency time.Duration) {
+	m.SinkFlushLatency.RecordValue(latency.Nanoseconds())
+	if m.sink != `` {
+		m.SinkFlushLatency.child(m.sink).RecordValue(latency.Nanoseconds())
+	}
+}
+
+// addSinkPending is called with the number of messages handed to a sink, and
+// with minus the number of those that it acknowledged, or that failed.
+func (m *Metrics) addSinkPending(messages int64) {
+	m.SinkPendingMessages.Inc(messages)
+	if m.sink != `` {
+		m.SinkPendingMessages.child(m.sink).Inc(messages)
+	}
+}
+
+// isCatchupScan returns whether a poll of the given interval of time is a
+// catch-up scan.
+func (m *Metrics) isCatchupScan(interval time.Duration) bool {
+	return interval > changefeedCatchupScanThreshold.Get(&m.settings.SV)
+}
+
+// recordCatchupScan is called after a catch-up scan finishes.
+func (m *Metrics) recordCatchupScan(duration time.Duration, bytes int64) {
+	m.CatchupScans.Inc(1)
+	m.CatchupScanNanos.Inc(duration.Nanoseconds())
+	m.CatchupScanBytes.Inc(bytes)
+}
+
+func (m *Metrics) overloaded() bool {
+	threshold := changefeedOverloadThreshold.Get(&m.settings.SV)
+	return threshold > 0 && m.Running.Value() > 0 &&
+		m.emittedBytesRate.Value() > float64(threshold)
+}
+
+// The names of the labels that the children of labeled metrics are exported
+// to prometheus under.
+const (
+	// metricsLabel is the label of the metrics of the feeds with a
+	// `metrics_label`.
+	metricsLabel = `label`
+	// sinkLabel is the label of the sink metrics, which is the scheme of the
+	// sink, see Metrics.withSink.
+	sinkLabel = `sink`
+)
+
+// labeledChildren are the children of a metric of all changefeeds, one for
+// each value of a label, which are only exported to prometheus.
+type labeledChildren struct {
+	metadata metric.Metadata
+	label    string
+	newChild func(metric.Metadata) metric.PrometheusExportable
+
+	mu struct {
+		syncutil.Mutex
+		children map[string]metric.PrometheusExportable
+	}
+}
+
+func (c *labeledChildren) init(
+	metadata metric.Metadata, label string, newChild func(metric.Metadata) metric.PrometheusExportable,
+) {
+	c.metadata = metadata
+	c.label = label
+	c.newChild = newChild
+	c.mu.children = make(map[string]metric.PrometheusExportable)
+}
+
+// get returns the child with the given value of the label.
+func (c *labeledChildren) get(value string) metric.PrometheusExportable {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	child, ok := c.mu.children[value]
+	if !ok {
+		metadata := c.metadata
+		metadata.Labels = append([]*metric.LabelPair(nil), metadata.Labels...)
+		metadata.AddLabel(c.label, value)
+		child = c.newChild(metadata)
+		c.mu.children[value] = child
+	}
+	return child
+}
+
+// EachChild implements the metric.PrometheusIterable interface.
+func (c *labeledChildren) EachChild(f func(*prometheusgo.Metric)) {
+	c.mu.Lock()
+	values := make([]string, 0, len(c.mu.children))
+	for value := range c.mu.children {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	children := make([]metric.PrometheusExportable, len(values))
+	for i, value := range values {
+		children[i] = c.mu.children[value]
+	}
+	c.mu.Unlock()
+
+	for _, child := range children {
+		m := child.ToPrometheusMetric()
+		m.Label = child.GetLabels()
+		f(m)
+	}
+}
+
+// labeledCounter is a counter of all changefeeds with a child counter for each
+// value of a label, which is only exported to prometheus.
+type labeledCounter struct {
+	*metric.Counter
+	labeledChildren
+}
+
+var _ metric.PrometheusIterable = &labeledCounter{}
+
+func newLabeledCounter(metadata metric.Metadata, label string) *labeledCounter {
+	c := &labeledCounter{Counter: metric.NewCounter(metadata)}
+	c.init(metadata, label, func(metadata metric.Metadata) metric.PrometheusExportable {
+		return metric.NewCounter(metadata)
+	})
+	return c
+}
+
+// child returns the counter of the changefeeds with the given value of the
+// label.
+func (c *labeledCounter) child(value string) *metric.Counter {
+	return c.get(value).(*metric.Counter)
+}
+
+// labeledGauge is a gauge of all changefeeds with a child gauge for each value
+// of a label, which is only exported to prometheus.
+type labeledGauge struct {
+	*metric.Gauge
+	labeledChildren
+}
+
+var _ metric.PrometheusIterable = &labeledGauge{}
+
+func newLabeledGauge(metadata metric.Metadata, label string) *labeledGauge {
+	g := &labeledGauge{Gauge: metric.NewGauge(metadata)}
+	g.init(metadata, label, func(metadata metric.Metadata) metric.PrometheusExportable {
+		return metric.NewGauge(metadata)
+	})
+	return g
+}
+
+// child returns the gauge of the changefeeds with the given value of the
+// label.
+func (g *labeledGauge) child(value string) *metric.Gauge {
+	return g.get(value).(*metric.Gauge)
+}
+
+// labeledHistogram is a histogram of all changefeeds with a child histogram
+// for each value of a label, which is only exported to prometheus. Values are
+// truncated to maxVal.
+type labeledHistogram struct {
+	*metric.Histogram
+	labeledChildren
+}
+
+var _ metric.PrometheusIterable = &labeledHistogram{}
+
+func newLabeledHistogram(
+	metadata metric.Metadata, label string, window time.Duration, maxVal time.Duration,
+) *labeledHistogram {
+	h := &labeledHistogram{
+		Histogram: metric.NewHistogram(metadata, window, maxVal.Nanoseconds(), 1),
+	}
+	h.init(metadata, label, func(metadata metric.Metadata) metric.PrometheusExportable {
+		return metric.NewHistogram(metadata, window, maxVal.Nanoseconds(), 1)
+	})
+	return h
+}
+
+// child returns the histogram of the changefeeds with the given value of the
+// label.
+func (h *labeledHistogram) child(value string) *metric.Histogram {
+	return h.get(value).(*metric.Histogram)
+}
@@ -0,0 +1,106 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+func TestMetricsLabel(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	st := cluster.MakeTestingClusterSettings()
+	m := MakeMetrics(st, metric.TestSampleInterval).(*Metrics)
+	m.recordEmit(1, 10)
+	m.withLabel(`orders`).recordEmit(2, 20)
+	m.withLabel(`users`).recordEmit(3, 30)
+	m.withLabel(`orders`).recordEmit(4, 40)
+
+	if emitted := m.EmittedMessages.Count(); emitted != 10 {
+		t.Errorf(`expected 10 emitted messages got %d`, emitted)
+	}
+	m.recordFlush()
+	m.withLabel(`orders`).recordFlush()
+	m.withLabel(`orders`).recordErrorRetry()
+	if flushes := m.Flushes.Count(); flushes != 2 {
+		t.Errorf(`expected 2 flushes got %d`, flushes)
+	}
+	if flushes := m.Flushes.child(`orders`).Count(); flushes != 1 {
+		t.Errorf(`expected 1 flush for orders got %d`, flushes)
+	}
+	if retries := m.ErrorRetries.child(`orders`).Count(); retries != 1 {
+		t.Errorf(`expected 1 error retry for orders got %d`, retries)
+	}
+
+	// Rows without an mvcc timestamp have no commit latency.
+	acked := timeutil.Now()
+	m.withLabel(`orders`).recordCommitLatency(hlc.Timestamp{WallTime: acked.UnixNano()}, acked)
+	m.recordCommitLatency(hlc.Timestamp{}, acked)
+	if count := m.CommitLatency.TotalCount(); count != 1 {
+		t.Errorf(`expected 1 commit latency got %d`, count)
+	}
+	if count := m.CommitLatency.child(`orders`).TotalCount(); count != 1 {
+		t.Errorf(`expected 1 commit latency for orders got %d`, count)
+	}
+
+	// The sink metrics are labeled with the scheme of the sink.
+	m.withSink(`kafka://localhost:9092`).addSinkPending(2)
+	m.withSink(``).addSinkPending(1)
+	if pending := m.SinkPendingMessages.Value(); pending != 3 {
+		t.Errorf(`expected 3 pending messages got %d`, pending)
+	}
+	for sink, expected := range map[string]int64{`kafka`: 2, `sinkless`: 1} {
+		if pending := m.SinkPendingMessages.child(sink).Value(); pending != expected {
+			t.Errorf(`expected %d pending messages for %s got %d`, expected, sink, pending)
+		}
+	}
+
+	registry := metric.NewRegistry()
+	registry.AddMetricStruct(m)
+	pe := metric.MakePrometheusExporter()
+	pe.ScrapeRegistry(registry)
+	families, err := pe.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The aggregate is exported without a label, and each label with its own
+	// counts.
+	expected := map[string]float64{``: 100, `orders`: 60, `users`: 30}
+	for _, family := range families {
+		if family.GetName() != `changefeed_emitted_bytes` {
+			continue
+		}
+		actual := make(map[string]float64)
+		for _, m := range family.GetMetric() {
+			var label string
+			for _, l := range m.GetLabel() {
+				if l.GetName() == `label` {
+					label = l.GetValue()
+				}
+			}
+			actual[label] = m.GetCounter().GetValue()
+		}
+		if len(actual) != len(expected) {
+			t.Fatalf(`expected %v got %v`, expected, actual)
+		}
+		for label, value := range expected {
+			if actual[label] != value {
+				t.Errorf(`expected %v got %v`, expected, actual)
+			}
+		}
+		return
+	}
+	t.Fatal(`no changefeed_emitted_bytes metrics were exported`)
+}
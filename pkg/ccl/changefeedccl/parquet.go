@@ -0,0 +1,553 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/pkg/errors"
+)
+
+// fileContentEncoder is implemented by fileFormatEncoders whose files are not
+// a sequence of delimited rows, such as columnar formats, which have to see
+// every row of a file before any of it can be written.
+type fileContentEncoder interface {
+	fileFormatEncoder
+	// EncodeFile returns the full contents of a file holding the given rows of
+	// a topic. Each row is what the encoder returned from EncodeValue, or from
+	// EncodeKey for rows without a value.
+	EncodeFile(topic string, rows [][]byte) ([]byte, error)
+}
+
+// parquetEncoder encodes changefeed entries as Apache Parquet files, for
+// export-style changefeeds into cloud storage that are queried directly by
+// tools like Spark, Athena, or BigQuery. The file schema is derived from the
+// table descriptor: every column of the table, in order, optional so that it
+// can hold NULLs, followed by the updated timestamp if the `updated` or
+// `timestamps` option is set. Deletions, which have no value, are written as their key: the primary
+// key columns set and every other column NULL. Resolved timestamp payloads are
+// the bare timestamp.
+//
+// Files are written with a single row group, a single uncompressed data page
+// per column, and PLAIN encoded values, which every Parquet reader supports.
+// There is no Parquet library available, so this implements just enough of the
+// format (including the Thrift compact protocol used by its metadata) to write
+// such files.
+//
+// Until a whole file is encoded, each row is kept in an intermediate form: for
+// every column, a byte that is 0 for NULL and 1 otherwise, followed in the
+// latter case by the PLAIN encoding of the value.
+type parquetEncoder struct {
+	updatedField bool
+
+	// schemas is the schema for each topic, as of the most recently encoded
+	// row, and schemaVersions the table descriptor it was computed from.
+	//
+	// TODO: A schema change in the middle of a file makes the rows before
+	// it undecodable with the new schema, and EncodeFile returns an error.
+	// Start a new file instead.
+	schemas        map[string][]parquetColumn
+	schemaVersions map[string]tableIDAndVersion
+
+	buf []byte
+}
+
+var _ Encoder = &parquetEncoder{}
+var _ fileContentEncoder = &parquetEncoder{}
+
+// parquetColumn is a column of a Parquet file, along with how to encode the
+// datums of the SQL column it was derived from.
+type parquetColumn struct {
+	name          string
+	physicalType  int32
+	convertedType int32
+	// appendPlain appends the PLAIN encoding of a non-NULL datum. Booleans are
+	// appended as a byte and bit-packed by EncodeFile.
+	appendPlain func(buf []byte, d tree.Datum) []byte
+}
+
+// The subset of the enums in the Parquet format's Thrift definitions used by
+// parquetEncoder.
+const (
+	parquetTypeBoolean   = 0
+	parquetTypeInt32     = 1
+	parquetTypeInt64     = 2
+	parquetTypeDouble    = 5
+	parquetTypeByteArray = 6
+
+	// parquetConvertedNone isn't in the Parquet format, it means the
+	// converted_type field is omitted.
+	parquetConvertedNone            = -1
+	parquetConvertedUTF8            = 0
+	parquetConvertedDate            = 6
+	parquetConvertedTimestampMicros = 10
+	parquetConvertedJSON            = 19
+
+	parquetRepetitionOptional = 1
+	parquetEncodingPlain      = 0
+	parquetEncodingRLE        = 3
+	parquetCodecUncompressed  = 0
+	parquetPageTypeData       = 0
+)
+
+const parquetMagic = `PAR1`
+
+func makeParquetEncoder(details jobspb.ChangefeedDetails) *parquetEncoder {
+	updatedField := hasUpdatedField(details.Opts)
+	return &parquetEncoder{
+		updatedField:   updatedField,
+		schemas:        make(map[string][]parquetColumn),
+		schemaVersions: make(map[string]tableIDAndVersion),
+	}
+}
+
+// EncodeKey implements the Encoder interface.
+func (e *parquetEncoder) EncodeKey(_ context.Context, row encodeRow) ([]byte, error) {
+	schema, err := e.updateSchema(row)
+	if err != nil {
+		return nil, err
+	}
+	colIdxByID := row.tableDesc.ColumnIdxMap()
+	isKey := make([]bool, len(row.datums))
+	for _, colID := range row.tableDesc.PrimaryIndex.ColumnIDs {
+		idx, ok := colIdxByID[colID]
+		if !ok {
+			return nil, errors.Errorf(`unknown column id: %d`, colID)
+		}
+		isKey[idx] = true
+	}
+	e.buf = e.buf[:0]
+	for i, d := range row.datums {
+		if !isKey[i] {
+			d = tree.DNull
+		}
+		e.buf = parquetAppendCell(e.buf, schema[i], d)
+	}
+	if e.updatedField {
+		e.buf = parquetAppendCell(e.buf, schema[len(schema)-1], tree.DNull)
+	}
+	return e.buf, nil
+}
+
+// EncodeValue implements the Encoder interface.
+func (e *parquetEncoder) EncodeValue(_ context.Context, row encodeRow) ([]byte, error) {
+	if row.deleted {
+		return nil, nil
+	}
+	schema, err := e.updateSchema(row)
+	if err != nil {
+		return nil, err
+	}
+	e.buf = e.buf[:0]
+	for i, d := range row.datums {
+		e.buf = parquetAppendCell(e.buf, schema[i], d)
+	}
+	if e.updatedField {
+		updated := tree.TimestampToDecimal(row.updated).Decimal.String()
+		e.buf = parquetAppendCell(e.buf, schema[len(schema)-1], tree.NewDString(updated))
+	}
+	return e.buf, nil
+}
+
+// EncodeResolvedTimestamp implements the Encoder interface.
+func (e *parquetEncoder) EncodeResolvedTimestamp(
+	_ context.Context, resolved hlc.Timestamp,
+) ([]byte, error) {
+	return []byte(tree.TimestampToDecimal(resolved).Decimal.String()), nil
+}
+
+// FileExtension implements the fileFormatEncoder interface.
+func (e *parquetEncoder) FileExtension() string {
+	return `parquet`
+}
+
+// FileHeader implements the fileFormatEncoder interface.
+func (e *parquetEncoder) FileHeader(string) []byte {
+	return nil
+}
+
+func (e *parquetEncoder) updateSchema(row encodeRow) ([]parquetColumn, error) {
+	topic := row.topic
+	version := tableIDAndVersion{id: row.tableDesc.ID, version: row.tableDesc.Version}
+	if prev, ok := e.schemaVersions[topic]; ok && prev == version {
+		return e.schemas[topic], nil
+	}
+	schema := make([]parquetColumn, 0, len(row.tableDesc.Columns)+1)
+	for i := range row.tableDesc.Columns {
+		col, err := columnDescToParquetColumn(&row.tableDesc.Columns[i])
+		if err != nil {
+			return nil, err
+		}
+		schema = append(schema, col)
+	}
+	if e.updatedField {
+		schema = append(schema, parquetColumn{
+			name:          csvUpdatedColumn,
+			physicalType:  parquetTypeByteArray,
+			convertedType: parquetConvertedUTF8,
+			appendPlain:   parquetAppendString,
+		})
+	}
+	e.schemas[topic] = schema
+	e.schemaVersions[topic] = version
+	return schema, nil
+}
+
+func columnDescToParquetColumn(colDesc *sqlbase.ColumnDescriptor) (parquetColumn, error) {
+	col := parquetColumn{name: colDesc.Name, convertedType: parquetConvertedNone}
+	switch colDesc.Type.SemanticType {
+	case sqlbase.ColumnType_BOOL:
+		col.physicalType = parquetTypeBoolean
+		col.appendPlain = func(buf []byte, d tree.Datum) []byte {
+			if *d.(*tree.DBool) {
+				return append(buf, 1)
+			}
+			return append(buf, 0)
+		}
+	case sqlbase.ColumnType_INT:
+		col.physicalType = parquetTypeInt64
+		col.appendPlain = func(buf []byte, d tree.Datum) []byte {
+			return parquetAppendInt64(buf, int64(*d.(*tree.DInt)))
+		}
+	case sqlbase.ColumnType_FLOAT:
+		col.physicalType = parquetTypeDouble
+		col.appendPlain = func(buf []byte, d tree.Datum) []byte {
+			return parquetAppendInt64(buf, int64(math.Float64bits(float64(*d.(*tree.DFloat)))))
+		}
+	case sqlbase.ColumnType_STRING:
+		col.physicalType = parquetTypeByteArray
+		col.convertedType = parquetConvertedUTF8
+		col.appendPlain = parquetAppendString
+	case sqlbase.ColumnType_BYTES:
+		col.physicalType = parquetTypeByteArray
+		col.appendPlain = func(buf []byte, d tree.Datum) []byte {
+			return parquetAppendByteArray(buf, []byte(*d.(*tree.DBytes)))
+		}
+	case sqlbase.ColumnType_DATE:
+		col.physicalType = parquetTypeInt32
+		col.convertedType = parquetConvertedDate
+		col.appendPlain = func(buf []byte, d tree.Datum) []byte {
+			// DDate is days since the unix epoch, which is exactly the parquet
+			// DATE converted type.
+			var scratch [4]byte
+			binary.LittleEndian.PutUint32(scratch[:], uint32(int32(*d.(*tree.DDate))))
+			return append(buf, scratch[:]...)
+		}
+	case sqlbase.ColumnType_TIMESTAMP:
+		col.physicalType = parquetTypeInt64
+		col.convertedType = parquetConvertedTimestampMicros
+		col.appendPlain = func(buf []byte, d tree.Datum) []byte {
+			return parquetAppendInt64(buf, d.(*tree.DTimestamp).UnixNano()/1000)
+		}
+	case sqlbase.ColumnType_TIMESTAMPTZ:
+		col.physicalType = parquetTypeInt64
+		col.convertedType = parquetConvertedTimestampMicros
+		col.appendPlain = func(buf []byte, d tree.Datum) []byte {
+			return parquetAppendInt64(buf, d.(*tree.DTimestampTZ).UnixNano()/1000)
+		}
+	case sqlbase.ColumnType_JSON:
+		col.physicalType = parquetTypeByteArray
+		col.convertedType = parquetConvertedJSON
+		col.appendPlain = func(buf []byte, d tree.Datum) []byte {
+			return parquetAppendByteArray(buf, []byte(d.(*tree.DJSON).JSON.String()))
+		}
+	case sqlbase.ColumnType_DECIMAL, sqlbase.ColumnType_UUID, sqlbase.ColumnType_INET,
+		sqlbase.ColumnType_INTERVAL, sqlbase.ColumnType_TIME:
+		// TODO: DECIMAL has a parquet logical type, but it needs a fixed
+		// scale, which isn't known for DECIMAL columns without one.
+		col.physicalType = parquetTypeByteArray
+		col.convertedType = parquetConvertedUTF8
+		col.appendPlain = parquetAppendString
+	default:
+		return parquetColumn{}, errors.Errorf(
+			`column %s: type %s not yet supported with %s=%s`,
+			colDesc.Name, colDesc.Type.SQLString(), optFormat, optFormatParquet)
+	}
+	return col, nil
+}
+
+// parquetAppendCell appends a datum in the intermediate row form.
+func parquetAppendCell(buf []byte, col parquetColumn, d tree.Datum) []byte {
+	if d == tree.DNull {
+		return append(buf, 0)
+	}
+	return col.appendPlain(append(buf, 1), d)
+}
+
+func parquetAppendString(buf []byte, d tree.Datum) []byte {
+	if s, ok := d.(*tree.DString); ok {
+		return parquetAppendByteArray(buf, []byte(*s))
+	}
+	return parquetAppendByteArray(buf, []byte(tree.AsStringWithFlags(d, tree.FmtBareStrings)))
+}
+
+func parquetAppendInt64(buf []byte, v int64) []byte {
+	var scratch [8]byte
+	binary.LittleEndian.PutUint64(scratch[:], uint64(v))
+	return append(buf, scratch[:]...)
+}
+
+func parquetAppendByteArray(buf []byte, b []byte) []byte {
+	var scratch [4]byte
+	binary.LittleEndian.PutUint32(scratch[:], uint32(len(b)))
+	return append(append(buf, scratch[:]...), b...)
+}
+
+// parquetColumnChunk accumulates the definition levels and the PLAIN encoded
+// non-NULL values of one column of a file.
+type parquetColumnChunk struct {
+	defined []bool
+	values  []byte
+	// bools holds boolean values until they're bit-packed.
+	bools []bool
+}
+
+// EncodeFile implements the fileContentEncoder interface.
+func (e *parquetEncoder) EncodeFile(topic string, rows [][]byte) ([]byte, error) {
+	schema, ok := e.schemas[topic]
+	if !ok {
+		return nil, errors.Errorf(`no parquet schema for %s`, topic)
+	}
+	chunks := make([]parquetColumnChunk, len(schema))
+	for _, row := range rows {
+		for i := range schema {
+			var err error
+			if row, err = chunks[i].addCell(schema[i], row); err != nil {
+				return nil, errors.Wrapf(err, `decoding column %s`, schema[i].name)
+			}
+		}
+		if len(row) > 0 {
+			return nil, errors.Errorf(`%d unexpected trailing bytes in row`, len(row))
+		}
+	}
+
+	buf := []byte(parquetMagic)
+	columnChunks := make([][]byte, len(schema))
+	var totalSize int64
+	for i, col := range schema {
+		page := parquetAppendDefinitionLevels(nil, chunks[i].defined)
+		if col.physicalType == parquetTypeBoolean {
+			page = parquetAppendBitPacked(page, chunks[i].bools)
+		} else {
+			page = append(page, chunks[i].values...)
+		}
+		pageHeader := thriftStruct(
+			thriftI32(1, parquetPageTypeData),
+			thriftI32(2, int64(len(page))),
+			thriftI32(3, int64(len(page))),
+			thriftStructField(5,
+				thriftI32(1, int64(len(rows))),
+				thriftI32(2, parquetEncodingPlain),
+				thriftI32(3, parquetEncodingRLE),
+				thriftI32(4, parquetEncodingRLE),
+			),
+		)
+		offset := int64(len(buf))
+		size := int64(len(pageHeader) + len(page))
+		buf = append(append(buf, pageHeader...), page...)
+		totalSize += size
+
+		columnChunks[i] = thriftStruct(
+			thriftI64(2, offset),
+			thriftStructField(3,
+				thriftI32(1, int64(col.physicalType)),
+				thriftList(2, thriftTypeI32, thriftI32Elems(parquetEncodingPlain, parquetEncodingRLE)),
+				thriftList(3, thriftTypeBinary, [][]byte{thriftBinaryElem([]byte(col.name))}),
+				thriftI32(4, parquetCodecUncompressed),
+				thriftI64(5, int64(len(rows))),
+				thriftI64(6, size),
+				thriftI64(7, size),
+				thriftI64(9, offset),
+			),
+		)
+	}
+
+	schemaElements := make([][]byte, 0, len(schema)+1)
+	schemaElements = append(schemaElements, thriftStruct(
+		thriftBinary(4, []byte(`schema`)),
+		thriftI32(5, int64(len(schema))),
+	))
+	for _, col := range schema {
+		fields := []thriftField{
+			thriftI32(1, int64(col.physicalType)),
+			thriftI32(3, parquetRepetitionOptional),
+			thriftBinary(4, []byte(col.name)),
+		}
+		if col.convertedType != parquetConvertedNone {
+			fields = append(fields, thriftI32(6, int64(col.convertedType)))
+		}
+		schemaElements = append(schemaElements, thriftStruct(fields...))
+	}
+
+	footer := thriftStruct(
+		thriftI32(1, 1 /* version */),
+		thriftList(2, thriftTypeStruct, schemaElements),
+		thriftI64(3, int64(len(rows))),
+		thriftList(4, thriftTypeStruct, [][]byte{thriftStruct(
+			thriftList(1, thriftTypeStruct, columnChunks),
+			thriftI64(2, totalSize),
+			thriftI64(3, int64(len(rows))),
+		)}),
+		thriftBinary(6, []byte(`cockroachdb changefeed`)),
+	)
+	buf = append(buf, footer...)
+	var footerLen [4]byte
+	binary.LittleEndian.PutUint32(footerLen[:], uint32(len(footer)))
+	buf = append(buf, footerLen[:]...)
+	return append(buf, parquetMagic...), nil
+}
+
+// addCell consumes one cell of the intermediate row form from the front of row
+// and returns the remainder.
+func (c *parquetColumnChunk) addCell(col parquetColumn, row []byte) ([]byte, error) {
+	if len(row) < 1 {
+		return nil, errors.New(`unexpected end of row`)
+	}
+	defined := row[0] != 0
+	row = row[1:]
+	c.defined = append(c.defined, defined)
+	if !defined {
+		return row, nil
+	}
+	var n int
+	switch col.physicalType {
+	case parquetTypeBoolean:
+		if len(row) < 1 {
+			return nil, errors.New(`unexpected end of row`)
+		}
+		c.bools = append(c.bools, row[0] != 0)
+		return row[1:], nil
+	case parquetTypeInt32:
+		n = 4
+	case parquetTypeInt64, parquetTypeDouble:
+		n = 8
+	case parquetTypeByteArray:
+		if len(row) < 4 {
+			return nil, errors.New(`unexpected end of row`)
+		}
+		n = 4 + int(binary.LittleEndian.Uint32(row))
+	default:
+		return nil, errors.Errorf(`unknown parquet type: %d`, col.physicalType)
+	}
+	if len(row) < n {
+		return nil, errors.New(`unexpected end of row`)
+	}
+	c.values = append(c.values, row[:n]...)
+	return row[n:], nil
+}
+
+// parquetAppendDefinitionLevels appends the definition levels of an optional
+// top-level column, which are 1 bit wide, as a length prefixed run in the
+// RLE/bit-packing hybrid encoding.
+func parquetAppendDefinitionLevels(buf []byte, defined []bool) []byte {
+	var run []byte
+	run = protoAppendUvarint(run, uint64((len(defined)+7)/8)<<1|1)
+	run = parquetAppendBitPacked(run, defined)
+	var scratch [4]byte
+	binary.LittleEndian.PutUint32(scratch[:], uint32(len(run)))
+	return append(append(buf, scratch[:]...), run...)
+}
+
+// parquetAppendBitPacked appends bools packed one per bit, least significant
+// bit first, padded with zeros to a whole number of bytes.
+func parquetAppendBitPacked(buf []byte, bools []bool) []byte {
+	for i := 0; i < len(bools); i += 8 {
+		var b byte
+		for j := 0; j < 8 && i+j < len(bools); j++ {
+			if bools[i+j] {
+				b |= 1 << uint(j)
+			}
+		}
+		buf = append(buf, b)
+	}
+	return buf
+}
+
+// The Thrift compact protocol types used by Parquet metadata.
+const (
+	thriftTypeI32    = 5
+	thriftTypeI64    = 6
+	thriftTypeBinary = 8
+	thriftTypeList   = 9
+	thriftTypeStruct = 12
+)
+
+// thriftField is an encoded field of a Thrift struct.
+type thriftField struct {
+	id    int
+	typ   byte
+	value []byte
+}
+
+// thriftStruct encodes a struct in the Thrift compact protocol. The fields must
+// be sorted by id.
+func thriftStruct(fields ...thriftField) []byte {
+	var buf []byte
+	lastID := 0
+	for _, f := range fields {
+		if delta := f.id - lastID; delta > 0 && delta <= 15 {
+			buf = append(buf, byte(delta)<<4|f.typ)
+		} else {
+			buf = append(buf, f.typ)
+			buf = protoAppendZigzag(buf, int64(f.id))
+		}
+		buf = append(buf, f.value...)
+		lastID = f.id
+	}
+	return append(buf, 0 /* stop */)
+}
+
+func thriftI32(id int, v int64) thriftField {
+	return thriftField{id: id, typ: thriftTypeI32, value: protoAppendZigzag(nil, v)}
+}
+
+func thriftI64(id int, v int64) thriftField {
+	return thriftField{id: id, typ: thriftTypeI64, value: protoAppendZigzag(nil, v)}
+}
+
+func thriftBinary(id int, b []byte) thriftField {
+	return thriftField{id: id, typ: thriftTypeBinary, value: thriftBinaryElem(b)}
+}
+
+func thriftStructField(id int, fields ...thriftField) thriftField {
+	return thriftField{id: id, typ: thriftTypeStruct, value: thriftStruct(fields...)}
+}
+
+// thriftList encodes a list of already encoded elements of the given type.
+func thriftList(id int, elemType byte, elems [][]byte) thriftField {
+	var value []byte
+	if len(elems) < 15 {
+		value = append(value, byte(len(elems))<<4|elemType)
+	} else {
+		value = append(value, 0xf0|elemType)
+		value = protoAppendUvarint(value, uint64(len(elems)))
+	}
+	for _, elem := range elems {
+		value = append(value, elem...)
+	}
+	return thriftField{id: id, typ: thriftTypeList, value: value}
+}
+
+func thriftI32Elems(vs ...int64) [][]byte {
+	elems := make([][]byte, len(vs))
+	for i, v := range vs {
+		elems[i] = protoAppendZigzag(nil, v)
+	}
+	return elems
+}
+
+func thriftBinaryElem(b []byte) []byte {
+	return append(protoAppendUvarint(nil, uint64(len(b))), b...)
+}
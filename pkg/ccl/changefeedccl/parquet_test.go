@@ -0,0 +1,207 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/pkg/errors"
+)
+
+// decodeThriftValue decodes a Thrift compact protocol value of the given type
+// into a human-readable string and returns the remaining bytes. Structs are
+// `{id:value ...}`, lists are `[value ...]`, and binary values are strings.
+func decodeThriftValue(typ byte, b []byte) (string, []byte, error) {
+	switch typ {
+	case thriftTypeI32, thriftTypeI64:
+		v, n := binary.Varint(b)
+		if n <= 0 {
+			return ``, nil, errors.New(`bad varint`)
+		}
+		return fmt.Sprint(v), b[n:], nil
+	case thriftTypeBinary:
+		l, n := binary.Uvarint(b)
+		if n <= 0 || uint64(len(b)-n) < l {
+			return ``, nil, errors.New(`bad length`)
+		}
+		return string(b[n : n+int(l)]), b[n+int(l):], nil
+	case thriftTypeList:
+		if len(b) < 1 {
+			return ``, nil, errors.New(`bad list header`)
+		}
+		size, elemType := uint64(b[0]>>4), b[0]&0xf
+		b = b[1:]
+		if size == 15 {
+			var n int
+			if size, n = binary.Uvarint(b); n <= 0 {
+				return ``, nil, errors.New(`bad list size`)
+			}
+			b = b[n:]
+		}
+		elems := make([]string, size)
+		for i := range elems {
+			var err error
+			if elems[i], b, err = decodeThriftValue(elemType, b); err != nil {
+				return ``, nil, err
+			}
+		}
+		return `[` + strings.Join(elems, ` `) + `]`, b, nil
+	case thriftTypeStruct:
+		var fields []string
+		id := 0
+		for {
+			if len(b) < 1 {
+				return ``, nil, errors.New(`bad field header`)
+			}
+			header := b[0]
+			b = b[1:]
+			if header == 0 {
+				return `{` + strings.Join(fields, ` `) + `}`, b, nil
+			}
+			if delta := int(header >> 4); delta != 0 {
+				id += delta
+			} else {
+				v, n := binary.Varint(b)
+				if n <= 0 {
+					return ``, nil, errors.New(`bad field id`)
+				}
+				id, b = int(v), b[n:]
+			}
+			var value string
+			var err error
+			if value, b, err = decodeThriftValue(header&0xf, b); err != nil {
+				return ``, nil, err
+			}
+			fields = append(fields, fmt.Sprintf(`%d:%s`, id, value))
+		}
+	default:
+		return ``, nil, errors.Errorf(`unsupported thrift type %d`, typ)
+	}
+}
+
+func TestParquetEncoder(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	tableDesc := &sqlbase.TableDescriptor{
+		Name: `foo`,
+		Columns: []sqlbase.ColumnDescriptor{
+			{ID: 1, Name: `a`, Type: sqlbase.ColumnType{SemanticType: sqlbase.ColumnType_INT}},
+			{ID: 2, Name: `b`, Type: sqlbase.ColumnType{SemanticType: sqlbase.ColumnType_STRING}},
+			{ID: 3, Name: `c`, Type: sqlbase.ColumnType{SemanticType: sqlbase.ColumnType_BOOL}},
+		},
+		PrimaryIndex: sqlbase.IndexDescriptor{ColumnIDs: []sqlbase.ColumnID{1}},
+	}
+	e := makeParquetEncoder(jobspb.ChangefeedDetails{
+		Opts: map[string]string{optTimestamps: ``},
+	})
+	ctx := context.Background()
+
+	var rows [][]byte
+	for _, datums := range []tree.Datums{
+		{tree.NewDInt(1), tree.NewDString(`x`), tree.DBoolTrue},
+		{tree.NewDInt(2), tree.DNull, tree.DBoolFalse},
+	} {
+		row := encodeRow{
+			topic: `foo`, datums: datums, updated: hlc.Timestamp{WallTime: 1}, tableDesc: tableDesc,
+		}
+		value, err := e.EncodeValue(ctx, row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, append([]byte(nil), value...))
+	}
+	deleted := encodeRow{
+		topic:     `foo`,
+		datums:    tree.Datums{tree.NewDInt(3), nil, nil},
+		deleted:   true,
+		tableDesc: tableDesc,
+	}
+	if value, err := e.EncodeValue(ctx, deleted); err != nil {
+		t.Fatal(err)
+	} else if value != nil {
+		t.Errorf(`expected no value for a deletion got %x`, value)
+	}
+	key, err := e.EncodeKey(ctx, deleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows = append(rows, append([]byte(nil), key...))
+
+	file, err := e.EncodeFile(`foo`, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(file, []byte(parquetMagic)) || !bytes.HasSuffix(file, []byte(parquetMagic)) {
+		t.Fatalf(`expected file to start and end with %s: %x`, parquetMagic, file)
+	}
+	footerLen := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	footer := file[len(file)-8-footerLen : len(file)-8]
+	actual, rest, err := decodeThriftValue(thriftTypeStruct, footer)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(rest) > 0 {
+		t.Fatalf(`unexpected %d trailing bytes in footer`, len(rest))
+	}
+
+	// The schema is the root followed by every column and the updated
+	// timestamp. The column chunks are at increasing offsets.
+	schema := `2:[{4:schema 5:4} {1:2 3:1 4:a} {1:6 3:1 4:b 6:0} {1:0 3:1 4:c} ` +
+		`{1:6 3:1 4:__crdb__updated 6:0}]`
+	if !strings.Contains(actual, schema) {
+		t.Errorf("expected schema\n  %s\nin footer\n  %s", schema, actual)
+	}
+	if !strings.Contains(actual, `3:3 4:[{1:[`) {
+		t.Errorf("expected 3 rows in one row group in footer\n  %s", actual)
+	}
+
+	// The first column chunk starts right after the magic with a data page
+	// header, followed by the definition levels and the values of column a.
+	pageHeader, page, err := decodeThriftValue(thriftTypeStruct, file[len(parquetMagic):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{1:0 2:30 3:30 5:{1:3 2:0 3:3 4:3}}`; pageHeader != expected {
+		t.Errorf(`expected page header %s got %s`, expected, pageHeader)
+	}
+	expectedPage := []byte{
+		2, 0, 0, 0, // length of the definition levels
+		3, 0x7, // a bit-packed run of one group of 8: all three defined
+		1, 0, 0, 0, 0, 0, 0, 0,
+		2, 0, 0, 0, 0, 0, 0, 0,
+		3, 0, 0, 0, 0, 0, 0, 0,
+	}
+	if !bytes.HasPrefix(page, expectedPage) {
+		t.Errorf(`expected page %x got %x`, expectedPage, page[:len(expectedPage)])
+	}
+
+	// A truncated row, like one encoded before a schema change, is an error.
+	rows = append(rows, []byte{1})
+	if _, err := e.EncodeFile(`foo`, rows); !testutils.IsError(err, `unexpected end of row`) {
+		t.Errorf(`expected 'unexpected end of row' error got %v`, err)
+	}
+
+	resolved, err := e.EncodeResolvedTimestamp(ctx, hlc.Timestamp{WallTime: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `3.0000000000`; string(resolved) != expected {
+		t.Errorf(`expected %s got %s`, expected, resolved)
+	}
+}
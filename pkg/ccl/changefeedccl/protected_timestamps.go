@@ -0,0 +1,67 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/internal/client"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/storage/protectedts"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+)
+
+// A changefeed with a job protects the data of its tables from garbage
+// collection at and after its high-water mark, see package protectedts, so
+// that a changefeed that's paused or behind for longer than the GC TTL of its
+// tables can still emit every change once it catches up. The protection is
+// moved forward whenever the high-water mark is, and released once the job
+// succeeds, fails or is canceled.
+//
+// A paused changefeed that's never resumed would keep its data forever, so
+// the `gc_protect_expires_after` option bounds how long the protection lasts
+// after the changefeed last made progress. A changefeed that's resumed after
+// its protection expired fails if the data it needs has been collected.
+
+// protectedTimestampID is the ID of the protected timestamp record of a
+// changefeed's job.
+func protectedTimestampID(jobID int64) string {
+	return fmt.Sprintf(`changefeed-%d`, jobID)
+}
+
+// protectChangefeedData protects the data of the tables of a changefeed at and
+// after ts, replacing the protection it had before, if any.
+func protectChangefeedData(
+	ctx context.Context,
+	execCfg *sql.ExecutorConfig,
+	jobID int64,
+	details jobspb.ChangefeedDetails,
+	ts hlc.Timestamp,
+) error {
+	rec := protectedts.Record{ID: protectedTimestampID(jobID), Timestamp: ts}
+	for _, tableDesc := range details.TableDescs {
+		rec.Spans = append(rec.Spans, tableDesc.TableSpan())
+	}
+	if expiresAfter, ok := details.Opts[optGCProtectExpiresAfter]; ok {
+		d, err := time.ParseDuration(expiresAfter)
+		if err != nil {
+			return err
+		}
+		rec.Expiration = execCfg.Clock.Now().Add(d.Nanoseconds(), 0)
+	}
+	return protectedts.Protect(ctx, execCfg.DB, rec)
+}
+
+// releaseChangefeedData releases the protection of a changefeed's data.
+func releaseChangefeedData(ctx context.Context, txn *client.Txn, jobID int64) error {
+	return protectedts.Release(ctx, txn, protectedTimestampID(jobID))
+}
@@ -0,0 +1,230 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/pkg/errors"
+)
+
+// protobufEnvelopeVersion is the version of the envelope described on
+// protobufEncoder. Any change to the envelope that isn't backward compatible
+// under the usual protobuf rules must bump it.
+const protobufEnvelopeVersion = 1
+
+// Field numbers of the messages described on protobufEncoder.
+const (
+	protoKeyVersion = 1
+	protoKeyDatums  = 2
+
+	protoValueVersion = 1
+	protoValueTable   = 2
+	protoValueColumns = 3
+	protoValueUpdated = 4
+
+	protoResolvedVersion  = 1
+	protoResolvedResolved = 2
+
+	protoColumnName  = 1
+	protoColumnDatum = 2
+
+	protoDatumNull   = 1
+	protoDatumBool   = 2
+	protoDatumInt    = 3
+	protoDatumFloat  = 4
+	protoDatumString = 5
+	protoDatumBytes  = 6
+	protoDatumText   = 7
+)
+
+// Protobuf wire types.
+const (
+	protoWireVarint  = 0
+	protoWireFixed64 = 1
+	protoWireBytes   = 2
+)
+
+// protobufEncoder encodes changefeed entries as protobuf messages. Unlike the
+// avro format, the messages don't depend on the schema of the watched table,
+// so consumers can decode them with a single, stable definition:
+//
+//	syntax = "proto3";
+//	package cockroach.changefeed.v1;
+//
+//	// Key is the primary key of a changed row.
+//	message Key {
+//	  uint32 version = 1;
+//	  repeated Datum datums = 2;
+//	}
+//
+//	// Value is every column of a changed row. Deletions have no value.
+//	message Value {
+//	  uint32 version = 1;
+//	  string table = 2;
+//	  repeated Column columns = 3;
+//	  // updated is only set with the `updated` or `timestamps` option.
+//	  string updated = 4;
+//	}
+//
+//	message Resolved {
+//	  uint32 version = 1;
+//	  string resolved = 2;
+//	}
+//
+//	message Column {
+//	  string name = 1;
+//	  Datum datum = 2;
+//	}
+//
+//	message Datum {
+//	  oneof value {
+//	    bool null = 1;
+//	    bool bool = 2;
+//	    sint64 int = 3;
+//	    double float = 4;
+//	    string string = 5;
+//	    bytes bytes = 6;
+//	    // text is any other type in its SQL string form.
+//	    string text = 7;
+//	  }
+//	}
+//
+// Timestamps are the decimal form of an HLC timestamp, like the json format.
+//
+// TODO: Optionally publish a per-table descriptor with typed fields to a
+// schema registry, as is done for avro.
+type protobufEncoder struct {
+	updatedField bool
+
+	buf, column, datum []byte
+}
+
+var _ Encoder = &protobufEncoder{}
+
+func makeProtobufEncoder(details jobspb.ChangefeedDetails) *protobufEncoder {
+	updatedField := hasUpdatedField(details.Opts)
+	return &protobufEncoder{updatedField: updatedField}
+}
+
+// EncodeKey implements the Encoder interface.
+func (e *protobufEncoder) EncodeKey(_ context.Context, row encodeRow) ([]byte, error) {
+	colIdxByID := row.tableDesc.ColumnIdxMap()
+	e.buf = protoAppendVarintField(e.buf[:0], protoKeyVersion, protobufEnvelopeVersion)
+	for _, colID := range row.tableDesc.PrimaryIndex.ColumnIDs {
+		idx, ok := colIdxByID[colID]
+		if !ok {
+			return nil, errors.Errorf(`unknown column id: %d`, colID)
+		}
+		e.datum = protoAppendDatum(e.datum[:0], row.datums[idx])
+		e.buf = protoAppendBytesField(e.buf, protoKeyDatums, e.datum)
+	}
+	return e.buf, nil
+}
+
+// EncodeValue implements the Encoder interface.
+func (e *protobufEncoder) EncodeValue(_ context.Context, row encodeRow) ([]byte, error) {
+	if row.deleted {
+		return nil, nil
+	}
+	e.buf = protoAppendVarintField(e.buf[:0], protoValueVersion, protobufEnvelopeVersion)
+	e.buf = protoAppendBytesField(e.buf, protoValueTable, []byte(row.tableDesc.Name))
+	for i := range row.tableDesc.Columns {
+		// Nested messages are length prefixed, so each level is encoded into
+		// its own buffer before being appended to its parent.
+		e.datum = protoAppendDatum(e.datum[:0], row.datums[i])
+		e.column = protoAppendBytesField(
+			e.column[:0], protoColumnName, []byte(row.tableDesc.Columns[i].Name))
+		e.column = protoAppendBytesField(e.column, protoColumnDatum, e.datum)
+		e.buf = protoAppendBytesField(e.buf, protoValueColumns, e.column)
+	}
+	if e.updatedField {
+		updated := tree.TimestampToDecimal(row.updated).Decimal.String()
+		e.buf = protoAppendBytesField(e.buf, protoValueUpdated, []byte(updated))
+	}
+	return e.buf, nil
+}
+
+// EncodeResolvedTimestamp implements the Encoder interface.
+func (e *protobufEncoder) EncodeResolvedTimestamp(
+	_ context.Context, resolved hlc.Timestamp,
+) ([]byte, error) {
+	var buf []byte
+	buf = protoAppendVarintField(buf, protoResolvedVersion, protobufEnvelopeVersion)
+	resolvedStr := tree.TimestampToDecimal(resolved).Decimal.String()
+	buf = protoAppendBytesField(buf, protoResolvedResolved, []byte(resolvedStr))
+	return buf, nil
+}
+
+// protoAppendDatum appends the fields of a Datum message (but not the length
+// prefix that nests it in another message).
+func protoAppendDatum(buf []byte, d tree.Datum) []byte {
+	if d == tree.DNull {
+		return protoAppendVarintField(buf, protoDatumNull, 1)
+	}
+	switch t := d.(type) {
+	case *tree.DBool:
+		var v uint64
+		if *t {
+			v = 1
+		}
+		return protoAppendVarintField(buf, protoDatumBool, v)
+	case *tree.DInt:
+		buf = protoAppendTag(buf, protoDatumInt, protoWireVarint)
+		return protoAppendZigzag(buf, int64(*t))
+	case *tree.DFloat:
+		buf = protoAppendTag(buf, protoDatumFloat, protoWireFixed64)
+		var scratch [8]byte
+		binary.LittleEndian.PutUint64(scratch[:], math.Float64bits(float64(*t)))
+		return append(buf, scratch[:]...)
+	case *tree.DString:
+		return protoAppendBytesField(buf, protoDatumString, []byte(*t))
+	case *tree.DBytes:
+		return protoAppendBytesField(buf, protoDatumBytes, []byte(*t))
+	case *tree.DJSON:
+		return protoAppendBytesField(buf, protoDatumText, []byte(t.JSON.String()))
+	default:
+		s := tree.AsStringWithFlags(d, tree.FmtBareStrings)
+		return protoAppendBytesField(buf, protoDatumText, []byte(s))
+	}
+}
+
+func protoAppendTag(buf []byte, field int, wireType int) []byte {
+	return protoAppendUvarint(buf, uint64(field)<<3|uint64(wireType))
+}
+
+func protoAppendUvarint(buf []byte, v uint64) []byte {
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(scratch[:], v)
+	return append(buf, scratch[:n]...)
+}
+
+// protoAppendZigzag appends a sint64. The varint encoding in encoding/binary
+// is already zigzag.
+func protoAppendZigzag(buf []byte, v int64) []byte {
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(scratch[:], v)
+	return append(buf, scratch[:n]...)
+}
+
+func protoAppendVarintField(buf []byte, field int, v uint64) []byte {
+	buf = protoAppendTag(buf, field, protoWireVarint)
+	return protoAppendUvarint(buf, v)
+}
+
+func protoAppendBytesField(buf []byte, field int, b []byte) []byte {
+	buf = protoAppendTag(buf, field, protoWireBytes)
+	buf = protoAppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
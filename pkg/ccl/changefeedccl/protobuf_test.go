@@ -0,0 +1,169 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/pkg/errors"
+)
+
+// decodeProtoFields decodes a protobuf message into a human-readable list of
+// `field:value` strings. Length-delimited fields listed in nested are decoded
+// as messages, using the map they point to for their own nested fields. Datum
+// messages, which are the only ones with a sint64, are nested under a nil map.
+func decodeProtoFields(b []byte, nested map[uint64]map[uint64]bool) ([]string, error) {
+	var fields []string
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errors.New(`bad tag`)
+		}
+		b = b[n:]
+		field, wireType := tag>>3, tag&7
+		var value string
+		switch wireType {
+		case protoWireVarint:
+			if field == protoDatumInt && nested == nil {
+				v, n := binary.Varint(b)
+				if n <= 0 {
+					return nil, errors.New(`bad sint64`)
+				}
+				b, value = b[n:], fmt.Sprint(v)
+			} else {
+				v, n := binary.Uvarint(b)
+				if n <= 0 {
+					return nil, errors.New(`bad varint`)
+				}
+				b, value = b[n:], fmt.Sprint(v)
+			}
+		case protoWireFixed64:
+			if len(b) < 8 {
+				return nil, errors.New(`bad fixed64`)
+			}
+			v := math.Float64frombits(binary.LittleEndian.Uint64(b))
+			b, value = b[8:], fmt.Sprint(v)
+		case protoWireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return nil, errors.New(`bad length`)
+			}
+			v := b[n : n+int(l)]
+			b = b[n+int(l):]
+			if inner, ok := nested[field]; ok {
+				var innerNested map[uint64]map[uint64]bool
+				if inner != nil {
+					innerNested = make(map[uint64]map[uint64]bool)
+					for f := range inner {
+						innerNested[f] = nil
+					}
+				}
+				innerFields, err := decodeProtoFields(v, innerNested)
+				if err != nil {
+					return nil, err
+				}
+				value = `{` + strings.Join(innerFields, ` `) + `}`
+			} else {
+				value = string(v)
+			}
+		default:
+			return nil, errors.Errorf(`unexpected wire type %d`, wireType)
+		}
+		fields = append(fields, fmt.Sprintf(`%d:%s`, field, value))
+	}
+	return fields, nil
+}
+
+func TestProtobufEncoder(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	tableDesc := &sqlbase.TableDescriptor{
+		Name: `foo`,
+		Columns: []sqlbase.ColumnDescriptor{
+			{ID: 1, Name: `a`, Type: sqlbase.ColumnType{SemanticType: sqlbase.ColumnType_INT}},
+			{ID: 2, Name: `b`, Type: sqlbase.ColumnType{SemanticType: sqlbase.ColumnType_STRING}},
+			{ID: 3, Name: `c`, Type: sqlbase.ColumnType{SemanticType: sqlbase.ColumnType_FLOAT}},
+			{ID: 4, Name: `d`, Type: sqlbase.ColumnType{SemanticType: sqlbase.ColumnType_BOOL}},
+		},
+		PrimaryIndex: sqlbase.IndexDescriptor{ColumnIDs: []sqlbase.ColumnID{1}},
+	}
+	row := encodeRow{
+		datums:    tree.Datums{tree.NewDInt(-3), tree.NewDString(`x`), tree.NewDFloat(1.5), tree.DNull},
+		updated:   hlc.Timestamp{WallTime: 1, Logical: 2},
+		tableDesc: tableDesc,
+	}
+	e := makeProtobufEncoder(jobspb.ChangefeedDetails{
+		Opts: map[string]string{optTimestamps: ``},
+	})
+	ctx := context.Background()
+
+	key, err := e.EncodeKey(ctx, row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The datums of a Key are nested Datum messages.
+	actual, err := decodeProtoFields(key, map[uint64]map[uint64]bool{protoKeyDatums: nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{`1:1`, `2:{3:-3}`}; !reflect.DeepEqual(expected, actual) {
+		t.Errorf(`expected %v got %v`, expected, actual)
+	}
+
+	value, err := e.EncodeValue(ctx, row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The columns of a Value are nested Column messages, which in turn have
+	// a nested Datum.
+	actual, err = decodeProtoFields(value, map[uint64]map[uint64]bool{
+		protoValueColumns: {protoColumnDatum: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`1:1`, `2:foo`,
+		`3:{1:a 2:{3:-3}}`, `3:{1:b 2:{5:x}}`, `3:{1:c 2:{4:1.5}}`, `3:{1:d 2:{1:1}}`,
+		`4:1.0000000002`,
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected\n  %v\ngot\n  %v", expected, actual)
+	}
+
+	row.deleted = true
+	if value, err := e.EncodeValue(ctx, row); err != nil {
+		t.Fatal(err)
+	} else if value != nil {
+		t.Errorf(`expected no value for a deletion got %x`, value)
+	}
+
+	resolved, err := e.EncodeResolvedTimestamp(ctx, hlc.Timestamp{WallTime: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err = decodeProtoFields(resolved, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{`1:1`, `2:3.0000000000`}; !reflect.DeepEqual(expected, actual) {
+		t.Errorf(`expected %v got %v`, expected, actual)
+	}
+}
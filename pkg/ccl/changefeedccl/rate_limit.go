@@ -0,0 +1,141 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"math"
+
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+)
+
+// changefeedBackfillRate is the rate limit of the backfills of all the
+// changefeeds on a node together, see makeBackfillLimiter.
+var changefeedBackfillRate = settings.RegisterByteSizeSetting(
+	"changefeed.backfill.max_rate",
+	"the rate limit (bytes/sec) of the initial scans and schema change backfills "+
+		"of the changefeeds on a node",
+	math.MaxInt64,
+)
+
+// changefeedSinkRate is the rate limit of the emission of all the changefeeds
+// on a node together, see makeSinkLimiter.
+var changefeedSinkRate = settings.RegisterByteSizeSetting(
+	"changefeed.sink.max_rate",
+	"the rate limit (bytes/sec) of the messages emitted by the changefeeds on a node",
+	math.MaxInt64,
+)
+
+// limiterBurst is the largest burst of the byte limiters. Costs bigger than
+// the burst of a limiter are waited for in several bursts.
+const limiterBurst = 2 << 20 // 2 MiB
+
+// byteLimiter throttles something a changefeed does to a number of bytes per
+// second, against both a limit shared by every changefeed on the node, from a
+// cluster setting, and one of its own, from an option.
+type byteLimiter struct {
+	settings *cluster.Settings
+	nodeRate *settings.ByteSizeSetting
+	node     *rate.Limiter
+	feed     *rate.Limiter
+}
+
+// makeBackfillLimiter returns the limiter of the backfills of a changefeed:
+// the rows of its initial scan and the ones written while a column of their
+// table is added or dropped. It keeps a new changefeed on a huge table, or a
+// schema change of a watched table, from starving foreground traffic. Every
+// decoded row is charged for the size of its changed kv against both
+// changefeed.backfill.max_rate and the `backfill_max_rate` option, like
+// `backfill_max_rate='10MiB'`. Other changes aren't throttled, so that a
+// changefeed that's caught up keeps up.
+func makeBackfillLimiter(metrics *Metrics, opts map[string]string) (*byteLimiter, error) {
+	return makeByteLimiter(
+		metrics, changefeedBackfillRate, metrics.backfillLimiter, opts, optBackfillMaxRate)
+}
+
+// makeSinkLimiter returns the limiter of the emission of a changefeed, which
+// keeps a changefeed, usually a backfilling one, from saturating the network
+// link to a sink shared with other workloads, like a kafka cluster. Every
+// emitted row is charged for the size of its key and value against both
+// changefeed.sink.max_rate and the `sink_max_rate` option.
+func makeSinkLimiter(metrics *Metrics, opts map[string]string) (*byteLimiter, error) {
+	return makeByteLimiter(metrics, changefeedSinkRate, metrics.sinkLimiter, opts, optSinkMaxRate)
+}
+
+// makeByteLimiter returns a limiter that shares the node's limit, node, with
+// every other changefeed run with the same metrics.
+func makeByteLimiter(
+	metrics *Metrics,
+	nodeRate *settings.ByteSizeSetting,
+	node *rate.Limiter,
+	opts map[string]string,
+	opt string,
+) (*byteLimiter, error) {
+	l := &byteLimiter{settings: metrics.settings, nodeRate: nodeRate, node: node}
+	if value, ok := opts[opt]; ok {
+		bytesPerSec, err := parseMaxRate(opt, value)
+		if err != nil {
+			return nil, err
+		}
+		burst := limiterBurst
+		if bytesPerSec < int64(burst) {
+			burst = int(bytesPerSec)
+		}
+		l.feed = rate.NewLimiter(rate.Limit(bytesPerSec), burst)
+	}
+	return l, nil
+}
+
+// parseMaxRate returns the bytes per second of a rate limit option, like
+// `backfill_max_rate`.
+func parseMaxRate(opt, value string) (int64, error) {
+	bytesPerSec, err := humanizeutil.ParseBytes(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, `invalid %s`, opt)
+	}
+	if bytesPerSec <= 0 {
+		return 0, errors.Errorf(`invalid %s: must be positive: %s`, opt, value)
+	}
+	return bytesPerSec, nil
+}
+
+// wait blocks until the given number of bytes can be processed.
+func (l *byteLimiter) wait(ctx context.Context, cost int) error {
+	// The node's limiter picks up changes to the setting as it's used,
+	// instead of from a callback, since some benchmarks make metrics that
+	// aren't registered anywhere.
+	nodeLimit := rate.Limit(l.nodeRate.Get(&l.settings.SV))
+	if l.node.Limit() != nodeLimit {
+		l.node.SetLimit(nodeLimit)
+	}
+	if err := waitN(ctx, l.node, cost); err != nil {
+		return err
+	}
+	if l.feed != nil {
+		return waitN(ctx, l.feed, cost)
+	}
+	return nil
+}
+
+func waitN(ctx context.Context, limiter *rate.Limiter, cost int) error {
+	for burst := limiter.Burst(); cost > 0; cost -= burst {
+		n := cost
+		if n > burst {
+			n = burst
+		}
+		if err := limiter.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -36,13 +36,15 @@ func newRowFetcherCache(leaseMgr *sql.LeaseManager) *rowFetcherCache {
 	}
 }
 
+// RowFetcherForKey returns a RowFetcher for the table of the given key, and
+// the table's descriptor.
 func (c *rowFetcherCache) RowFetcherForKey(
 	ctx context.Context, key engine.MVCCKey,
-) (*sqlbase.RowFetcher, error) {
+) (*sqlbase.RowFetcher, *sqlbase.TableDescriptor, error) {
 	// TODO(dan): Handle interleaved tables.
 	_, tableID, _, err := sqlbase.DecodeTableIDIndexID(key.Key)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// TODO(dan): We don't really need a lease, this is just a convenient way to
@@ -50,13 +52,13 @@ func (c *rowFetcherCache) RowFetcherForKey(
 	// we acquire it. Avoid the lease entirely.
 	tableDesc, _, err := c.leaseMgr.Acquire(ctx, key.Timestamp, tableID)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if err := c.leaseMgr.Release(tableDesc); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if rf, ok := c.fetchers[tableDesc]; ok {
-		return rf, nil
+		return rf, tableDesc, nil
 	}
 
 	// TODO(dan): Allow for decoding a subset of the columns.
@@ -80,11 +82,11 @@ func (c *rowFetcherCache) RowFetcherForKey(
 			ValNeededForCol:  valNeededForCol,
 		},
 	); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	// TODO(dan): Bound the size of the cache. Resolved notifications will let
 	// us evict anything for timestamps entirely before the notification. Then
 	// probably an LRU just in case?
 	c.fetchers[tableDesc] = &rf
-	return &rf, nil
+	return &rf, tableDesc, nil
 }
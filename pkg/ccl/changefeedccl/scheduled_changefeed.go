@@ -0,0 +1,112 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/pkg/errors"
+)
+
+// `CREATE SCHEDULE FOR CHANGEFEED ... RECURRING '@daily'` creates a changefeed
+// that runs over and over instead of continuously, for recurring incremental
+// exports to cloud storage. Its first run is its initial scan or, with
+// `initial_scan='no'` or a `cursor`, a single poll from then, and every later
+// run is a single poll from the high-water mark the previous run left off at
+// up to when it starts. Each run ends once everything it read has been
+// emitted, along with a resolved timestamp, as with `initial_scan='only'`, and
+// the job then waits, still running, for the next one. The first run starts
+// right away, and the later ones at the start of every hour (`@hourly`), UTC
+// day (`@daily`) or week, starting on Mondays (`@weekly`). A recurrence can
+// also be a duration, like `6h`, in which case the runs are at multiples of it
+// since the start of the first day of year 1, UTC. A scheduled changefeed
+// that's resumed, or whose job is adopted by another node, runs right away
+// too. The changes between runs are kept from being garbage collected by the
+// protected timestamp of the changefeed. The recurrence is kept in the
+// changefeed's details.
+//
+// TODO: Support cron expressions, and move this to a general schedules
+// subsystem that isn't tied to changefeeds, once there is one.
+
+// minRecurrence is the shortest time between the runs of a scheduled
+// changefeed.
+const minRecurrence = time.Minute
+
+// parseRecurrence returns the time between the runs of a scheduled changefeed
+// with the given recurrence.
+func parseRecurrence(recurrence string) (time.Duration, error) {
+	switch recurrence {
+	case `@hourly`:
+		return time.Hour, nil
+	case `@daily`, `@midnight`:
+		return 24 * time.Hour, nil
+	case `@weekly`:
+		return 7 * 24 * time.Hour, nil
+	}
+	every, err := time.ParseDuration(recurrence)
+	if err != nil {
+		return 0, errors.Errorf(
+			`invalid recurrence %q: expected @hourly, @daily, @weekly or a duration`, recurrence)
+	}
+	if every < minRecurrence {
+		return 0, errors.Errorf(`invalid recurrence %q: must be at least %s`, recurrence, minRecurrence)
+	}
+	return every, nil
+}
+
+// changefeedRecurrence returns the time between the runs of a changefeed with
+// the given recurrence, and whether it's a scheduled changefeed at all.
+func changefeedRecurrence(recurrence string) (time.Duration, bool, error) {
+	if recurrence == `` {
+		return 0, false, nil
+	}
+	every, err := parseRecurrence(recurrence)
+	if err != nil {
+		return 0, false, err
+	}
+	return every, true, nil
+}
+
+// nextRun returns when a scheduled changefeed that recurs every so often runs
+// next, after now.
+func nextRun(every time.Duration, now time.Time) time.Time {
+	return now.UTC().Truncate(every).Add(every)
+}
+
+// waitForNextRun waits until the next run of a scheduled changefeed is due.
+// It returns early, with the error from updating its progress, if the job is
+// paused or canceled in the meantime.
+func waitForNextRun(
+	ctx context.Context, execCfg *sql.ExecutorConfig, job *jobs.Job, next time.Time,
+) error {
+	cancelCheckFn := makeCancelCheck(execCfg, job.Progressed)
+	for {
+		if err := cancelCheckFn(ctx); err != nil {
+			return err
+		}
+		wait := next.Sub(timeutil.Now())
+		if wait <= 0 {
+			return nil
+		}
+		// The cancel check is itself rate limited by
+		// changefeed.cancel_check_interval.
+		if wait > time.Second {
+			wait = time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
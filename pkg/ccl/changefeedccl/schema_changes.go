@@ -0,0 +1,190 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+)
+
+// Adding a column with a default (or a computed column) and dropping a column
+// rewrite every row of the table, which a changefeed sees as a change to every
+// row. By default, those rows are emitted like any other change, which can
+// surprise consumers with a re-emit of a whole table. The
+// `schema_change_policy` option picks what to do instead. `backfill` is the
+// default. `nobackfill` skips the rows that a column backfill rewrote without
+// changing their columns. `stop` emits every change up to the schema change
+// and then fails the changefeed, and `pause` pauses it instead, so that
+// resuming it carries on with the backfill.
+//
+// The `schema_change_events` option picks which schema changes stop or pause
+// a changefeed. With `default`, they're the ones that rewrite the rows, and
+// with `column_changes`, they're any column being added or dropped.
+type schemaChangePolicyType string
+type schemaChangeEventsType string
+
+const (
+	optSchemaChangePolicyBackfill   schemaChangePolicyType = `backfill`
+	optSchemaChangePolicyNoBackfill schemaChangePolicyType = `nobackfill`
+	optSchemaChangePolicyStop       schemaChangePolicyType = `stop`
+	optSchemaChangePolicyPause      schemaChangePolicyType = `pause`
+
+	optSchemaChangeEventsDefault       schemaChangeEventsType = `default`
+	optSchemaChangeEventsColumnChanges schemaChangeEventsType = `column_changes`
+)
+
+// schemaChangeEventError is returned by a changefeed that stops at a schema
+// change event, once it has emitted every change before it.
+type schemaChangeEventError struct {
+	tableName string
+	ts        hlc.Timestamp
+	policy    schemaChangePolicyType
+}
+
+func (e *schemaChangeEventError) Error() string {
+	return fmt.Sprintf(`schema change event on table %s at %s, stopping the changefeed with %s='%s'`,
+		e.tableName, e.ts, optSchemaChangePolicy, e.policy)
+}
+
+// isSchemaChangeEvent returns whether the change of a table's descriptor from
+// prev to cur, which are consecutive versions, is a schema change event.
+func isSchemaChangeEvent(
+	events schemaChangeEventsType, prev, cur *sqlbase.TableDescriptor,
+) bool {
+	prevCols := make(map[sqlbase.ColumnID]struct{})
+	for _, col := range prev.Columns {
+		prevCols[col.ID] = struct{}{}
+	}
+	for _, m := range prev.Mutations {
+		if col := m.GetColumn(); col != nil {
+			prevCols[col.ID] = struct{}{}
+		}
+	}
+	curPublic := make(map[sqlbase.ColumnID]struct{})
+	for _, col := range cur.Columns {
+		curPublic[col.ID] = struct{}{}
+	}
+
+	// A dropped column stops being public as soon as the drop starts.
+	for _, col := range prev.Columns {
+		if _, ok := curPublic[col.ID]; !ok {
+			return true
+		}
+	}
+	// An added column shows up first as a mutation.
+	added := append([]sqlbase.ColumnDescriptor(nil), cur.Columns...)
+	for _, m := range cur.Mutations {
+		if col := m.GetColumn(); col != nil && m.Direction == sqlbase.DescriptorMutation_ADD {
+			added = append(added, *col)
+		}
+	}
+	for _, col := range added {
+		if _, ok := prevCols[col.ID]; ok {
+			continue
+		}
+		if events == optSchemaChangeEventsColumnChanges || col.DefaultExpr != nil || col.IsComputed() {
+			return true
+		}
+	}
+	return false
+}
+
+// hasColumnBackfill returns whether a column of the table is being added or
+// dropped, in which case the rows may be rewritten by a backfill.
+func hasColumnBackfill(tableDesc *sqlbase.TableDescriptor) bool {
+	for _, m := range tableDesc.Mutations {
+		if m.GetColumn() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// sameRow returns whether two versions of a row have the same value for every
+// column of the newer one.
+func sameRow(
+	tableDesc *sqlbase.TableDescriptor,
+	row tree.Datums,
+	prevTableDesc *sqlbase.TableDescriptor,
+	prevRow tree.Datums,
+) bool {
+	prevIdxs := make(map[sqlbase.ColumnID]int, len(prevTableDesc.Columns))
+	for i, col := range prevTableDesc.Columns {
+		prevIdxs[col.ID] = i
+	}
+	evalCtx := &tree.EvalContext{SessionData: &sessiondata.SessionData{}}
+	for i, col := range tableDesc.Columns {
+		prevIdx, ok := prevIdxs[col.ID]
+		if !ok || row[i].Compare(evalCtx, prevRow[prevIdx]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// tableDescAt returns the version of a table's descriptor valid at ts.
+func tableDescAt(
+	ctx context.Context, leaseMgr *sql.LeaseManager, ts hlc.Timestamp, tableID sqlbase.ID,
+) (*sqlbase.TableDescriptor, error) {
+	// TODO: We don't really need a lease, see RowFetcherForKey.
+	tableDesc, _, err := leaseMgr.Acquire(ctx, ts, tableID)
+	if err != nil {
+		return nil, err
+	}
+	if err := leaseMgr.Release(tableDesc); err != nil {
+		return nil, err
+	}
+	return tableDesc, nil
+}
+
+// nextSchemaChangeEvent returns the first schema change event of the given
+// tables in (start, end], as the error to stop the changefeed with, or nil if
+// there's none.
+func nextSchemaChangeEvent(
+	ctx context.Context,
+	leaseMgr *sql.LeaseManager,
+	policy schemaChangePolicyType,
+	events schemaChangeEventsType,
+	tableDescs []sqlbase.TableDescriptor,
+	start, end hlc.Timestamp,
+) (*schemaChangeEventError, error) {
+	var first *schemaChangeEventError
+	for i := range tableDescs {
+		startDesc, err := tableDescAt(ctx, leaseMgr, start, tableDescs[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		cur, err := tableDescAt(ctx, leaseMgr, end, tableDescs[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		// Walk back through the versions after start, the earliest event
+		// wins.
+		for cur.Version > startDesc.Version {
+			prev, err := tableDescAt(ctx, leaseMgr, cur.ModificationTime.Prev(), cur.ID)
+			if err != nil {
+				return nil, err
+			}
+			if isSchemaChangeEvent(events, prev, cur) &&
+				(first == nil || cur.ModificationTime.Less(first.ts)) {
+				first = &schemaChangeEventError{
+					tableName: cur.Name, ts: cur.ModificationTime, policy: policy,
+				}
+			}
+			cur = prev
+		}
+	}
+	return first, nil
+}
@@ -0,0 +1,192 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	gojson "encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/httputil"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/pkg/errors"
+)
+
+type schemaRegistryOutageType string
+
+const (
+	optSchemaRegistryOutageFail  schemaRegistryOutageType = `fail`
+	optSchemaRegistryOutageRetry schemaRegistryOutageType = `retry`
+	optSchemaRegistryOutagePause schemaRegistryOutageType = `pause`
+)
+
+// schemaRegistryOutageRetryOpts controls how requests to an unavailable schema
+// registry are retried with `schema_registry_outage='retry'`, and
+// schemaRegistryOutageRetryTimeout is how long they're retried for before
+// the feed fails. The feed doesn't move on in the meantime, so the changes
+// that it hasn't emitted yet stay buffered in kv.
+var schemaRegistryOutageRetryOpts = retry.Options{
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+	Multiplier:     2,
+}
+var schemaRegistryOutageRetryTimeout = 5 * time.Minute
+
+// schemaRegistryUnavailableError is returned for requests to a schema registry
+// that couldn't be reached or that returned a server error, as opposed to
+// rejecting the request. The `schema_registry_outage` option picks what a
+// feed does about them.
+type schemaRegistryUnavailableError struct {
+	cause error
+}
+
+func (e *schemaRegistryUnavailableError) Error() string {
+	return `schema registry unavailable: ` + e.cause.Error()
+}
+
+// Cause implements the causer interface used by errors.Cause.
+func (e *schemaRegistryUnavailableError) Cause() error { return e.cause }
+
+// isSchemaRegistryUnavailableError returns true if any error in the chain of
+// causes is a schemaRegistryUnavailableError.
+func isSchemaRegistryUnavailableError(err error) bool {
+	for err != nil {
+		if _, ok := err.(*schemaRegistryUnavailableError); ok {
+			return true
+		}
+		causer, ok := err.(interface{ Cause() error })
+		if !ok {
+			return false
+		}
+		err = causer.Cause()
+	}
+	return false
+}
+
+// schemaRegistryParamCACert is the parameter of a `confluent_schema_registry`
+// URI with a base64-encoded PEM certificate to verify the registry with,
+// instead of the system's root CAs. It's for self-hosted registries with
+// their own CA.
+const schemaRegistryParamCACert = `ca_cert`
+
+// schemaRegistryConn makes requests to the Confluent schema registry at a
+// `confluent_schema_registry` URI. Credentials in the user info of the URI,
+// like the API key and secret of a Confluent Cloud registry, are sent with
+// basic auth. They're kept out of the URL of the requests, so they don't show
+// up in errors.
+type schemaRegistryConn struct {
+	baseURL            url.URL
+	username, password string
+	client             *http.Client
+}
+
+func makeSchemaRegistryConn(registryURI string) (*schemaRegistryConn, error) {
+	u, err := url.Parse(registryURI)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != `http` && u.Scheme != `https` {
+		return nil, errors.Errorf(`unsupported scheme: %q`, u.Scheme)
+	}
+	c := &schemaRegistryConn{client: http.DefaultClient}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+		u.User = nil
+	}
+
+	params := u.Query()
+	if caCert := params.Get(schemaRegistryParamCACert); caCert != `` {
+		if u.Scheme != `https` {
+			return nil, errors.Errorf(`parameter %s requires https`, schemaRegistryParamCACert)
+		}
+		pemBytes, err := base64.StdEncoding.DecodeString(caCert)
+		if err != nil {
+			return nil, errors.Wrapf(err, `decoding parameter %s`, schemaRegistryParamCACert)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pemBytes) {
+			return nil, errors.Errorf(`parameter %s has no PEM certificates`, schemaRegistryParamCACert)
+		}
+		c.client = &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: roots},
+		}}
+	}
+	params.Del(schemaRegistryParamCACert)
+	u.RawQuery = params.Encode()
+	c.baseURL = *u
+	return c, nil
+}
+
+// do sends a request with req as its json body to the given path of the
+// registry and decodes the json response into res, if it's non-nil. Errors
+// connecting to the registry and server errors are returned as a
+// schemaRegistryUnavailableError.
+func (c *schemaRegistryConn) do(
+	ctx context.Context, method string, relPath string, req interface{}, res interface{},
+) error {
+	u := c.baseURL
+	u.Path = path.Join(u.Path, relPath)
+
+	var buf bytes.Buffer
+	if err := gojson.NewEncoder(&buf).Encode(req); err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequest(method, u.String(), &buf)
+	if err != nil {
+		return err
+	}
+	httpReq = httpReq.WithContext(ctx)
+	httpReq.Header.Set(httputil.ContentTypeHeader, `application/vnd.schemaregistry.v1+json`)
+	if c.username != `` || c.password != `` {
+		httpReq.SetBasicAuth(c.username, c.password)
+	}
+	httpRes, err := c.client.Do(httpReq)
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		return &schemaRegistryUnavailableError{cause: err}
+	}
+	defer httpRes.Body.Close()
+	if httpRes.StatusCode < 200 || httpRes.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(httpRes.Body)
+		err := errors.Errorf(`schema registry returned %s: %s`, httpRes.Status, body)
+		if httpRes.StatusCode >= 500 {
+			err = &schemaRegistryUnavailableError{cause: err}
+		}
+		return err
+	}
+	if res == nil {
+		return nil
+	}
+	return gojson.NewDecoder(httpRes.Body).Decode(res)
+}
+
+// redactSchemaRegistryURI replaces the password in a `confluent_schema_registry`
+// URI, if any, so it can be shown in the job description.
+func redactSchemaRegistryURI(registryURI string) string {
+	u, err := url.Parse(registryURI)
+	if err != nil || u.User == nil {
+		return registryURI
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), `redacted`)
+	}
+	return u.String()
+}
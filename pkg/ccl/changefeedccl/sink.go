@@ -10,14 +10,28 @@ package changefeedccl
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/dustin/go-humanize"
 
 	"github.com/Shopify/sarama"
+	"github.com/cockroachdb/cockroach/pkg/ccl/storageccl"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/pkg/errors"
 )
 
@@ -25,6 +39,12 @@ import (
 type SinkRow struct {
 	Topic      string
 	Key, Value []byte
+	// PartitionKey, if set, is what the kafka sink hashes to pick the
+	// partition of the row instead of its key, see kafkaSinkConfig.
+	PartitionKey []byte
+	// Updated is the mvcc timestamp of the change the row is for, which the
+	// commit latency of the changefeed is measured from.
+	Updated hlc.Timestamp
 }
 
 // Sink is an abstration for anything that a changefeed may emit into.
@@ -34,45 +54,457 @@ type Sink interface {
 	Close() error
 }
 
+// offsetBootstrapSink is implemented by sinks that can publish where in the
+// sink a consumer has to start reading to see every change after a resolved
+// timestamp. EmitOffsetBootstrap is called after every row at or below the
+// resolved timestamp has been emitted.
+type offsetBootstrapSink interface {
+	EmitOffsetBootstrap(ctx context.Context, resolved hlc.Timestamp) error
+}
+
+// retryableSinkError wraps an error returned by a Sink to indicate that the
+// emission may be retried. Some of the rows may have been emitted before the
+// failure, so retrying may result in duplicates.
+type retryableSinkError struct {
+	cause error
+}
+
+// MarkRetryableSinkError wraps the given error so that the changefeed retries
+// the emission that returned it instead of failing.
+func MarkRetryableSinkError(cause error) error {
+	return &retryableSinkError{cause: cause}
+}
+
+func (e *retryableSinkError) Error() string {
+	return `retryable sink error: ` + e.cause.Error()
+}
+
+// Cause implements the causer interface used by errors.Cause.
+func (e *retryableSinkError) Cause() error { return e.cause }
+
+// isRetryableSinkError returns true if any error in the chain of causes was
+// marked with MarkRetryableSinkError.
+func isRetryableSinkError(err error) bool {
+	for err != nil {
+		if _, ok := err.(*retryableSinkError); ok {
+			return true
+		}
+		causer, ok := err.(interface{ Cause() error })
+		if !ok {
+			return false
+		}
+		err = causer.Cause()
+	}
+	return false
+}
+
+func getSink(
+	ctx context.Context,
+	execCfg *sql.ExecutorConfig,
+	sinkURIRaw string,
+	opts map[string]string,
+	encoder Encoder,
+	resultsCh chan<- tree.Datums,
+) (Sink, error) {
+	sinkURI, err := url.Parse(sinkURIRaw)
+	if err != nil {
+		return nil, err
+	}
+	if !isCloudStorageSinkScheme(sinkURI.Scheme) {
+		var fileOnlyFormat formatType
+		switch encoder.(type) {
+		case *csvEncoder:
+			fileOnlyFormat = optFormatCSV
+		case *parquetEncoder:
+			fileOnlyFormat = optFormatParquet
+		}
+		if fileOnlyFormat != `` {
+			return nil, errors.Errorf(
+				`%s=%s is only supported with cloud storage sinks`, optFormat, fileOnlyFormat)
+		}
+		if _, ok := opts[optCompression]; ok {
+			return nil, errors.Errorf(
+				`WITH option %s is only supported with cloud storage sinks`, optCompression)
+		}
+	}
+	if _, ok := opts[optKafkaSinkConfig]; ok && sinkURI.Scheme != sinkSchemeKafka {
+		return nil, errors.Errorf(`%s is only supported with kafka sinks`, optKafkaSinkConfig)
+	}
+	if _, ok := opts[optWebhookSinkConfig]; ok && sinkURI.Scheme != sinkSchemeWebhookHTTPS {
+		return nil, errors.Errorf(`%s is only supported with webhook sinks`, optWebhookSinkConfig)
+	}
+
+	var sink Sink
+	switch sinkURI.Scheme {
+	case sinkSchemeChannel:
+		return &channelSink{resultsCh: resultsCh}, nil
+	case sinkSchemeKafka:
+		sink, err = getKafkaSink(sinkURI, execCfg.Settings, opts[optKafkaSinkConfig])
+	case sinkSchemeUserFile:
+		var storage fileStorage
+		storage, err = makeUserFileStorage(ctx, execCfg.InternalExecutor, sinkURI)
+		if err == nil {
+			sink = makeCloudStorageSink(storage, encoder, compressionType(opts[optCompression]))
+		}
+	case sinkSchemeInMem:
+		sink, err = getInMemSink(sinkURI.Host)
+	case sinkSchemeWebhookHTTPS:
+		if _, ok := encoder.(*jsonEncoder); !ok {
+			return nil, errors.Errorf(`webhook sinks only support %s=%s`, optFormat, optFormatJSON)
+		}
+		sink, err = makeWebhookSink(sinkURI, opts[optWebhookSinkConfig])
+	case sinkSchemeNodelocal, sinkSchemeS3, sinkSchemeGS, sinkSchemeAzure,
+		sinkSchemeHTTP, sinkSchemeHTTPS:
+		var storage fileStorage
+		storage, err = storageccl.ExportStorageFromURI(ctx, sinkURIRaw, execCfg.Settings)
+		if err == nil {
+			sink = makeCloudStorageSink(storage, encoder, compressionType(opts[optCompression]))
+		}
+	default:
+		return nil, errors.Errorf(`unsupported sink: %s`, sinkURI.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sinkRecordDir != `` {
+		if sink, err = makeRecordingSink(sink, sinkRecordDir); err != nil {
+			return nil, err
+		}
+	}
+
+	// We abuse the job's results channel to make CREATE CHANGEFEED wait for
+	// this before returning to the user to ensure the setup went okay. Job
+	// resumption doesn't have the same hack, but at the moment ignores results
+	// and so is currently okay. Return nil instead of anything meaningful so
+	// that if we start doing anything with the results returned by resumed
+	// jobs, then it breaks instead of returning nonsense.
+	resultsCh <- tree.Datums(nil)
+	return sink, nil
+}
+
 type kafkaSink struct {
 	// TODO(dan): This uses the shopify kafka producer library because the
 	// official confluent one depends on librdkafka and it didn't seem worth it
 	// to add a new c dep for the prototype. Revisit before 2.1 and check
 	// stability, performance, etc.
-	sarama.SyncProducer
-	client sarama.Client
+	//
+	// TODO: Rows are delivered at least once, so consumers see duplicates
+	// after a changefeed restarts from its last resolved timestamp. An opt-in
+	// exactly-once mode would use a transactional producer, with a
+	// transactional ID derived from the job ID so that a restarted changefeed
+	// fences off its previous incarnation, and commit a transaction whenever
+	// a resolved timestamp is emitted, aborting it if the changefeed restarts.
+	// Consumers reading with read_committed isolation would then only see the
+	// rows up to each resolved timestamp once. That needs idempotent and
+	// transactional producers, which the vendored sarama (v1.13) doesn't have,
+	// and brokers running kafka 0.11 or newer.
+	producer sarama.AsyncProducer
+	client   sarama.Client
+	settings *cluster.Settings
 
 	kafkaTopicPrefix string
 	topicsSeen       map[string]struct{}
+	// topicExists, if set, is called the first time a topic is written to,
+	// which fails if it doesn't exist, see getKafkaSink.
+	topicExists func(topic string) (bool, error)
+
+	// bootstrapTopic, if set, is the topic that offset bootstrap records are
+	// published to. See EmitOffsetBootstrap.
+	bootstrapTopic string
+
+	// worker reads the acknowledgements of the producer, see ackLoop.
+	worker sync.WaitGroup
 
-	rowsEmitted  uint64
-	bytesEmitted uint64
+	mu struct {
+		syncutil.Mutex
+		// inflight is the number of messages sent to the producer that haven't
+		// been acknowledged yet. flushCh, if non-nil, is closed once it drops
+		// to zero.
+		inflight int64
+		flushCh  chan struct{}
+		// batchSize, if non-zero, is the most messages that are in flight at
+		// once, see reduceBatchSize. reduced is set once it's been reduced
+		// for the messages in flight.
+		batchSize int64
+		reduced   bool
+		// nextOffsets is, for every partition of every topic written to, the
+		// offset after the last message written to it.
+		nextOffsets map[string]map[int32]int64
+
+		rowsEmitted  uint64
+		bytesEmitted uint64
+	}
 }
 
-func getKafkaSink(kafkaTopicPrefix string, bootstrapServers string) (Sink, error) {
+var _ asyncSink = &kafkaSink{}
+var _ offsetBootstrapSink = &kafkaSink{}
+
+func getKafkaSink(sinkURI *url.URL, st *cluster.Settings, sinkConfig string) (Sink, error) {
+	config, err := makeKafkaConfig(sinkURI.Query())
+	if err != nil {
+		return nil, err
+	}
+	if err := applyKafkaSinkConfig(config, sinkConfig); err != nil {
+		return nil, err
+	}
+	// Brokers that auto-create topics do so with their default number of
+	// partitions and replication factor. `auto_create_topics=false` makes the
+	// changefeed fail as soon as it writes to a topic that doesn't exist,
+	// instead.
+	//
+	// TODO: Create missing topics with a given number of partitions and
+	// replication factor, which needs a version of sarama that can send
+	// CreateTopics requests.
+	autoCreateTopics := true
+	if value := sinkURI.Query().Get(sinkParamAutoCreateTopics); value != `` {
+		if autoCreateTopics, err = strconv.ParseBool(value); err != nil {
+			return nil, errors.Wrapf(err, `param %s must be a bool`, sinkParamAutoCreateTopics)
+		}
+	}
+	bootstrapServers := sinkURI.Host
+	client, err := sarama.NewClient(strings.Split(bootstrapServers, `,`), config)
+	if err != nil {
+		return nil, errors.Wrapf(err, `connecting to kafka: %s`, bootstrapServers)
+	}
+	producer, err := sarama.NewAsyncProducerFromClient(client)
+	if err != nil {
+		_ = client.Close()
+		return nil, errors.Wrapf(err, `connecting to kafka: %s`, bootstrapServers)
+	}
+	sink := makeKafkaSink(st, client, producer, sinkURI.Query())
+	if !autoCreateTopics {
+		sink.topicExists = func(topic string) (bool, error) {
+			// Metadata requests for every topic, unlike the ones for a given
+			// topic, don't create it.
+			if err := client.RefreshMetadata(); err != nil {
+				return false, errors.Wrap(err, `fetching kafka topics`)
+			}
+			topics, err := client.Topics()
+			if err != nil {
+				return false, errors.Wrap(err, `fetching kafka topics`)
+			}
+			for _, t := range topics {
+				if t == topic {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+	}
+	return sink, nil
+}
+
+// makeKafkaSink returns a sink that sends messages with the given producer,
+// which must return its successes and errors, and starts reading them. client
+// may be nil if the producer doesn't need to be closed with one.
+func makeKafkaSink(
+	st *cluster.Settings, client sarama.Client, producer sarama.AsyncProducer, params url.Values,
+) *kafkaSink {
 	sink := &kafkaSink{
-		kafkaTopicPrefix: kafkaTopicPrefix,
+		settings:         st,
+		producer:         producer,
+		client:           client,
+		kafkaTopicPrefix: params.Get(sinkParamTopicPrefix),
 		topicsSeen:       make(map[string]struct{}),
+		bootstrapTopic:   params.Get(sinkParamOffsetBootstrapTopic),
 	}
+	sink.mu.nextOffsets = make(map[string]map[int32]int64)
+	sink.worker.Add(1)
+	go func() {
+		defer sink.worker.Done()
+		sink.ackLoop()
+	}()
+	return sink
+}
 
+// makeKafkaConfig returns the config of the kafka client of a sink with the
+// given sink URI parameters.
+//
+// The connections to the brokers can be tuned for the network between the
+// cluster and them. `tls_enabled=true` connects with TLS, and
+// `tls_server_name` overrides the server name sent in the TLS handshake (SNI)
+// and verified against the broker certificates, for brokers behind an
+// SNI-routing proxy. `ca_cert` is a base64-encoded PEM certificate to verify
+// the brokers with, instead of the system's root CAs, and `client_cert` and
+// `client_key` are a base64-encoded PEM certificate and key to authenticate to
+// brokers that require mutual TLS. `dial_timeout` and `keep_alive` override
+// the timeout to connect to a broker and the TCP keep-alive period.
+// `kafka_version`, like `kafka_version=0.10.2.0`, is the protocol version
+// spoken to the brokers, which sarama otherwise assumes is the oldest one it
+// supports.
+//
+// Brokers that require SASL/PLAIN authentication, like Confluent Cloud's, are
+// authenticated with by `sasl_enabled=true` along with `sasl_user` and
+// `sasl_password`, usually over TLS. `sasl_handshake=false` skips the SASL
+// handshake request, for brokers older than 0.10 that don't support it.
+// `sasl_mechanism` is PLAIN, the only mechanism supported so far.
+func makeKafkaConfig(params url.Values) (*sarama.Config, error) {
+	// TODO: Messages are only compressed with the codecs sarama has, see
+	// kafkaSinkConfig. Narrow tables with repetitive payloads would benefit a
+	// lot from zstd with a dictionary per table, trained on sampled payloads
+	// and identified by a version in a message header so that consumers can
+	// pick the right one. That needs zstd, which the vendored sarama doesn't
+	// support (nor does it support record headers), and a zstd library with
+	// dictionary training, which isn't vendored.
 	config := sarama.NewConfig()
 	config.Producer.Return.Successes = true
 	config.Producer.Partitioner = newChangefeedPartitioner
 
-	var err error
-	sink.client, err = sarama.NewClient(strings.Split(bootstrapServers, `,`), config)
-	if err != nil {
-		return nil, errors.Wrapf(err, `connecting to kafka: %s`, bootstrapServers)
+	if tlsEnabled := params.Get(sinkParamTLSEnabled); tlsEnabled != `` {
+		var err error
+		if config.Net.TLS.Enable, err = strconv.ParseBool(tlsEnabled); err != nil {
+			return nil, errors.Wrapf(err, `param %s must be a bool`, sinkParamTLSEnabled)
+		}
 	}
-	sink.SyncProducer, err = sarama.NewSyncProducerFromClient(sink.client)
-	if err != nil {
-		return nil, errors.Wrapf(err, `connecting to kafka: %s`, bootstrapServers)
+	if version := params.Get(sinkParamKafkaVersion); version != `` {
+		var ok bool
+		if config.Version, ok = kafkaVersions[version]; !ok {
+			return nil, errors.Errorf(`param %s is not a supported kafka version: %s`,
+				sinkParamKafkaVersion, version)
+		}
 	}
-	return sink, nil
+
+	pemParams := make(map[string][]byte)
+	for _, param := range []string{sinkParamCACert, sinkParamClientCert, sinkParamClientKey} {
+		if value := params.Get(param); value != `` {
+			decoded, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, errors.Wrapf(err, `decoding param %s`, param)
+			}
+			pemParams[param] = decoded
+		}
+	}
+	for _, param := range []string{
+		sinkParamTLSServerName, sinkParamCACert, sinkParamClientCert, sinkParamClientKey,
+	} {
+		if params.Get(param) != `` && !config.Net.TLS.Enable {
+			return nil, errors.Errorf(`param %s requires %s=true`, param, sinkParamTLSEnabled)
+		}
+	}
+	if config.Net.TLS.Enable {
+		tlsConfig := &tls.Config{ServerName: params.Get(sinkParamTLSServerName)}
+		if caCert, ok := pemParams[sinkParamCACert]; ok {
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+				return nil, errors.Errorf(`param %s has no PEM certificates`, sinkParamCACert)
+			}
+		}
+		clientCert, hasCert := pemParams[sinkParamClientCert]
+		clientKey, hasKey := pemParams[sinkParamClientKey]
+		if hasCert != hasKey {
+			return nil, errors.Errorf(`params %s and %s must be given together`,
+				sinkParamClientCert, sinkParamClientKey)
+		}
+		if hasCert {
+			cert, err := tls.X509KeyPair(clientCert, clientKey)
+			if err != nil {
+				return nil, errors.Wrapf(err, `invalid params %s and %s`,
+					sinkParamClientCert, sinkParamClientKey)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		config.Net.TLS.Config = tlsConfig
+	}
+
+	for param, b := range map[string]*bool{
+		sinkParamSASLEnabled:   &config.Net.SASL.Enable,
+		sinkParamSASLHandshake: &config.Net.SASL.Handshake,
+	} {
+		if value := params.Get(param); value != `` {
+			var err error
+			if *b, err = strconv.ParseBool(value); err != nil {
+				return nil, errors.Wrapf(err, `param %s must be a bool`, param)
+			}
+		}
+	}
+	switch mechanism := strings.ToUpper(params.Get(sinkParamSASLMechanism)); mechanism {
+	case ``, sinkSASLMechanismPlain:
+	case sinkSASLMechanismSCRAMSHA256, sinkSASLMechanismSCRAMSHA512:
+		// TODO: Support SCRAM, which some managed kafka offerings
+		// require. It needs a version of sarama with pluggable SASL
+		// mechanisms (v1.22 or newer) and a SCRAM client library, neither of
+		// which is vendored. Rather than fall back to PLAIN and send the
+		// password in the clear, refuse to connect.
+		return nil, errors.Errorf(`param %s=%s is not supported yet`,
+			sinkParamSASLMechanism, mechanism)
+	case sinkSASLMechanismOAuthBearer:
+		// TODO: Support OAUTHBEARER, for clusters fronted by an OIDC
+		// identity provider. The sink would get tokens from a token endpoint
+		// with the client credentials grant, with the endpoint, client ID and
+		// secret, and scopes in params, and refresh them before they expire.
+		// It needs a version of sarama with token providers (v1.21 or newer)
+		// and an oauth2 client library, neither of which is vendored.
+		return nil, errors.Errorf(`param %s=%s is not supported yet`,
+			sinkParamSASLMechanism, mechanism)
+	case sinkSASLMechanismGSSAPI:
+		// TODO: Support Kerberos, for enterprise clusters secured with
+		// it. The sink would authenticate as the principal in params, with a
+		// keytab or credential cache on the node's disk and a krb5.conf. It
+		// needs a version of sarama with GSSAPI (v1.22 or newer) and a
+		// Kerberos library, neither of which is vendored.
+		return nil, errors.Errorf(`param %s=%s is not supported yet`,
+			sinkParamSASLMechanism, mechanism)
+	case sinkSASLMechanismAWSMSKIAM:
+		// TODO: Support IAM authentication, for Amazon MSK clusters
+		// that disable every other method. The sink would sign its
+		// authentication payload with SigV4, using the region and the role
+		// or credentials in params, and reauthenticate before the signature
+		// expires. It needs a version of sarama with pluggable SASL
+		// mechanisms (v1.22 or newer); the AWS SDK is already vendored for
+		// S3.
+		return nil, errors.Errorf(`param %s=%s is not supported yet`,
+			sinkParamSASLMechanism, mechanism)
+	default:
+		return nil, errors.Errorf(`param %s must be one of %s, %s, %s, %s, %s or %s: %s`,
+			sinkParamSASLMechanism, sinkSASLMechanismPlain, sinkSASLMechanismSCRAMSHA256,
+			sinkSASLMechanismSCRAMSHA512, sinkSASLMechanismOAuthBearer, sinkSASLMechanismGSSAPI,
+			sinkSASLMechanismAWSMSKIAM, params.Get(sinkParamSASLMechanism))
+	}
+	config.Net.SASL.User = params.Get(sinkParamSASLUser)
+	config.Net.SASL.Password = params.Get(sinkParamSASLPassword)
+	if config.Net.SASL.Enable {
+		if config.Net.SASL.User == `` || config.Net.SASL.Password == `` {
+			return nil, errors.Errorf(`params %s and %s are required with %s=true`,
+				sinkParamSASLUser, sinkParamSASLPassword, sinkParamSASLEnabled)
+		}
+	} else {
+		for _, param := range []string{
+			sinkParamSASLHandshake, sinkParamSASLMechanism,
+			sinkParamSASLUser, sinkParamSASLPassword,
+		} {
+			if params.Get(param) != `` {
+				return nil, errors.Errorf(`param %s requires %s=true`, param, sinkParamSASLEnabled)
+			}
+		}
+	}
+
+	// TODO: Binding connections to a source address, for nodes with
+	// several NICs and per-interface egress policies, needs a version of
+	// sarama that lets the dialer be configured (Net.LocalAddr).
+	for param, d := range map[string]*time.Duration{
+		sinkParamDialTimeout: &config.Net.DialTimeout,
+		sinkParamKeepAlive:   &config.Net.KeepAlive,
+	} {
+		value := params.Get(param)
+		if value == `` {
+			continue
+		}
+		var err error
+		if *d, err = time.ParseDuration(value); err != nil {
+			return nil, errors.Wrapf(err, `param %s must be a duration`, param)
+		}
+		if *d < 0 {
+			return nil, errors.Errorf(`param %s must be non-negative: %s`, param, value)
+		}
+	}
+	return config, nil
 }
 
+// Close implements the Sink interface. Messages that haven't been acknowledged
+// yet are flushed, but their done callbacks may not be called.
 func (s *kafkaSink) Close() error {
-	err := s.SyncProducer.Close()
+	err := s.producer.Close()
+	s.worker.Wait()
 	if s.client != nil {
 		if e := s.client.Close(); err == nil {
 			err = e
@@ -81,38 +513,265 @@ func (s *kafkaSink) Close() error {
 	return err
 }
 
-func (s *kafkaSink) EmitRows(ctx context.Context, rows []SinkRow) error {
-	// TODO(dan): Figure out if it's safe to reuse these after SendMessages
-	// returns and save them across calls to EmitRows.
-	m := make([]sarama.ProducerMessage, len(rows))
-	messages := make([]*sarama.ProducerMessage, len(rows))
+// EmitRowAsync implements the asyncSink interface. The message of the row is
+// handed to the producer, which batches messages to the same broker, and done
+// is called by ackLoop once it's acknowledged.
+//
+// TODO: Consumers that route or filter messages by their operation
+// (insert, update or delete), table or commit timestamp have to decode the
+// value to do so. These could be sent as record headers instead, with a
+// `kafka_sink_config` field picking which ones, if SinkRow carried them. The
+// vendored sarama (v1.13) can't send record headers, which need kafka 0.11.
+func (s *kafkaSink) EmitRowAsync(ctx context.Context, row SinkRow, done func(error)) error {
+	topic := s.kafkaTopicPrefix + row.Topic
+	if err := s.seeTopic(topic); err != nil {
+		return err
+	}
+	return s.send(ctx, &sarama.ProducerMessage{
+		Topic:    topic,
+		Key:      sarama.ByteEncoder(row.Key),
+		Value:    sarama.ByteEncoder(row.Value),
+		Metadata: &kafkaMessageMetadata{done: done, partitionKey: row.PartitionKey},
+	})
+}
 
-	var bytes uint64
-	for i, row := range rows {
-		topic := s.kafkaTopicPrefix + row.Topic
-		if _, ok := s.topicsSeen[topic]; !ok {
-			s.topicsSeen[topic] = struct{}{}
+// seeTopic records that a topic is written to, the first time it is.
+func (s *kafkaSink) seeTopic(topic string) error {
+	if _, ok := s.topicsSeen[topic]; ok {
+		return nil
+	}
+	if s.topicExists != nil {
+		exists, err := s.topicExists(topic)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return errors.Errorf(`kafka topic %s does not exist and %s=false`,
+				topic, sinkParamAutoCreateTopics)
 		}
-		m[i] = sarama.ProducerMessage{
-			Topic: topic,
-			Key:   sarama.ByteEncoder(row.Key),
-			Value: sarama.ByteEncoder(row.Value),
+	}
+	s.topicsSeen[topic] = struct{}{}
+	return nil
+}
+
+// kafkaMessageMetadata is the Metadata of the messages sent to the producer.
+type kafkaMessageMetadata struct {
+	// done, if non-nil, is called once the message has been acknowledged.
+	done func(error)
+	// partitionKey is the PartitionKey of the message's row.
+	partitionKey []byte
+}
+
+// messageDone calls the done callback of a message, if it has one.
+func messageDone(m *sarama.ProducerMessage, err error) {
+	if md, ok := m.Metadata.(*kafkaMessageMetadata); ok && md.done != nil {
+		md.done(err)
+	}
+}
+
+// send hands a message to the producer. The done callback of the message, if
+// any, is in its Metadata.
+func (s *kafkaSink) send(ctx context.Context, m *sarama.ProducerMessage) error {
+	for {
+		s.mu.Lock()
+		if s.mu.batchSize == 0 || s.mu.inflight < s.mu.batchSize {
+			s.mu.inflight++
+			s.mu.Unlock()
+			break
+		}
+		s.mu.Unlock()
+		// Once the batch size has been reduced, every batch is flushed
+		// before the next one is sent.
+		if err := s.Flush(ctx); err != nil {
+			return err
 		}
-		messages[i] = &m[i]
-		bytes += uint64(len(row.Key) + len(row.Value))
 	}
-	if err := s.SendMessages(messages); err != nil {
-		return errors.Wrapf(err, `sending %d messages to kafka`, len(rows))
+	select {
+	case <-ctx.Done():
+		s.ack(nil /* m */)
+		return ctx.Err()
+	case s.producer.Input() <- m:
+		return nil
 	}
+}
 
-	s.rowsEmitted += uint64(len(rows))
-	s.bytesEmitted += bytes
-	if log.V(1) {
-		log.Infof(ctx, "emitted %d records (%s) to kafka. total %d records (%s)",
-			len(rows), humanize.IBytes(bytes), s.rowsEmitted, humanize.IBytes(s.bytesEmitted))
+// ackLoop reads the acknowledgements of the producer until it's closed.
+func (s *kafkaSink) ackLoop() {
+	successes, errs := s.producer.Successes(), s.producer.Errors()
+	for successes != nil || errs != nil {
+		select {
+		case m, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+			s.ack(m)
+			messageDone(m, nil)
+		case e, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			err := errors.Wrapf(e.Err, `sending message to kafka topic %s`, e.Msg.Topic)
+			if e.Err == sarama.ErrMessageSizeTooLarge && s.reduceBatchSize() {
+				err = MarkRetryableSinkError(err)
+			}
+			s.ack(nil /* m */)
+			messageDone(e.Msg, err)
+		}
 	}
+}
 
-	return nil
+// ack records that a message is no longer in flight. m is the message if it
+// was sent successfully, in which case its offset is recorded.
+func (s *kafkaSink) ack(m *sarama.ProducerMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m != nil {
+		s.mu.rowsEmitted++
+		for _, e := range []sarama.Encoder{m.Key, m.Value} {
+			if e != nil {
+				s.mu.bytesEmitted += uint64(e.Length())
+			}
+		}
+		if s.bootstrapTopic != `` && m.Topic != s.bootstrapTopic {
+			offsets, ok := s.mu.nextOffsets[m.Topic]
+			if !ok {
+				offsets = make(map[int32]int64)
+				s.mu.nextOffsets[m.Topic] = offsets
+			}
+			if m.Offset+1 > offsets[m.Partition] {
+				offsets[m.Partition] = m.Offset + 1
+			}
+		}
+	}
+	s.mu.inflight--
+	if s.mu.inflight == 0 {
+		s.mu.reduced = false
+		if s.mu.flushCh != nil {
+			close(s.mu.flushCh)
+			s.mu.flushCh = nil
+		}
+	}
+}
+
+// batchReductionRetryEnabled is whether a kafka sink retries the messages of
+// a batch that's rejected as too large in smaller batches, see
+// reduceBatchSize.
+var batchReductionRetryEnabled = settings.RegisterBoolSetting(
+	"changefeed.batch_reduction_retry.enabled",
+	"if true, messages that kafka rejects as too large are retried in progressively smaller batches",
+	true,
+)
+
+// reduceBatchSize is called when kafka rejects a message as too large, which
+// it does when the batch it was sent in is bigger than the broker's
+// `message.max.bytes`. It halves the number of messages in flight at once,
+// and so the size of the batches the producer can build out of them, and
+// returns whether the message should be retried. Every message of the
+// rejected batch fails, but the batch size is only reduced once for all of
+// them. A message that's too large on its own isn't retried.
+func (s *kafkaSink) reduceBatchSize() bool {
+	if s.settings == nil || !batchReductionRetryEnabled.Get(&s.settings.SV) {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mu.reduced {
+		return true
+	}
+	size := s.mu.batchSize
+	if size == 0 {
+		size = s.mu.inflight
+	}
+	if size <= 1 {
+		return false
+	}
+	s.mu.batchSize, s.mu.reduced = size/2, true
+	log.Infof(context.TODO(), "kafka rejected a batch as too large, "+
+		"sending at most %d messages at once", s.mu.batchSize)
+	return true
+}
+
+// Flush implements the asyncSink interface.
+func (s *kafkaSink) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	if s.mu.inflight == 0 {
+		rows, bytes := s.mu.rowsEmitted, s.mu.bytesEmitted
+		s.mu.Unlock()
+		if log.V(1) {
+			log.Infof(ctx, "flushed kafka sink. total %d records (%s)", rows, humanize.IBytes(bytes))
+		}
+		return nil
+	}
+	if s.mu.flushCh == nil {
+		s.mu.flushCh = make(chan struct{})
+	}
+	flushCh := s.mu.flushCh
+	s.mu.Unlock()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-flushCh:
+		return s.Flush(ctx)
+	}
+}
+
+// sendAndFlush sends messages and waits until they've all been acknowledged,
+// returning the first error any of them failed with.
+func (s *kafkaSink) sendAndFlush(ctx context.Context, messages []*sarama.ProducerMessage) error {
+	var mu syncutil.Mutex
+	var firstErr error
+	done := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, m := range messages {
+		md, _ := m.Metadata.(*kafkaMessageMetadata)
+		if md == nil {
+			md = &kafkaMessageMetadata{}
+			m.Metadata = md
+		}
+		md.done = done
+		if err := s.send(ctx, m); err != nil {
+			return err
+		}
+	}
+	if err := s.Flush(ctx); err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return firstErr
+}
+
+// EmitRows implements the Sink interface.
+//
+// TODO: Consumers reading tables related by foreign keys would like the
+// rows of a resolved window to show up in all of their topics at once. With a
+// transactional producer, the rows emitted between two resolved timestamps
+// could be sent in one producer transaction, committed right before the
+// resolved timestamp, so that read_committed consumers see cross-table
+// consistent batches. The vendored sarama has no transactional (or even
+// idempotent) producer, so this waits on upgrading it.
+func (s *kafkaSink) EmitRows(ctx context.Context, rows []SinkRow) error {
+	messages := make([]*sarama.ProducerMessage, len(rows))
+	for i, row := range rows {
+		topic := s.kafkaTopicPrefix + row.Topic
+		if err := s.seeTopic(topic); err != nil {
+			return err
+		}
+		messages[i] = &sarama.ProducerMessage{
+			Topic:    topic,
+			Key:      sarama.ByteEncoder(row.Key),
+			Value:    sarama.ByteEncoder(row.Value),
+			Metadata: &kafkaMessageMetadata{partitionKey: row.PartitionKey},
+		}
+	}
+	return errors.Wrapf(s.sendAndFlush(ctx, messages), `sending %d messages to kafka`, len(rows))
 }
 
 func (s *kafkaSink) EmitResolvedTimestamp(ctx context.Context, payload []byte) error {
@@ -136,19 +795,87 @@ func (s *kafkaSink) EmitResolvedTimestamp(ctx context.Context, payload []byte) e
 			})
 		}
 	}
-	return s.SendMessages(messages)
+	return s.sendAndFlush(ctx, messages)
+}
+
+// kafkaOffsetBootstrap is the value of an offset bootstrap record.
+type kafkaOffsetBootstrap struct {
+	// Resolved is the resolved timestamp, formatted like the `updated` and
+	// `resolved` fields of the json format.
+	Resolved string `json:"resolved"`
+	// Offsets maps every topic and partition written to by the changefeed to
+	// the offset a consumer has to start reading at to see every change after
+	// the resolved timestamp. Partitions are keyed by their number in decimal.
+	Offsets map[string]map[string]int64 `json:"offsets"`
+}
+
+// EmitOffsetBootstrap implements the offsetBootstrapSink interface. With the
+// `offset_bootstrap_topic` sink parameter, it publishes a record to that topic
+// mapping the resolved timestamp to the offsets of every partition, so that a
+// new consumer can start from a chosen logical time instead of the earliest or
+// latest offsets. A consumer that wants every change after some time looks for
+// the latest bootstrap record resolved at or before it and seeks to its
+// offsets. As always, it may see duplicates of changes from before the time.
+//
+// Records are json, whatever the format of the changefeed, and are published
+// to partition 0 of the topic (which isn't prefixed by `topic_prefix`) without
+// a key, in increasing order of resolved timestamps. Partitions that haven't
+// been written to aren't included, they have to be read from the beginning.
+func (s *kafkaSink) EmitOffsetBootstrap(ctx context.Context, resolved hlc.Timestamp) error {
+	if s.bootstrapTopic == `` {
+		return nil
+	}
+	s.mu.Lock()
+	bootstrap := kafkaOffsetBootstrap{
+		Resolved: tree.TimestampToDecimal(resolved).Decimal.String(),
+		Offsets:  make(map[string]map[string]int64, len(s.mu.nextOffsets)),
+	}
+	for topic, offsets := range s.mu.nextOffsets {
+		partitions := make(map[string]int64, len(offsets))
+		for partition, offset := range offsets {
+			partitions[strconv.Itoa(int(partition))] = offset
+		}
+		bootstrap.Offsets[topic] = partitions
+	}
+	s.mu.Unlock()
+	value, err := json.Marshal(bootstrap)
+	if err != nil {
+		return err
+	}
+	err = s.sendAndFlush(ctx, []*sarama.ProducerMessage{{
+		Topic:     s.bootstrapTopic,
+		Partition: 0,
+		Value:     sarama.ByteEncoder(value),
+	}})
+	return errors.Wrap(err, `sending offset bootstrap record to kafka`)
 }
 
+// changefeedPartitioner sends messages without a key, like resolved
+// timestamps, to the partition they were given. Messages with a partition key
+// are hashed by it, and other ones are partitioned by keyed, which hashes
+// their key unless the sink was configured otherwise.
 type changefeedPartitioner struct {
-	hash sarama.Partitioner
+	hash  sarama.Partitioner
+	keyed sarama.Partitioner
 }
 
 var _ sarama.Partitioner = &changefeedPartitioner{}
 var _ sarama.PartitionerConstructor = newChangefeedPartitioner
+var _ sarama.PartitionerConstructor = newRoundRobinChangefeedPartitioner
 
 func newChangefeedPartitioner(topic string) sarama.Partitioner {
+	hash := sarama.NewHashPartitioner(topic)
+	return &changefeedPartitioner{hash: hash, keyed: hash}
+}
+
+// newRoundRobinChangefeedPartitioner returns a partitioner that spreads the
+// rows of a topic over its partitions in turn, instead of by their key, so
+// that a hot key doesn't pin all the traffic to one partition. The changes to
+// a row are then no longer in order within a partition.
+func newRoundRobinChangefeedPartitioner(topic string) sarama.Partitioner {
 	return &changefeedPartitioner{
-		hash: sarama.NewHashPartitioner(topic),
+		hash:  sarama.NewHashPartitioner(topic),
+		keyed: sarama.NewRoundRobinPartitioner(topic),
 	}
 }
 
@@ -159,7 +886,11 @@ func (p *changefeedPartitioner) Partition(
 	if message.Key == nil {
 		return message.Partition, nil
 	}
-	return p.hash.Partition(message, numPartitions)
+	if md, ok := message.Metadata.(*kafkaMessageMetadata); ok && md.partitionKey != nil {
+		return p.hash.Partition(
+			&sarama.ProducerMessage{Key: sarama.ByteEncoder(md.partitionKey)}, numPartitions)
+	}
+	return p.keyed.Partition(message, numPartitions)
 }
 
 type channelSink struct {
@@ -0,0 +1,150 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/pkg/errors"
+)
+
+// asyncSink is implemented by sinks that can have many messages in flight at
+// once. Instead of blocking on every batch, like EmitRows, a changefeed hands
+// rows to the sink as it encodes them and only waits for them to be
+// acknowledged before it emits a resolved timestamp. This keeps high-latency
+// sinks, like kafka in another region, busy with the next batches while the
+// acknowledgements of the previous ones are on their way back.
+type asyncSink interface {
+	Sink
+	// EmitRowAsync starts emitting a row and returns without waiting for the
+	// sink to acknowledge it. done is called exactly once, from any goroutine,
+	// with nil once the row has been emitted or with the error it failed with.
+	// If the row can't be handed to the sink at all, the error is returned
+	// and done isn't called.
+	EmitRowAsync(ctx context.Context, row SinkRow, done func(error)) error
+	// Flush waits until done has been called for every row emitted so far.
+	Flush(ctx context.Context) error
+}
+
+// asyncEmitter emits the rows of a changefeed to an asyncSink. Once a row fails
+// with an error marked by MarkRetryableSinkError, no more rows are handed to
+// the sink until the next flush, which emits that row and every row after it
+// again, in order, including the ones that were acknowledged in the meantime.
+// This way an update to a key is never emitted after a later update to the
+// same key, at the cost of duplicates, which changefeeds already allow. Any
+// other failure is returned by the next flush.
+type asyncEmitter struct {
+	sink    asyncSink
+	metrics *Metrics
+
+	// rows are the rows emitted since the last flush, in order. It's only
+	// used by emit and flush, which are never called concurrently.
+	rows []SinkRow
+
+	mu struct {
+		syncutil.Mutex
+		// firstFailed is the index in rows of the first row that failed with a
+		// retryable error since the last flush, or -1 if none did.
+		firstFailed int
+		// err is the first error a row failed with that isn't retryable.
+		err error
+		// messages and bytes count the rows acknowledged since the last flush.
+		messages int
+		bytes    int64
+	}
+}
+
+func makeAsyncEmitter(sink asyncSink, metrics *Metrics) *asyncEmitter {
+	e := &asyncEmitter{sink: sink, metrics: metrics}
+	e.mu.firstFailed = -1
+	return e
+}
+
+// emit hands rows to the sink without waiting for them, unless a row failed
+// with a retryable error since the last flush, in which case they're held
+// until then. The keys and values of the rows must not be modified until the
+// next flush returns.
+func (e *asyncEmitter) emit(ctx context.Context, rows []SinkRow) error {
+	for _, row := range rows {
+		row := row
+		idx := len(e.rows)
+		e.rows = append(e.rows, row)
+		e.mu.Lock()
+		failing := e.mu.firstFailed >= 0
+		e.mu.Unlock()
+		if failing {
+			continue
+		}
+		// The row is pending until it's acknowledged, which may happen before
+		// EmitRowAsync returns.
+		e.metrics.addSinkPending(1)
+		if err := e.sink.EmitRowAsync(ctx, row, func(err error) { e.ack(idx, row, err) }); err != nil {
+			e.metrics.addSinkPending(-1)
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *asyncEmitter) ack(idx int, row SinkRow, err error) {
+	e.metrics.addSinkPending(-1)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch {
+	case err == nil:
+		e.mu.messages++
+		e.mu.bytes += int64(len(row.Key) + len(row.Value))
+		e.metrics.recordCommitLatency(row.Updated, timeutil.Now())
+	case isRetryableSinkError(err):
+		if e.mu.firstFailed < 0 || idx < e.mu.firstFailed {
+			e.mu.firstFailed = idx
+		}
+	case e.mu.err == nil:
+		e.mu.err = err
+	}
+}
+
+// flush waits until every row emitted so far has been acknowledged, emitting
+// the rows from the first one that failed with a retryable error on again,
+// with backoff.
+func (e *asyncEmitter) flush(ctx context.Context) error {
+	err := emitWithRetry(ctx, func() error {
+		start := timeutil.Now()
+		err := e.sink.Flush(ctx)
+		e.metrics.recordSinkFlush(timeutil.Since(start))
+		if err != nil {
+			return err
+		}
+		e.mu.Lock()
+		firstFailed, messages, bytes, err := e.mu.firstFailed, e.mu.messages, e.mu.bytes, e.mu.err
+		e.mu.firstFailed, e.mu.messages, e.mu.bytes = -1, 0, 0
+		e.mu.Unlock()
+
+		e.metrics.recordEmit(messages, bytes)
+		if err != nil {
+			return err
+		}
+		if firstFailed < 0 {
+			e.rows = e.rows[:0]
+			return nil
+		}
+		retrying := append([]SinkRow(nil), e.rows[firstFailed:]...)
+		e.rows = e.rows[:0]
+		if err := e.emit(ctx, retrying); err != nil {
+			return err
+		}
+		return MarkRetryableSinkError(errors.Errorf(`%d rows failed to emit`, len(retrying)))
+	})
+	if err == nil {
+		e.metrics.recordFlush()
+	}
+	return err
+}
@@ -0,0 +1,215 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/pkg/errors"
+)
+
+const (
+	sinkSchemeNodelocal = `nodelocal`
+	sinkSchemeS3        = `s3`
+	sinkSchemeGS        = `gs`
+	sinkSchemeAzure     = `azure`
+	sinkSchemeHTTP      = `http`
+	sinkSchemeHTTPS     = `https`
+)
+
+// isCloudStorageSinkScheme returns whether a sink URI with the given scheme is
+// emitted to by a cloudStorageSink.
+func isCloudStorageSinkScheme(scheme string) bool {
+	switch scheme {
+	case sinkSchemeUserFile, sinkSchemeNodelocal, sinkSchemeS3, sinkSchemeGS,
+		sinkSchemeAzure, sinkSchemeHTTP, sinkSchemeHTTPS:
+		return true
+	}
+	return false
+}
+
+// fileStorage is the subset of storageccl.ExportStorage needed by
+// cloudStorageSink. It's pulled out so that storage which isn't (yet) an
+// ExportStorage, like userfile, can be used as well.
+type fileStorage interface {
+	io.Closer
+	WriteFile(ctx context.Context, basename string, content io.ReadSeeker) error
+}
+
+// cloudStorageSinkTargetFileSize is the size at which the buffered rows for a
+// topic are written out as a file, even if EmitRows hasn't returned yet.
+//
+// TODO: Make this configurable.
+const cloudStorageSinkTargetFileSize = 16 << 20 // 16 MiB
+
+// cloudStorageSink emits to files in some storage. Rows are written as
+// newline delimited json, one file per topic per call to EmitRows. The value of
+// each row is written as a line, or the key if the value is empty (a deletion
+// or `envelope=key_only`). Keys are always json arrays and values are always
+// json objects, so the two can be told apart. Encoders that implement
+// fileFormatEncoder can change the file extension and add a header to every
+// file, and those that implement fileContentEncoder encode the whole file from
+// its rows.
+//
+// With the `compression` option, every file of rows is compressed and gets the
+// suffix of the compression, like `.ndjson.gz`. `.RESOLVED` files are small
+// and not compressed, so that they stay as easy to poll.
+//
+// Resolved timestamps are written to `.RESOLVED` files. File names sort in the
+// order they were written, so a consumer that has read every file up to and
+// including a `.RESOLVED` file has seen every change at or below that
+// timestamp.
+type cloudStorageSink struct {
+	storage fileStorage
+	// sessionID is unique to this instance of the sink so that a changefeed
+	// restarted after a failure doesn't overwrite files written before it.
+	sessionID string
+	fileID    int64
+	files     map[string]*bytes.Buffer
+
+	ext         string
+	header      func(topic string) []byte
+	encodeFile  func(topic string, rows [][]byte) ([]byte, error)
+	compression compressionType
+}
+
+func makeCloudStorageSink(
+	storage fileStorage, encoder Encoder, compression compressionType,
+) *cloudStorageSink {
+	s := &cloudStorageSink{
+		storage:     storage,
+		sessionID:   fmt.Sprintf(`%019d`, timeutil.Now().UnixNano()),
+		files:       make(map[string]*bytes.Buffer),
+		ext:         `ndjson`,
+		compression: compression,
+	}
+	if f, ok := encoder.(fileFormatEncoder); ok {
+		s.ext, s.header = f.FileExtension(), f.FileHeader
+	}
+	if f, ok := encoder.(fileContentEncoder); ok {
+		s.encodeFile = f.EncodeFile
+	}
+	return s
+}
+
+// EmitRows implements the Sink interface.
+func (s *cloudStorageSink) EmitRows(ctx context.Context, rows []SinkRow) error {
+	for _, row := range rows {
+		file, ok := s.files[row.Topic]
+		if !ok {
+			file = &bytes.Buffer{}
+			s.files[row.Topic] = file
+		}
+		line := row.Value
+		if len(line) == 0 {
+			line = row.Key
+		}
+		if s.encodeFile != nil {
+			// The rows are handed to encodeFile when the file is flushed, so
+			// they're length prefixed instead of delimited.
+			var scratch [binary.MaxVarintLen64]byte
+			file.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(line)))])
+			file.Write(line)
+		} else {
+			file.Write(line)
+			file.WriteByte('\n')
+		}
+
+		if file.Len() >= cloudStorageSinkTargetFileSize {
+			if err := s.flushFile(ctx, row.Topic, file); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Everything has to be durable by the time EmitRows returns. Flush in a
+	// deterministic order to keep the file names predictable.
+	topics := make([]string, 0, len(s.files))
+	for topic := range s.files {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	for _, topic := range topics {
+		if err := s.flushFile(ctx, topic, s.files[topic]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EmitResolvedTimestamp implements the Sink interface.
+func (s *cloudStorageSink) EmitResolvedTimestamp(ctx context.Context, payload []byte) error {
+	name := fmt.Sprintf(`%s-%08d.RESOLVED`, s.sessionID, s.fileID)
+	s.fileID++
+	return s.storage.WriteFile(ctx, name, bytes.NewReader(payload))
+}
+
+func (s *cloudStorageSink) flushFile(ctx context.Context, topic string, file *bytes.Buffer) error {
+	if file.Len() == 0 {
+		return nil
+	}
+	name := fmt.Sprintf(`%s-%08d-%s.%s`, s.sessionID, s.fileID, topic, s.ext)
+	if s.compression == optCompressionGzip {
+		name += `.gz`
+	}
+	s.fileID++
+	if log.V(1) {
+		log.Infof(ctx, `writing %d bytes to %s`, file.Len(), name)
+	}
+	var content io.ReadSeeker = bytes.NewReader(file.Bytes())
+	if s.encodeFile != nil {
+		var rows [][]byte
+		for buf := file.Bytes(); len(buf) > 0; {
+			n, l := binary.Uvarint(buf)
+			if l <= 0 || uint64(len(buf)-l) < n {
+				return errors.Errorf(`corrupt buffered rows for %s`, topic)
+			}
+			rows = append(rows, buf[l:l+int(n)])
+			buf = buf[l+int(n):]
+		}
+		encoded, err := s.encodeFile(topic, rows)
+		if err != nil {
+			return err
+		}
+		content = bytes.NewReader(encoded)
+	} else if s.header != nil {
+		if header := s.header(topic); len(header) > 0 {
+			content = bytes.NewReader(append(append([]byte(nil), header...), file.Bytes()...))
+		}
+	}
+	if s.compression == optCompressionGzip {
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		if _, err := io.Copy(gz, content); err != nil {
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+		content = bytes.NewReader(compressed.Bytes())
+	}
+	if err := s.storage.WriteFile(ctx, name, content); err != nil {
+		return err
+	}
+	file.Reset()
+	return nil
+}
+
+// Close implements the Sink interface.
+func (s *cloudStorageSink) Close() error {
+	return s.storage.Close()
+}
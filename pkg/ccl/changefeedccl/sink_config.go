@@ -0,0 +1,208 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+// sinkFlushConfig is the `Flush` field of the JSON config options of the sinks
+// that batch messages, `kafka_sink_config` and `webhook_sink_config`, like
+// `{"Flush": {"Messages": 1000, "Bytes": 1048576, "Frequency": "100ms"}}`. It
+// trades latency for throughput: bigger batches that are sent less often take
+// fewer requests to emit the same rows, but each row waits longer to be sent.
+// Fields that aren't set, or are zero, keep the sink's defaults.
+type sinkFlushConfig struct {
+	// Messages is the number of messages that triggers a flush, or for the
+	// webhook sink the most messages in a batch.
+	Messages int
+	// Bytes is the size of the messages that triggers a flush, or for the
+	// webhook sink the largest size of a batch.
+	Bytes int
+	// Frequency is the longest a message waits before it's flushed.
+	Frequency string
+}
+
+// frequency validates a flush config of the given option and returns its
+// Frequency, which is zero if it isn't set.
+func (c sinkFlushConfig) frequency(opt string) (time.Duration, error) {
+	if c.Messages < 0 || c.Bytes < 0 {
+		return 0, errors.Errorf(
+			`invalid %s: Flush.Messages and Flush.Bytes must be non-negative`, opt)
+	}
+	if c.Frequency == `` {
+		return 0, nil
+	}
+	frequency, err := time.ParseDuration(c.Frequency)
+	if err != nil {
+		return 0, errors.Wrapf(err, `invalid %s Flush.Frequency`, opt)
+	}
+	if frequency <= 0 {
+		return 0, errors.Errorf(
+			`invalid %s Flush.Frequency: must be positive: %s`, opt, c.Frequency)
+	}
+	return frequency, nil
+}
+
+// kafkaSinkConfig is the `kafka_sink_config` option, which tunes the producer
+// of a kafka sink for the brokers it sends to, like
+// `{"Flush": {"Messages": 1000}, "RequiredAcks": "ALL", "Compression": "GZIP"}`.
+// Fields that aren't set keep sarama's defaults.
+type kafkaSinkConfig struct {
+	Flush sinkFlushConfig
+	// RequiredAcks is the acknowledgement a message waits for: `NONE`, `ONE`,
+	// from the leader of its partition, or `ALL`, from every in-sync replica.
+	RequiredAcks string
+	// Version is the kafka version the brokers are assumed to run, like
+	// `0.10.2.0`, which overrides the sink's `kafka_version` parameter. Some
+	// features, like LZ4 compression, need a newer version than the default.
+	Version string
+	// Compression is the codec messages are compressed with: `NONE`, `GZIP`,
+	// `SNAPPY` or `LZ4`.
+	Compression string
+	// ClientID is the ID the producer sends the brokers, for their logs and
+	// quotas.
+	ClientID string
+	// Partitioner picks the partition of a row: `hash`, by its key, which
+	// keeps the changes to a row in order, `round_robin`, or `column`, by the
+	// value of PartitionColumn, so that a hot key doesn't pin all the traffic
+	// to one partition. The rows of a table without that column, or that
+	// don't have its value, like deletes, are hashed by their key, and
+	// the changes to a row are only in order while the value doesn't change.
+	Partitioner     string
+	PartitionColumn string
+}
+
+const (
+	kafkaPartitionerHash       = `hash`
+	kafkaPartitionerRoundRobin = `round_robin`
+	kafkaPartitionerColumn     = `column`
+)
+
+var kafkaRequiredAcks = map[string]sarama.RequiredAcks{
+	`NONE`: sarama.NoResponse,
+	`ONE`:  sarama.WaitForLocal,
+	`ALL`:  sarama.WaitForAll,
+}
+
+var kafkaCompressionCodecs = map[string]sarama.CompressionCodec{
+	`NONE`:   sarama.CompressionNone,
+	`GZIP`:   sarama.CompressionGZIP,
+	`SNAPPY`: sarama.CompressionSnappy,
+	`LZ4`:    sarama.CompressionLZ4,
+}
+
+// kafkaVersions are the kafka versions the vendored sarama knows about.
+//
+// TODO: Newer versions, and the features that need them, like record
+// headers, zstd compression and idempotent producers, need a newer sarama.
+var kafkaVersions = map[string]sarama.KafkaVersion{
+	`0.8.2.0`:  sarama.V0_8_2_0,
+	`0.8.2.1`:  sarama.V0_8_2_1,
+	`0.8.2.2`:  sarama.V0_8_2_2,
+	`0.9.0.0`:  sarama.V0_9_0_0,
+	`0.9.0.1`:  sarama.V0_9_0_1,
+	`0.10.0.0`: sarama.V0_10_0_0,
+	`0.10.0.1`: sarama.V0_10_0_1,
+	`0.10.1.0`: sarama.V0_10_1_0,
+	`0.10.2.0`: sarama.V0_10_2_0,
+}
+
+// applyKafkaSinkConfig overrides the producer settings of a kafka client
+// config with the given `kafka_sink_config`, which may be empty. By default,
+// messages are sent as soon as the producer can, in batches of whatever has
+// queued up while the previous batch to the same broker was in flight.
+func applyKafkaSinkConfig(config *sarama.Config, sinkConfig string) error {
+	if sinkConfig == `` {
+		return nil
+	}
+	var c kafkaSinkConfig
+	if err := json.Unmarshal([]byte(sinkConfig), &c); err != nil {
+		return errors.Wrapf(err, `invalid %s`, optKafkaSinkConfig)
+	}
+	frequency, err := c.Flush.frequency(optKafkaSinkConfig)
+	if err != nil {
+		return err
+	}
+	config.Producer.Flush.Messages = c.Flush.Messages
+	config.Producer.Flush.Bytes = c.Flush.Bytes
+	config.Producer.Flush.Frequency = frequency
+
+	if c.RequiredAcks != `` {
+		acks, ok := kafkaRequiredAcks[strings.ToUpper(c.RequiredAcks)]
+		if !ok {
+			return errors.Errorf(`invalid %s RequiredAcks: expected NONE, ONE or ALL: %s`,
+				optKafkaSinkConfig, c.RequiredAcks)
+		}
+		config.Producer.RequiredAcks = acks
+	}
+	if c.Version != `` {
+		version, ok := kafkaVersions[c.Version]
+		if !ok {
+			return errors.Errorf(`invalid %s Version: unsupported kafka version: %s`,
+				optKafkaSinkConfig, c.Version)
+		}
+		config.Version = version
+	}
+	if c.Compression != `` {
+		codec, ok := kafkaCompressionCodecs[strings.ToUpper(c.Compression)]
+		if !ok {
+			return errors.Errorf(`invalid %s Compression: expected NONE, GZIP, SNAPPY or LZ4: %s`,
+				optKafkaSinkConfig, c.Compression)
+		}
+		config.Producer.Compression = codec
+	}
+	if c.ClientID != `` {
+		config.ClientID = c.ClientID
+	}
+	partitioner := strings.ToLower(c.Partitioner)
+	switch partitioner {
+	case ``, kafkaPartitionerHash, kafkaPartitionerColumn:
+	case kafkaPartitionerRoundRobin:
+		config.Producer.Partitioner = newRoundRobinChangefeedPartitioner
+	default:
+		return errors.Errorf(`invalid %s Partitioner: expected %s, %s or %s: %s`, optKafkaSinkConfig,
+			kafkaPartitionerHash, kafkaPartitionerRoundRobin, kafkaPartitionerColumn, c.Partitioner)
+	}
+	if (partitioner == kafkaPartitionerColumn) != (c.PartitionColumn != ``) {
+		return errors.Errorf(`invalid %s: PartitionColumn is required by, and only allowed with, `+
+			`Partitioner %s`, optKafkaSinkConfig, kafkaPartitionerColumn)
+	}
+	// Catch combinations sarama doesn't support, like LZ4 with brokers older
+	// than 0.10, when the changefeed is created instead of when it connects.
+	if err := config.Validate(); err != nil {
+		return errors.Wrapf(err, `invalid %s`, optKafkaSinkConfig)
+	}
+	return nil
+}
+
+// validateKafkaSinkConfig returns an error if a `kafka_sink_config` is
+// invalid.
+func validateKafkaSinkConfig(sinkConfig string) error {
+	return applyKafkaSinkConfig(sarama.NewConfig(), sinkConfig)
+}
+
+// kafkaPartitionColumn returns the column that the rows of a changefeed with
+// the given options are partitioned by, or an empty string if they aren't.
+func kafkaPartitionColumn(opts map[string]string) (string, error) {
+	sinkConfig, ok := opts[optKafkaSinkConfig]
+	if !ok {
+		return ``, nil
+	}
+	var c kafkaSinkConfig
+	if err := json.Unmarshal([]byte(sinkConfig), &c); err != nil {
+		return ``, errors.Wrapf(err, `invalid %s`, optKafkaSinkConfig)
+	}
+	return c.PartitionColumn, nil
+}
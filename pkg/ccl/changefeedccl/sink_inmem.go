@@ -0,0 +1,309 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/randutil"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/pkg/errors"
+)
+
+// sinkSchemeInMem routes a changefeed to an InMemSink registered with
+// RegisterInMemSink. The host of the URI is the name it was registered under.
+const sinkSchemeInMem = `inmem`
+
+var inMemSinks struct {
+	syncutil.Mutex
+	byName map[string]*InMemSink
+}
+
+// InMemRecord is a row emitted to an InMemSink.
+type InMemRecord struct {
+	Topic      string
+	Key, Value []byte
+}
+
+// String returns the record in the same `topic: key->value` form used by
+// assertions in the changefeed tests.
+func (r InMemRecord) String() string {
+	return fmt.Sprintf(`%s: %s->%s`, r.Topic, r.Key, r.Value)
+}
+
+// InMemSinkChaos makes an InMemSink misbehave in the ways a real sink might, so
+// that the changefeed's retry and ordering logic gets exercised by fast unit
+// tests. Each probability is in [0,1] and the zero value is a well-behaved sink.
+type InMemSinkChaos struct {
+	// Seed is used to make the misbehavior reproducible. If zero, a seed is
+	// picked and returned by SetChaos so that tests can log it.
+	Seed int64
+	// DelayProbability is the chance that an emission is delayed by a random
+	// duration up to MaxDelay before being recorded.
+	DelayProbability float64
+	MaxDelay         time.Duration
+	// DuplicateProbability is the chance that any given row is recorded twice.
+	DuplicateProbability float64
+	// FailProbability is the chance that an emission fails with a retryable
+	// error. Emissions of rows that fail may record some prefix of the rows
+	// first, as a real sink might.
+	FailProbability float64
+}
+
+// InMemSink records everything a changefeed emits in memory. It's intended to
+// let unit tests in any package assert on what a changefeed would emit without
+// standing up kafka or using the sinkless `CREATE CHANGEFEED` rows.
+//
+// Create one with RegisterInMemSink and point a changefeed at it with the sink
+// URI returned by its URI method.
+type InMemSink struct {
+	name string
+	mu   struct {
+		syncutil.Mutex
+		records  []InMemRecord
+		resolved [][]byte
+		closed   bool
+		chaos    InMemSinkChaos
+		rng      *rand.Rand
+		// notify is closed and replaced whenever anything is emitted.
+		notify chan struct{}
+	}
+}
+
+// RegisterInMemSink creates an InMemSink and makes it available to changefeeds
+// as `inmem://<name>`. The returned function unregisters it, after which any
+// changefeed still using it will fail to start.
+func RegisterInMemSink(name string) (*InMemSink, func()) {
+	s := &InMemSink{name: name}
+	s.mu.notify = make(chan struct{})
+
+	inMemSinks.Lock()
+	defer inMemSinks.Unlock()
+	if inMemSinks.byName == nil {
+		inMemSinks.byName = make(map[string]*InMemSink)
+	}
+	if _, ok := inMemSinks.byName[name]; ok {
+		panic(fmt.Sprintf(`in-memory sink %s is already registered`, name))
+	}
+	inMemSinks.byName[name] = s
+	return s, func() {
+		inMemSinks.Lock()
+		defer inMemSinks.Unlock()
+		delete(inMemSinks.byName, name)
+	}
+}
+
+func getInMemSink(name string) (*InMemSink, error) {
+	inMemSinks.Lock()
+	defer inMemSinks.Unlock()
+	s, ok := inMemSinks.byName[name]
+	if !ok {
+		return nil, errors.Errorf(`in-memory sink %s is not registered`, name)
+	}
+	return s, nil
+}
+
+// SetChaos configures how the sink misbehaves from now on and returns the seed
+// in use. Passing the zero InMemSinkChaos turns misbehavior back off.
+func (s *InMemSink) SetChaos(chaos InMemSinkChaos) int64 {
+	if chaos.Seed == 0 {
+		chaos.Seed = randutil.NewPseudoSeed()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.chaos = chaos
+	s.mu.rng = rand.New(rand.NewSource(chaos.Seed))
+	return chaos.Seed
+}
+
+// chaosDelayLocked returns how long the current emission should be delayed
+// according to the sink's chaos config.
+func (s *InMemSink) chaosDelayLocked() time.Duration {
+	c := s.mu.chaos
+	if c.MaxDelay <= 0 || s.mu.rng == nil || s.mu.rng.Float64() >= c.DelayProbability {
+		return 0
+	}
+	return time.Duration(s.mu.rng.Int63n(int64(c.MaxDelay)))
+}
+
+func (s *InMemSink) chaosLocked(probability float64) bool {
+	return s.mu.rng != nil && s.mu.rng.Float64() < probability
+}
+
+func (s *InMemSink) sleep(ctx context.Context) error {
+	s.mu.Lock()
+	delay := s.chaosDelayLocked()
+	s.mu.Unlock()
+	if delay == 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// URI returns the sink URI that routes a changefeed to this sink.
+func (s *InMemSink) URI() string {
+	return sinkSchemeInMem + `://` + s.name
+}
+
+// EmitRows implements the Sink interface.
+func (s *InMemSink) EmitRows(ctx context.Context, rows []SinkRow) error {
+	if err := s.sleep(ctx); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(rows) > 0 {
+		defer s.notifyLocked()
+	}
+	failAt := -1
+	if s.chaosLocked(s.mu.chaos.FailProbability) {
+		failAt = s.mu.rng.Intn(len(rows) + 1)
+	}
+	for i, row := range rows {
+		if i == failAt {
+			break
+		}
+		// The row's bytes are reused by the caller after this returns.
+		r := InMemRecord{
+			Topic: row.Topic,
+			Key:   append([]byte(nil), row.Key...),
+			Value: append([]byte(nil), row.Value...),
+		}
+		s.mu.records = append(s.mu.records, r)
+		if s.chaosLocked(s.mu.chaos.DuplicateProbability) {
+			s.mu.records = append(s.mu.records, r)
+		}
+	}
+	if failAt >= 0 {
+		return MarkRetryableSinkError(errors.Errorf(
+			`injected failure after %d of %d rows`, failAt, len(rows)))
+	}
+	return nil
+}
+
+// EmitResolvedTimestamp implements the Sink interface.
+func (s *InMemSink) EmitResolvedTimestamp(ctx context.Context, payload []byte) error {
+	if err := s.sleep(ctx); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.chaosLocked(s.mu.chaos.FailProbability) {
+		return MarkRetryableSinkError(errors.New(`injected failure`))
+	}
+	s.mu.resolved = append(s.mu.resolved, append([]byte(nil), payload...))
+	if s.chaosLocked(s.mu.chaos.DuplicateProbability) {
+		s.mu.resolved = append(s.mu.resolved, s.mu.resolved[len(s.mu.resolved)-1])
+	}
+	s.notifyLocked()
+	return nil
+}
+
+// Close implements the Sink interface. The recorded rows are kept.
+func (s *InMemSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.closed = true
+	s.notifyLocked()
+	return nil
+}
+
+func (s *InMemSink) notifyLocked() {
+	close(s.mu.notify)
+	s.mu.notify = make(chan struct{})
+}
+
+// Records returns every row emitted so far, in the order they were emitted.
+func (s *InMemSink) Records() []InMemRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]InMemRecord(nil), s.mu.records...)
+}
+
+// RecordsForTopic returns every row emitted so far to the given topic, in the
+// order they were emitted.
+func (s *InMemSink) RecordsForTopic(topic string) []InMemRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []InMemRecord
+	for _, r := range s.mu.records {
+		if r.Topic == topic {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// LatestByKey returns the most recently emitted row for each key in the given
+// topic, indexed by the key.
+func (s *InMemSink) LatestByKey(topic string) map[string]InMemRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	latest := make(map[string]InMemRecord)
+	for _, r := range s.mu.records {
+		if r.Topic == topic {
+			latest[string(r.Key)] = r
+		}
+	}
+	return latest
+}
+
+// Resolved returns every resolved timestamp payload emitted so far.
+func (s *InMemSink) Resolved() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte(nil), s.mu.resolved...)
+}
+
+// Closed returns whether a changefeed using the sink has shut down.
+func (s *InMemSink) Closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mu.closed
+}
+
+// Reset forgets everything recorded so far.
+func (s *InMemSink) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.records = nil
+	s.mu.resolved = nil
+}
+
+// WaitForRecords blocks until at least n rows have been emitted and returns
+// them. It returns an error if they don't show up before the timeout.
+func (s *InMemSink) WaitForRecords(n int, timeout time.Duration) ([]InMemRecord, error) {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		records, notify := s.mu.records, s.mu.notify
+		if len(records) >= n {
+			records = append([]InMemRecord(nil), records...)
+		}
+		s.mu.Unlock()
+		if len(records) >= n {
+			return records, nil
+		}
+
+		select {
+		case <-notify:
+		case <-deadline:
+			return nil, errors.Errorf(`expected %d records after %s got %d`,
+				n, timeout, len(records))
+		}
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/util/envutil"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/pkg/errors"
+)
+
+// sinkRecordDir, if set, makes every changefeed sink on the node record what it
+// emits to a file in this directory, to be diffed against the recording of a
+// later run with DiffSinkRecordings. It's intended for acceptance runs that
+// guard against subtle changes in emission behavior across refactors.
+var sinkRecordDir = envutil.EnvOrDefaultString("COCKROACH_CHANGEFEED_RECORD_SINK_DIR", "")
+
+// sinkRecordingExt is the extension of the files written by recordingSink.
+const sinkRecordingExt = `sinkrec`
+
+// The kinds of entries in a sink recording.
+const (
+	sinkRecordingRow      = `row`
+	sinkRecordingFlush    = `flush`
+	sinkRecordingResolved = `resolved`
+)
+
+// SinkRecordingEntry is one interaction with a sink: an emitted row, the end of
+// a successful call to EmitRows (a flush boundary), or a resolved timestamp.
+type SinkRecordingEntry struct {
+	Kind  string
+	Topic string
+	// Key is only set for rows. Value is the row's value or the resolved
+	// timestamp payload.
+	Key, Value []byte
+}
+
+// String returns the entry as it's written in a recording, without the
+// trailing newline. Topics, keys, and values are quoted, so every entry is one
+// line.
+func (e SinkRecordingEntry) String() string {
+	switch e.Kind {
+	case sinkRecordingRow:
+		return fmt.Sprintf(`%s %s %s %s`, e.Kind,
+			strconv.Quote(e.Topic), strconv.Quote(string(e.Key)), strconv.Quote(string(e.Value)))
+	case sinkRecordingResolved:
+		return fmt.Sprintf(`%s %s`, e.Kind, strconv.Quote(string(e.Value)))
+	default:
+		return e.Kind
+	}
+}
+
+// recordingSink wraps a Sink and writes every successful interaction with it to
+// a recording. Emissions that return an error aren't recorded, so the
+// recording only depends on what the sink accepted.
+type recordingSink struct {
+	wrapped Sink
+	mu      struct {
+		syncutil.Mutex
+		w *bufio.Writer
+		c io.Closer
+	}
+}
+
+var _ Sink = &recordingSink{}
+var _ offsetBootstrapSink = &recordingSink{}
+
+// makeRecordingSink wraps a Sink so that it records to a new file in dir.
+func makeRecordingSink(wrapped Sink, dir string) (*recordingSink, error) {
+	name := fmt.Sprintf(`%019d.%s`, timeutil.Now().UnixNano(), sinkRecordingExt)
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return nil, errors.Wrap(err, `creating sink recording`)
+	}
+	s := &recordingSink{wrapped: wrapped}
+	s.mu.w, s.mu.c = bufio.NewWriter(f), f
+	return s, nil
+}
+
+// EmitRows implements the Sink interface.
+func (s *recordingSink) EmitRows(ctx context.Context, rows []SinkRow) error {
+	if err := s.wrapped.EmitRows(ctx, rows); err != nil {
+		return err
+	}
+	entries := make([]SinkRecordingEntry, 0, len(rows)+1)
+	for _, row := range rows {
+		entries = append(entries, SinkRecordingEntry{
+			Kind: sinkRecordingRow, Topic: row.Topic, Key: row.Key, Value: row.Value,
+		})
+	}
+	return s.record(append(entries, SinkRecordingEntry{Kind: sinkRecordingFlush})...)
+}
+
+// EmitResolvedTimestamp implements the Sink interface.
+func (s *recordingSink) EmitResolvedTimestamp(ctx context.Context, payload []byte) error {
+	if err := s.wrapped.EmitResolvedTimestamp(ctx, payload); err != nil {
+		return err
+	}
+	return s.record(SinkRecordingEntry{Kind: sinkRecordingResolved, Value: payload})
+}
+
+// EmitOffsetBootstrap implements the offsetBootstrapSink interface by passing
+// it through to the wrapped sink. Bootstrap records aren't recorded, their
+// offsets depend on the state of the sink.
+func (s *recordingSink) EmitOffsetBootstrap(ctx context.Context, resolved hlc.Timestamp) error {
+	if b, ok := s.wrapped.(offsetBootstrapSink); ok {
+		return b.EmitOffsetBootstrap(ctx, resolved)
+	}
+	return nil
+}
+
+// Close implements the Sink interface.
+func (s *recordingSink) Close() error {
+	err := s.wrapped.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e := s.mu.w.Flush(); err == nil {
+		err = e
+	}
+	if e := s.mu.c.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+func (s *recordingSink) record(entries ...SinkRecordingEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range entries {
+		if _, err := fmt.Fprintln(s.mu.w, e.String()); err != nil {
+			return errors.Wrap(err, `writing sink recording`)
+		}
+	}
+	// Flush so that a recording is complete up to the last emission even if
+	// the node is killed.
+	return errors.Wrap(s.mu.w.Flush(), `writing sink recording`)
+}
+
+// ReadSinkRecording parses a recording written by a sink while
+// COCKROACH_CHANGEFEED_RECORD_SINK_DIR was set.
+func ReadSinkRecording(r io.Reader) ([]SinkRecordingEntry, error) {
+	var entries []SinkRecordingEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		e, err := parseSinkRecordingEntry(scanner.Text())
+		if err != nil {
+			return nil, errors.Wrapf(err, `line %d`, lineNum)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+func parseSinkRecordingEntry(line string) (SinkRecordingEntry, error) {
+	kind, rest := line, ``
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		kind, rest = line[:i], line[i+1:]
+	}
+	var fields []string
+	for len(rest) > 0 {
+		quoted := sinkRecordingQuotedPrefix(rest)
+		field, err := strconv.Unquote(quoted)
+		if err != nil {
+			return SinkRecordingEntry{}, errors.Wrapf(err, `parsing %q`, line)
+		}
+		fields = append(fields, field)
+		rest = strings.TrimPrefix(rest[len(quoted):], ` `)
+	}
+
+	e := SinkRecordingEntry{Kind: kind}
+	switch {
+	case kind == sinkRecordingRow && len(fields) == 3:
+		e.Topic, e.Key, e.Value = fields[0], []byte(fields[1]), []byte(fields[2])
+	case kind == sinkRecordingResolved && len(fields) == 1:
+		e.Value = []byte(fields[0])
+	case kind == sinkRecordingFlush && len(fields) == 0:
+	default:
+		return SinkRecordingEntry{}, errors.Errorf(`unknown sink recording entry: %q`, line)
+	}
+	return e, nil
+}
+
+// sinkRecordingQuotedPrefix returns the double-quoted string at the start of s,
+// as written by strconv.Quote, or all of s if it doesn't end.
+func sinkRecordingQuotedPrefix(s string) string {
+	if len(s) == 0 || s[0] != '"' {
+		return s
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return s[:i+1]
+		}
+	}
+	return s
+}
+
+// SinkRecordingDiffOptions controls which differences between two sink
+// recordings DiffSinkRecordings reports. The zero value compares every entry
+// exactly.
+type SinkRecordingDiffOptions struct {
+	// IgnoreFlushes ignores where the flush boundaries are, which depend on
+	// the timing of the run.
+	IgnoreFlushes bool
+	// PerKey only compares the order of rows with the same topic and key, and
+	// the order of resolved timestamps, which is all a changefeed guarantees.
+	// It implies IgnoreFlushes.
+	PerKey bool
+	// Normalize, if set, is applied to every value and resolved timestamp
+	// payload before comparing them. It can be used to mask out the parts that
+	// are expected to change between runs, like updated timestamps.
+	Normalize func(value []byte) []byte
+}
+
+// DiffSinkRecordings compares the recording of a run against the recording of
+// an earlier one and returns a description of every difference, or nothing if
+// they match.
+func DiffSinkRecordings(
+	expected, actual []SinkRecordingEntry, opts SinkRecordingDiffOptions,
+) []string {
+	normalize := func(entries []SinkRecordingEntry) map[string][]string {
+		streams := make(map[string][]string)
+		for _, e := range entries {
+			if e.Kind == sinkRecordingFlush && (opts.IgnoreFlushes || opts.PerKey) {
+				continue
+			}
+			if opts.Normalize != nil && e.Value != nil {
+				e.Value = opts.Normalize(e.Value)
+			}
+			var stream string
+			if opts.PerKey {
+				stream = e.Kind + ` ` + strconv.Quote(e.Topic) + ` ` + strconv.Quote(string(e.Key))
+			}
+			streams[stream] = append(streams[stream], e.String())
+		}
+		return streams
+	}
+	expectedStreams, actualStreams := normalize(expected), normalize(actual)
+
+	var diffs []string
+	for stream, expectedLines := range expectedStreams {
+		diffs = append(diffs, diffSinkRecordingStream(
+			stream, expectedLines, actualStreams[stream])...)
+	}
+	for stream, actualLines := range actualStreams {
+		if _, ok := expectedStreams[stream]; !ok {
+			diffs = append(diffs, diffSinkRecordingStream(stream, nil, actualLines)...)
+		}
+	}
+	// Map iteration order is random, make the output deterministic.
+	sort.Strings(diffs)
+	return diffs
+}
+
+func diffSinkRecordingStream(stream string, expected, actual []string) []string {
+	prefix := ``
+	if stream != `` {
+		prefix = stream + `: `
+	}
+	for i := 0; i < len(expected) || i < len(actual); i++ {
+		switch {
+		case i >= len(actual):
+			return []string{fmt.Sprintf(`%sentry %d: missing %s (and %d more)`,
+				prefix, i, expected[i], len(expected)-i-1)}
+		case i >= len(expected):
+			return []string{fmt.Sprintf(`%sentry %d: unexpected %s (and %d more)`,
+				prefix, i, actual[i], len(actual)-i-1)}
+		case expected[i] != actual[i]:
+			// Everything after the first difference in a stream is likely to
+			// differ as well, so only report the first one.
+			return []string{fmt.Sprintf(`%sentry %d: expected %s got %s`,
+				prefix, i, expected[i], actual[i])}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+)
+
+func TestSinkRecording(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	dir, cleanup := testutils.TempDir(t)
+	defer cleanup()
+
+	inMem, unregister := RegisterInMemSink(`recording`)
+	defer unregister()
+	sink, err := makeRecordingSink(inMem, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.EmitRows(ctx, []SinkRow{
+		{Topic: `foo`, Key: []byte(`[1]`), Value: []byte(`{"a": 1, "ts": "1"}`)},
+		{Topic: `foo`, Key: []byte(`[2]`), Value: []byte("line\nbreak \"quoted\"")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.EmitRows(ctx, []SinkRow{{Topic: `foo`, Key: []byte(`[1]`)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.EmitResolvedTimestamp(ctx, []byte(`{"resolved": "2"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(inMem.Records()) != 3 || len(inMem.Resolved()) != 1 {
+		t.Fatalf(`expected the wrapped sink to get everything got %v %s`,
+			inMem.Records(), inMem.Resolved())
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, `*.`+sinkRecordingExt))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(paths) != 1 {
+		t.Fatalf(`expected one recording got %v`, paths)
+	}
+	f, err := os.Open(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	recording, err := ReadSinkRecording(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []string
+	for _, e := range recording {
+		actual = append(actual, e.String())
+	}
+	expected := []string{
+		`row "foo" "[1]" "{\"a\": 1, \"ts\": \"1\"}"`,
+		`row "foo" "[2]" "line\nbreak \"quoted\""`,
+		`flush`,
+		`row "foo" "[1]" ""`,
+		`flush`,
+		`resolved "{\"resolved\": \"2\"}"`,
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected\n  %v\ngot\n  %v", expected, actual)
+	}
+
+	if diffs := DiffSinkRecordings(recording, recording, SinkRecordingDiffOptions{}); len(diffs) > 0 {
+		t.Errorf(`expected no differences got %v`, diffs)
+	}
+
+	// A later run with different timestamps, batched differently, and with the
+	// rows of different keys interleaved differently.
+	later := []SinkRecordingEntry{
+		{Kind: sinkRecordingRow, Topic: `foo`, Key: []byte(`[2]`),
+			Value: []byte("line\nbreak \"quoted\"")},
+		{Kind: sinkRecordingRow, Topic: `foo`, Key: []byte(`[1]`),
+			Value: []byte(`{"a": 1, "ts": "7"}`)},
+		{Kind: sinkRecordingRow, Topic: `foo`, Key: []byte(`[1]`)},
+		{Kind: sinkRecordingFlush},
+		{Kind: sinkRecordingResolved, Value: []byte(`{"resolved": "8"}`)},
+	}
+	maskTimestamps := func(value []byte) []byte {
+		return regexp.MustCompile(`"[0-9]+"`).ReplaceAll(value, []byte(`"?"`))
+	}
+	if diffs := DiffSinkRecordings(recording, later, SinkRecordingDiffOptions{
+		PerKey: true, Normalize: maskTimestamps,
+	}); len(diffs) > 0 {
+		t.Errorf(`expected no differences got %v`, diffs)
+	}
+	diffs := DiffSinkRecordings(recording, later, SinkRecordingDiffOptions{
+		IgnoreFlushes: true, Normalize: maskTimestamps,
+	})
+	if len(diffs) != 1 || !strings.Contains(diffs[0], `entry 0: expected row "foo" "[1]"`) {
+		t.Errorf(`expected a difference in the first entry got %v`, diffs)
+	}
+
+	// A change in emission behavior is reported per key.
+	later[2].Value = []byte(`{"a": 2, "ts": "9"}`)
+	diffs = DiffSinkRecordings(recording, later, SinkRecordingDiffOptions{
+		PerKey: true, Normalize: maskTimestamps,
+	})
+	expectedDiff := `row "foo" "[1]": entry 1: expected row "foo" "[1]" "" ` +
+		`got row "foo" "[1]" "{\"a\": 2, \"ts\": \"?\"}"`
+	if !reflect.DeepEqual([]string{expectedDiff}, diffs) {
+		t.Errorf("expected\n  %v\ngot\n  %v", expectedDiff, diffs)
+	}
+}
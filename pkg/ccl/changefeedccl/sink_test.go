@@ -0,0 +1,629 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/security/securitytest"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/pkg/errors"
+)
+
+// fakeAsyncProducer is a sarama.AsyncProducer that assigns every message
+// sent to it a partition by its key and the next offset of that partition, and
+// acknowledges it after latency, like a broker across a network would. It
+// acknowledges messages in order but has any number in flight at once. If
+// maxBatch is set, the messages sent while it has that many in flight are
+// rejected as too large, like a broker would reject a batch.
+type fakeAsyncProducer struct {
+	numPartitions int32
+	latency       time.Duration
+	maxBatch      int32
+	pending       int32
+
+	input     chan *sarama.ProducerMessage
+	inflight  chan fakeInflightMessage
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+	done      chan struct{}
+
+	nextOffsets map[string]map[int32]int64
+	sent        []*sarama.ProducerMessage
+}
+
+type fakeInflightMessage struct {
+	m       *sarama.ProducerMessage
+	ackTime time.Time
+	err     error
+}
+
+var _ sarama.AsyncProducer = &fakeAsyncProducer{}
+
+func makeFakeAsyncProducer(numPartitions int32, latency time.Duration) *fakeAsyncProducer {
+	p := &fakeAsyncProducer{
+		numPartitions: numPartitions,
+		latency:       latency,
+		input:         make(chan *sarama.ProducerMessage),
+		inflight:      make(chan fakeInflightMessage, 100000),
+		successes:     make(chan *sarama.ProducerMessage),
+		errors:        make(chan *sarama.ProducerError),
+		done:          make(chan struct{}),
+		nextOffsets:   make(map[string]map[int32]int64),
+	}
+	go func() {
+		defer close(p.inflight)
+		for m := range p.input {
+			if pending := atomic.AddInt32(&p.pending, 1); p.maxBatch > 0 && pending > p.maxBatch {
+				p.inflight <- fakeInflightMessage{
+					m: m, ackTime: timeutil.Now().Add(p.latency), err: sarama.ErrMessageSizeTooLarge,
+				}
+				continue
+			}
+			if m.Key != nil {
+				key, _ := m.Key.Encode()
+				m.Partition = int32(len(key)) % p.numPartitions
+			}
+			if p.nextOffsets[m.Topic] == nil {
+				p.nextOffsets[m.Topic] = make(map[int32]int64)
+			}
+			m.Offset = p.nextOffsets[m.Topic][m.Partition]
+			p.nextOffsets[m.Topic][m.Partition]++
+			p.sent = append(p.sent, m)
+			p.inflight <- fakeInflightMessage{m: m, ackTime: timeutil.Now().Add(p.latency)}
+		}
+	}()
+	go func() {
+		defer close(p.done)
+		defer close(p.errors)
+		defer close(p.successes)
+		for f := range p.inflight {
+			time.Sleep(f.ackTime.Sub(timeutil.Now()))
+			atomic.AddInt32(&p.pending, -1)
+			if f.err != nil {
+				p.errors <- &sarama.ProducerError{Msg: f.m, Err: f.err}
+				continue
+			}
+			p.successes <- f.m
+		}
+	}()
+	return p
+}
+
+func (p *fakeAsyncProducer) AsyncClose() { close(p.input) }
+func (p *fakeAsyncProducer) Close() error {
+	p.AsyncClose()
+	<-p.done
+	return nil
+}
+func (p *fakeAsyncProducer) Input() chan<- *sarama.ProducerMessage     { return p.input }
+func (p *fakeAsyncProducer) Successes() <-chan *sarama.ProducerMessage { return p.successes }
+func (p *fakeAsyncProducer) Errors() <-chan *sarama.ProducerError      { return p.errors }
+
+func TestKafkaSinkOffsetBootstrap(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	producer := makeFakeAsyncProducer(2 /* numPartitions */, 0 /* latency */)
+	sink := makeKafkaSink(cluster.MakeTestingClusterSettings(), nil /* client */, producer, url.Values{
+		sinkParamTopicPrefix:          {`p_`},
+		sinkParamOffsetBootstrapTopic: {`bootstrap`},
+	})
+
+	if err := sink.EmitRows(ctx, []SinkRow{
+		{Topic: `foo`, Key: []byte(`[1]`), Value: []byte(`{"a": 1}`)},
+		{Topic: `foo`, Key: []byte(`[10]`), Value: []byte(`{"a": 10}`)},
+		{Topic: `foo`, Key: []byte(`[2]`), Value: []byte(`{"a": 2}`)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.EmitOffsetBootstrap(ctx, hlc.Timestamp{WallTime: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.EmitRows(ctx, []SinkRow{
+		{Topic: `bar`, Key: []byte(`[1]`), Value: []byte(`{"a": 1}`)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.EmitOffsetBootstrap(ctx, hlc.Timestamp{WallTime: 2, Logical: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var actual []string
+	for _, m := range producer.sent {
+		if m.Topic != `bootstrap` {
+			continue
+		}
+		if m.Partition != 0 || m.Key != nil {
+			t.Errorf(`expected bootstrap records in partition 0 without a key got %d %v`,
+				m.Partition, m.Key)
+		}
+		value, err := m.Value.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual = append(actual, string(value))
+	}
+	expected := []string{
+		`{"resolved":"1.0000000000","offsets":{"p_foo":{"0":1,"1":2}}}`,
+		`{"resolved":"2.0000000001","offsets":{"p_bar":{"1":1},"p_foo":{"0":1,"1":2}}}`,
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf(`expected %v got %v`, expected, actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf(`expected %s got %s`, expected[i], actual[i])
+		}
+	}
+}
+
+func TestKafkaConfig(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	params, err := url.ParseQuery(
+		`tls_enabled=true&tls_server_name=broker-1.example.com&dial_timeout=5s&keep_alive=1m&` +
+			`kafka_version=0.10.1.0&` +
+			`sasl_enabled=true&sasl_mechanism=PLAIN&sasl_user=key&sasl_password=secret`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := makeKafkaConfig(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.Net.TLS.Enable {
+		t.Errorf(`expected TLS to be enabled`)
+	}
+	if config.Net.TLS.Config == nil || config.Net.TLS.Config.ServerName != `broker-1.example.com` {
+		t.Errorf(`expected server name broker-1.example.com got %+v`, config.Net.TLS.Config)
+	}
+	if config.Net.DialTimeout != 5*time.Second {
+		t.Errorf(`expected dial timeout 5s got %s`, config.Net.DialTimeout)
+	}
+	if config.Net.KeepAlive != time.Minute {
+		t.Errorf(`expected keep-alive 1m got %s`, config.Net.KeepAlive)
+	}
+	if config.Version != sarama.V0_10_1_0 {
+		t.Errorf(`expected kafka version 0.10.1.0 got %v`, config.Version)
+	}
+	if sasl := config.Net.SASL; !sasl.Enable || !sasl.Handshake || sasl.User != `key` ||
+		sasl.Password != `secret` {
+		t.Errorf(`expected SASL/PLAIN as key got %+v`, sasl)
+	}
+
+	pemParam := func(name string) string {
+		pem := securitytest.MustAsset(filepath.Join(security.EmbeddedCertsDir, name))
+		return base64.StdEncoding.EncodeToString(pem)
+	}
+	config, err = makeKafkaConfig(url.Values{
+		sinkParamTLSEnabled: {`true`},
+		sinkParamCACert:     {pemParam(security.EmbeddedCACert)},
+		sinkParamClientCert: {pemParam(security.EmbeddedRootCert)},
+		sinkParamClientKey:  {pemParam(security.EmbeddedRootKey)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig := config.Net.TLS.Config; tlsConfig == nil || tlsConfig.RootCAs == nil ||
+		len(tlsConfig.Certificates) != 1 {
+		t.Errorf(`expected a CA and a client certificate got %+v`, tlsConfig)
+	}
+
+	for query, expectedErr := range map[string]string{
+		`tls_enabled=yes`:                   `param tls_enabled must be a bool`,
+		`tls_server_name=broker`:            `param tls_server_name requires tls_enabled=true`,
+		`dial_timeout=5`:                    `param dial_timeout must be a duration`,
+		`keep_alive=-1s`:                    `param keep_alive must be non-negative`,
+		`tls_enabled=false&dial_timeout=1s`: ``,
+		`sasl_enabled=1`:                    `params sasl_user and sasl_password are required`,
+		`sasl_user=key&sasl_password=s`:     `param sasl_user requires sasl_enabled=true`,
+		`sasl_handshake=maybe`:              `param sasl_handshake must be a bool`,
+		`sasl_mechanism=plain`:              `param sasl_mechanism requires sasl_enabled=true`,
+		`sasl_mechanism=SCRAM-SHA-512`:      `param sasl_mechanism=SCRAM-SHA-512 is not supported yet`,
+		`sasl_mechanism=GSSAPI`:             `param sasl_mechanism=GSSAPI is not supported yet`,
+		`sasl_mechanism=DIGEST-MD5`:         `param sasl_mechanism must be one of PLAIN`,
+		`sasl_mechanism=aws_msk_iam`:        `param sasl_mechanism=AWS_MSK_IAM is not supported yet`,
+		`sasl_mechanism=oauthbearer`:        `param sasl_mechanism=OAUTHBEARER is not supported yet`,
+		`ca_cert=AAAA`:                      `param ca_cert requires tls_enabled=true`,
+		`kafka_version=2.0.0`:               `param kafka_version is not a supported kafka version`,
+		`tls_enabled=true&ca_cert=!`:        `decoding param ca_cert`,
+		`tls_enabled=true&ca_cert=AAAA`:     `param ca_cert has no PEM certificates`,
+		`tls_enabled=true&client_key=AAAA`:  `params client_cert and client_key must be given together`,
+	} {
+		params, err := url.ParseQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := makeKafkaConfig(params); !testutils.IsError(err, expectedErr) {
+			t.Errorf(`%s: expected error '%s' got: %+v`, query, expectedErr, err)
+		}
+	}
+
+	if err := applyKafkaSinkConfig(config, `{
+		"Flush": {"Messages": 100, "Bytes": 65536, "Frequency": "10ms"},
+		"RequiredAcks": "all", "Version": "0.10.2.0", "Compression": "LZ4", "ClientID": "cdc"
+	}`); err != nil {
+		t.Fatal(err)
+	}
+	if f := config.Producer.Flush; f.Messages != 100 || f.Bytes != 65536 ||
+		f.Frequency != 10*time.Millisecond {
+		t.Errorf(`expected flush after 100 messages, 64 KiB or 10ms got %+v`, f)
+	}
+	if config.Producer.RequiredAcks != sarama.WaitForAll {
+		t.Errorf(`expected acks from all replicas got %v`, config.Producer.RequiredAcks)
+	}
+	if config.Version != sarama.V0_10_2_0 || config.Producer.Compression != sarama.CompressionLZ4 ||
+		config.ClientID != `cdc` {
+		t.Errorf(`expected version 0.10.2.0, LZ4 and client ID cdc got %v, %v and %s`,
+			config.Version, config.Producer.Compression, config.ClientID)
+	}
+	for sinkConfig, expectedErr := range map[string]string{
+		`{"Flush": {"Bytes": -1}}`:         `Flush.Messages and Flush.Bytes must be non-negative`,
+		`{"Flush": {"Frequency": "1"}}`:    `invalid kafka_sink_config Flush.Frequency`,
+		`{"Flush": {"Frequency": "-1ms"}}`: `must be positive`,
+		`{"Flush": []}`:                    `invalid kafka_sink_config`,
+		`{"RequiredAcks": "2"}`:            `invalid kafka_sink_config RequiredAcks`,
+		`{"Version": "3.0.0"}`:             `unsupported kafka version: 3.0.0`,
+		`{"Compression": "ZSTD"}`:          `invalid kafka_sink_config Compression`,
+		`{"ClientID": "a b"}`:              `invalid kafka_sink_config`,
+		`{"Partitioner": "random"}`:        `invalid kafka_sink_config Partitioner`,
+		`{"Partitioner": "column"}`:        `PartitionColumn is required by`,
+		`{"PartitionColumn": "a"}`:         `PartitionColumn is required by`,
+	} {
+		if err := applyKafkaSinkConfig(config, sinkConfig); !testutils.IsError(err, expectedErr) {
+			t.Errorf(`%s: expected error '%s' got: %+v`, sinkConfig, expectedErr, err)
+		}
+	}
+}
+
+// flakyAsyncSink is an asyncSink that fails the first emission of every row,
+// or of the rows with the keys in flaky if it's set, with a retryable error
+// and records the rows it emitted.
+type flakyAsyncSink struct {
+	flaky    map[string]bool
+	attempts map[string]int
+	emitted  []string
+}
+
+var _ asyncSink = &flakyAsyncSink{}
+
+func (s *flakyAsyncSink) EmitRowAsync(_ context.Context, row SinkRow, done func(error)) error {
+	s.attempts[string(row.Key)]++
+	if s.attempts[string(row.Key)] == 1 && (s.flaky == nil || s.flaky[string(row.Key)]) {
+		done(MarkRetryableSinkError(errors.New(`flaky`)))
+		return nil
+	}
+	s.emitted = append(s.emitted, string(row.Key))
+	done(nil)
+	return nil
+}
+
+func (s *flakyAsyncSink) Flush(context.Context) error { return nil }
+
+func (s *flakyAsyncSink) EmitRows(context.Context, []SinkRow) error {
+	return errors.New(`unimplemented`)
+}
+func (s *flakyAsyncSink) EmitResolvedTimestamp(context.Context, []byte) error {
+	return errors.New(`unimplemented`)
+}
+func (s *flakyAsyncSink) Close() error { return nil }
+
+func TestAsyncEmitterRetries(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	sink := &flakyAsyncSink{attempts: make(map[string]int)}
+	st := cluster.MakeTestingClusterSettings()
+	metrics := MakeMetrics(st, metric.TestSampleInterval).(*Metrics)
+	e := makeAsyncEmitter(sink, metrics)
+	if err := e.emit(ctx, []SinkRow{{Key: []byte(`a`)}, {Key: []byte(`b`)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(sink.emitted)
+	if expected := []string{`a`, `b`}; !reflect.DeepEqual(sink.emitted, expected) {
+		t.Errorf(`expected %v got %v`, expected, sink.emitted)
+	}
+	if emitted := metrics.EmittedMessages.Count(); emitted != 2 {
+		t.Errorf(`expected 2 emitted messages got %d`, emitted)
+	}
+	// The retried rows were pending again until they were acknowledged.
+	if pending := metrics.SinkPendingMessages.Value(); pending != 0 {
+		t.Errorf(`expected no pending messages got %d`, pending)
+	}
+	if flushes := metrics.SinkFlushLatency.TotalCount(); flushes < 2 {
+		t.Errorf(`expected at least 2 sink flushes got %d`, flushes)
+	}
+
+	// The rows after a failed one are held until it's emitted again, so the
+	// updates of a key are never emitted out of order.
+	sink = &flakyAsyncSink{flaky: map[string]bool{`a`: true}, attempts: make(map[string]int)}
+	e = makeAsyncEmitter(sink, metrics)
+	rows := []SinkRow{{Key: []byte(`a`)}, {Key: []byte(`b`)}}
+	if err := e.emit(ctx, rows); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{`a`, `b`}; !reflect.DeepEqual(sink.emitted, expected) {
+		t.Errorf(`expected %v got %v`, expected, sink.emitted)
+	}
+}
+
+func TestKafkaSinkBatchReduction(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	rows := make([]SinkRow, 20)
+	for i := range rows {
+		rows[i] = SinkRow{Topic: `t`, Key: []byte(strconv.Itoa(i)), Value: []byte(`{}`)}
+	}
+
+	st := cluster.MakeTestingClusterSettings()
+	producer := makeFakeAsyncProducer(1 /* numPartitions */, 10*time.Millisecond /* latency */)
+	producer.maxBatch = 4
+	sink := makeKafkaSink(st, nil /* client */, producer, url.Values{})
+	metrics := MakeMetrics(st, metric.TestSampleInterval).(*Metrics)
+	e := makeAsyncEmitter(sink, metrics)
+	if err := e.emit(ctx, rows); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if emitted := metrics.EmittedMessages.Count(); emitted != int64(len(rows)) {
+		t.Errorf(`expected %d emitted messages got %d`, len(rows), emitted)
+	}
+	// The rows after a rejected one may be sent again, see asyncEmitter, but
+	// every row is sent.
+	sent := make(map[string]struct{})
+	for _, m := range producer.sent {
+		key, _ := m.Key.Encode()
+		sent[string(key)] = struct{}{}
+	}
+	if len(sent) != len(rows) {
+		t.Errorf(`expected %d rows sent got %d`, len(rows), len(sent))
+	}
+
+	// Without batch reduction, the rejected messages fail the flush.
+	batchReductionRetryEnabled.Override(&st.SV, false)
+	producer = makeFakeAsyncProducer(1 /* numPartitions */, 10*time.Millisecond /* latency */)
+	producer.maxBatch = 4
+	sink = makeKafkaSink(st, nil /* client */, producer, url.Values{})
+	defer func() { _ = sink.Close() }()
+	e = makeAsyncEmitter(sink, metrics)
+	if err := e.emit(ctx, rows); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.flush(ctx); !testutils.IsError(err, `too large`) {
+		t.Errorf(`expected 'too large' error got: %+v`, err)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	var requests, down int32
+	var mu syncutil.Mutex
+	var payloads []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every other request fails as unavailable, and is retried.
+		if atomic.AddInt32(&requests, 1)%2 == 1 || atomic.LoadInt32(&down) == 1 {
+			http.Error(w, `try again`, http.StatusServiceUnavailable)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		payloads = append(payloads, string(body))
+	}))
+	defer server.Close()
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: `CERTIFICATE`, Bytes: server.Certificate().Raw})
+	sinkURI, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinkURI.Scheme = sinkSchemeWebhookHTTPS
+	sinkURI.RawQuery = url.Values{
+		sinkParamCACert: {base64.StdEncoding.EncodeToString(certPEM)},
+	}.Encode()
+
+	ctx := context.Background()
+	sink, err := makeWebhookSink(sinkURI,
+		`{"Flush": {"Messages": 3, "Bytes": 16}, "Retry": {"Max": 2, "Backoff": "1ms"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := []SinkRow{
+		{Key: []byte(`[1]`), Value: []byte(`{"a": 1}`)},
+		{Key: []byte(`[2]`), Value: []byte(`{"a": 2}`)},
+		{Key: []byte(`[3]`)},
+	}
+	if err := sink.EmitRows(ctx, rows); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.EmitResolvedTimestamp(ctx, []byte(`{"resolved":"1.0"}`)); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`{"payload":[{"a":1},{"a":2}],"length":2}`,
+		`{"payload":[[3]],"length":1}`,
+		`{"resolved":"1.0"}`,
+	}
+	mu.Lock()
+	if !reflect.DeepEqual(payloads, expected) {
+		t.Errorf(`expected %v got %v`, expected, payloads)
+	}
+	mu.Unlock()
+
+	// Once the retries run out, the error is returned.
+	atomic.StoreInt32(&down, 1)
+	if err := sink.EmitRows(ctx, rows[:1]); !testutils.IsError(err, `503 Service Unavailable`) {
+		t.Errorf(`expected '503 Service Unavailable' error got: %+v`, err)
+	}
+
+	for config, expectedErr := range map[string]string{
+		`{"Flush": {"Messages": -1}}`:    `must be non-negative`,
+		`{"Flush": {"Frequency": "1s"}}`: `Flush.Frequency is not supported by webhook sinks`,
+		`{"Retry": {"Backoff": "x"}}`:    `invalid webhook_sink_config Retry.Backoff`,
+		`{"Timeout": "-1s"}`:             `invalid webhook_sink_config Timeout: must be positive`,
+		`{"Timeout": 3}`:                 `invalid webhook_sink_config`,
+	} {
+		if _, err := parseWebhookSinkConfig(config); !testutils.IsError(err, expectedErr) {
+			t.Errorf(`%s: expected '%s' error got: %+v`, config, expectedErr, err)
+		}
+	}
+}
+
+func TestSinkConfigSchemes(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	// The scheme of an external connection is only known once it's resolved,
+	// so the options that need a certain scheme are checked by both
+	// validateChangefeed and getSink.
+	for opt, sinkConfig := range map[string]string{
+		optKafkaSinkConfig:   `{}`,
+		optWebhookSinkConfig: `{}`,
+	} {
+		opts := map[string]string{opt: sinkConfig}
+		details := jobspb.ChangefeedDetails{SinkURI: `external://conn`, Opts: opts}
+		if _, err := validateChangefeed(details); err != nil {
+			t.Errorf(`%s: expected external connections to be allowed got: %+v`, opt, err)
+		}
+		details.SinkURI = `nodelocal:///feed`
+		if _, err := validateChangefeed(details); !testutils.IsError(err, `is only supported with`) {
+			t.Errorf(`%s: expected 'only supported with' error got: %+v`, opt, err)
+		}
+		_, err := getSink(context.Background(), &sql.ExecutorConfig{}, `nodelocal:///feed`, opts,
+			nil /* encoder */, nil /* resultsCh */)
+		if !testutils.IsError(err, `is only supported with`) {
+			t.Errorf(`%s: expected 'only supported with' error got: %+v`, opt, err)
+		}
+	}
+}
+
+func TestChangefeedPartitioner(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	const numPartitions = 8
+	partition := func(p sarama.Partitioner, key, partitionKey string) int32 {
+		m := &sarama.ProducerMessage{Key: sarama.ByteEncoder(key)}
+		if partitionKey != `` {
+			m.Metadata = &kafkaMessageMetadata{partitionKey: []byte(partitionKey)}
+		}
+		partition, err := p.Partition(m, numPartitions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return partition
+	}
+
+	// Rows with the same partition key go to the same partition, whatever
+	// their key, and the same one as a row with that key.
+	hash := newChangefeedPartitioner(`t`)
+	for i := 0; i < 10; i++ {
+		expected := partition(hash, `us-east`, ``)
+		if p := partition(hash, strconv.Itoa(i), `us-east`); p != expected {
+			t.Errorf(`expected partition %d got %d`, expected, p)
+		}
+	}
+
+	// Round-robin spreads rows with the same key over every partition.
+	roundRobin := newRoundRobinChangefeedPartitioner(`t`)
+	seen := make(map[int32]struct{})
+	for i := 0; i < numPartitions; i++ {
+		seen[partition(roundRobin, `hot`, ``)] = struct{}{}
+	}
+	if len(seen) != numPartitions {
+		t.Errorf(`expected rows in %d partitions got %d`, numPartitions, len(seen))
+	}
+
+	// Messages without a key, like resolved timestamps, keep their partition.
+	for _, p := range []sarama.Partitioner{hash, roundRobin} {
+		resolved, err := p.Partition(&sarama.ProducerMessage{Partition: 3}, numPartitions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resolved != 3 {
+			t.Errorf(`expected partition 3 got %d`, resolved)
+		}
+	}
+}
+
+func TestKafkaSinkAutoCreateTopics(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	producer := makeFakeAsyncProducer(1 /* numPartitions */, 0 /* latency */)
+	sink := makeKafkaSink(st, nil /* client */, producer, url.Values{
+		sinkParamTopicPrefix: {`p_`},
+	})
+	defer func() { _ = sink.Close() }()
+	var checked []string
+	sink.topicExists = func(topic string) (bool, error) {
+		checked = append(checked, topic)
+		return topic == `p_exists`, nil
+	}
+
+	rows := []SinkRow{{Topic: `exists`, Key: []byte(`1`)}, {Topic: `exists`, Key: []byte(`2`)}}
+	if err := sink.EmitRows(ctx, rows); err != nil {
+		t.Fatal(err)
+	}
+	err := sink.EmitRows(ctx, []SinkRow{{Topic: `missing`, Key: []byte(`1`)}})
+	const expectedErr = `kafka topic p_missing does not exist and auto_create_topics=false`
+	if !testutils.IsError(err, expectedErr) {
+		t.Errorf(`expected '%s' error got: %+v`, expectedErr, err)
+	}
+	// Each topic is only checked the first time it's written to.
+	if expected := []string{`p_exists`, `p_missing`}; !reflect.DeepEqual(checked, expected) {
+		t.Errorf(`expected %v checked got %v`, expected, checked)
+	}
+	if len(producer.sent) != len(rows) {
+		t.Errorf(`expected %d messages sent got %d`, len(rows), len(producer.sent))
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	gojson "encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/httputil"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/pkg/errors"
+)
+
+const sinkSchemeWebhookHTTPS = `webhook-https`
+
+// webhookSinkConfig is the `webhook_sink_config` option, like
+// `{"Flush": {"Messages": 100}, "Retry": {"Max": 5, "Backoff": "1s"}, "Timeout": "10s"}`.
+// Downstream HTTP endpoints vary hugely in how much they can take at once and
+// how long they take to answer, so every field can be tuned and the ones that
+// aren't set keep their defaults. The rows emitted together are sent right
+// away, so Flush.Frequency isn't supported.
+type webhookSinkConfig struct {
+	Flush sinkFlushConfig
+	Retry struct {
+		// Max is how many times a failed request is retried.
+		Max int
+		// Backoff is how long to wait before the first retry. The wait
+		// doubles with every retry after it.
+		Backoff string
+	}
+	// Timeout is how long to wait for the answer to a request.
+	Timeout string
+}
+
+// webhookSinkOpts is a parsed webhookSinkConfig.
+type webhookSinkOpts struct {
+	batchSize int
+	// batchBytes, if non-zero, is the largest size of the rows sent in one
+	// request. A row bigger than that is sent on its own.
+	batchBytes int
+	retryOpts  retry.Options
+	timeout    time.Duration
+}
+
+// parseWebhookSinkConfig returns the options of a webhook sink with the given
+// `webhook_sink_config`, which may be empty.
+func parseWebhookSinkConfig(config string) (webhookSinkOpts, error) {
+	opts := webhookSinkOpts{
+		batchSize: 1,
+		retryOpts: retry.Options{
+			InitialBackoff: 500 * time.Millisecond,
+			MaxBackoff:     30 * time.Second,
+			Multiplier:     2,
+			MaxRetries:     3,
+		},
+		timeout: 3 * time.Second,
+	}
+	if config == `` {
+		return opts, nil
+	}
+	var c webhookSinkConfig
+	if err := gojson.Unmarshal([]byte(config), &c); err != nil {
+		return webhookSinkOpts{}, errors.Wrapf(err, `invalid %s`, optWebhookSinkConfig)
+	}
+	frequency, err := c.Flush.frequency(optWebhookSinkConfig)
+	if err != nil {
+		return webhookSinkOpts{}, err
+	}
+	if frequency != 0 {
+		return webhookSinkOpts{}, errors.Errorf(
+			`invalid %s: Flush.Frequency is not supported by webhook sinks`, optWebhookSinkConfig)
+	}
+	if c.Retry.Max < 0 {
+		return webhookSinkOpts{}, errors.Errorf(
+			`invalid %s: Retry.Max must be non-negative`, optWebhookSinkConfig)
+	}
+	if c.Flush.Messages > 0 {
+		opts.batchSize = c.Flush.Messages
+	}
+	opts.batchBytes = c.Flush.Bytes
+	if c.Retry.Max > 0 {
+		opts.retryOpts.MaxRetries = c.Retry.Max
+	}
+	for _, d := range []struct {
+		field string
+		value string
+		dst   *time.Duration
+	}{
+		{`Retry.Backoff`, c.Retry.Backoff, &opts.retryOpts.InitialBackoff},
+		{`Timeout`, c.Timeout, &opts.timeout},
+	} {
+		if d.value == `` {
+			continue
+		}
+		var err error
+		if *d.dst, err = time.ParseDuration(d.value); err != nil {
+			return webhookSinkOpts{}, errors.Wrapf(err, `invalid %s %s`, optWebhookSinkConfig, d.field)
+		}
+		if *d.dst <= 0 {
+			return webhookSinkOpts{}, errors.Errorf(
+				`invalid %s %s: must be positive: %s`, optWebhookSinkConfig, d.field, d.value)
+		}
+	}
+	if opts.retryOpts.MaxBackoff < opts.retryOpts.InitialBackoff {
+		opts.retryOpts.MaxBackoff = opts.retryOpts.InitialBackoff
+	}
+	return opts, nil
+}
+
+// webhookSink emits to an HTTP endpoint at a `webhook-https://` URI, which is
+// the https URI of the endpoint. Rows are POSTed as a json object with the
+// values of up to Flush.Messages rows, of at most Flush.Bytes together, under
+// `payload`, and their number under `length`. The key of a row is sent
+// instead of its value if the value is empty, as with cloud storage sinks.
+// Resolved timestamps are POSTed as their payload. Only the json format is
+// supported. The `ca_cert` parameter is a base64-encoded PEM certificate to
+// verify the endpoint with, instead of the system's root CAs.
+//
+// A request that fails to connect, times out, or gets a 5xx or 429 answer is
+// retried with backoff, up to Retry.Max times, after which the changefeed
+// fails. Any other answer that isn't a 2xx fails it right away.
+type webhookSink struct {
+	url    url.URL
+	client *http.Client
+	opts   webhookSinkOpts
+}
+
+var _ Sink = &webhookSink{}
+
+func makeWebhookSink(sinkURI *url.URL, config string) (*webhookSink, error) {
+	opts, err := parseWebhookSinkConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	u := *sinkURI
+	u.Scheme = `https`
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	params := u.Query()
+	if caCert := params.Get(sinkParamCACert); caCert != `` {
+		pemBytes, err := base64.StdEncoding.DecodeString(caCert)
+		if err != nil {
+			return nil, errors.Wrapf(err, `decoding parameter %s`, sinkParamCACert)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pemBytes) {
+			return nil, errors.Errorf(`parameter %s has no PEM certificates`, sinkParamCACert)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+	params.Del(sinkParamCACert)
+	u.RawQuery = params.Encode()
+	return &webhookSink{
+		url:    u,
+		client: &http.Client{Transport: transport, Timeout: opts.timeout},
+		opts:   opts,
+	}, nil
+}
+
+// webhookPayload is the body of the requests that rows are sent in.
+type webhookPayload struct {
+	Payload []gojson.RawMessage `json:"payload"`
+	Length  int                 `json:"length"`
+}
+
+// EmitRows implements the Sink interface.
+func (s *webhookSink) EmitRows(ctx context.Context, rows []SinkRow) error {
+	for len(rows) > 0 {
+		var payload webhookPayload
+		var size int
+		for _, row := range rows {
+			value := row.Value
+			if len(value) == 0 {
+				value = row.Key
+			}
+			if payload.Length == s.opts.batchSize ||
+				(payload.Length > 0 && s.opts.batchBytes > 0 && size+len(value) > s.opts.batchBytes) {
+				break
+			}
+			payload.Payload = append(payload.Payload, gojson.RawMessage(value))
+			payload.Length++
+			size += len(value)
+		}
+		rows = rows[payload.Length:]
+		body, err := gojson.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		if err := s.post(ctx, body); err != nil {
+			return errors.Wrapf(err, `sending %d rows to webhook`, payload.Length)
+		}
+	}
+	return nil
+}
+
+// EmitResolvedTimestamp implements the Sink interface.
+func (s *webhookSink) EmitResolvedTimestamp(ctx context.Context, payload []byte) error {
+	return errors.Wrap(s.post(ctx, payload), `sending resolved timestamp to webhook`)
+}
+
+// post sends a request with the given json body to the endpoint, retrying it
+// as configured.
+func (s *webhookSink) post(ctx context.Context, body []byte) error {
+	var err error
+	for r := retry.StartWithCtx(ctx, s.opts.retryOpts); r.Next(); {
+		var retryable bool
+		if retryable, err = s.postOnce(ctx, body); err == nil || !retryable {
+			return err
+		}
+		log.VEventf(ctx, 1, `retrying webhook request: %s`, err)
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// postOnce sends a request with the given json body to the endpoint, and
+// returns whether it may be retried if it failed.
+func (s *webhookSink) postOnce(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.url.String(), bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set(httputil.ContentTypeHeader, httputil.JSONContentType)
+	res, err := s.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+	resBody, _ := ioutil.ReadAll(res.Body)
+	err = errors.Errorf(`webhook returned %s: %s`, res.Status, resBody)
+	return res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests, err
+}
+
+// Close implements the Sink interface.
+func (s *webhookSink) Close() error {
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+// A changefeed restarts from its high-water mark. The initial scan of a huge
+// table, or a poll that has hours of changes to catch up on, like the rows
+// rewritten by the backfill of a schema change, can take long enough that a
+// restart in the middle of it is likely, and starting it over every time means
+// it may never finish. So a changefeed with a job also persists, every
+// changefeed.span_checkpoint_interval, the spans it has emitted past its
+// high-water mark and up to which timestamp. Once restarted, it picks up each
+// of those spans from there instead.
+//
+// The checkpoint is kept in the progress of the changefeed's job, next to its
+// high-water mark. Entries at or below the high-water mark are stale and
+// ignored. ALTER CHANGEFEED also writes one, to give the tables it adds an
+// initial scan without scanning the others again.
+//
+// TODO: Changefeeds with the `coalesce_interval` option hold back rows
+// that have been read, so they don't checkpoint spans.
+
+var spanCheckpointInterval = settings.RegisterNonNegativeDurationSetting(
+	"changefeed.span_checkpoint_interval",
+	"how often a changefeed persists the spans it has emitted past its high-water mark, "+
+		"or 0 to disable",
+	30*time.Second,
+)
+
+// makeSpanCheckpoint returns the entries of a checkpoint as they're persisted
+// in the progress of a changefeed's job.
+func makeSpanCheckpoint(spans []timestampedSpan) []jobspb.ChangefeedProgress_CheckpointedSpan {
+	checkpoint := make([]jobspb.ChangefeedProgress_CheckpointedSpan, len(spans))
+	for i, s := range spans {
+		checkpoint[i] = jobspb.ChangefeedProgress_CheckpointedSpan{Span: s.span, Timestamp: s.ts}
+	}
+	return checkpoint
+}
+
+// restoreSpanCheckpoint splits the watched spans of a changefeed into the ones
+// that a checkpoint says were emitted past highwater, with the timestamp they
+// were emitted up to, sorted by it, and the rest. Entries of tables that are
+// no longer watched are ignored.
+func restoreSpanCheckpoint(
+	spans []roachpb.Span,
+	checkpoint []jobspb.ChangefeedProgress_CheckpointedSpan,
+	highwater hlc.Timestamp,
+) (done []timestampedSpan, rest []roachpb.Span) {
+	var watched, remaining roachpb.SpanGroup
+	watched.Add(spans...)
+	remaining.Add(spans...)
+	for _, s := range checkpoint {
+		if !highwater.Less(s.Timestamp) || !watched.Contains(s.Span.Key) {
+			continue
+		}
+		done = append(done, timestampedSpan{span: s.Span, ts: s.Timestamp})
+		remaining.Sub(s.Span)
+	}
+	if done == nil {
+		return nil, spans
+	}
+	sort.Slice(done, func(i, j int) bool { return done[i].ts.Less(done[j].ts) })
+	return done, remaining.Slice()
+}
+
+// spanCheckpointer collects the spans a changefeed emits past its high-water
+// mark and decides when they're persisted.
+type spanCheckpointer struct {
+	interval  time.Duration
+	spans     []timestampedSpan
+	lastSaved time.Time
+}
+
+func makeSpanCheckpointer(interval time.Duration) *spanCheckpointer {
+	return &spanCheckpointer{interval: interval, lastSaved: timeutil.Now()}
+}
+
+// add records that a span has been read up to a timestamp. It must only be
+// persisted once the rows read from it have been emitted.
+func (c *spanCheckpointer) add(s timestampedSpan) {
+	// The chunks of an initial scan are read one after the other, at the same
+	// timestamp, so they're merged to keep the checkpoint small.
+	if n := len(c.spans); n > 0 && c.spans[n-1].ts == s.ts &&
+		c.spans[n-1].span.EndKey.Equal(s.span.Key) {
+		c.spans[n-1].span.EndKey = s.span.EndKey
+		return
+	}
+	c.spans = append(c.spans, s)
+}
+
+// resolved forgets every span, once the high-water mark passes them.
+func (c *spanCheckpointer) resolved() {
+	c.spans = c.spans[:0]
+}
+
+// due returns the spans to persist, if it's time to, or nil.
+func (c *spanCheckpointer) due() []timestampedSpan {
+	if c.interval <= 0 || len(c.spans) == 0 || timeutil.Since(c.lastSaved) < c.interval {
+		return nil
+	}
+	return c.spans
+}
+
+// saved records that the spans returned by due were persisted.
+func (c *spanCheckpointer) saved() {
+	c.lastSaved = timeutil.Now()
+}
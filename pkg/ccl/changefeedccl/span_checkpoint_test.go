@@ -0,0 +1,68 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+)
+
+func TestSpanCheckpoint(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sp := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+	ts := func(wallTime int64) hlc.Timestamp { return hlc.Timestamp{WallTime: wallTime} }
+
+	c := makeSpanCheckpointer(time.Nanosecond)
+	if spans := c.due(); spans != nil {
+		t.Fatalf(`expected nothing to checkpoint got %v`, spans)
+	}
+	// Adjacent spans read at the same timestamp are merged.
+	c.add(timestampedSpan{span: sp(`a`, `b`), ts: ts(2)})
+	c.add(timestampedSpan{span: sp(`b`, `c`), ts: ts(2)})
+	c.add(timestampedSpan{span: sp(`x`, `y`), ts: ts(3)})
+	time.Sleep(time.Millisecond)
+	spans := c.due()
+	expected := []timestampedSpan{
+		{span: sp(`a`, `c`), ts: ts(2)},
+		{span: sp(`x`, `y`), ts: ts(3)},
+	}
+	if !reflect.DeepEqual(spans, expected) {
+		t.Fatalf(`expected %v got %v`, expected, spans)
+	}
+
+	checkpoint := makeSpanCheckpoint(spans)
+
+	// Entries at or below the highwater, or of tables that aren't watched
+	// anymore, are ignored.
+	watched := []roachpb.Span{sp(`a`, `m`)}
+	done, rest := restoreSpanCheckpoint(watched, checkpoint, ts(1))
+	if expected := expected[:1]; !reflect.DeepEqual(done, expected) {
+		t.Errorf(`expected %v got %v`, expected, done)
+	}
+	if expected := []roachpb.Span{sp(`c`, `m`)}; !reflect.DeepEqual(rest, expected) {
+		t.Errorf(`expected %v got %v`, expected, rest)
+	}
+	done, rest = restoreSpanCheckpoint(watched, checkpoint, ts(2))
+	if done != nil || !reflect.DeepEqual(rest, watched) {
+		t.Errorf(`expected no checkpointed spans got %v and %v`, done, rest)
+	}
+
+	c.resolved()
+	if spans := c.due(); spans != nil {
+		t.Fatalf(`expected nothing to checkpoint got %v`, spans)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/cockroachdb/cockroach/pkg/internal/client"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
+	"github.com/pkg/errors"
+)
+
+// topicNamer names the topic of each watched table. By default, it's the
+// table's name. With the `full_table_name` option, it's the fully-qualified
+// `db.public.table` name instead, so that the feeds of tables with the same
+// name in different databases (or clusters) can share a Kafka cluster without
+// their topics colliding. The `topic_prefix` sink parameter is applied by the
+// sink, on top of this.
+//
+// With the `topic_name` sink parameter, every table goes to the one topic
+// instead, for consumers that want a single multiplexed stream. The table name
+// is then carried in the key of every message, see jsonEncoder.
+type topicNamer struct {
+	fullTableName bool
+	// dbNames is the name of the database of every watched table, by the
+	// database's ID. It's only set with fullTableName.
+	dbNames map[sqlbase.ID]string
+	// singleTopic is the value of the `topic_name` sink parameter.
+	singleTopic string
+}
+
+// makeTopicNamer returns a topicNamer for the given (validated) details. The
+// database names are looked up when the feed starts, so renaming a database
+// doesn't change the topics of a running feed.
+func makeTopicNamer(
+	ctx context.Context, execCfg *sql.ExecutorConfig, details jobspb.ChangefeedDetails,
+) (*topicNamer, error) {
+	n := &topicNamer{}
+	// The sink URI of a job may be an external connection, so this has to be
+	// checked after it's resolved, not in validateChangefeed.
+	var err error
+	if n.singleTopic, err = singleTopicName(details.SinkURI); err != nil {
+		return nil, err
+	}
+	if format := formatType(details.Opts[optFormat]); n.singleTopic != `` && format != optFormatJSON {
+		return nil, errors.Errorf(`sink parameter %s is only supported with %s=%s`,
+			sinkParamTopicName, optFormat, optFormatJSON)
+	}
+
+	if _, n.fullTableName = details.Opts[optFullTableName]; !n.fullTableName {
+		return n, nil
+	}
+	if err := execCfg.DB.Txn(ctx, func(ctx context.Context, txn *client.Txn) error {
+		n.dbNames = make(map[sqlbase.ID]string)
+		for _, tableDesc := range details.TableDescs {
+			if _, ok := n.dbNames[tableDesc.ParentID]; ok {
+				continue
+			}
+			dbDesc, err := sqlbase.GetDatabaseDescFromID(ctx, txn, tableDesc.ParentID)
+			if err != nil {
+				return err
+			}
+			n.dbNames[tableDesc.ParentID] = dbDesc.Name
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// singleTopicName returns the value of the `topic_name` parameter of a sink
+// URI, if any.
+func singleTopicName(sinkURI string) (string, error) {
+	if sinkURI == `` {
+		return ``, nil
+	}
+	u, err := url.Parse(sinkURI)
+	if err != nil {
+		return ``, err
+	}
+	return u.Query().Get(sinkParamTopicName), nil
+}
+
+// tableName returns the name of the given table as it's used for its topic,
+// and in single topic mode, its keys.
+func (n *topicNamer) tableName(tableDesc *sqlbase.TableDescriptor) string {
+	if !n.fullTableName {
+		return tableDesc.Name
+	}
+	return n.dbNames[tableDesc.ParentID] + `.` + tree.PublicSchema + `.` + tableDesc.Name
+}
+
+// topic returns the name of the topic the rows of the given table go to.
+func (n *topicNamer) topic(tableDesc *sqlbase.TableDescriptor) string {
+	if n.singleTopic != `` {
+		return n.singleTopic
+	}
+	return n.tableName(tableDesc)
+}
@@ -0,0 +1,128 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path"
+
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/pkg/errors"
+)
+
+// sinkSchemeUserFile writes changefeed output into a table in the cluster, for
+// users without any external storage. The host of the URI is the table, which
+// defaults to one per user in defaultdb, and the path is a prefix for the file
+// names.
+//
+// The files are written as the user that created the changefeed, which is
+// recorded in the URI when the changefeed is created.
+const sinkSchemeUserFile = `userfile`
+
+// userFileTable returns the default userfile table for the given user.
+func userFileTable(user string) string {
+	tableName := tree.MakeTableName(`defaultdb`, tree.Name(`userfiles_`+user))
+	return tree.AsString(&tableName)
+}
+
+// normalizeUserFileURI fills in the default table for a userfile URI and
+// records the given user as the one to write files as. Any user already in the
+// URI is overwritten, so it can't be used to write as someone else.
+func normalizeUserFileURI(user string, sinkURI string) (string, error) {
+	parsed, err := url.Parse(sinkURI)
+	if err != nil {
+		return ``, err
+	}
+	if parsed.Scheme != sinkSchemeUserFile {
+		return sinkURI, nil
+	}
+	if parsed.Host == `` {
+		parsed.Host = userFileTable(user)
+	}
+	if _, err := parser.ParseTableName(parsed.Host); err != nil {
+		return ``, errors.Wrapf(err, `invalid userfile table: %s`, parsed.Host)
+	}
+	parsed.User = url.User(user)
+	return parsed.String(), nil
+}
+
+// userFileStorage is a fileStorage that keeps files in a table.
+//
+// TODO: Each file is a single row, so files must fit in a single raft
+// command. Split them into chunks if this becomes a problem.
+type userFileStorage struct {
+	ie     *sql.InternalExecutor
+	user   string
+	table  string
+	prefix string
+}
+
+var _ fileStorage = &userFileStorage{}
+
+func makeUserFileStorage(
+	ctx context.Context, ie *sql.InternalExecutor, sinkURI *url.URL,
+) (*userFileStorage, error) {
+	if sinkURI.User == nil || sinkURI.User.Username() == `` {
+		return nil, errors.Errorf(`userfile sink is missing a user: %s`, sinkURI)
+	}
+	tableName, err := parser.ParseTableName(sinkURI.Host)
+	if err != nil {
+		return nil, errors.Wrapf(err, `invalid userfile table: %s`, sinkURI.Host)
+	}
+	s := &userFileStorage{
+		ie:     ie,
+		user:   sinkURI.User.Username(),
+		table:  tree.AsString(tableName),
+		prefix: sinkURI.Path,
+	}
+	if _, err := s.exec(ctx, `create-userfile-table`,
+		`CREATE TABLE IF NOT EXISTS `+s.table+` (
+			filename STRING PRIMARY KEY,
+			content BYTES NOT NULL,
+			written TIMESTAMP NOT NULL DEFAULT now()
+		)`,
+	); err != nil {
+		return nil, errors.Wrapf(err, `creating userfile table %s`, s.table)
+	}
+	return s, nil
+}
+
+func (s *userFileStorage) exec(
+	ctx context.Context, opName string, stmt string, qargs ...interface{},
+) (int, error) {
+	return s.ie.ExecWithSessionArgs(
+		ctx, opName, nil /* txn */, sql.SessionArgs{User: s.user}, stmt, qargs...)
+}
+
+// WriteFile implements the fileStorage interface.
+func (s *userFileStorage) WriteFile(
+	ctx context.Context, basename string, content io.ReadSeeker,
+) error {
+	buf, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	filename := path.Join(s.prefix, basename)
+	if _, err := s.exec(ctx, `write-userfile`,
+		`UPSERT INTO `+s.table+` (filename, content) VALUES ($1, $2)`, filename, buf,
+	); err != nil {
+		return errors.Wrapf(err, `writing userfile %s`, filename)
+	}
+	return nil
+}
+
+// Close implements the fileStorage interface.
+func (s *userFileStorage) Close() error {
+	return nil
+}
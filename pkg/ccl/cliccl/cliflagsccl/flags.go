@@ -47,3 +47,28 @@ example:
 `,
 	}
 )
+
+// Flags of `cockroach debug cdc-consume`.
+var (
+	CDCTopic = cliflags.FlagInfo{
+		Name:        "topic",
+		Description: `Topic to consume, as named by the changefeed. Can be repeated.`,
+	}
+
+	CDCFromBeginning = cliflags.FlagInfo{
+		Name:        "from-beginning",
+		Description: `Consume the topics from their oldest messages instead of only new ones.`,
+	}
+
+	CDCSchemaRegistry = cliflags.FlagInfo{
+		Name: "schema-registry",
+		Description: `
+The confluent_schema_registry URI of the changefeed, to decode messages in
+format=experimental_avro.`,
+	}
+
+	CDCMaxMessages = cliflags.FlagInfo{
+		Name:        "max-messages",
+		Description: `Stop after this many messages. 0 means no limit.`,
+	}
+)
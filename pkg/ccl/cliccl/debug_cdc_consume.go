@@ -0,0 +1,67 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package cliccl
+
+import (
+	"context"
+	"os"
+	"os/signal"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl"
+	"github.com/cockroachdb/cockroach/pkg/ccl/cliccl/cliflagsccl"
+	"github.com/cockroachdb/cockroach/pkg/cli"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var cdcConsumeOpts changefeedccl.ConsumeOptions
+
+func init() {
+	debugCDCConsumeCmd := &cobra.Command{
+		Use:   "cdc-consume <sink-uri> --topic <topic>",
+		Short: "print the messages of a changefeed",
+		Long: `
+Consumes the messages that a changefeed emits to a kafka:// sink and prints
+them, one per line, to check that the changefeed works end-to-end. The sink
+URI takes the same parameters as the changefeed's. Messages in
+format=experimental_avro are decoded with the --schema-registry.
+`,
+		Args: cobra.ExactArgs(1),
+		RunE: runDebugCDCConsume,
+	}
+	f := debugCDCConsumeCmd.Flags()
+	f.StringSliceVar(&cdcConsumeOpts.Topics, cliflagsccl.CDCTopic.Name, nil,
+		cliflagsccl.CDCTopic.Usage())
+	cli.BoolFlag(f, &cdcConsumeOpts.FromBeginning, cliflagsccl.CDCFromBeginning, false)
+	cli.StringFlag(f, &cdcConsumeOpts.SchemaRegistry, cliflagsccl.CDCSchemaRegistry, "")
+	cli.IntFlag(f, &cdcConsumeOpts.MaxMessages, cliflagsccl.CDCMaxMessages, 0)
+	cli.AddDebugCmd(debugCDCConsumeCmd)
+}
+
+func runDebugCDCConsume(cmd *cobra.Command, args []string) error {
+	if len(cdcConsumeOpts.Topics) == 0 {
+		return errors.New("at least one --topic is required")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Stop consuming cleanly on ^C.
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, os.Interrupt)
+	defer signal.Stop(signalCh)
+	go func() {
+		select {
+		case <-signalCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return changefeedccl.ConsumeKafka(ctx, args[0], cdcConsumeOpts, os.Stdout)
+}
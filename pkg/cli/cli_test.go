@@ -2308,11 +2308,13 @@ writing ` + os.DevNull + `
   debug/nodes/1/ranges/20
   debug/nodes/1/ranges/21
   debug/nodes/1/ranges/22
+  debug/nodes/1/ranges/23
   debug/schema/defaultdb@details
   debug/schema/postgres@details
   debug/schema/system@details
   debug/schema/system/descriptor
   debug/schema/system/eventlog
+  debug/schema/system/external_connections
   debug/schema/system/jobs
   debug/schema/system/lease
   debug/schema/system/locations
@@ -669,6 +669,13 @@ If specified, print the system config contents. Beware that the output will be
 long and not particularly human-readable.`,
 	}
 
+	JobTraceDuration = FlagInfo{
+		Name: "duration",
+		Description: `
+How long to trace the job for. The trace is held in memory by the node running
+the job until then, and can't be longer than 5 minutes.`,
+	}
+
 	Decommission = FlagInfo{
 		Name: "decommission",
 		Description: `
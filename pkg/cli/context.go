@@ -95,6 +95,7 @@ func initCLIDefaults() {
 	debugCtx.printSystemConfig = false
 	debugCtx.maxResults = 1000
 	debugCtx.ballastSize = base.SizeSpec{}
+	debugCtx.jobTraceDuration = 10 * time.Second
 
 	zoneCtx.zoneConfig = ""
 	zoneCtx.zoneDisableReplication = false
@@ -203,6 +204,7 @@ var debugCtx struct {
 	ballastSize       base.SizeSpec
 	printSystemConfig bool
 	maxResults        int64
+	jobTraceDuration  time.Duration
 }
 
 // zoneCtx captures the command-line parameters of the `zone` command.
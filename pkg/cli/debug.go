@@ -1155,6 +1155,12 @@ func init() {
 		"only write to the WAL, not to sstables")
 }
 
+// AddDebugCmd adds a command to `cockroach debug`, for the debug commands
+// defined outside of this package.
+func AddDebugCmd(c *cobra.Command) {
+	debugCmd.AddCommand(c)
+}
+
 // DebugCmdsForRocksDB lists debug commands that access rocksdb.
 var DebugCmdsForRocksDB = []*cobra.Command{
 	debugCheckStoreCmd,
@@ -1176,6 +1182,7 @@ var debugCmds = append(DebugCmdsForRocksDB,
 	debugSyncTestCmd,
 	debugEnvCmd,
 	debugZipCmd,
+	debugJobTraceCmd,
 )
 
 var debugCmd = &cobra.Command{
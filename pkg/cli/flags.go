@@ -270,6 +270,7 @@ func init() {
 
 	clientCmds := []*cobra.Command{
 		debugGossipValuesCmd,
+		debugJobTraceCmd,
 		debugZipCmd,
 		dumpCmd,
 		genHAProxyCmd,
@@ -335,7 +336,7 @@ func init() {
 	StringFlag(dumpCmd.Flags(), &dumpCtx.asOf, cliflags.DumpTime, dumpCtx.asOf)
 
 	// Commands that establish a SQL connection.
-	sqlCmds := []*cobra.Command{sqlShellCmd, dumpCmd, demoCmd}
+	sqlCmds := []*cobra.Command{sqlShellCmd, dumpCmd, demoCmd, debugJobTraceCmd}
 	sqlCmds = append(sqlCmds, zoneCmds...)
 	sqlCmds = append(sqlCmds, userCmds...)
 	for _, cmd := range sqlCmds {
@@ -397,6 +398,10 @@ func init() {
 		f := debugBallastCmd.Flags()
 		VarFlag(f, &debugCtx.ballastSize, cliflags.Size)
 	}
+	{
+		f := debugJobTraceCmd.Flags()
+		DurationFlag(f, &debugCtx.jobTraceDuration, cliflags.JobTraceDuration, debugCtx.jobTraceDuration)
+	}
 }
 
 func extraServerFlagInit() {
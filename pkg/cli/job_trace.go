@@ -0,0 +1,133 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package cli
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var debugJobTraceCmd = &cobra.Command{
+	Use:   "job-trace <job id> <file>",
+	Short: "gather the trace and state of a running job into a zip file",
+	Long: `
+
+Gather what a running job, like a changefeed that's stuck, is doing into a zip
+file, without restarting it. The job is traced for --duration, and the zip has
+the trace, which includes its KV requests, the state the job reports, which
+for a changefeed is its frontier and the status of its sink, along with the
+job's row in crdb_internal.jobs and, for a changefeed, crdb_internal.changefeeds
+and the changefeed metrics of the node.
+
+The job can only be traced by the node that's running it, so the command must
+connect to that node. The error returned otherwise says which node it's on.
+`,
+	Args: cobra.ExactArgs(2),
+	RunE: MaybeDecorateGRPCError(runDebugJobTrace),
+}
+
+// jobTraceResult is the part of the result of crdb_internal.job_trace that's
+// written to separate files.
+type jobTraceResult struct {
+	Trace string          `json:"trace"`
+	State json.RawMessage `json:"state"`
+}
+
+func runDebugJobTrace(cmd *cobra.Command, args []string) error {
+	jobID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return errors.Wrapf(err, "invalid job id %q", args[0])
+	}
+	base := fmt.Sprintf("debug/job-%d", jobID)
+
+	conn, err := getPasswordAndMakeSQLClient("cockroach debug job-trace")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Tracing the job is what's most likely to fail, so it's done before the
+	// zip is created.
+	fmt.Printf("tracing job %d for %s\n", jobID, debugCtx.jobTraceDuration)
+	vals, err := conn.QueryRow(
+		"SELECT crdb_internal.job_trace($1, $2::INTERVAL)",
+		[]driver.Value{jobID, debugCtx.jobTraceDuration.String()},
+	)
+	if err != nil {
+		return err
+	}
+	var raw []byte
+	switch t := vals[0].(type) {
+	case []byte:
+		raw = t
+	case string:
+		raw = []byte(t)
+	default:
+		return errors.Errorf("unexpected job trace of type %T", vals[0])
+	}
+	var res jobTraceResult
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return errors.Wrap(err, "decoding job trace")
+	}
+
+	name := args[1]
+	out, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("writing %s\n", name)
+
+	z := newZipper(out)
+	defer z.close()
+
+	if err := z.createRaw(base+"/trace.txt", []byte(res.Trace)); err != nil {
+		return err
+	}
+	var state bytes.Buffer
+	if err := json.Indent(&state, res.State, "", "  "); err != nil {
+		return err
+	}
+	if err := z.createRaw(base+"/state.json", state.Bytes()); err != nil {
+		return err
+	}
+
+	for _, table := range []struct {
+		query string
+		name  string
+	}{
+		{fmt.Sprintf("SELECT * FROM crdb_internal.jobs WHERE id = %d;", jobID), base + "/job"},
+		{
+			fmt.Sprintf("SELECT * FROM crdb_internal.changefeeds WHERE job_id = %d;", jobID),
+			base + "/changefeed",
+		},
+		{
+			"SELECT * FROM crdb_internal.node_metrics WHERE name LIKE 'changefeed.%';",
+			base + "/metrics",
+		},
+	} {
+		if err := dumpTableDataForZip(z, conn, table.query, table.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
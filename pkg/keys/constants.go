@@ -233,6 +233,10 @@ var (
 	// StoreIDGenerator is the global store ID generator sequence.
 	StoreIDGenerator = roachpb.Key(makeKey(SystemPrefix, roachpb.RKey("store-idgen")))
 
+	// ProtectedTimestampPrefix is the key prefix of the records that protect
+	// spans of data from garbage collection, see package protectedts.
+	ProtectedTimestampPrefix = roachpb.Key(makeKey(SystemPrefix, roachpb.RKey("protectedts-")))
+
 	// StatusPrefix specifies the key prefix to store all status details.
 	StatusPrefix = roachpb.Key(makeKey(SystemPrefix, roachpb.RKey("status-")))
 	// StatusNodePrefix stores all status info for nodes.
@@ -311,17 +315,18 @@ const (
 	// to "Ranges" instead of a Table - these IDs are needed to store custom
 	// configuration for non-table ranges (e.g. Zone Configs).
 	// NOTE: IDs must be <= MaxReservedDescID.
-	LeaseTableID           = 11
-	EventLogTableID        = 12
-	RangeEventTableID      = 13
-	UITableID              = 14
-	JobsTableID            = 15
-	MetaRangesID           = 16
-	SystemRangesID         = 17
-	TimeseriesRangesID     = 18
-	WebSessionsTableID     = 19
-	TableStatisticsTableID = 20
-	LocationsTableID       = 21
-	LivenessRangesID       = 22
-	RoleMembersTableID     = 23
+	LeaseTableID               = 11
+	EventLogTableID            = 12
+	RangeEventTableID          = 13
+	UITableID                  = 14
+	JobsTableID                = 15
+	MetaRangesID               = 16
+	SystemRangesID             = 17
+	TimeseriesRangesID         = 18
+	WebSessionsTableID         = 19
+	TableStatisticsTableID     = 20
+	LocationsTableID           = 21
+	LivenessRangesID           = 22
+	RoleMembersTableID         = 23
+	ExternalConnectionsTableID = 24
 )
@@ -447,13 +447,16 @@ func NewServer(cfg Config, stopper *stop.Stopper) (*Server, error) {
 		s.db,
 		internalExecutor,
 		&s.nodeIDContainer,
+		s.cfg.Locality,
 		st,
+		s.cfg.HistogramWindowInterval(),
 		func(opName, user string) (interface{}, func()) {
 			// This is a hack to get around a Go package dependency cycle. See comment
 			// in sql/jobs/registry.go on planHookMaker.
 			return sql.NewInternalPlanner(opName, nil, user, &sql.MemoryMetrics{}, &execCfg)
 		},
 	)
+	s.registry.AddMetricStruct(s.jobRegistry.MetricsStruct())
 
 	distSQLMetrics := distsqlrun.MakeDistSQLMetrics(cfg.HistogramWindowInterval())
 	s.registry.AddMetricStruct(distSQLMetrics)
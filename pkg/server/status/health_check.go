@@ -54,6 +54,12 @@ var trackedMetrics = map[string]threshold{
 	"requests.slow.raft":          gaugeZero,
 	"sys.goroutines":              {gauge: true, min: 5000},
 
+	// Changefeeds overloading the node. These are only nonzero while the node's
+	// changefeeds emit more than the changefeed.node_overload_threshold setting,
+	// so that the alerts attribute the load to changefeeds.
+	"changefeed.overload.bytes_per_second": gaugeZero,
+	"changefeed.overload.feeds":            gaugeZero,
+
 	// Latencies (which are really histograms, but we get to see a fixed number
 	// of percentiles as gauges)
 	"raft.process.logcommit.latency-90": {gauge: true, min: int64(500 * time.Millisecond)},
@@ -0,0 +1,93 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package server
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/server/serverpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// Changefeeds returns the running changefeeds of the cluster, for
+// orchestration tooling that can't speak SQL.
+func (s *statusServer) Changefeeds(
+	ctx context.Context, req *serverpb.ChangefeedsRequest,
+) (*serverpb.ChangefeedsResponse, error) {
+	ctx = propagateGatewayMetadata(ctx)
+	ctx = s.AnnotateCtx(ctx)
+	resp, err := s.changefeeds(ctx)
+	if err != nil {
+		log.Error(ctx, err)
+		return nil, grpcstatus.Errorf(codes.Internal, err.Error())
+	}
+	return resp, nil
+}
+
+// changefeeds returns the running changefeeds of the cluster, by job ID.
+func (s *statusServer) changefeeds(ctx context.Context) (*serverpb.ChangefeedsResponse, error) {
+	const stmt = `SELECT id, payload, progress FROM system.jobs WHERE status = $1 ORDER BY id`
+	rows, _ /* cols */, err := s.admin.server.internalExecutor.Query(
+		ctx, "status-changefeeds", nil /* txn */, stmt, jobs.StatusRunning,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &serverpb.ChangefeedsResponse{
+		Changefeeds: []serverpb.ChangefeedsResponse_Changefeed{},
+	}
+	now := s.admin.server.clock.PhysicalNow()
+	for _, row := range rows {
+		payload, err := jobs.UnmarshalPayload(row[1])
+		if err != nil {
+			return nil, err
+		}
+		details := payload.GetChangefeed()
+		if details == nil {
+			continue
+		}
+		progress, err := jobs.UnmarshalProgress(row[2])
+		if err != nil {
+			return nil, err
+		}
+
+		cf := serverpb.ChangefeedsResponse_Changefeed{
+			JobID:       int64(tree.MustBeDInt(row[0])),
+			Description: payload.Description,
+			Tables:      details.TableNames,
+		}
+		if len(cf.Tables) == 0 {
+			// Changefeeds created before the names were kept in their details.
+			for _, desc := range details.TableDescs {
+				cf.Tables = append(cf.Tables, desc.Name)
+			}
+		}
+		if p := progress.GetChangefeed(); p != nil && p.Highwater != (hlc.Timestamp{}) {
+			cf.Highwater = p.Highwater.AsOfSystemTime()
+			cf.LagNanos = now - p.Highwater.WallTime
+		}
+		if payload.Lease != nil {
+			cf.NodeID = payload.Lease.NodeID
+		}
+		resp.Changefeeds = append(resp.Changefeeds, cf)
+	}
+	return resp, nil
+}
@@ -551,6 +551,22 @@ func TestStatusVars(t *testing.T) {
 	}
 }
 
+func TestStatusChangefeeds(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	s, _, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(context.TODO())
+
+	// Changefeeds are an enterprise feature, so there are none to serve here.
+	// They're tested in changefeedccl.
+	var resp serverpb.ChangefeedsResponse
+	if err := getStatusJSONProto(s, "changefeeds", &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Changefeeds) != 0 {
+		t.Errorf("expected no changefeeds, got: %+v", resp.Changefeeds)
+	}
+}
+
 func TestSpanStatsResponse(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	ts := startServer(t)
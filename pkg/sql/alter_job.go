@@ -0,0 +1,114 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package sql
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
+	"github.com/pkg/errors"
+)
+
+// alterJobOwnerNode represents an ALTER JOB ... OWNER TO statement.
+type alterJobOwnerNode struct {
+	jobID tree.TypedExpr
+	owner string
+
+	run alterJobOwnerRun
+}
+
+// AlterJobOwner changes the owner of a job, which is the user it runs as and,
+// along with the members of that user if it's a role, the user allowed to
+// control it.
+// Privileges: control of the job, and membership of the new owner.
+func (p *planner) AlterJobOwner(ctx context.Context, n *tree.AlterJobOwner) (planNode, error) {
+	jobID, err := p.analyzeExpr(
+		ctx, n.Job, nil, tree.IndexedVarHelper{}, types.Int, true, "ALTER JOB",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := p.GetAllUsersAndRoles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	owner := string(n.Owner)
+	if _, ok := users[owner]; !ok {
+		return nil, errors.Errorf("user or role %s does not exist", &n.Owner)
+	}
+
+	return &alterJobOwnerNode{jobID: jobID, owner: owner}, nil
+}
+
+// alterJobOwnerRun is the run-time state of alterJobOwnerNode for local
+// execution.
+type alterJobOwnerRun struct {
+	rowsAffected int
+}
+
+func (n *alterJobOwnerNode) startExec(params runParams) error {
+	d, err := n.jobID.Eval(params.EvalContext())
+	if err != nil {
+		return err
+	}
+	if d == tree.DNull {
+		return nil
+	}
+	jobID, ok := tree.AsDInt(d)
+	if !ok {
+		return pgerror.NewErrorf(pgerror.CodeInternalError,
+			"programming error: %q: expected *DInt, found %T", d, d)
+	}
+
+	job, err := params.p.ExecCfg().JobRegistry.LoadJobWithTxn(
+		params.ctx, int64(jobID), params.p.txn,
+	)
+	if err != nil {
+		return err
+	}
+	if err := params.p.CheckCanControlJob(
+		params.ctx, int64(jobID), job.Payload().Username,
+	); err != nil {
+		return err
+	}
+	// A job can't be handed to a user the session user couldn't act as,
+	// since the job then runs as that user.
+	ok, err = params.p.actsAsUser(params.ctx, n.owner)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return pgerror.NewErrorf(pgerror.CodeInsufficientPrivilegeError,
+			"user %s must be a member of %s to make it the owner of job %d",
+			params.SessionData().User, n.owner, jobID)
+	}
+
+	if err := job.WithTxn(params.p.txn).SetOwner(params.ctx, n.owner); err != nil {
+		return err
+	}
+	n.run.rowsAffected++
+	return nil
+}
+
+func (*alterJobOwnerNode) Next(runParams) (bool, error) { return false, nil }
+func (*alterJobOwnerNode) Values() tree.Datums          { return tree.Datums{} }
+func (*alterJobOwnerNode) Close(context.Context)        {}
+
+func (n *alterJobOwnerNode) FastPathResults() (int, bool) {
+	return n.run.rowsAffected, true
+}
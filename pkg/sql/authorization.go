@@ -19,6 +19,7 @@ import (
 	"fmt"
 
 	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
 	"github.com/cockroachdb/cockroach/pkg/sql/privilege"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
@@ -54,6 +55,10 @@ type AuthorizationAccessor interface {
 	// MemberOfWithAdminOption looks up all the roles (direct and indirect) that 'member' is a member
 	// of and returns a map of role -> isAdmin.
 	MemberOfWithAdminOption(ctx context.Context, member string) (map[string]bool, error)
+
+	// CheckCanControlJob errors if the session user isn't allowed to pause,
+	// resume, cancel or alter the job with the given ID and owner.
+	CheckCanControlJob(ctx context.Context, jobID int64, owner string) error
 }
 
 var _ AuthorizationAccessor = &planner{}
@@ -166,6 +171,43 @@ func (p *planner) RequireSuperUser(ctx context.Context, action string) error {
 	return fmt.Errorf("only superusers are allowed to %s", action)
 }
 
+// CheckCanControlJob implements the AuthorizationAccessor interface. A job
+// can be controlled by its owner, by the members (direct or indirect) of the
+// owner if it's a role, and by super-users. That lets a team own its jobs
+// through a role, without needing admin to pause or resume them.
+func (p *planner) CheckCanControlJob(ctx context.Context, jobID int64, owner string) error {
+	ok, err := p.actsAsUser(ctx, owner)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return pgerror.NewErrorf(pgerror.CodeInsufficientPrivilegeError,
+			"user %s does not have privileges to control job %d owned by %s",
+			p.SessionData().User, jobID, owner)
+	}
+	return nil
+}
+
+// actsAsUser returns whether the session user is the given user, a member
+// (direct or indirect) of it, or a super-user.
+func (p *planner) actsAsUser(ctx context.Context, user string) (bool, error) {
+	sessionUser := p.SessionData().User
+	if sessionUser == user || sessionUser == security.RootUser || sessionUser == security.NodeUser {
+		return true, nil
+	}
+
+	// Expand role memberships.
+	memberOf, err := p.MemberOfWithAdminOption(ctx, sessionUser)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := memberOf[sqlbase.AdminRole]; ok {
+		return true, nil
+	}
+	_, ok := memberOf[user]
+	return ok, nil
+}
+
 // MemberOfWithAdminOption looks up all the roles 'member' belongs to (direct and indirect) and
 // returns a map of "role" -> "isAdmin".
 // The "isAdmin" flag applies to both direct and indirect members.
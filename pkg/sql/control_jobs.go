@@ -16,8 +16,12 @@ package sql
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
 	"github.com/cockroachdb/cockroach/pkg/sql/jobs"
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
 	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
@@ -27,7 +31,9 @@ import (
 type controlJobsNode struct {
 	rows          planNode
 	desiredStatus jobs.Status
-	numRows       int
+	// reasonFn, if set, returns why the jobs are paused.
+	reasonFn func() (string, error)
+	numRows  int
 }
 
 var jobCommandToDesiredStatus = map[tree.JobCommand]jobs.Status{
@@ -51,20 +57,90 @@ func (p *planner) ControlJobs(ctx context.Context, n *tree.ControlJobs) (planNod
 			tree.JobCommandToStatement[n.Command], cols[0].Typ)
 	}
 
+	var reasonFn func() (string, error)
+	if n.Reason != nil {
+		if n.Command != tree.PauseJob {
+			return nil, errors.Errorf("%s JOBS does not take a reason",
+				tree.JobCommandToStatement[n.Command])
+		}
+		if reasonFn, err = p.TypeAsString(n.Reason, "PAUSE JOBS"); err != nil {
+			return nil, err
+		}
+	}
+
 	return &controlJobsNode{
 		rows:          rows,
 		desiredStatus: jobCommandToDesiredStatus[n.Command],
+		reasonFn:      reasonFn,
 	}, nil
 }
 
+// jobStatusesToControl are the statuses of the jobs that PAUSE and RESUME ALL
+// JOBS apply to, which are the ones that aren't already in the desired state.
+var jobStatusesToControl = map[tree.JobCommand][]jobs.Status{
+	tree.PauseJob:  {jobs.StatusPending, jobs.StatusRunning},
+	tree.ResumeJob: {jobs.StatusPaused},
+}
+
+// ControlJobsOfType pauses or resumes all the jobs of a type, like PAUSE ALL
+// CHANGEFEED JOBS, which lets operators quiesce a kind of background work
+// across the cluster, say before maintenance, without listing the jobs.
+// Privileges: control of every job of the type that's paused or resumed.
+func (p *planner) ControlJobsOfType(
+	ctx context.Context, n *tree.ControlJobsOfType,
+) (planNode, error) {
+	statuses, ok := jobStatusesToControl[n.Command]
+	if !ok {
+		return nil, errors.Errorf("%s ALL JOBS is not supported",
+			tree.JobCommandToStatement[n.Command])
+	}
+	typ, ok := jobspb.Type_value[n.Type]
+	if !ok || jobspb.Type(typ) == jobspb.TypeUnspecified {
+		return nil, errors.Errorf("unknown job type %s", n.Type)
+	}
+
+	quoted := make([]string, len(statuses))
+	for i, s := range statuses {
+		quoted[i] = fmt.Sprintf("'%s'", s)
+	}
+	stmt, err := parser.ParseOne(fmt.Sprintf(
+		`SELECT id FROM crdb_internal.jobs WHERE type = '%s' AND status IN (%s)`,
+		jobspb.Type(typ), strings.Join(quoted, ", "),
+	))
+	if err != nil {
+		return nil, err
+	}
+	return p.ControlJobs(ctx, &tree.ControlJobs{
+		Jobs:    stmt.(*tree.Select),
+		Command: n.Command,
+		Reason:  n.Reason,
+	})
+}
+
 // FastPathResults implements the planNodeFastPath inteface.
 func (n *controlJobsNode) FastPathResults() (int, bool) {
 	return n.numRows, true
 }
 
+// changefeedControlEvents are the events recorded in the event log when a
+// changefeed is paused or resumed, so that its lifecycle can be audited
+// without polling system.jobs. The events of the changefeeds that pause or
+// fail on their own are recorded by the changefeeds.
+var changefeedControlEvents = map[jobs.Status]EventLogType{
+	jobs.StatusPaused:  EventLogChangefeedPaused,
+	jobs.StatusRunning: EventLogChangefeedResumed,
+}
+
 // startExec implements the execStartable interface.
 func (n *controlJobsNode) startExec(params runParams) error {
 	reg := params.p.ExecCfg().JobRegistry
+	var reason string
+	if n.reasonFn != nil {
+		var err error
+		if reason, err = n.reasonFn(); err != nil {
+			return err
+		}
+	}
 	for {
 		ok, err := n.rows.Next(params)
 		if err != nil {
@@ -85,9 +161,19 @@ func (n *controlJobsNode) startExec(params runParams) error {
 				"programming error: %q: expected *DInt, found %T", jobIDDatum, jobIDDatum)
 		}
 
+		job, err := reg.LoadJobWithTxn(params.ctx, int64(jobID), params.p.txn)
+		if err != nil {
+			return err
+		}
+		if err := params.p.CheckCanControlJob(
+			params.ctx, int64(jobID), job.Payload().Username,
+		); err != nil {
+			return err
+		}
+
 		switch n.desiredStatus {
 		case jobs.StatusPaused:
-			err = reg.Pause(params.ctx, params.p.txn, int64(jobID))
+			err = reg.PauseWithReason(params.ctx, params.p.txn, int64(jobID), reason)
 		case jobs.StatusRunning:
 			err = reg.Resume(params.ctx, params.p.txn, int64(jobID))
 		case jobs.StatusCanceled:
@@ -99,6 +185,23 @@ func (n *controlJobsNode) startExec(params runParams) error {
 		if err != nil {
 			return err
 		}
+		if eventType, ok := changefeedControlEvents[n.desiredStatus]; ok &&
+			job.Payload().Type() == jobspb.TypeChangefeed {
+			if err := MakeEventLogger(params.extendedEvalCtx.ExecCfg).InsertEventRecord(
+				params.ctx,
+				params.p.txn,
+				eventType,
+				0, /* no target */
+				int32(params.extendedEvalCtx.NodeID),
+				EventLogChangefeedDetail{
+					JobID:  int64(jobID),
+					User:   params.SessionData().User,
+					Reason: reason,
+				},
+			); err != nil {
+				return err
+			}
+		}
 		n.numRows++
 	}
 	return nil
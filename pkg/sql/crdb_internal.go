@@ -38,11 +38,14 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/server/status"
 	"github.com/cockroachdb/cockroach/pkg/settings"
 	"github.com/cockroachdb/cockroach/pkg/sql/jobs"
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs/jobspb"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/builtins"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
 	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
 	"github.com/cockroachdb/cockroach/pkg/storage"
+	"github.com/cockroachdb/cockroach/pkg/util/duration"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/json"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
@@ -57,6 +60,9 @@ var crdbInternal = virtualSchema{
 		crdbInternalBackwardDependenciesTable,
 		crdbInternalBuildInfoTable,
 		crdbInternalBuiltinFunctionsTable,
+		crdbInternalChangefeedLaggingSpansTable,
+		crdbInternalChangefeedResolvedGroupsTable,
+		crdbInternalChangefeedsTable,
 		crdbInternalClusterQueriesTable,
 		crdbInternalClusterSessionsTable,
 		crdbInternalClusterSettingsTable,
@@ -403,7 +409,11 @@ CREATE TABLE crdb_internal.jobs (
 	modified           TIMESTAMP,
 	fraction_completed FLOAT,
 	error              STRING,
-	coordinator_id     INT
+	pause_reason       STRING,
+	coordinator_id     INT,
+	changefeed_tables  STRING[],
+	changefeed_sink    STRING,
+	changefeed_options JSONB
 );
 `,
 	populate: func(ctx context.Context, p *planner, _ *DatabaseDescriptor, addRow func(...tree.Datum) error) error {
@@ -419,9 +429,11 @@ CREATE TABLE crdb_internal.jobs (
 			id, status, created, payloadBytes, progressBytes := r[0], r[1], r[2], r[3], r[4]
 
 			var jobType, description, username, descriptorIDs, started,
-				finished, modified, fractionCompleted, errorStr, leaseNode = tree.DNull,
+				finished, modified, fractionCompleted, errorStr, pauseReason, leaseNode = tree.DNull,
 				tree.DNull, tree.DNull, tree.DNull, tree.DNull, tree.DNull, tree.DNull, tree.DNull,
-				tree.DNull, tree.DNull
+				tree.DNull, tree.DNull, tree.DNull
+			cfTables, cfSink, cfOptions := tree.Datum(tree.DNull), tree.Datum(tree.DNull),
+				tree.Datum(tree.DNull)
 
 			// Extract data from the payload.
 			payload, err := jobs.UnmarshalPayload(payloadBytes)
@@ -444,6 +456,7 @@ CREATE TABLE crdb_internal.jobs (
 					leaseNode = tree.NewDInt(tree.DInt(payload.Lease.NodeID))
 				}
 				errorStr = tree.NewDString(payload.Error)
+				pauseReason = tree.NewDString(payload.PauseReason)
 			}
 
 			// Extract data from the progress field.
@@ -462,6 +475,18 @@ CREATE TABLE crdb_internal.jobs (
 				modified = tsOrNull(progress.ModifiedMicros)
 			}
 
+			if payload != nil && payload.GetChangefeed() != nil {
+				cfProgress := &jobspb.ChangefeedProgress{}
+				if progress != nil && progress.GetChangefeed() != nil {
+					cfProgress = progress.GetChangefeed()
+				}
+				if cfTables, cfSink, cfOptions, err = changefeedJobColumns(
+					payload.GetChangefeed(), cfProgress,
+				); err != nil {
+					return err
+				}
+			}
+
 			// Report the data.
 			if err := addRow(
 				id,
@@ -476,12 +501,398 @@ CREATE TABLE crdb_internal.jobs (
 				modified,
 				fractionCompleted,
 				errorStr,
+				pauseReason,
 				leaseNode,
+				cfTables,
+				cfSink,
+				cfOptions,
+			); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+}
+
+// changefeedJobColumns returns the tables watched by a changefeed, the scheme
+// of its sink and its options, for the changefeed columns of
+// crdb_internal.jobs. The sink is the one the changefeed last resolved its
+// sink URI to, if it has run, and is NULL if the URI can't be parsed. The
+// passwords in the options that are URIs are redacted.
+func changefeedJobColumns(
+	details *jobspb.ChangefeedDetails, progress *jobspb.ChangefeedProgress,
+) (tables, sink, options tree.Datum, _ error) {
+	tablesArr := tree.NewDArray(types.String)
+	if len(details.TableNames) > 0 {
+		for _, name := range details.TableNames {
+			if err := tablesArr.Append(tree.NewDString(name)); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+	} else {
+		// Changefeeds created before the names were kept in their details.
+		for i := range details.TableDescs {
+			if err := tablesArr.Append(tree.NewDString(details.TableDescs[i].Name)); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+	}
+	sink = tree.DNull
+	if progress.SinkScheme != `` {
+		sink = tree.NewDString(progress.SinkScheme)
+	} else if details.SinkURI != `` {
+		if sinkURI, err := url.Parse(details.SinkURI); err == nil {
+			sink = tree.NewDString(sinkURI.Scheme)
+		}
+	}
+	opts := json.NewObjectBuilder(len(details.Opts))
+	for name, value := range details.Opts {
+		if value == `` {
+			opts.Add(name, json.NullJSONValue)
+			continue
+		}
+		if u, err := url.Parse(value); err == nil && u.User != nil {
+			if _, ok := u.User.Password(); ok {
+				u.User = url.UserPassword(u.User.Username(), `redacted`)
+				value = u.String()
+			}
+		}
+		opts.Add(name, json.FromString(value))
+	}
+	return tablesArr, sink, tree.NewDJSON(opts.Build()), nil
+}
+
+// changefeedLabelOpt is the `label` option of CREATE CHANGEFEED, which names
+// the group of changefeeds that a changefeed is part of.
+const changefeedLabelOpt = `label`
+
+// crdbInternalChangefeedResolvedGroupsTable exposes, for every group of the
+// changefeeds that aren't done by label, the timestamp up to which every one
+// of them has emitted every change. Operations that span the tables of several
+// changefeeds can use it as the single point up to which everything
+// downstream is caught up. It's NULL until every changefeed of the group is
+// done with its initial scan.
+var crdbInternalChangefeedResolvedGroupsTable = virtualSchemaTable{
+	schema: `
+CREATE TABLE crdb_internal.changefeed_resolved_groups (
+	label           STRING,
+	resolved        DECIMAL,
+	resolved_time   TIMESTAMP,
+	num_changefeeds INT,
+	slowest_job_id  INT
+);
+`,
+	populate: func(ctx context.Context, p *planner, _ *DatabaseDescriptor, addRow func(...tree.Datum) error) error {
+		query := `SELECT id, payload, progress FROM system.jobs WHERE status IN ($1, $2, $3)`
+		rows, _ /* cols */, err :=
+			p.ExtendedEvalContext().ExecCfg.InternalExecutor.QueryWithSessionArgs(
+				ctx, "crdb-internal-changefeed-resolved-groups-table", p.txn,
+				SessionArgs{User: p.SessionData().User}, query,
+				jobs.StatusPending, jobs.StatusRunning, jobs.StatusPaused)
+		if err != nil {
+			return err
+		}
+
+		type group struct {
+			resolved       hlc.Timestamp
+			numChangefeeds int
+			slowestJobID   int64
+		}
+		groups := make(map[string]*group)
+		var labels []string
+		for _, r := range rows {
+			id, payloadBytes, progressBytes := int64(tree.MustBeDInt(r[0])), r[1], r[2]
+			payload, err := jobs.UnmarshalPayload(payloadBytes)
+			if err != nil {
+				return err
+			}
+			details := payload.GetChangefeed()
+			if details == nil {
+				continue
+			}
+			label, ok := details.Opts[changefeedLabelOpt]
+			if !ok {
+				continue
+			}
+			progress, err := jobs.UnmarshalProgress(progressBytes)
+			if err != nil {
+				return err
+			}
+			var highwater hlc.Timestamp
+			if cfProgress := progress.GetChangefeed(); cfProgress != nil {
+				highwater = cfProgress.Highwater
+			}
+
+			g, ok := groups[label]
+			if !ok {
+				g = &group{resolved: highwater, slowestJobID: id}
+				groups[label] = g
+				labels = append(labels, label)
+			} else if highwater.Less(g.resolved) {
+				g.resolved, g.slowestJobID = highwater, id
+			}
+			g.numChangefeeds++
+		}
+
+		sort.Strings(labels)
+		for _, label := range labels {
+			g := groups[label]
+			resolved, resolvedTime := tree.DNull, tree.DNull
+			if g.resolved != (hlc.Timestamp{}) {
+				resolved = tree.TimestampToDecimal(g.resolved)
+				resolvedTime = tree.MakeDTimestamp(timeutil.Unix(0, g.resolved.WallTime), time.Microsecond)
+			}
+			if err := addRow(
+				tree.NewDString(label),
+				resolved,
+				resolvedTime,
+				tree.NewDInt(tree.DInt(g.numChangefeeds)),
+				tree.NewDInt(tree.DInt(g.slowestJobID)),
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// changefeedSpanFrontier is a span watched by a changefeed and the timestamp
+// up to which it has been emitted.
+type changefeedSpanFrontier struct {
+	span roachpb.Span
+	ts   hlc.Timestamp
+}
+
+// changefeedSpanFrontiers returns the spans watched by a changefeed and how
+// far each of them has been emitted, which is its high-water mark unless its
+// span checkpoint says it's further along.
+func changefeedSpanFrontiers(
+	details *jobspb.ChangefeedDetails, progress *jobspb.ChangefeedProgress,
+) []changefeedSpanFrontier {
+	var watched, rest roachpb.SpanGroup
+	for i := range details.TableDescs {
+		watched.Add(details.TableDescs[i].PrimaryIndexSpan())
+		rest.Add(details.TableDescs[i].PrimaryIndexSpan())
+	}
+	var frontiers []changefeedSpanFrontier
+	for _, s := range progress.SpanCheckpoint {
+		// Entries at or below the high-water mark are stale.
+		if !progress.Highwater.Less(s.Timestamp) || !watched.Contains(s.Span.Key) {
+			continue
+		}
+		frontiers = append(frontiers, changefeedSpanFrontier{span: s.Span, ts: s.Timestamp})
+		rest.Sub(s.Span)
+	}
+	for _, span := range rest.Slice() {
+		frontiers = append(frontiers, changefeedSpanFrontier{span: span, ts: progress.Highwater})
+	}
+	return frontiers
+}
+
+// crdbInternalChangefeedsTable exposes the progress of every changefeed that
+// isn't done, so that changefeeds can be monitored with plain SQL: its
+// high-water mark, how far behind the present that is, and how much of its
+// initial scan is done. The backfill fraction is the fraction of the ranges of
+// the watched tables that the initial scan has emitted, according to the span
+// checkpoint of the changefeed, and 1 once the changefeed has a high-water
+// mark.
+var crdbInternalChangefeedsTable = virtualSchemaTable{
+	schema: `
+CREATE TABLE crdb_internal.changefeeds (
+	job_id            INT,
+	status            STRING,
+	high_water        DECIMAL,
+	high_water_time   TIMESTAMP,
+	lag               INTERVAL,
+	backfill_fraction FLOAT
+);
+`,
+	populate: func(ctx context.Context, p *planner, _ *DatabaseDescriptor, addRow func(...tree.Datum) error) error {
+		query := `SELECT id, status, payload, progress FROM system.jobs WHERE status IN ($1, $2, $3)`
+		rows, _ /* cols */, err :=
+			p.ExtendedEvalContext().ExecCfg.InternalExecutor.QueryWithSessionArgs(
+				ctx, "crdb-internal-changefeeds-table", p.txn,
+				SessionArgs{User: p.SessionData().User}, query,
+				jobs.StatusPending, jobs.StatusRunning, jobs.StatusPaused)
+		if err != nil {
+			return err
+		}
+
+		now := timeutil.Now()
+		for _, r := range rows {
+			id, status, payloadBytes, progressBytes := r[0], r[1], r[2], r[3]
+			payload, err := jobs.UnmarshalPayload(payloadBytes)
+			if err != nil {
+				return err
+			}
+			details := payload.GetChangefeed()
+			if details == nil {
+				continue
+			}
+			progress, err := jobs.UnmarshalProgress(progressBytes)
+			if err != nil {
+				return err
+			}
+			cfProgress := progress.GetChangefeed()
+			if cfProgress == nil {
+				cfProgress = &jobspb.ChangefeedProgress{}
+			}
+			highwater := cfProgress.Highwater
+
+			highWater, highWaterTime, lag := tree.DNull, tree.DNull, tree.DNull
+			backfillFraction := 1.0
+			if highwater != (hlc.Timestamp{}) {
+				highWater = tree.TimestampToDecimal(highwater)
+				highWaterTime = tree.MakeDTimestamp(timeutil.Unix(0, highwater.WallTime), time.Microsecond)
+				lag = &tree.DInterval{
+					Duration: duration.Duration{Nanos: now.Sub(timeutil.Unix(0, highwater.WallTime)).Nanoseconds()},
+				}
+			} else if backfillFraction, err = changefeedBackfillFraction(
+				ctx, p.txn, details, cfProgress,
+			); err != nil {
+				return err
+			}
+			if err := addRow(
+				id,
+				status,
+				highWater,
+				highWaterTime,
+				lag,
+				tree.NewDFloat(tree.DFloat(backfillFraction)),
 			); err != nil {
 				return err
 			}
 		}
+		return nil
+	},
+}
+
+// changefeedBackfillFraction returns the fraction of the ranges of the tables
+// watched by a changefeed without a high-water mark that its initial scan has
+// emitted, according to its span checkpoint. A range counts as emitted once
+// the checkpoint covers where the range starts in its table.
+func changefeedBackfillFraction(
+	ctx context.Context,
+	txn *client.Txn,
+	details *jobspb.ChangefeedDetails,
+	progress *jobspb.ChangefeedProgress,
+) (float64, error) {
+	var emitted roachpb.SpanGroup
+	for _, f := range changefeedSpanFrontiers(details, progress) {
+		if f.ts != (hlc.Timestamp{}) {
+			emitted.Add(f.span)
+		}
+	}
+	var numRanges, numEmitted int
+	for i := range details.TableDescs {
+		span := details.TableDescs[i].PrimaryIndexSpan()
+		kvs, err := ScanMetaKVs(ctx, txn, span)
+		if err != nil {
+			return 0, err
+		}
+		for _, kv := range kvs {
+			var desc roachpb.RangeDescriptor
+			if err := kv.ValueProto(&desc); err != nil {
+				return 0, err
+			}
+			start := desc.StartKey.AsRawKey()
+			if start.Compare(span.Key) < 0 {
+				start = span.Key
+			}
+			if start.Compare(span.EndKey) >= 0 {
+				continue
+			}
+			numRanges++
+			if emitted.Contains(start) {
+				numEmitted++
+			}
+		}
+	}
+	if numRanges == 0 {
+		return 0, nil
+	}
+	return float64(numEmitted) / float64(numRanges), nil
+}
+
+// crdbInternalChangefeedLaggingSpansTable exposes the spans watched by every
+// changefeed that isn't done, along with the timestamp up to which each one
+// has been emitted, laggiest first. The resolved timestamp of a changefeed is
+// held back by its laggiest span, so this shows where a changefeed that's
+// fallen behind is stuck. The spans past the high-water mark are only known
+// once a changefeed persists them, every changefeed.span_checkpoint_interval.
+var crdbInternalChangefeedLaggingSpansTable = virtualSchemaTable{
+	schema: `
+CREATE TABLE crdb_internal.changefeed_lagging_spans (
+	job_id        INT,
+	start_key     STRING,
+	end_key       STRING,
+	resolved      DECIMAL,
+	resolved_time TIMESTAMP,
+	lag           INTERVAL
+);
+`,
+	populate: func(ctx context.Context, p *planner, _ *DatabaseDescriptor, addRow func(...tree.Datum) error) error {
+		query := `SELECT id, payload, progress FROM system.jobs WHERE status IN ($1, $2, $3)`
+		rows, _ /* cols */, err :=
+			p.ExtendedEvalContext().ExecCfg.InternalExecutor.QueryWithSessionArgs(
+				ctx, "crdb-internal-changefeed-lagging-spans-table", p.txn,
+				SessionArgs{User: p.SessionData().User}, query,
+				jobs.StatusPending, jobs.StatusRunning, jobs.StatusPaused)
+		if err != nil {
+			return err
+		}
+
+		type laggingSpan struct {
+			jobID int64
+			changefeedSpanFrontier
+		}
+		var spans []laggingSpan
+		for _, r := range rows {
+			id, payloadBytes, progressBytes := int64(tree.MustBeDInt(r[0])), r[1], r[2]
+			payload, err := jobs.UnmarshalPayload(payloadBytes)
+			if err != nil {
+				return err
+			}
+			details := payload.GetChangefeed()
+			if details == nil {
+				continue
+			}
+			progress, err := jobs.UnmarshalProgress(progressBytes)
+			if err != nil {
+				return err
+			}
+			cfProgress := progress.GetChangefeed()
+			if cfProgress == nil {
+				cfProgress = &jobspb.ChangefeedProgress{}
+			}
+			for _, f := range changefeedSpanFrontiers(details, cfProgress) {
+				spans = append(spans, laggingSpan{jobID: id, changefeedSpanFrontier: f})
+			}
+		}
 
+		sort.SliceStable(spans, func(i, j int) bool { return spans[i].ts.Less(spans[j].ts) })
+		now := timeutil.Now()
+		for _, s := range spans {
+			resolved, resolvedTime, lag := tree.DNull, tree.DNull, tree.DNull
+			if s.ts != (hlc.Timestamp{}) {
+				resolved = tree.TimestampToDecimal(s.ts)
+				resolvedTime = tree.MakeDTimestamp(timeutil.Unix(0, s.ts.WallTime), time.Microsecond)
+				lag = &tree.DInterval{
+					Duration: duration.Duration{Nanos: now.Sub(timeutil.Unix(0, s.ts.WallTime)).Nanoseconds()},
+				}
+			}
+			if err := addRow(
+				tree.NewDInt(tree.DInt(s.jobID)),
+				tree.NewDString(keys.PrettyPrint(nil /* valDirs */, s.span.Key)),
+				tree.NewDString(keys.PrettyPrint(nil /* valDirs */, s.span.EndKey)),
+				resolved,
+				resolvedTime,
+				lag,
+			); err != nil {
+				return err
+			}
+		}
 		return nil
 	},
 }
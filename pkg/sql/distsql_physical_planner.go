@@ -525,6 +525,25 @@ type spanPartition struct {
 	spans roachpb.Spans
 }
 
+// PartitionSpans finds out which nodes own the ranges touching the given
+// spans and splits the spans by owning node, see partitionSpans. It's used to
+// show how the work of a statement over the spans would be distributed, like
+// by EXPLAIN CREATE CHANGEFEED.
+func (dsp *DistSQLPlanner) PartitionSpans(
+	ctx context.Context, evalCtx *extendedEvalContext, txn *client.Txn, spans roachpb.Spans,
+) (map[roachpb.NodeID]roachpb.Spans, error) {
+	planCtx := dsp.newPlanningCtx(ctx, evalCtx, txn)
+	partitions, err := dsp.partitionSpans(&planCtx, spans)
+	if err != nil {
+		return nil, err
+	}
+	byNode := make(map[roachpb.NodeID]roachpb.Spans, len(partitions))
+	for _, p := range partitions {
+		byNode[p.node] = p.spans
+	}
+	return byNode, nil
+}
+
 func (dsp *DistSQLPlanner) checkNodeHealth(
 	ctx context.Context, nodeID roachpb.NodeID, addr string,
 ) error {
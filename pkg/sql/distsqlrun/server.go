@@ -37,6 +37,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/storage/engine"
 	"github.com/cockroachdb/cockroach/pkg/util/contextutil"
 	"github.com/cockroachdb/cockroach/pkg/util/envutil"
+	"github.com/cockroachdb/cockroach/pkg/util/json"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/mon"
 	"github.com/cockroachdb/cockroach/pkg/util/stop"
@@ -605,6 +606,13 @@ func (ep *dummyEvalPlanner) EvalSubquery(expr *tree.Subquery) (tree.Datum, error
 	return nil, errEvalPlanner
 }
 
+// Implements the tree.EvalPlanner interface.
+func (ep *dummyEvalPlanner) TraceJob(
+	ctx context.Context, jobID int64, d time.Duration,
+) (json.JSON, error) {
+	return nil, errEvalPlanner
+}
+
 var errSequenceOperators = errors.New("cannot backfill such sequence operation")
 
 // Implements the tree.SequenceOperators interface by returning errors.
@@ -93,6 +93,21 @@ const (
 	EventLogSetZoneConfig EventLogType = "set_zone_config"
 	// EventLogRemoveZoneConfig is recorded when a zone config is removed.
 	EventLogRemoveZoneConfig EventLogType = "remove_zone_config"
+
+	// EventLogChangefeedLagAlert is recorded when a changefeed falls further
+	// behind than its lag_alert option allows.
+	EventLogChangefeedLagAlert EventLogType = "changefeed_lag_alert"
+	// EventLogChangefeedMarker is recorded by crdb_internal.changefeed_emit_marker
+	// for a changefeed to emit.
+	EventLogChangefeedMarker EventLogType = "changefeed_marker"
+	// EventLogChangefeedPaused is recorded when a changefeed is paused, either
+	// by a user or by the changefeed itself.
+	EventLogChangefeedPaused EventLogType = "changefeed_paused"
+	// EventLogChangefeedResumed is recorded when a paused changefeed is
+	// resumed.
+	EventLogChangefeedResumed EventLogType = "changefeed_resumed"
+	// EventLogChangefeedFailed is recorded when a changefeed fails.
+	EventLogChangefeedFailed EventLogType = "changefeed_failed"
 )
 
 // EventLogSetClusterSettingDetail is the json details for a settings change.
@@ -102,6 +117,15 @@ type EventLogSetClusterSettingDetail struct {
 	User        string
 }
 
+// EventLogChangefeedDetail is the json details for a changefeed being paused,
+// resumed or failing. User is empty when the changefeed paused or failed on
+// its own, and Reason is why it was paused or the error it failed with.
+type EventLogChangefeedDetail struct {
+	JobID  int64
+	User   string `json:",omitempty"`
+	Reason string `json:",omitempty"`
+}
+
 // An EventLogger exposes methods used to record events to the event table.
 type EventLogger struct {
 	*InternalExecutor
@@ -333,6 +333,7 @@ func doExpandPlan(
 	case *alterIndexNode:
 	case *alterTableNode:
 	case *alterSequenceNode:
+	case *alterJobOwnerNode:
 	case *alterUserSetPasswordNode:
 	case *scrubNode:
 	case *createDatabaseNode:
@@ -834,6 +835,7 @@ func (p *planner) simplifyOrderings(plan planNode, usefulOrdering sqlbase.Column
 	case *alterIndexNode:
 	case *alterTableNode:
 	case *alterSequenceNode:
+	case *alterJobOwnerNode:
 	case *alterUserSetPasswordNode:
 	case *scrubNode:
 	case *createDatabaseNode:
@@ -0,0 +1,69 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package sql
+
+import (
+	"context"
+	encjson "encoding/json"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/json"
+	"github.com/cockroachdb/cockroach/pkg/util/tracing"
+	"github.com/pkg/errors"
+)
+
+// maxJobTraceDuration is the longest a job can be traced for at once, since
+// the trace is held in memory until then.
+const maxJobTraceDuration = 5 * time.Minute
+
+// jobTrace is the JSON returned by crdb_internal.job_trace.
+type jobTrace struct {
+	JobID  int64       `json:"job_id"`
+	NodeID int32       `json:"node_id"`
+	Trace  string      `json:"trace"`
+	State  interface{} `json:"state"`
+}
+
+// TraceJob implements the tree.EvalPlanner interface.
+// Privileges: control of the job.
+func (p *planner) TraceJob(ctx context.Context, jobID int64, d time.Duration) (json.JSON, error) {
+	if d <= 0 || d > maxJobTraceDuration {
+		return nil, errors.Errorf(
+			"job trace duration must be positive and at most %s, got %s", maxJobTraceDuration, d)
+	}
+	reg := p.ExecCfg().JobRegistry
+	job, err := reg.LoadJobWithTxn(ctx, jobID, p.txn)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.CheckCanControlJob(ctx, jobID, job.Payload().Username); err != nil {
+		return nil, err
+	}
+
+	trace, err := reg.TraceJob(ctx, jobID, d)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := encjson.Marshal(jobTrace{
+		JobID:  jobID,
+		NodeID: int32(p.ExecCfg().NodeID.Get()),
+		Trace:  tracing.FormatRecordedSpans(trace.Spans),
+		State:  trace.State,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.ParseJSON(string(encoded))
+}
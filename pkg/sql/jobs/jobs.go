@@ -197,17 +197,24 @@ func (j *Job) DetailProgressed(ctx context.Context, progressedFn DetailProgresse
 
 // Paused sets the status of the tracked job to paused. It does not directly
 // pause the job; instead, it expects the job to call job.Progressed soon,
-// observe a "job is paused" error, and abort further work.
-func (j *Job) paused(ctx context.Context) error {
+// observe a "job is paused" error, and abort further work. The reason, if
+// any, is kept so that SHOW JOBS says why the job was paused, until it's
+// resumed or canceled.
+func (j *Job) paused(ctx context.Context, reason string) error {
 	return j.update(ctx, func(_ *client.Txn, status *Status, payload *jobspb.Payload, _ *jobspb.Progress) (bool, error) {
 		if *status == StatusPaused {
-			// Already paused - do nothing.
-			return false, nil
+			// Already paused - only update the reason, if a new one was given.
+			if reason == "" || reason == payload.PauseReason {
+				return false, nil
+			}
+			payload.PauseReason = reason
+			return true, nil
 		}
 		if status.Terminal() {
 			return false, &InvalidStatusError{*j.id, *status, "pause", payload.Error}
 		}
 		*status = StatusPaused
+		payload.PauseReason = reason
 		return true, nil
 	})
 }
@@ -228,6 +235,8 @@ func (j *Job) resumed(ctx context.Context) error {
 			return false, fmt.Errorf("job with status %s cannot be resumed", *status)
 		}
 		*status = StatusRunning
+		// Clear the reason the job was paused for.
+		payload.PauseReason = ""
 		// NB: A nil lease indicates the job is not resumable, whereas an empty
 		// lease is always considered expired.
 		payload.Lease = &jobspb.Lease{}
@@ -252,6 +261,8 @@ func (j *Job) canceled(
 			}
 			return false, fmt.Errorf("job with status %s cannot be canceled", *status)
 		}
+		// Clear the reason the job was paused for, if it was.
+		payload.PauseReason = ""
 		*status = StatusCanceled
 		if fn != nil {
 			if err := fn(ctx, txn, j); err != nil {
@@ -318,6 +329,33 @@ func (j *Job) SetDetails(ctx context.Context, details interface{}) error {
 	})
 }
 
+// UpdatePaused updates the payload and progress of the tracked job, which
+// must be paused, with updateFn. It's used to alter the configuration of a
+// job, such as its description, the descriptors it refers to and its details,
+// along with where it resumes from, without racing with it running.
+func (j *Job) UpdatePaused(
+	ctx context.Context, updateFn func(*jobspb.Payload, *jobspb.Progress) error,
+) error {
+	return j.update(ctx, func(_ *client.Txn, status *Status, payload *jobspb.Payload, progress *jobspb.Progress) (bool, error) {
+		if *status != StatusPaused {
+			return false, errors.Errorf("job %d must be paused to be altered, but its status is %s", *j.id, *status)
+		}
+		if err := updateFn(payload, progress); err != nil {
+			return false, err
+		}
+		return true, nil
+	})
+}
+
+// SetOwner sets the user the tracked job runs as, which, along with the
+// members of that user if it's a role, is allowed to control the job.
+func (j *Job) SetOwner(ctx context.Context, owner string) error {
+	return j.update(ctx, func(_ *client.Txn, _ *Status, payload *jobspb.Payload, _ *jobspb.Progress) (bool, error) {
+		payload.Username = owner
+		return true, nil
+	})
+}
+
 // SetProgress sets the details field of the currently running tracked job.
 func (j *Job) SetProgress(ctx context.Context, details interface{}) error {
 	return j.updateRow(ctx, updateProgressOnly,
@@ -546,6 +584,18 @@ func (j *Job) adopt(ctx context.Context, oldLease *jobspb.Lease) error {
 	})
 }
 
+// releaseLease gives up the lease of the job, if it's still the given one, so
+// that another node adopts it.
+func (j *Job) releaseLease(ctx context.Context, lease *jobspb.Lease) error {
+	return j.update(ctx, func(_ *client.Txn, _ *Status, payload *jobspb.Payload, _ *jobspb.Progress) (bool, error) {
+		if !payload.Lease.Equal(lease) {
+			return false, nil
+		}
+		payload.Lease = &jobspb.Lease{}
+		return true, nil
+	})
+}
+
 // UnmarshalPayload unmarshals and returns the Payload encoded in the input
 // datum, which should be a tree.DBytes.
 func UnmarshalPayload(datum tree.Datum) (*jobspb.Payload, error) {
@@ -810,6 +810,71 @@ func TestJobLifecycle(t *testing.T) {
 		}
 	})
 
+	t.Run("paused jobs show the reason they were paused for", func(t *testing.T) {
+		job, exp := startLeasedJob(t, defaultRecord)
+		reason := func() string {
+			var reason, jobErr string
+			if err := sqlDB.QueryRow(
+				`SELECT pause_reason, error FROM crdb_internal.jobs WHERE id = $1`, *job.ID(),
+			).Scan(&reason, &jobErr); err != nil {
+				t.Fatal(err)
+			}
+			// The reason is kept apart from the error of the job.
+			if jobErr != "" {
+				t.Fatalf("expected no error, got %q", jobErr)
+			}
+			return reason
+		}
+
+		if _, err := sqlDB.Exec(
+			`PAUSE JOB $1 WITH REASON = 'maintenance'`, *job.ID(),
+		); err != nil {
+			t.Fatal(err)
+		}
+		if err := exp.verify(job.ID(), jobs.StatusPaused); err != nil {
+			t.Fatal(err)
+		}
+		if e, a := "maintenance", reason(); e != a {
+			t.Fatalf("expected reason %q, got %q", e, a)
+		}
+		if err := registry.PauseWithReason(ctx, nil, *job.ID(), "upgrade"); err != nil {
+			t.Fatal(err)
+		}
+		if e, a := "upgrade", reason(); e != a {
+			t.Fatalf("expected reason %q, got %q", e, a)
+		}
+		if _, err := sqlDB.Exec(
+			`RESUME JOB $1 WITH REASON = 'done'`, *job.ID(),
+		); !testutils.IsError(err, "syntax error") {
+			t.Fatalf("expected syntax error, but got '%v'", err)
+		}
+		if err := registry.Resume(ctx, nil, *job.ID()); err != nil {
+			t.Fatal(err)
+		}
+		if e, a := "", reason(); e != a {
+			t.Fatalf("expected no reason, got %q", a)
+		}
+
+		// The reason is cleared when a paused job is canceled.
+		if err := registry.PauseWithReason(ctx, nil, *job.ID(), "maintenance"); err != nil {
+			t.Fatal(err)
+		}
+		if err := registry.Cancel(ctx, nil, *job.ID()); err != nil {
+			t.Fatal(err)
+		}
+		if err := exp.verify(job.ID(), jobs.StatusCanceled); err != nil {
+			t.Fatal(err)
+		}
+		if e, a := "", reason(); e != a {
+			t.Fatalf("expected no reason, got %q", a)
+		}
+		if err := registry.Resume(ctx, nil, *job.ID()); !testutils.IsError(
+			err, "job with status canceled cannot be resumed",
+		) {
+			t.Fatalf("expected 'cannot be resumed' error, but got '%v'", err)
+		}
+	})
+
 	t.Run("cancelable jobs can be canceled until finished", func(t *testing.T) {
 		{
 			job, exp := startLeasedJob(t, defaultRecord)
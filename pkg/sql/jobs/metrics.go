@@ -0,0 +1,44 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package jobs
+
+import (
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+)
+
+// Metrics are the metrics of the jobs run by a Registry. Job implementations
+// that live in other packages, like changefeeds, register theirs with a hook.
+type Metrics struct {
+	Changefeed metric.Struct
+}
+
+// MetricStruct implements the metric.Struct interface.
+func (Metrics) MetricStruct() {}
+
+// MakeChangefeedMetricsHook, if set, is called by MakeRegistry to create the
+// metrics for the changefeeds run on this node. It's set by the changefeedccl
+// package, which can't be depended on here.
+var MakeChangefeedMetricsHook func(*cluster.Settings, time.Duration) metric.Struct
+
+func makeMetrics(st *cluster.Settings, histogramWindowInterval time.Duration) Metrics {
+	var m Metrics
+	if MakeChangefeedMetricsHook != nil {
+		m.Changefeed = MakeChangefeedMetricsHook(st, histogramWindowInterval)
+	}
+	return m
+}
@@ -17,6 +17,7 @@ package jobs
 import (
 	"context"
 	"fmt"
+	"math"
 	"strings"
 	"time"
 
@@ -31,12 +32,15 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/sql/jobs/jobspb"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/builtins"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
 	"github.com/cockroachdb/cockroach/pkg/sql/sqlutil"
 	"github.com/cockroachdb/cockroach/pkg/storage"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/stop"
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/cockroach/pkg/util/tracing"
 )
 
 const defaultLeniencySetting = 60 * time.Second
@@ -49,8 +53,27 @@ var (
 		"jobs.registry.leniency",
 		"the amount of time to defer any attempts to reschedule a job",
 		defaultLeniencySetting)
+
+	// debugPausepoints is the pausepoints at which jobs pause themselves, see
+	// CheckPausepoint.
+	debugPausepoints = settings.RegisterStringSetting(
+		"jobs.debug.pausepoints",
+		"comma-separated names of the points in job execution at which jobs pause themselves",
+		"")
+
+	// retentionTime is how long the records of finished jobs are kept in
+	// system.jobs, see CleanupOldJobs.
+	retentionTime = settings.RegisterNonNegativeDurationSetting(
+		"jobs.retention_time",
+		"the amount of time to retain records of succeeded, failed and canceled jobs, "+
+			"or 0 to retain them forever",
+		14*24*time.Hour)
 )
 
+func init() {
+	debugPausepoints.Hide()
+}
+
 // NodeLiveness is the subset of storage.NodeLiveness's interface needed
 // by Registry.
 type NodeLiveness interface {
@@ -89,8 +112,10 @@ type Registry struct {
 	ex       sqlutil.InternalExecutor
 	clock    *hlc.Clock
 	nodeID   *base.NodeIDContainer
+	locality roachpb.Locality
 	settings *cluster.Settings
 	planFn   planHookMaker
+	metrics  Metrics
 
 	mu struct {
 		syncutil.Mutex
@@ -107,6 +132,9 @@ type Registry struct {
 		// propagated to jobs via the .Progressed call. This function should not be
 		// used to cancel a job in that way.
 		jobs map[int64]context.CancelFunc
+		// running holds the jobs being resumed by this registry, so that they
+		// can be traced, see TraceJob.
+		running map[int64]*runningJob
 	}
 }
 
@@ -126,14 +154,17 @@ type planHookMaker func(opName, user string) (interface{}, func())
 
 // MakeRegistry creates a new Registry. planFn is a wrapper around
 // sql.newInternalPlanner. It returns a sql.PlanHookState, but must be
-// coerced into that in the Resumer functions.
+// coerced into that in the Resumer functions. histogramWindowInterval is the
+// window of the histograms in the metrics of the jobs.
 func MakeRegistry(
 	ac log.AmbientContext,
 	clock *hlc.Clock,
 	db *client.DB,
 	ex sqlutil.InternalExecutor,
 	nodeID *base.NodeIDContainer,
+	locality roachpb.Locality,
 	settings *cluster.Settings,
+	histogramWindowInterval time.Duration,
 	planFn planHookMaker,
 ) *Registry {
 	r := &Registry{
@@ -142,14 +173,23 @@ func MakeRegistry(
 		db:       db,
 		ex:       ex,
 		nodeID:   nodeID,
+		locality: locality,
 		settings: settings,
 		planFn:   planFn,
+		metrics:  makeMetrics(settings, histogramWindowInterval),
 	}
 	r.mu.epoch = 1
 	r.mu.jobs = make(map[int64]context.CancelFunc)
+	r.mu.running = make(map[int64]*runningJob)
 	return r
 }
 
+// MetricsStruct returns the metrics of the jobs run by this registry, for
+// registration in the node's metric.Registry.
+func (r *Registry) MetricsStruct() *Metrics {
+	return &r.metrics
+}
+
 // lenientNow returns the timestamp after which we should attempt
 // to steal a job from a node whose liveness is failing.  This allows
 // jobs coordinated by a node which is temporarily saturated to continue.
@@ -206,6 +246,54 @@ func (r *Registry) StartJob(
 	return j, errCh, nil
 }
 
+// ExecutionLocalityHook, if set, returns the tiers of locality that a node
+// must have to run a job, or nil if any node may run it. It's set by the
+// changefeedccl package, for changefeeds with the `execution_locality`
+// option.
+var ExecutionLocalityHook func(*jobspb.Payload) []roachpb.Tier
+
+// mayRun returns whether this node may run the job with the given payload,
+// see ExecutionLocalityHook.
+func (r *Registry) mayRun(payload *jobspb.Payload) bool {
+	if ExecutionLocalityHook == nil {
+		return true
+	}
+	for _, required := range ExecutionLocalityHook(payload) {
+		found := false
+		for _, tier := range r.locality.Tiers {
+			if tier == required {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// MayRun returns whether this node may run the job of the given record, see
+// ExecutionLocalityHook. A job that it can't run should be created with
+// CreateAdoptableJob instead of being started.
+func (r *Registry) MayRun(record Record) bool {
+	return r.mayRun(&jobspb.Payload{Details: jobspb.WrapPayloadDetails(record.Details)})
+}
+
+// CreateAdoptableJob creates a running job without running it, so that it's
+// adopted by the registry of a node that may run it. Its lease is empty,
+// which no node holds, so it's adopted as if the node running it had died.
+func (r *Registry) CreateAdoptableJob(ctx context.Context, record Record) (*Job, error) {
+	j := r.NewJob(record)
+	if err := j.insert(ctx, r.makeJobID(), &jobspb.Lease{}); err != nil {
+		return nil, err
+	}
+	if err := j.Started(ctx); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
 // NewJob creates a new Job.
 func (r *Registry) NewJob(record Record) *Job {
 	job := &Job{
@@ -254,8 +342,14 @@ var DefaultCancelInterval = base.DefaultHeartbeatInterval
 // Registry.Start has been called will not have any effect.
 var DefaultAdoptInterval = 30 * time.Second
 
+// DefaultGCInterval is a reasonable interval at which to delete the records of
+// jobs that finished longer than jobs.retention_time ago.
+var DefaultGCInterval = time.Hour
+
 // Start polls the current node for liveness failures and cancels all registered
-// jobs if it observes a failure.
+// jobs if it observes a failure. It also adopts the jobs whose leases have
+// expired, and deletes the records of old finished jobs every
+// DefaultGCInterval.
 func (r *Registry) Start(
 	ctx context.Context,
 	stopper *stop.Stopper,
@@ -289,6 +383,77 @@ func (r *Registry) Start(
 			}
 		}
 	})
+
+	stopper.RunWorker(context.Background(), func(ctx context.Context) {
+		for {
+			select {
+			case <-time.After(DefaultGCInterval):
+				retention := retentionTime.Get(&r.settings.SV)
+				if retention == 0 {
+					continue
+				}
+				if err := r.CleanupOldJobs(ctx, r.clock.PhysicalTime().Add(-retention)); err != nil {
+					log.Warningf(ctx, "error while cleaning up old jobs: %s", err)
+				}
+			case <-stopper.ShouldStop():
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// cleanupBatchSize is how many job records CleanupOldJobs looks at at once.
+const cleanupBatchSize = 1000
+
+// CleanupOldJobs deletes the records of the jobs that succeeded, failed or
+// were canceled before olderThan, so that system.jobs doesn't grow forever on
+// clusters that run many short jobs, like recurring exports. Every node
+// cleans up periodically, see Start, and it doesn't matter if two of them do
+// at once. Visible for testing.
+func (r *Registry) CleanupOldJobs(ctx context.Context, olderThan time.Time) error {
+	const stmt = `SELECT id, payload FROM system.jobs
+		WHERE status IN ($1, $2, $3) AND created < $4 AND id > $5 ORDER BY id LIMIT $6`
+	olderThanMicros := timeutil.ToUnixMicros(olderThan)
+	var deleted int
+	for after := int64(math.MinInt64); ; {
+		rows, _ /* cols */, err := r.ex.Query(
+			ctx, "gc-jobs", nil /* txn */, stmt, StatusSucceeded, StatusFailed, StatusCanceled,
+			olderThan, after, cleanupBatchSize,
+		)
+		if err != nil {
+			return err
+		}
+		toDelete := tree.NewDArray(types.Int)
+		for _, row := range rows {
+			id := row[0].(*tree.DInt)
+			after = int64(*id)
+			payload, err := UnmarshalPayload(row[1])
+			if err != nil {
+				return err
+			}
+			if payload.FinishedMicros < olderThanMicros {
+				if err := toDelete.Append(id); err != nil {
+					return err
+				}
+			}
+		}
+		if len(toDelete.Array) > 0 {
+			n, err := r.ex.Exec(
+				ctx, "gc-jobs", nil /* txn */, `DELETE FROM system.jobs WHERE id = ANY($1)`, toDelete,
+			)
+			if err != nil {
+				return err
+			}
+			deleted += n
+		}
+		if len(rows) < cleanupBatchSize {
+			break
+		}
+	}
+	if deleted > 0 {
+		log.Infof(ctx, "cleaned up %d job records that finished before %s", deleted, olderThan)
+	}
 	return nil
 }
 
@@ -358,11 +523,43 @@ func (r *Registry) Cancel(ctx context.Context, txn *client.Txn, id int64) error
 
 // Pause marks the job with id as paused using the specified txn (may be nil).
 func (r *Registry) Pause(ctx context.Context, txn *client.Txn, id int64) error {
+	return r.PauseWithReason(ctx, txn, id, "")
+}
+
+// PauseWithReason is like Pause, but also records why the job was paused,
+// which is shown in the pause_reason column of SHOW JOBS until the job is
+// resumed or canceled.
+func (r *Registry) PauseWithReason(
+	ctx context.Context, txn *client.Txn, id int64, reason string,
+) error {
 	job, _, err := r.getJobFn(ctx, txn, id)
 	if err != nil {
 		return err
 	}
-	return job.WithTxn(txn).paused(ctx)
+	return job.WithTxn(txn).paused(ctx, reason)
+}
+
+// CheckPausepoint is called by the running job with id when it reaches the
+// point in its execution with the given name, like `changefeed.before_flush`.
+// If the name is one of the comma-separated jobs.debug.pausepoints, the job is
+// paused, with the pausepoint as the reason, and the returned error stops it
+// just as if it had been paused by PAUSE JOB right then. This lets tests pause
+// a job at a known point instead of racing PAUSE JOB against its progress. The
+// pausepoint should be cleared before the job is resumed, or it pauses again
+// as soon as it gets back there.
+func (r *Registry) CheckPausepoint(ctx context.Context, id int64, name string) error {
+	for _, p := range strings.Split(debugPausepoints.Get(&r.settings.SV), ",") {
+		if strings.TrimSpace(p) != name {
+			continue
+		}
+		reason := fmt.Sprintf("pausepoint %s", name)
+		log.Infof(ctx, "job %d: pausing at %s", id, reason)
+		if err := r.PauseWithReason(ctx, nil /* txn */, id, reason); err != nil {
+			return err
+		}
+		return &InvalidStatusError{id, StatusPaused, "continue", reason}
+	}
+	return nil
 }
 
 // Resume resumes the paused job with id using the specified txn (may be nil).
@@ -454,8 +651,17 @@ func (r *Registry) resume(
 		defer cleanup()
 		spanName := fmt.Sprintf(`%s-%d`, payload.Type(), *job.ID())
 		var span opentracing.Span
-		ctx, span = r.ac.AnnotateCtxWithSpan(ctx, spanName)
+		ctx = r.ac.AnnotateCtx(ctx)
+		// The span is recordable so that the job can be traced while it runs.
+		if parentSp := opentracing.SpanFromContext(ctx); parentSp != nil {
+			span = parentSp.Tracer().StartSpan(
+				spanName, opentracing.ChildOf(parentSp.Context()), tracing.Recordable)
+		} else {
+			span = r.ac.Tracer.StartSpan(spanName, tracing.Recordable)
+		}
+		ctx = opentracing.ContextWithSpan(ctx, span)
 		defer span.Finish()
+		defer r.trackRunning(*job.ID(), span, resumer)()
 		resumeErr := resumer.Resume(ctx, job, phs, resultsCh)
 		if resumeErr != nil && ctx.Err() != nil {
 			// The context was canceled. Tell the user, but don't attempt to mark the
@@ -577,6 +783,24 @@ func (r *Registry) maybeAdoptJob(ctx context.Context, nl NodeLiveness) error {
 		_, running := r.mu.jobs[*id]
 		r.mu.Unlock()
 
+		if !r.mayRun(payload) {
+			// If this node holds the lease of a job it may not run, it gives
+			// the lease up for a node that may to adopt the job.
+			if payload.Lease.NodeID == r.nodeID.Get() {
+				if running {
+					r.unregister(*id)
+				}
+				job := Job{id: id, registry: r}
+				if err := job.releaseLease(ctx, payload.Lease); err != nil {
+					return errors.Wrap(err, "unable to release lease")
+				}
+			}
+			if log.V(2) {
+				log.Infof(ctx, "job %d: skipping: not allowed to run on this node", *id)
+			}
+			continue
+		}
+
 		var needsResume bool
 		if payload.Lease.NodeID == r.nodeID.Get() {
 			// If we hold the lease for a job, check to see if we're actually running
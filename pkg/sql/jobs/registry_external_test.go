@@ -16,8 +16,11 @@ package jobs_test
 
 import (
 	"context"
+	"fmt"
 	"math"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -26,6 +29,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/sql/jobs"
 	"github.com/cockroachdb/cockroach/pkg/sql/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/sqlbase"
 	"github.com/cockroachdb/cockroach/pkg/sql/sqlutil"
 	"github.com/cockroachdb/cockroach/pkg/testutils"
@@ -34,8 +38,10 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
 	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/tracing"
 	"github.com/pkg/errors"
 )
@@ -91,7 +97,7 @@ func TestRegistryResumeExpiredLease(t *testing.T) {
 		nodeID.Reset(id)
 		r := jobs.MakeRegistry(
 			ac, clock, db, s.InternalExecutor().(sqlutil.InternalExecutor),
-			nodeID, s.ClusterSettings(), jobs.FakePHS,
+			nodeID, roachpb.Locality{}, s.ClusterSettings(), metric.TestSampleInterval, jobs.FakePHS,
 		)
 		if err := r.Start(ctx, s.Stopper(), nodeLiveness, cancelInterval, adoptInterval); err != nil {
 			t.Fatal(err)
@@ -270,3 +276,161 @@ func TestRegistryResumeActiveLease(t *testing.T) {
 		t.Fatalf("expected job %d to be resumed, but got %d", e, a)
 	}
 }
+
+// tracedResumer is a resumer that logs an event every millisecond until it's
+// done, and reports how many events it logged as its state.
+type tracedResumer struct {
+	jobs.FakeResumer
+	resumeCh chan<- int64
+	doneCh   <-chan struct{}
+	events   *int64
+}
+
+func (r tracedResumer) Resume(
+	ctx context.Context, job *jobs.Job, _ interface{}, _ chan<- tree.Datums,
+) error {
+	r.resumeCh <- *job.ID()
+	for {
+		select {
+		case <-r.doneCh:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Millisecond):
+		}
+		log.Eventf(ctx, "traced event %d", atomic.AddInt64(r.events, 1))
+	}
+}
+
+func (r tracedResumer) ReportState() interface{} {
+	return map[string]int64{"events": atomic.LoadInt64(r.events)}
+}
+
+func TestRegistryTraceJob(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	defer func(oldInterval time.Duration) {
+		jobs.DefaultAdoptInterval = oldInterval
+	}(jobs.DefaultAdoptInterval)
+	jobs.DefaultAdoptInterval = 100 * time.Millisecond
+
+	resumeCh := make(chan int64)
+	doneCh := make(chan struct{})
+	var events int64
+	defer jobs.ResetResumeHooks()()
+	jobs.AddResumeHook(func(_ jobspb.Type, _ *cluster.Settings) jobs.Resumer {
+		return tracedResumer{resumeCh: resumeCh, doneCh: doneCh, events: &events}
+	})
+
+	ctx := context.Background()
+	s, sqlDB, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(ctx)
+	registry := s.JobRegistry().(*jobs.Registry)
+
+	payload, err := protoutil.Marshal(&jobspb.Payload{
+		Lease:   &jobspb.Lease{NodeID: 1, Epoch: 1},
+		Details: jobspb.WrapPayloadDetails(jobspb.BackupDetails{}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress, err := protoutil.Marshal(&jobspb.Progress{
+		Details: jobspb.WrapProgressDetails(jobspb.BackupProgress{}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id int64
+	sqlutils.MakeSQLRunner(sqlDB).QueryRow(t,
+		`INSERT INTO system.jobs (status, payload, progress) VALUES ($1, $2, $3) RETURNING id`,
+		jobs.StatusRunning, payload, progress).Scan(&id)
+	if e, a := id, <-resumeCh; e != a {
+		t.Fatalf("expected job %d to be resumed, but got %d", e, a)
+	}
+	defer close(doneCh)
+
+	if _, err := registry.TraceJob(ctx, id+1, time.Millisecond); !testutils.IsError(
+		err, fmt.Sprintf("job %d is not running on node 1", id+1),
+	) {
+		t.Fatalf("expected 'not running' error, got %v", err)
+	}
+
+	trace, err := registry.TraceJob(ctx, id, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Spans) == 0 {
+		t.Fatal("expected the job's span to be recorded")
+	}
+	if op := trace.Spans[0].Operation; !strings.HasSuffix(op, fmt.Sprintf("-%d", id)) {
+		t.Errorf("expected the span of job %d, got %s", id, op)
+	}
+	formatted := tracing.FormatRecordedSpans(trace.Spans)
+	if !strings.Contains(formatted, "traced event") {
+		t.Errorf("expected the job's events in its trace, got:\n%s", formatted)
+	}
+	if state, ok := trace.State.(map[string]int64); !ok || state["events"] == 0 {
+		t.Errorf("expected the state reported by the job, got %v", trace.State)
+	}
+}
+
+func TestRegistryCleanupOldJobs(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := context.Background()
+	s, rawSQLDB, _ := serverutils.StartServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(ctx)
+	registry := s.JobRegistry().(*jobs.Registry)
+	sqlDB := sqlutils.MakeSQLRunner(rawSQLDB)
+
+	newJob := func() *jobs.Job {
+		t.Helper()
+		job := registry.NewJob(jobs.Record{
+			Description: "cleanup",
+			Username:    "robot",
+			Details:     jobspb.RestoreDetails{},
+			Progress:    jobspb.RestoreProgress{},
+		})
+		if err := job.Created(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := job.Started(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return job
+	}
+	succeeded, failed, running := newJob(), newJob(), newJob()
+	if err := succeeded.Succeeded(ctx, jobs.NoopFn); err != nil {
+		t.Fatal(err)
+	}
+	if err := failed.Failed(ctx, errors.New("boom"), jobs.NoopFn); err != nil {
+		t.Fatal(err)
+	}
+
+	const query = `SELECT id FROM system.jobs WHERE id IN ($1, $2, $3) ORDER BY id`
+	expectJobs := func(expected ...*jobs.Job) {
+		t.Helper()
+		var rows [][]string
+		for _, job := range expected {
+			rows = append(rows, []string{fmt.Sprint(*job.ID())})
+		}
+		actual := sqlDB.QueryStr(t, query, *succeeded.ID(), *failed.ID(), *running.ID())
+		if !reflect.DeepEqual(rows, actual) {
+			t.Fatalf("expected jobs %v got %v", rows, actual)
+		}
+	}
+	cleanup := func(olderThan time.Time) {
+		t.Helper()
+		if err := registry.CleanupOldJobs(ctx, olderThan); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Nothing finished before the jobs were created.
+	cleanup(timeutil.Now().Add(-time.Hour))
+	expectJobs(succeeded, failed, running)
+
+	// The finished jobs are deleted, but not the running one.
+	cleanup(timeutil.Now().Add(time.Hour))
+	expectJobs(running)
+}
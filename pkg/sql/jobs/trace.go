@@ -0,0 +1,109 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package jobs
+
+import (
+	"context"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+
+	"github.com/cockroachdb/cockroach/pkg/util/tracing"
+)
+
+// StateReporter is implemented by the Resumers that can report the state of
+// the job they're running, such as how far along it is and what it's waiting
+// on, to be included in its trace.
+type StateReporter interface {
+	// ReportState returns the state of the job, which must be marshalable as
+	// JSON. It's called concurrently with Resume.
+	ReportState() interface{}
+}
+
+// JobTrace is what's collected by TraceJob about a job.
+type JobTrace struct {
+	// Spans are the spans recorded while the job was traced.
+	Spans []tracing.RecordedSpan
+	// State is the state reported by the job's Resumer at the end of the
+	// trace, or nil if it isn't a StateReporter.
+	State interface{}
+}
+
+// runningJob is a job being resumed by a registry.
+type runningJob struct {
+	span    opentracing.Span
+	resumer Resumer
+	// tracing is set while the job is being traced, and is protected by the
+	// registry's mutex.
+	tracing bool
+}
+
+// trackRunning records that the job with the given ID is being resumed with
+// the given span and resumer, until the returned func is called.
+func (r *Registry) trackRunning(id int64, span opentracing.Span, resumer Resumer) func() {
+	job := &runningJob{span: span, resumer: resumer}
+	r.mu.Lock()
+	r.mu.running[id] = job
+	r.mu.Unlock()
+	return func() {
+		r.mu.Lock()
+		if r.mu.running[id] == job {
+			delete(r.mu.running, id)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// TraceJob records the trace of the job with the given ID for the given
+// duration, which must be running on this node. The trace has the events of
+// the job and of the operations it starts during that time, including the ones
+// done remotely, such as its KV requests, along with the state its Resumer
+// reports at the end, if it's a StateReporter. A job can only be traced by one
+// caller at a time.
+func (r *Registry) TraceJob(ctx context.Context, id int64, d time.Duration) (JobTrace, error) {
+	r.mu.Lock()
+	job, ok := r.mu.running[id]
+	busy := ok && job.tracing
+	if ok {
+		job.tracing = true
+	}
+	r.mu.Unlock()
+	if !ok {
+		return JobTrace{}, errors.Errorf("job %d is not running on node %d", id, r.nodeID.Get())
+	}
+	if busy {
+		return JobTrace{}, errors.Errorf("job %d is already being traced", id)
+	}
+	defer func() {
+		r.mu.Lock()
+		job.tracing = false
+		r.mu.Unlock()
+	}()
+
+	tracing.StartRecording(job.span, tracing.SnowballRecording)
+	defer tracing.StopRecording(job.span)
+	select {
+	case <-ctx.Done():
+		return JobTrace{}, ctx.Err()
+	case <-time.After(d):
+	}
+
+	trace := JobTrace{Spans: tracing.GetRecording(job.span)}
+	if reporter, ok := job.resumer.(StateReporter); ok {
+		trace.State = reporter.ReportState()
+	}
+	return trace, nil
+}
@@ -201,6 +201,7 @@ func (p *planner) applyLimit(plan planNode, numRows int64, soft bool) {
 	case *alterIndexNode:
 	case *alterTableNode:
 	case *alterSequenceNode:
+	case *alterJobOwnerNode:
 	case *alterUserSetPasswordNode:
 	case *scrubNode:
 	case *createDatabaseNode:
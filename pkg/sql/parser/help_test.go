@@ -70,6 +70,9 @@ func TestContextualHelp(t *testing.T) {
 		{`ALTER USER IF ??`, `ALTER USER`},
 		{`ALTER USER foo WITH PASSWORD ??`, `ALTER USER`},
 
+		{`ALTER JOB ??`, `ALTER JOB`},
+		{`ALTER JOB 123 OWNER TO ??`, `ALTER JOB`},
+
 		{`CANCEL ??`, `CANCEL`},
 		{`CANCEL JOB ??`, `CANCEL JOBS`},
 		{`CANCEL JOBS ??`, `CANCEL JOBS`},
@@ -205,8 +208,10 @@ func TestContextualHelp(t *testing.T) {
 		{`GRANT ALL ON foo TO bar ??`, `GRANT`},
 
 		{`PAUSE ??`, `PAUSE JOBS`},
+		{`PAUSE ALL CHANGEFEED ??`, `PAUSE JOBS`},
 
 		{`RESUME ??`, `RESUME JOBS`},
+		{`RESUME ALL CHANGEFEED ??`, `RESUME JOBS`},
 
 		{`REVOKE ALL ??`, `REVOKE`},
 		{`REVOKE ALL ON foo FROM ??`, `REVOKE`},
@@ -320,6 +320,10 @@ func TestParse(t *testing.T) {
 		{`CANCEL SESSIONS IF EXISTS SELECT a`},
 		{`RESUME JOBS SELECT a`},
 		{`PAUSE JOBS SELECT a`},
+		{`PAUSE JOBS SELECT a WITH REASON = 'maintenance'`},
+		{`PAUSE ALL CHANGEFEED JOBS`},
+		{`PAUSE ALL CHANGEFEED JOBS WITH REASON = 'maintenance'`},
+		{`RESUME ALL CHANGEFEED JOBS`},
 
 		{`EXPLAIN SELECT 1`},
 		{`EXPLAIN EXPLAIN SELECT 1`},
@@ -427,7 +431,9 @@ func TestParse(t *testing.T) {
 		{`PREPARE a (INT) AS CANCEL JOBS SELECT $1`},
 		{`PREPARE a AS PAUSE JOBS SELECT 1`},
 		{`PREPARE a (INT) AS PAUSE JOBS SELECT $1`},
+		{`PREPARE a (INT, STRING) AS PAUSE JOBS SELECT $1 WITH REASON = $2`},
 		{`PREPARE a AS RESUME JOBS SELECT 1`},
+		{`PREPARE a (STRING) AS PAUSE ALL CHANGEFEED JOBS WITH REASON = $1`},
 		{`PREPARE a (INT) AS RESUME JOBS SELECT $1`},
 		{`PREPARE a AS IMPORT TABLE a CREATE USING 'b' CSV DATA ('c') WITH temp = 'd'`},
 		{`PREPARE a (STRING, STRING, STRING) AS IMPORT TABLE a CREATE USING $1 CSV DATA ($2) WITH temp = $3`},
@@ -1021,7 +1027,19 @@ func TestParse(t *testing.T) {
 		// {`CREATE CHANGEFEED FOR TABLE foo VALUES FROM (1) TO (2) INTO 'sink'`},
 		// {`CREATE CHANGEFEED FOR TABLE foo PARTITION bar, baz INTO 'sink'`},
 		{`CREATE CHANGEFEED FOR DATABASE foo INTO 'sink'`},
+		{`CREATE CHANGEFEED FOR ALL TABLES INTO 'sink'`},
 		{`CREATE CHANGEFEED FOR TABLE foo INTO 'sink' WITH bar = 'baz'`},
+		{`CREATE SCHEDULE FOR CHANGEFEED TABLE foo INTO 'sink' WITH initial_scan = 'only' RECURRING '@daily'`},
+		{`EXPLAIN CREATE CHANGEFEED FOR TABLE foo INTO 'sink'`},
+		{`ALTER CHANGEFEED 123 ADD TABLE foo`},
+		{`ALTER CHANGEFEED 123 DROP TABLE foo, bar`},
+		{`ALTER CHANGEFEED $1 ADD TABLE foo DROP TABLE bar`},
+		{`ALTER JOB 123 OWNER TO foo`},
+		{`ALTER JOB $1 OWNER TO "my role"`},
+
+		{`CREATE EXTERNAL CONNECTION foo AS 'kafka://bar'`},
+		{`DROP EXTERNAL CONNECTION foo`},
+		{`DROP EXTERNAL CONNECTION IF EXISTS foo`},
 
 		// Regression for #15926
 		{`SELECT * FROM ((t1 NATURAL JOIN t2 WITH ORDINALITY AS o1)) WITH ORDINALITY AS o2`},
@@ -1378,6 +1396,8 @@ func TestParse2(t *testing.T) {
 		{`CANCEL JOB a`, `CANCEL JOBS VALUES (a)`},
 		{`RESUME JOB a`, `RESUME JOBS VALUES (a)`},
 		{`PAUSE JOB a`, `PAUSE JOBS VALUES (a)`},
+		{`PAUSE ALL changefeed JOBS`, `PAUSE ALL CHANGEFEED JOBS`},
+		{`PAUSE JOB a WITH REASON = 'maintenance'`, `PAUSE JOBS VALUES (a) WITH REASON = 'maintenance'`},
 		{`CANCEL QUERY a`, `CANCEL QUERIES VALUES (a)`},
 		{`CANCEL QUERY IF EXISTS a`, `CANCEL QUERIES IF EXISTS VALUES (a)`},
 		{`CANCEL SESSION a`, `CANCEL SESSIONS VALUES (a)`},
@@ -336,6 +336,12 @@ func (u *sqlSymUnion) alterTableCmd() tree.AlterTableCmd {
 func (u *sqlSymUnion) alterTableCmds() tree.AlterTableCmds {
     return u.val.(tree.AlterTableCmds)
 }
+func (u *sqlSymUnion) alterChangefeedCmd() tree.AlterChangefeedCmd {
+    return u.val.(tree.AlterChangefeedCmd)
+}
+func (u *sqlSymUnion) alterChangefeedCmds() tree.AlterChangefeedCmds {
+    return u.val.(tree.AlterChangefeedCmds)
+}
 func (u *sqlSymUnion) alterIndexCmd() tree.AlterIndexCmd {
     return u.val.(tree.AlterIndexCmd)
 }
@@ -472,7 +478,7 @@ func newNameFromStr(s string) *tree.Name {
 %token <str> CHARACTER CHARACTERISTICS CHECK
 %token <str> CLUSTER COALESCE COLLATE COLLATION COLUMN COLUMNS COMMENT COMMIT
 %token <str> COMMITTED COMPACT CONCAT CONFIGURATION CONFIGURATIONS CONFIGURE
-%token <str> CONFLICT CONSTRAINT CONSTRAINTS CONTAINS COPY COVERING CREATE
+%token <str> CONFLICT CONNECTION CONSTRAINT CONSTRAINTS CONTAINS COPY COVERING CREATE
 %token <str> CROSS CUBE CURRENT CURRENT_CATALOG CURRENT_DATE CURRENT_SCHEMA
 %token <str> CURRENT_ROLE CURRENT_TIME CURRENT_TIMESTAMP
 %token <str> CURRENT_USER CYCLE
@@ -485,7 +491,7 @@ func newNameFromStr(s string) *tree.Name {
 %token <str> EXISTS EXECUTE EXPERIMENTAL
 %token <str> EXPERIMENTAL_FINGERPRINTS EXPERIMENTAL_REPLICA
 %token <str> EXPERIMENTAL_AUDIT
-%token <str> EXPLAIN EXPORT EXTRACT EXTRACT_DURATION
+%token <str> EXPLAIN EXPORT EXTERNAL EXTRACT EXTRACT_DURATION
 
 %token <str> FALSE FAMILY FETCH FETCHVAL FETCHTEXT FETCHVAL_PATH FETCHTEXT_PATH
 %token <str> FILES FILTER
@@ -516,20 +522,20 @@ func newNameFromStr(s string) *tree.Name {
 %token <str> NULLS NUMERIC
 
 %token <str> OF OFF OFFSET OID OIDVECTOR ON ONLY OPTION OPTIONS OR
-%token <str> ORDER ORDINALITY OUT OUTER OVER OVERLAPS OVERLAY OWNED
+%token <str> ORDER ORDINALITY OUT OUTER OVER OVERLAPS OVERLAY OWNED OWNER
 
 %token <str> PARENT PARTIAL PARTITION PASSWORD PAUSE PHYSICAL PLACING
 %token <str> PLANS POSITION PRECEDING PRECISION PREPARE PRIMARY PRIORITY
 
 %token <str> QUERIES QUERY
 
-%token <str> RANGE RANGES READ REAL RECURSIVE REF REFERENCES
+%token <str> RANGE RANGES READ REAL REASON RECURRING RECURSIVE REF REFERENCES
 %token <str> REGCLASS REGPROC REGPROCEDURE REGNAMESPACE REGTYPE
 %token <str> REMOVE_PATH RENAME REPEATABLE
 %token <str> RELEASE RESET RESTORE RESTRICT RESUME RETURNING REVOKE RIGHT
 %token <str> ROLE ROLES ROLLBACK ROLLUP ROW ROWS RSHIFT
 
-%token <str> SAVEPOINT SCATTER SCHEMA SCHEMAS SCRUB SEARCH SECOND SELECT SEQUENCE SEQUENCES
+%token <str> SAVEPOINT SCATTER SCHEDULE SCHEMA SCHEMAS SCRUB SEARCH SECOND SELECT SEQUENCE SEQUENCES
 %token <str> SERIAL SERIAL2 SERIAL4 SERIAL8
 %token <str> SERIALIZABLE SESSION SESSIONS SESSION_USER SET SETTING SETTINGS
 %token <str> SHOW SIMILAR SIMPLE SMALLINT SMALLSERIAL SNAPSHOT SOME SPLIT SQL
@@ -582,6 +588,8 @@ func newNameFromStr(s string) *tree.Name {
 %type <tree.Statement> alter_sequence_stmt
 %type <tree.Statement> alter_database_stmt
 %type <tree.Statement> alter_user_stmt
+%type <tree.Statement> alter_changefeed_stmt
+%type <tree.Statement> alter_job_stmt
 %type <tree.Statement> alter_range_stmt
 
 // ALTER RANGE
@@ -649,6 +657,7 @@ func newNameFromStr(s string) *tree.Name {
 %type <tree.Statement> create_user_stmt
 %type <tree.Statement> create_view_stmt
 %type <tree.Statement> create_changefeed_stmt
+%type <tree.Statement> create_external_connection_stmt
 %type <tree.Statement> create_sequence_stmt
 %type <tree.Statement> create_stats_stmt
 %type <tree.Statement> delete_stmt
@@ -656,6 +665,7 @@ func newNameFromStr(s string) *tree.Name {
 
 %type <tree.Statement> drop_stmt
 %type <tree.Statement> drop_ddl_stmt
+%type <tree.Statement> drop_external_connection_stmt
 %type <tree.Statement> drop_database_stmt
 %type <tree.Statement> drop_index_stmt
 %type <tree.Statement> drop_role_stmt
@@ -745,6 +755,8 @@ func newNameFromStr(s string) *tree.Name {
 
 %type <tree.AlterTableCmd> alter_table_cmd
 %type <tree.AlterTableCmds> alter_table_cmds
+%type <tree.AlterChangefeedCmd> alter_changefeed_cmd
+%type <tree.AlterChangefeedCmds> alter_changefeed_cmds
 %type <tree.AlterIndexCmd> alter_index_cmd
 %type <tree.AlterIndexCmds> alter_index_cmds
 
@@ -1091,10 +1103,12 @@ stmt:
 
 // %Help: ALTER
 // %Category: Group
-// %Text: ALTER TABLE, ALTER INDEX, ALTER VIEW, ALTER SEQUENCE, ALTER DATABASE, ALTER USER
+// %Text: ALTER TABLE, ALTER INDEX, ALTER VIEW, ALTER SEQUENCE, ALTER DATABASE, ALTER USER, ALTER JOB
 alter_stmt:
   alter_ddl_stmt      // help texts in sub-rule
 | alter_user_stmt     // EXTEND WITH HELP: ALTER USER
+| alter_changefeed_stmt
+| alter_job_stmt      // EXTEND WITH HELP: ALTER JOB
 | ALTER error         // SHOW HELP: ALTER
 
 alter_ddl_stmt:
@@ -1180,6 +1194,49 @@ alter_sequence_options_stmt:
     $$.val = &tree.AlterSequence{Name: $5.normalizableTableNameFromUnresolvedName(), Options: $6.seqOpts(), IfExists: true}
   }
 
+alter_changefeed_stmt:
+  ALTER CHANGEFEED a_expr alter_changefeed_cmds
+  {
+    $$.val = &tree.AlterChangefeed{
+      Jobs: $3.expr(),
+      Cmds: $4.alterChangefeedCmds(),
+    }
+  }
+
+alter_changefeed_cmds:
+  alter_changefeed_cmd
+  {
+    $$.val = tree.AlterChangefeedCmds{$1.alterChangefeedCmd()}
+  }
+| alter_changefeed_cmds alter_changefeed_cmd
+  {
+    $$.val = append($1.alterChangefeedCmds(), $2.alterChangefeedCmd())
+  }
+
+alter_changefeed_cmd:
+  // ALTER CHANGEFEED <job> ADD <targets>
+  ADD targets
+  {
+    $$.val = &tree.AlterChangefeedAddTarget{Targets: $2.targetList()}
+  }
+  // ALTER CHANGEFEED <job> DROP <targets>
+| DROP targets
+  {
+    $$.val = &tree.AlterChangefeedDropTarget{Targets: $2.targetList()}
+  }
+
+// %Help: ALTER JOB - change the owner of a job
+// %Category: Misc
+// %Text:
+// ALTER JOB <jobid> OWNER TO <name>
+// %SeeAlso: PAUSE JOBS, RESUME JOBS, CANCEL JOBS, SHOW JOBS
+alter_job_stmt:
+  ALTER JOB a_expr OWNER TO name
+  {
+    $$.val = &tree.AlterJobOwner{Job: $3.expr(), Owner: tree.Name($6)}
+  }
+| ALTER JOB error // SHOW HELP: ALTER JOB
+
 // %Help: ALTER USER - change user properties
 // %Category: Priv
 // %Text:
@@ -1904,6 +1961,7 @@ create_stmt:
 | create_role_stmt     // EXTEND WITH HELP: CREATE ROLE
 | create_ddl_stmt      // help texts in sub-rule
 | create_stats_stmt    // EXTEND WITH HELP: CREATE STATISTICS
+| create_external_connection_stmt
 | CREATE error         // SHOW HELP: CREATE
 
 create_ddl_stmt:
@@ -1943,6 +2001,23 @@ create_changefeed_stmt:
       Options: $6.kvOptions(),
     }
   }
+| CREATE CHANGEFEED FOR ALL TABLES opt_changefeed_sink opt_with_options
+  {
+    $$.val = &tree.CreateChangefeed{
+      AllTables: true,
+      SinkURI: $6.expr(),
+      Options: $7.kvOptions(),
+    }
+  }
+| CREATE SCHEDULE FOR CHANGEFEED targets INTO string_or_placeholder opt_with_options RECURRING string_or_placeholder
+  {
+    $$.val = &tree.CreateChangefeed{
+      Targets: $5.targetList(),
+      SinkURI: $7.expr(),
+      Options: $8.kvOptions(),
+      Recurrence: $10.expr(),
+    }
+  }
 
 opt_changefeed_sink:
   INTO string_or_placeholder
@@ -1954,6 +2029,15 @@ opt_changefeed_sink:
     $$.val = nil
   }
 
+create_external_connection_stmt:
+  CREATE EXTERNAL CONNECTION name AS string_or_placeholder
+  {
+    $$.val = &tree.CreateExternalConnection{
+      Name: tree.Name($4),
+      URI: $6.expr(),
+    }
+  }
+
 // %Help: DELETE - delete rows from a table
 // %Category: DML
 // %Text: DELETE FROM <tablename> [WHERE <expr>]
@@ -1998,6 +2082,7 @@ drop_stmt:
   drop_ddl_stmt      // help texts in sub-rule
 | drop_role_stmt     // EXTEND WITH HELP: DROP ROLE
 | drop_user_stmt     // EXTEND WITH HELP: DROP USER
+| drop_external_connection_stmt
 | DROP error         // SHOW HELP: DROP
 
 drop_ddl_stmt:
@@ -2128,6 +2213,16 @@ drop_role_stmt:
   }
 | DROP ROLE error // SHOW HELP: DROP ROLE
 
+drop_external_connection_stmt:
+  DROP EXTERNAL CONNECTION name
+  {
+    $$.val = &tree.DropExternalConnection{Name: tree.Name($4), IfExists: false}
+  }
+| DROP EXTERNAL CONNECTION IF EXISTS name
+  {
+    $$.val = &tree.DropExternalConnection{Name: tree.Name($6), IfExists: true}
+  }
+
 table_name_list:
   table_name
   {
@@ -3499,8 +3594,9 @@ for_grantee_clause:
 // %Help: PAUSE JOBS - pause background jobs
 // %Category: Misc
 // %Text:
-// PAUSE JOBS <selectclause>
-// PAUSE JOB <jobid>
+// PAUSE JOBS <selectclause> [WITH REASON = <reason>]
+// PAUSE JOB <jobid> [WITH REASON = <reason>]
+// PAUSE ALL <jobtype> JOBS [WITH REASON = <reason>]
 // %SeeAlso: SHOW JOBS, CANCEL JOBS, RESUME JOBS
 pause_stmt:
   PAUSE JOB a_expr
@@ -3512,10 +3608,34 @@ pause_stmt:
       Command: tree.PauseJob,
     }
   }
+| PAUSE JOB a_expr WITH REASON '=' string_or_placeholder
+  {
+    $$.val = &tree.ControlJobs{
+      Jobs: &tree.Select{
+        Select: &tree.ValuesClause{Tuples: []*tree.Tuple{{Exprs: tree.Exprs{$3.expr()}}}},
+      },
+      Command: tree.PauseJob,
+      Reason: $7.expr(),
+    }
+  }
 | PAUSE JOBS select_stmt
   {
     $$.val = &tree.ControlJobs{Jobs: $3.slct(), Command: tree.PauseJob}
   }
+| PAUSE JOBS select_stmt WITH REASON '=' string_or_placeholder
+  {
+    $$.val = &tree.ControlJobs{Jobs: $3.slct(), Command: tree.PauseJob, Reason: $7.expr()}
+  }
+| PAUSE ALL name JOBS
+  {
+    $$.val = &tree.ControlJobsOfType{Type: strings.ToUpper($3), Command: tree.PauseJob}
+  }
+| PAUSE ALL name JOBS WITH REASON '=' string_or_placeholder
+  {
+    $$.val = &tree.ControlJobsOfType{
+      Type: strings.ToUpper($3), Command: tree.PauseJob, Reason: $8.expr(),
+    }
+  }
 | PAUSE error // SHOW HELP: PAUSE JOBS
 
 // %Help: CREATE TABLE - create a new table
@@ -4412,6 +4532,7 @@ release_stmt:
 // %Text:
 // RESUME JOBS <selectclause>
 // RESUME JOB <jobid>
+// RESUME ALL <jobtype> JOBS
 // %SeeAlso: SHOW JOBS, CANCEL JOBS, PAUSE JOBS
 resume_stmt:
   RESUME JOB a_expr
@@ -4427,6 +4548,10 @@ resume_stmt:
   {
     $$.val = &tree.ControlJobs{Jobs: $3.slct(), Command: tree.ResumeJob}
   }
+| RESUME ALL name JOBS
+  {
+    $$.val = &tree.ControlJobsOfType{Type: strings.ToUpper($3), Command: tree.ResumeJob}
+  }
 | RESUME error // SHOW HELP: RESUME JOBS
 
 // %Help: SAVEPOINT - start a retryable block
@@ -8073,6 +8198,7 @@ unreserved_keyword:
 | COMMITTED
 | COMPACT
 | CONFLICT
+| CONNECTION
 | CONFIGURATION
 | CONFIGURATIONS
 | CONFIGURE
@@ -8104,6 +8230,7 @@ unreserved_keyword:
 | EXPERIMENTAL_REPLICA
 | EXPLAIN
 | EXPORT
+| EXTERNAL
 | FILES
 | FILTER
 | FIRST
@@ -8166,6 +8293,7 @@ unreserved_keyword:
 | ORDINALITY
 | OVER
 | OWNED
+| OWNER
 | PARENT
 | PARTIAL
 | PARTITION
@@ -8181,6 +8309,8 @@ unreserved_keyword:
 | RANGE
 | RANGES
 | READ
+| REASON
+| RECURRING
 | RECURSIVE
 | REF
 | REGCLASS
@@ -8206,6 +8336,7 @@ unreserved_keyword:
 | STATUS
 | SAVEPOINT
 | SCATTER
+| SCHEDULE
 | SCHEMA
 | SCHEMAS
 | SCRUB
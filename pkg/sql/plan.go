@@ -159,6 +159,7 @@ type planNodeFastPath interface {
 }
 
 var _ planNode = &alterIndexNode{}
+var _ planNode = &alterJobOwnerNode{}
 var _ planNode = &alterSequenceNode{}
 var _ planNode = &alterTableNode{}
 var _ planNode = &createDatabaseNode{}
@@ -209,6 +210,7 @@ var _ planNode = &zeroNode{}
 
 var _ planNodeFastPath = &CreateUserNode{}
 var _ planNodeFastPath = &DropUserNode{}
+var _ planNodeFastPath = &alterJobOwnerNode{}
 var _ planNodeFastPath = &alterUserSetPasswordNode{}
 var _ planNodeFastPath = &createTableNode{}
 var _ planNodeFastPath = &deleteNode{}
@@ -684,6 +686,8 @@ func (p *planner) newPlan(
 		return p.AlterTable(ctx, n)
 	case *tree.AlterSequence:
 		return p.AlterSequence(ctx, n)
+	case *tree.AlterJobOwner:
+		return p.AlterJobOwner(ctx, n)
 	case *tree.AlterUserSetPassword:
 		return p.AlterUserSetPassword(ctx, n)
 	case *tree.CancelQueries:
@@ -692,6 +696,8 @@ func (p *planner) newPlan(
 		return p.CancelSessions(ctx, n)
 	case *tree.ControlJobs:
 		return p.ControlJobs(ctx, n)
+	case *tree.ControlJobsOfType:
+		return p.ControlJobsOfType(ctx, n)
 	case *tree.Scrub:
 		return p.Scrub(ctx, n)
 	case *tree.CreateDatabase:
@@ -863,6 +869,8 @@ func (p *planner) doPrepare(ctx context.Context, stmt tree.Statement) (planNode,
 	p.isPreparing = true
 
 	switch n := stmt.(type) {
+	case *tree.AlterJobOwner:
+		return p.AlterJobOwner(ctx, n)
 	case *tree.AlterUserSetPassword:
 		return p.AlterUserSetPassword(ctx, n)
 	case *tree.CancelQueries:
@@ -871,6 +879,8 @@ func (p *planner) doPrepare(ctx context.Context, stmt tree.Statement) (planNode,
 		return p.CancelSessions(ctx, n)
 	case *tree.ControlJobs:
 		return p.ControlJobs(ctx, n)
+	case *tree.ControlJobsOfType:
+		return p.ControlJobsOfType(ctx, n)
 	case *tree.CreateUser:
 		return p.CreateUser(ctx, n)
 	case *tree.CreateTable:
@@ -40,15 +40,19 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/build"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/sql/coltypes"
+	"github.com/cockroachdb/cockroach/pkg/sql/jobs/jobspb"
 	"github.com/cockroachdb/cockroach/pkg/sql/lex"
 	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/types"
 	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
 	"github.com/cockroachdb/cockroach/pkg/util/duration"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/ipaddr"
 	"github.com/cockroachdb/cockroach/pkg/util/json"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/cockroach/pkg/util/timeofday"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
@@ -2858,6 +2862,185 @@ may increase either contention or retry errors, or both.`,
 				"Incorrect use can severely impact performance.",
 		},
 	),
+
+	// changefeed_emit_marker records an application-defined marker that the
+	// changefeed emits along with its changes, so that downstream consumers can
+	// coordinate on a point in the stream.
+	"crdb_internal.changefeed_emit_marker": makeBuiltin(
+		tree.FunctionProperties{
+			Category: categorySystemInfo,
+			Impure:   true,
+		},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"job_id", types.Int}, {"marker", types.String}},
+			ReturnType: tree.FixedReturnType(types.Decimal),
+			Fn: func(ctx *tree.EvalContext, args tree.Datums) (tree.Datum, error) {
+				return emitChangefeedMarker(
+					ctx, int64(tree.MustBeDInt(args[0])), string(tree.MustBeDString(args[1])))
+			},
+			Info: "Emits `marker` from the changefeed job `job_id` at the commit timestamp of the " +
+				"current transaction, once every change at or below it has been emitted, and " +
+				"returns the timestamp like cluster_logical_timestamp(). Markers are only " +
+				"emitted by changefeeds with format=json.",
+		},
+	),
+
+	// wait_for_changefeed blocks until a changefeed has emitted every change up
+	// to a timestamp, so that scripts can write, wait for the writes to
+	// propagate through the feed, and go on.
+	"crdb_internal.wait_for_changefeed": makeBuiltin(
+		tree.FunctionProperties{
+			Category: categorySystemInfo,
+			Impure:   true,
+		},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"job_id", types.Int}, {"timestamp", types.Decimal}},
+			ReturnType: tree.FixedReturnType(types.Decimal),
+			Fn: func(ctx *tree.EvalContext, args tree.Datums) (tree.Datum, error) {
+				return waitForChangefeed(ctx, int64(tree.MustBeDInt(args[0])), args[1].(*tree.DDecimal))
+			},
+			Info: "Waits until the resolved timestamp of the changefeed job `job_id` is at or " +
+				"past `timestamp`, given like the result of cluster_logical_timestamp(), and " +
+				"returns the resolved timestamp. Use a statement timeout to limit the wait.",
+		},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"job_id", types.Int}, {"timestamp", types.TimestampTZ}},
+			ReturnType: tree.FixedReturnType(types.Decimal),
+			Fn: func(ctx *tree.EvalContext, args tree.Datums) (tree.Datum, error) {
+				ts := hlc.Timestamp{WallTime: args[1].(*tree.DTimestampTZ).UnixNano()}
+				return waitForChangefeed(ctx, int64(tree.MustBeDInt(args[0])), tree.TimestampToDecimal(ts))
+			},
+			Info: "Waits until the resolved timestamp of the changefeed job `job_id` is at or " +
+				"past `timestamp` and returns the resolved timestamp. Use a statement timeout " +
+				"to limit the wait.",
+		},
+	),
+	// job_trace is how `cockroach debug job-trace` collects what a running
+	// job, like a stuck changefeed, is doing without restarting it.
+	"crdb_internal.job_trace": makeBuiltin(
+		tree.FunctionProperties{
+			Category: categorySystemInfo,
+			Impure:   true,
+		},
+		tree.Overload{
+			Types:      tree.ArgTypes{{"job_id", types.Int}, {"duration", types.Interval}},
+			ReturnType: tree.FixedReturnType(types.JSON),
+			Fn: func(ctx *tree.EvalContext, args tree.Datums) (tree.Datum, error) {
+				secs := args[1].(*tree.DInterval).Duration.AsFloat64()
+				trace, err := ctx.Planner.TraceJob(
+					ctx.Ctx(), int64(tree.MustBeDInt(args[0])), time.Duration(secs*float64(time.Second)),
+				)
+				if err != nil {
+					return nil, err
+				}
+				return tree.NewDJSON(trace), nil
+			},
+			Info: "Records the trace of the job `job_id`, which must be running on the node " +
+				"the function is called on, for `duration` and returns it along with the state " +
+				"the job reports, like the frontier and sink of a changefeed.",
+		},
+	),
+}
+
+// emitChangefeedMarker records a changefeed_marker event for a changefeed job
+// in the transaction of the statement. The changefeed picks it up from the
+// event log when its resolved timestamp passes the transaction's commit
+// timestamp, which is fixed here so that it can be returned.
+//
+// The event is inserted with the commit timestamp in its timestamp column, so
+// that the changefeed only has to look at the events around its resolved
+// timestamp. Its info has the job, the marker, and the exact commit timestamp.
+func emitChangefeedMarker(ctx *tree.EvalContext, jobID int64, marker string) (tree.Datum, error) {
+	row, err := ctx.InternalExecutor.QueryRow(
+		ctx.Ctx(), "changefeed-emit-marker", ctx.Txn,
+		`SELECT status, payload FROM system.jobs WHERE id = $1`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if len(row) == 0 {
+		return nil, pgerror.NewErrorf(pgerror.CodeUndefinedObjectError, "job %d does not exist", jobID)
+	}
+	switch status := string(tree.MustBeDString(row[0])); status {
+	case "failed", "canceled", "succeeded":
+		return nil, pgerror.NewErrorf(pgerror.CodeObjectNotInPrerequisiteStateError,
+			"changefeed job %d is %s", jobID, status)
+	}
+	var payload jobspb.Payload
+	if err := protoutil.Unmarshal([]byte(tree.MustBeDBytes(row[1])), &payload); err != nil {
+		return nil, err
+	}
+	if payload.GetChangefeed() == nil {
+		return nil, pgerror.NewErrorf(pgerror.CodeInvalidParameterValueError,
+			"job %d is not a changefeed", jobID)
+	}
+
+	ts := ctx.GetClusterTimestamp()
+	info, err := json.MakeJSON(map[string]interface{}{
+		"JobID":     jobID,
+		"Marker":    marker,
+		"Timestamp": ts.Decimal.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	wallTime := timeutil.Unix(0, ctx.Txn.CommitTimestamp().WallTime)
+	// The event type is sql.EventLogChangefeedMarker, which can't be imported
+	// here.
+	if _, err := ctx.InternalExecutor.QueryRow(
+		ctx.Ctx(), "changefeed-emit-marker", ctx.Txn,
+		`INSERT INTO system.eventlog (timestamp, "eventType", "targetID", "reportingID", info) `+
+			`VALUES ($1, 'changefeed_marker', 0, $2, $3) RETURNING 1`,
+		wallTime, int64(ctx.NodeID), info.String(),
+	); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// waitForChangefeed polls the highwater of a changefeed job until it's at or
+// past target, and returns it. It gives up if the job stops for good, but not
+// if it's paused, because it may yet be resumed.
+func waitForChangefeed(
+	ctx *tree.EvalContext, jobID int64, target *tree.DDecimal,
+) (tree.Datum, error) {
+	retryOpts := retry.Options{
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+	}
+	for r := retry.StartWithCtx(ctx.Ctx(), retryOpts); r.Next(); {
+		// The highwater is read in a new transaction every time, the one of the
+		// statement would never see it move.
+		row, err := ctx.InternalExecutor.QueryRow(
+			ctx.Ctx(), "wait-for-changefeed", nil, /* txn */
+			`SELECT status, progress FROM system.jobs WHERE id = $1`, jobID)
+		if err != nil {
+			return nil, err
+		}
+		if len(row) == 0 {
+			return nil, pgerror.NewErrorf(pgerror.CodeUndefinedObjectError, "job %d does not exist", jobID)
+		}
+		switch status := string(tree.MustBeDString(row[0])); status {
+		case "failed", "canceled", "succeeded":
+			return nil, pgerror.NewErrorf(pgerror.CodeObjectNotInPrerequisiteStateError,
+				"changefeed job %d is %s", jobID, status)
+		}
+		var progress jobspb.Progress
+		if row[1] != tree.DNull {
+			if err := protoutil.Unmarshal([]byte(tree.MustBeDBytes(row[1])), &progress); err != nil {
+				return nil, err
+			}
+		}
+		changefeed := progress.GetChangefeed()
+		if changefeed == nil {
+			return nil, pgerror.NewErrorf(pgerror.CodeInvalidParameterValueError,
+				"job %d is not a changefeed", jobID)
+		}
+		if resolved := tree.TimestampToDecimal(changefeed.Highwater); resolved.Cmp(&target.Decimal) >= 0 {
+			return resolved, nil
+		}
+	}
+	return nil, ctx.Ctx().Err()
 }
 
 var lengthImpls = makeBuiltin(tree.FunctionProperties{Category: categoryString},
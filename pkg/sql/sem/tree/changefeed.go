@@ -17,16 +17,30 @@ package tree
 // CreateChangefeed represents a CREATE CHANGEFEED statement.
 type CreateChangefeed struct {
 	Targets TargetList
-	SinkURI Expr
-	Options KVOptions
+	// AllTables is set for CREATE CHANGEFEED FOR ALL TABLES, in which case
+	// Targets is empty.
+	AllTables bool
+	SinkURI   Expr
+	Options   KVOptions
+	// Recurrence is set for CREATE SCHEDULE FOR CHANGEFEED, which runs the
+	// changefeed again every time the recurrence comes around.
+	Recurrence Expr
 }
 
 var _ Statement = &CreateChangefeed{}
 
 // Format implements the NodeFormatter interface.
 func (node *CreateChangefeed) Format(ctx *FmtCtx) {
-	ctx.WriteString("CREATE CHANGEFEED FOR ")
-	ctx.FormatNode(&node.Targets)
+	if node.Recurrence != nil {
+		ctx.WriteString("CREATE SCHEDULE FOR CHANGEFEED ")
+	} else {
+		ctx.WriteString("CREATE CHANGEFEED FOR ")
+	}
+	if node.AllTables {
+		ctx.WriteString("ALL TABLES")
+	} else {
+		ctx.FormatNode(&node.Targets)
+	}
 	if node.SinkURI != nil {
 		ctx.WriteString(" INTO ")
 		ctx.FormatNode(node.SinkURI)
@@ -35,4 +49,102 @@ func (node *CreateChangefeed) Format(ctx *FmtCtx) {
 		ctx.WriteString(" WITH ")
 		ctx.FormatNode(&node.Options)
 	}
+	if node.Recurrence != nil {
+		ctx.WriteString(" RECURRING ")
+		ctx.FormatNode(node.Recurrence)
+	}
+}
+
+// AlterChangefeed represents an ALTER CHANGEFEED statement.
+type AlterChangefeed struct {
+	Jobs Expr
+	Cmds AlterChangefeedCmds
+}
+
+var _ Statement = &AlterChangefeed{}
+
+// Format implements the NodeFormatter interface.
+func (node *AlterChangefeed) Format(ctx *FmtCtx) {
+	ctx.WriteString("ALTER CHANGEFEED ")
+	ctx.FormatNode(node.Jobs)
+	ctx.FormatNode(&node.Cmds)
+}
+
+// AlterChangefeedCmds represents a list of changefeed alterations.
+type AlterChangefeedCmds []AlterChangefeedCmd
+
+// Format implements the NodeFormatter interface.
+func (node *AlterChangefeedCmds) Format(ctx *FmtCtx) {
+	for _, n := range *node {
+		ctx.FormatNode(n)
+	}
+}
+
+// AlterChangefeedCmd represents a changefeed modification operation.
+type AlterChangefeedCmd interface {
+	NodeFormatter
+	// Placeholder function to ensure that only desired types
+	// (AlterChangefeed*) conform to the AlterChangefeedCmd interface.
+	alterChangefeedCmd()
+}
+
+func (*AlterChangefeedAddTarget) alterChangefeedCmd()  {}
+func (*AlterChangefeedDropTarget) alterChangefeedCmd() {}
+
+var _ AlterChangefeedCmd = &AlterChangefeedAddTarget{}
+var _ AlterChangefeedCmd = &AlterChangefeedDropTarget{}
+
+// AlterChangefeedAddTarget represents an ADD <targets> command.
+type AlterChangefeedAddTarget struct {
+	Targets TargetList
+}
+
+// Format implements the NodeFormatter interface.
+func (node *AlterChangefeedAddTarget) Format(ctx *FmtCtx) {
+	ctx.WriteString(" ADD ")
+	ctx.FormatNode(&node.Targets)
+}
+
+// AlterChangefeedDropTarget represents a DROP <targets> command.
+type AlterChangefeedDropTarget struct {
+	Targets TargetList
+}
+
+// Format implements the NodeFormatter interface.
+func (node *AlterChangefeedDropTarget) Format(ctx *FmtCtx) {
+	ctx.WriteString(" DROP ")
+	ctx.FormatNode(&node.Targets)
+}
+
+// CreateExternalConnection represents a CREATE EXTERNAL CONNECTION statement.
+type CreateExternalConnection struct {
+	Name Name
+	URI  Expr
+}
+
+var _ Statement = &CreateExternalConnection{}
+
+// Format implements the NodeFormatter interface.
+func (node *CreateExternalConnection) Format(ctx *FmtCtx) {
+	ctx.WriteString("CREATE EXTERNAL CONNECTION ")
+	ctx.FormatNode(&node.Name)
+	ctx.WriteString(" AS ")
+	ctx.FormatNode(node.URI)
+}
+
+// DropExternalConnection represents a DROP EXTERNAL CONNECTION statement.
+type DropExternalConnection struct {
+	Name     Name
+	IfExists bool
+}
+
+var _ Statement = &DropExternalConnection{}
+
+// Format implements the NodeFormatter interface.
+func (node *DropExternalConnection) Format(ctx *FmtCtx) {
+	ctx.WriteString("DROP EXTERNAL CONNECTION ")
+	if node.IfExists {
+		ctx.WriteString("IF EXISTS ")
+	}
+	ctx.FormatNode(&node.Name)
 }
@@ -2346,6 +2346,10 @@ type EvalPlanner interface {
 
 	// EvalSubquery returns the Datum for the given subquery node.
 	EvalSubquery(expr *Subquery) (Datum, error)
+
+	// TraceJob records the trace of a job running on this node for the given
+	// duration and returns it, along with the state of the job, as JSON.
+	TraceJob(ctx context.Context, jobID int64, d time.Duration) (json.JSON, error)
 }
 
 // SessionBoundInternalExecutor is a subset of sqlutil.InternalExecutor used by
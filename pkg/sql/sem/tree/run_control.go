@@ -18,6 +18,9 @@ package tree
 type ControlJobs struct {
 	Jobs    *Select
 	Command JobCommand
+	// Reason, if set, is why the jobs are paused. It's only allowed with
+	// PauseJob.
+	Reason Expr
 }
 
 // JobCommand determines which type of action to effect on the selected job(s).
@@ -42,6 +45,47 @@ func (n *ControlJobs) Format(ctx *FmtCtx) {
 	ctx.WriteString(JobCommandToStatement[n.Command])
 	ctx.WriteString(" JOBS ")
 	ctx.FormatNode(n.Jobs)
+	if n.Reason != nil {
+		ctx.WriteString(" WITH REASON = ")
+		ctx.FormatNode(n.Reason)
+	}
+}
+
+// ControlJobsOfType represents a PAUSE/RESUME ALL ... JOBS statement, which
+// controls all the jobs of a type, like CHANGEFEED.
+type ControlJobsOfType struct {
+	// Type is the type of the jobs, as in the type column of SHOW JOBS.
+	Type    string
+	Command JobCommand
+	// Reason, if set, is why the jobs are paused. It's only allowed with
+	// PauseJob.
+	Reason Expr
+}
+
+// Format implements the NodeFormatter interface.
+func (n *ControlJobsOfType) Format(ctx *FmtCtx) {
+	ctx.WriteString(JobCommandToStatement[n.Command])
+	ctx.WriteString(" ALL ")
+	ctx.WriteString(n.Type)
+	ctx.WriteString(" JOBS")
+	if n.Reason != nil {
+		ctx.WriteString(" WITH REASON = ")
+		ctx.FormatNode(n.Reason)
+	}
+}
+
+// AlterJobOwner represents an ALTER JOB ... OWNER TO statement.
+type AlterJobOwner struct {
+	Job   Expr
+	Owner Name
+}
+
+// Format implements the NodeFormatter interface.
+func (n *AlterJobOwner) Format(ctx *FmtCtx) {
+	ctx.WriteString("ALTER JOB ")
+	ctx.FormatNode(n.Job)
+	ctx.WriteString(" OWNER TO ")
+	ctx.FormatNode(&n.Owner)
 }
 
 // CancelQueries represents a CANCEL QUERIES statement.
@@ -191,6 +191,18 @@ func (*AlterSequence) StatementType() StatementType { return DDL }
 // StatementTag returns a short string identifying the type of statement.
 func (*AlterSequence) StatementTag() string { return "ALTER SEQUENCE" }
 
+// StatementType implements the Statement interface.
+func (*AlterChangefeed) StatementType() StatementType { return RowsAffected }
+
+// StatementTag returns a short string identifying the type of statement.
+func (*AlterChangefeed) StatementTag() string { return "ALTER CHANGEFEED" }
+
+// StatementType implements the Statement interface.
+func (*AlterJobOwner) StatementType() StatementType { return RowsAffected }
+
+// StatementTag returns a short string identifying the type of statement.
+func (*AlterJobOwner) StatementTag() string { return "ALTER JOB" }
+
 // StatementType implements the Statement interface.
 func (*AlterUserSetPassword) StatementType() StatementType { return RowsAffected }
 
@@ -225,6 +237,16 @@ func (n *ControlJobs) StatementTag() string {
 
 func (*ControlJobs) independentFromParallelizedPriors() {}
 
+// StatementType implements the Statement interface.
+func (*ControlJobsOfType) StatementType() StatementType { return RowsAffected }
+
+// StatementTag returns a short string identifying the type of statement.
+func (n *ControlJobsOfType) StatementTag() string {
+	return fmt.Sprintf("%s ALL JOBS", JobCommandToStatement[n.Command])
+}
+
+func (*ControlJobsOfType) independentFromParallelizedPriors() {}
+
 // StatementType implements the Statement interface.
 func (*CancelQueries) StatementType() StatementType { return RowsAffected }
 
@@ -267,6 +289,14 @@ func (*CreateDatabase) StatementType() StatementType { return DDL }
 // StatementTag returns a short string identifying the type of statement.
 func (*CreateDatabase) StatementTag() string { return "CREATE DATABASE" }
 
+// StatementType implements the Statement interface.
+func (*CreateExternalConnection) StatementType() StatementType { return RowsAffected }
+
+// StatementTag returns a short string identifying the type of statement.
+func (*CreateExternalConnection) StatementTag() string { return "CREATE EXTERNAL CONNECTION" }
+
+func (*CreateExternalConnection) hiddenFromShowQueries() {}
+
 // StatementType implements the Statement interface.
 func (*CreateIndex) StatementType() StatementType { return DDL }
 
@@ -358,6 +388,12 @@ func (*DropDatabase) StatementType() StatementType { return DDL }
 // StatementTag returns a short string identifying the type of statement.
 func (*DropDatabase) StatementTag() string { return "DROP DATABASE" }
 
+// StatementType implements the Statement interface.
+func (*DropExternalConnection) StatementType() StatementType { return RowsAffected }
+
+// StatementTag returns a short string identifying the type of statement.
+func (*DropExternalConnection) StatementTag() string { return "DROP EXTERNAL CONNECTION" }
+
 // StatementType implements the Statement interface.
 func (*DropIndex) StatementType() StatementType { return DDL }
 
@@ -876,7 +912,9 @@ func (*ValuesClause) StatementType() StatementType { return Rows }
 // StatementTag returns a short string identifying the type of statement.
 func (*ValuesClause) StatementTag() string { return "VALUES" }
 
+func (n *AlterChangefeed) String() string           { return AsString(n) }
 func (n *AlterIndex) String() string                { return AsString(n) }
+func (n *AlterJobOwner) String() string             { return AsString(n) }
 func (n *AlterTable) String() string                { return AsString(n) }
 func (n *AlterTableCmds) String() string            { return AsString(n) }
 func (n *AlterTableAddColumn) String() string       { return AsString(n) }
@@ -892,12 +930,14 @@ func (n *AlterSequence) String() string             { return AsString(n) }
 func (n *Backup) String() string                    { return AsString(n) }
 func (n *BeginTransaction) String() string          { return AsString(n) }
 func (n *ControlJobs) String() string               { return AsString(n) }
+func (n *ControlJobsOfType) String() string         { return AsString(n) }
 func (n *CancelQueries) String() string             { return AsString(n) }
 func (n *CancelSessions) String() string            { return AsString(n) }
 func (n *CommitTransaction) String() string         { return AsString(n) }
 func (n *CopyFrom) String() string                  { return AsString(n) }
 func (n *CreateChangefeed) String() string          { return AsString(n) }
 func (n *CreateDatabase) String() string            { return AsString(n) }
+func (n *CreateExternalConnection) String() string  { return AsString(n) }
 func (n *CreateIndex) String() string               { return AsString(n) }
 func (n *CreateRole) String() string                { return AsString(n) }
 func (n *CreateTable) String() string               { return AsString(n) }
@@ -908,6 +948,7 @@ func (n *CreateView) String() string                { return AsString(n) }
 func (n *Deallocate) String() string                { return AsString(n) }
 func (n *Delete) String() string                    { return AsString(n) }
 func (n *DropDatabase) String() string              { return AsString(n) }
+func (n *DropExternalConnection) String() string    { return AsString(n) }
 func (n *DropIndex) String() string                 { return AsString(n) }
 func (n *DropRole) String() string                  { return AsString(n) }
 func (n *DropTable) String() string                 { return AsString(n) }
@@ -25,7 +25,8 @@ import (
 func (p *planner) ShowJobs(ctx context.Context, n *tree.ShowJobs) (planNode, error) {
 	return p.delegateQuery(ctx, "SHOW JOBS",
 		`SELECT id, type, description, username, status, created, started, finished, modified,
-            fraction_completed, error, coordinator_id
+            fraction_completed, error, pause_reason, coordinator_id, changefeed_tables,
+            changefeed_sink, changefeed_options
        FROM crdb_internal.jobs`,
 		nil, nil)
 }
@@ -184,6 +184,17 @@ CREATE TABLE system.role_members (
   INDEX ("role"),
   INDEX ("member")
 );`
+
+	// external_connections stores named, access-controlled URIs (e.g. changefeed
+	// sinks along with their credentials) that can be referenced by name.
+	ExternalConnectionsTableSchema = `
+CREATE TABLE system.external_connections (
+  name    STRING    NOT NULL PRIMARY KEY,
+  uri     STRING    NOT NULL,
+  owner   STRING    NOT NULL,
+  created TIMESTAMP NOT NULL DEFAULT now(),
+  FAMILY (name, uri, owner, created)
+);`
 )
 
 func pk(name string) IndexDescriptor {
@@ -218,11 +229,12 @@ var SystemAllowedPrivileges = map[ID]privilege.List{
 	// users will be able to modify system tables' schemas at will. CREATE and
 	// DROP privileges are allowed on the above system tables for backwards
 	// compatibility reasons only!
-	keys.JobsTableID:            privilege.ReadWriteData,
-	keys.WebSessionsTableID:     privilege.ReadWriteData,
-	keys.TableStatisticsTableID: privilege.ReadWriteData,
-	keys.LocationsTableID:       privilege.ReadWriteData,
-	keys.RoleMembersTableID:     privilege.ReadWriteData,
+	keys.JobsTableID:                privilege.ReadWriteData,
+	keys.WebSessionsTableID:         privilege.ReadWriteData,
+	keys.TableStatisticsTableID:     privilege.ReadWriteData,
+	keys.LocationsTableID:           privilege.ReadWriteData,
+	keys.RoleMembersTableID:         privilege.ReadWriteData,
+	keys.ExternalConnectionsTableID: privilege.ReadWriteData,
 }
 
 // Helpers used to make some of the TableDescriptor literals below more concise.
@@ -792,6 +804,36 @@ var (
 		FormatVersion:  InterleavedFormatVersion,
 		NextMutationID: 1,
 	}
+
+	// ExternalConnectionsTable is the descriptor for the external_connections
+	// table.
+	ExternalConnectionsTable = TableDescriptor{
+		Name:     "external_connections",
+		ID:       keys.ExternalConnectionsTableID,
+		ParentID: keys.SystemDatabaseID,
+		Version:  1,
+		Columns: []ColumnDescriptor{
+			{Name: "name", ID: 1, Type: colTypeString},
+			{Name: "uri", ID: 2, Type: colTypeString},
+			{Name: "owner", ID: 3, Type: colTypeString},
+			{Name: "created", ID: 4, Type: colTypeTimestamp, DefaultExpr: &nowString},
+		},
+		NextColumnID: 5,
+		Families: []ColumnFamilyDescriptor{
+			{
+				Name:        "fam_0_name_uri_owner_created",
+				ID:          0,
+				ColumnNames: []string{"name", "uri", "owner", "created"},
+				ColumnIDs:   []ColumnID{1, 2, 3, 4},
+			},
+		},
+		NextFamilyID:   1,
+		PrimaryIndex:   pk("name"),
+		NextIndexID:    2,
+		Privileges:     NewCustomSuperuserPrivilegeDescriptor(SystemAllowedPrivileges[keys.ExternalConnectionsTableID]),
+		FormatVersion:  InterleavedFormatVersion,
+		NextMutationID: 1,
+	}
 )
 
 // Create a kv pair for the zone config for the given key and config value.
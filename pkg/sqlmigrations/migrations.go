@@ -190,6 +190,13 @@ var backwardCompatibleMigrations = []migrationDescriptor{
 		name:   "add progress to system.jobs",
 		workFn: addJobsProgress,
 	},
+	{
+		// Introduced in v2.1.
+		// TODO: Bake into v2.2.
+		name:             "create system.external_connections table",
+		workFn:           createExternalConnectionsTable,
+		newDescriptorIDs: staticIDs(keys.ExternalConnectionsTableID),
+	},
 }
 
 func staticIDs(ids ...sqlbase.ID) func(ctx context.Context, db db) ([]sqlbase.ID, error) {
@@ -503,6 +510,10 @@ func createSystemTable(ctx context.Context, r runner, desc sqlbase.TableDescript
 	return err
 }
 
+func createExternalConnectionsTable(ctx context.Context, r runner) error {
+	return createSystemTable(ctx, r, sqlbase.ExternalConnectionsTable)
+}
+
 var reportingOptOut = envutil.EnvOrDefaultBool("COCKROACH_SKIP_ENABLING_DIAGNOSTIC_REPORTING", false)
 
 func runStmtAsRootWithRetry(
@@ -32,6 +32,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/storage/abortspan"
 	"github.com/cockroachdb/cockroach/pkg/storage/engine"
 	"github.com/cockroachdb/cockroach/pkg/storage/engine/enginepb"
+	"github.com/cockroachdb/cockroach/pkg/storage/protectedts"
 	"github.com/cockroachdb/cockroach/pkg/storage/rditer"
 	"github.com/cockroachdb/cockroach/pkg/storage/storagebase"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
@@ -61,6 +62,10 @@ const (
 	// gcKeyVersionChunkBytes is the threshold size for splitting
 	// GCRequests into multiple batches.
 	gcKeyVersionChunkBytes = base.ChunkRaftCommandThresholdBytes
+
+	// gcProtectedTimestampsRefreshInterval is how often the protected
+	// timestamp records are reread, at most, see protectedts.Cache.
+	gcProtectedTimestampsRefreshInterval = 10 * time.Second
 )
 
 // gcQueue manages a queue of replicas slated to be scanned in their
@@ -78,11 +83,14 @@ const (
 // single priority. If any task is overdue, shouldQueue returns true.
 type gcQueue struct {
 	*baseQueue
+	// protected keeps the protected timestamp records, which extend the TTL
+	// of the ranges they protect.
+	protected *protectedts.Cache
 }
 
 // newGCQueue returns a new instance of gcQueue.
 func newGCQueue(store *Store, gossip *gossip.Gossip) *gcQueue {
-	gcq := &gcQueue{}
+	gcq := &gcQueue{protected: protectedts.NewCache(store.DB())}
 	gcq.baseQueue = newBaseQueue(
 		"gc", gcq, store, gossip,
 		queueConfig{
@@ -153,12 +161,19 @@ func (r gcQueueScore) String() string {
 func (gcq *gcQueue) shouldQueue(
 	ctx context.Context, now hlc.Timestamp, repl *Replica, sysCfg config.SystemConfig,
 ) (bool, float64) {
-	r := makeGCQueueScore(ctx, repl, now, sysCfg)
+	r := makeGCQueueScore(ctx, repl, now, sysCfg, gcq.protected)
 	return r.ShouldQueue, r.FinalScore
 }
 
+// makeGCQueueScore scores a replica with the TTL of its zone, extended so that
+// the data that's protected by the records in protected, as of their last
+// refresh, isn't counted as collectable.
 func makeGCQueueScore(
-	ctx context.Context, repl *Replica, now hlc.Timestamp, sysCfg config.SystemConfig,
+	ctx context.Context,
+	repl *Replica,
+	now hlc.Timestamp,
+	sysCfg config.SystemConfig,
+	protected *protectedts.Cache,
 ) gcQueueScore {
 	repl.mu.Lock()
 	ms := *repl.mu.state.Stats
@@ -171,11 +186,12 @@ func makeGCQueueScore(
 		log.Errorf(ctx, "could not find zone config for range %s: %s", repl, err)
 		return gcQueueScore{}
 	}
+	policy := protectedGCPolicy(zone.GC, now, protected.EarliestProtected(rangeSpan(desc), now))
 	// Use desc.RangeID for fuzzing the final score, so that different ranges
 	// have slightly different priorities and even symmetrical workloads don't
 	// trigger GC at the same time.
 	r := makeGCQueueScoreImpl(
-		ctx, int64(desc.RangeID), now, ms, zone.GC.TTLSeconds,
+		ctx, int64(desc.RangeID), now, ms, policy.TTLSeconds,
 	)
 	if (gcThreshold != hlc.Timestamp{}) {
 		r.LikelyLastGC = time.Duration(now.WallTime - gcThreshold.Add(r.TTL.Nanoseconds(), 0).WallTime)
@@ -495,8 +511,11 @@ func processAbortSpan(
 // 7) push these transactions (again, recreating txn entries).
 // 8) send a GCRequest.
 func (gcq *gcQueue) process(ctx context.Context, repl *Replica, sysCfg config.SystemConfig) error {
+	if err := gcq.refreshProtected(ctx); err != nil {
+		return err
+	}
 	now := repl.store.Clock().Now()
-	r := makeGCQueueScore(ctx, repl, now, sysCfg)
+	r := makeGCQueueScore(ctx, repl, now, sysCfg, gcq.protected)
 	if !r.ShouldQueue {
 		log.Eventf(ctx, "skipping replica; low score %s", r)
 		return nil
@@ -579,8 +598,14 @@ func (gcq *gcQueue) processImpl(
 	if err != nil {
 		return errors.Errorf("could not find zone config for range %s: %s", repl, err)
 	}
+	// Data that's protected by a protected timestamp record is kept, however
+	// old it is.
+	if err := gcq.refreshProtected(ctx); err != nil {
+		return err
+	}
+	policy := protectedGCPolicy(zone.GC, now, gcq.protected.EarliestProtected(rangeSpan(desc), now))
 
-	info, err := RunGC(ctx, desc, snap, now, zone.GC, &replicaGCer{repl: repl},
+	info, err := RunGC(ctx, desc, snap, now, policy, &replicaGCer{repl: repl},
 		func(ctx context.Context, intents []roachpb.Intent) error {
 			intentCount, err := repl.store.intentResolver.cleanupIntents(ctx, intents, now, roachpb.PUSH_ABORT)
 			if err == nil {
@@ -601,12 +626,43 @@ func (gcq *gcQueue) processImpl(
 	}
 
 	log.Eventf(ctx, "MVCC stats after GC: %+v", repl.GetMVCCStats())
-	log.Eventf(ctx, "GC score after GC: %s", makeGCQueueScore(ctx, repl, repl.store.Clock().Now(), sysCfg))
+	log.Eventf(ctx, "GC score after GC: %s",
+		makeGCQueueScore(ctx, repl, repl.store.Clock().Now(), sysCfg, gcq.protected))
 	info.updateMetrics(gcq.store.metrics)
 
 	return nil
 }
 
+// refreshProtected rereads the protected timestamp records if they weren't
+// read recently.
+func (gcq *gcQueue) refreshProtected(ctx context.Context) error {
+	err := gcq.protected.MaybeRefresh(ctx, gcProtectedTimestampsRefreshInterval)
+	return errors.Wrap(err, "could not read protected timestamps")
+}
+
+// rangeSpan returns the span of the keys of a range.
+func rangeSpan(desc *roachpb.RangeDescriptor) roachpb.Span {
+	return roachpb.Span{Key: desc.StartKey.AsRawKey(), EndKey: desc.EndKey.AsRawKey()}
+}
+
+// protectedGCPolicy returns policy with its TTL extended, if need be, so that
+// the GC threshold doesn't pass the protected timestamp, if any. The GC queue
+// scores ranges with the extended TTL too, so a protected range isn't queued
+// again and again for versions it can't collect.
+func protectedGCPolicy(policy config.GCPolicy, now, protected hlc.Timestamp) config.GCPolicy {
+	if protected == (hlc.Timestamp{}) || !protected.Less(now) {
+		return policy
+	}
+	ttlSeconds := (now.WallTime - protected.WallTime + 1e9 - 1) / 1e9
+	if ttlSeconds > math.MaxInt32 {
+		ttlSeconds = math.MaxInt32
+	}
+	if ttlSeconds > int64(policy.TTLSeconds) {
+		policy.TTLSeconds = int32(ttlSeconds)
+	}
+	return policy
+}
+
 // GCInfo contains statistics and insights from a GC run.
 type GCInfo struct {
 	// Now is the timestamp used for age computations.
@@ -27,6 +27,7 @@ import (
 	"github.com/pkg/errors"
 	"golang.org/x/sync/syncmap"
 
+	"github.com/cockroachdb/cockroach/pkg/config"
 	"github.com/cockroachdb/cockroach/pkg/keys"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/storage/engine"
@@ -52,6 +53,32 @@ func makeTS(nanos int64, logical int32) hlc.Timestamp {
 	}
 }
 
+func TestGCQueueProtectedGCPolicy(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	now := hlc.Timestamp{WallTime: 100e9, Logical: 3}
+	policy := config.GCPolicy{TTLSeconds: 10}
+	for _, tc := range []struct {
+		protected hlc.Timestamp
+		expected  int32
+	}{
+		{hlc.Timestamp{}, 10},
+		{hlc.Timestamp{WallTime: 95e9}, 10},
+		{hlc.Timestamp{WallTime: 50e9}, 50},
+		{hlc.Timestamp{WallTime: 50e9 - 1, Logical: 7}, 51},
+		{hlc.Timestamp{WallTime: 200e9}, 10},
+	} {
+		p := protectedGCPolicy(policy, now, tc.protected)
+		if p.TTLSeconds != tc.expected {
+			t.Errorf("%s: expected a TTL of %ds got %ds", tc.protected, tc.expected, p.TTLSeconds)
+		}
+		if gc := engine.MakeGarbageCollector(now, p); tc.protected.Less(gc.Threshold) &&
+			tc.protected != (hlc.Timestamp{}) {
+			t.Errorf("%s: GC threshold %s is past the protected timestamp", tc.protected, gc.Threshold)
+		}
+	}
+}
+
 func TestGCQueueScoreString(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	for i, c := range []struct {
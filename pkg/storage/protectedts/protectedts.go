@@ -0,0 +1,154 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package protectedts keeps the records that protect spans of data from
+// garbage collection. The GC queue doesn't remove any version of the data in
+// a protected span that's needed to read it at (or incrementally after) the
+// protected timestamp, even once the version is older than the GC TTL of its
+// zone. This lets a long-running reader, like a changefeed that's paused or
+// behind, pick up where it was without the data it hasn't read yet being
+// garbage collected.
+//
+// Every record is stored under its own key in keys.ProtectedTimestampPrefix.
+// There are expected to be few of them, so the GC queue of a store keeps all of
+// them in a Cache, which it refreshes by rescanning them now and then.
+package protectedts
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/internal/client"
+	"github.com/cockroachdb/cockroach/pkg/keys"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/pkg/errors"
+)
+
+func recordKey(id string) roachpb.Key {
+	return append(append(roachpb.Key(nil), keys.ProtectedTimestampPrefix...), id...)
+}
+
+// Protect writes a record, replacing the record with the same ID, if any.
+func Protect(ctx context.Context, db *client.DB, rec Record) error {
+	if rec.ID == `` {
+		return errors.New(`protected timestamp record needs an ID`)
+	}
+	return db.Put(ctx, recordKey(rec.ID), &rec)
+}
+
+// Release removes a record, in txn, so that it can be removed along with the
+// rest of its owner's state. Releasing a record that doesn't exist is a no-op.
+func Release(ctx context.Context, txn *client.Txn, id string) error {
+	return txn.Del(ctx, recordKey(id))
+}
+
+// GetRecord returns the record with the given ID, or nil if there's none.
+func GetRecord(ctx context.Context, db *client.DB, id string) (*Record, error) {
+	kv, err := db.Get(ctx, recordKey(id))
+	if err != nil {
+		return nil, err
+	}
+	if !kv.Exists() {
+		return nil, nil
+	}
+	var rec Record
+	if err := kv.ValueProto(&rec); err != nil {
+		return nil, errors.Wrapf(err, `decoding protected timestamp record %s`, id)
+	}
+	return &rec, nil
+}
+
+// Cache keeps every record, as of its last refresh, so that finding the
+// protection of a range doesn't need a scan of its own.
+//
+// A record that's written after the last refresh isn't seen until the next
+// one, so a record only surely protects data that's still within the GC TTL
+// of its zone for the refresh interval of every cache. An owner that protects
+// its data as it reads it, like a changefeed, is always well within that.
+type Cache struct {
+	db *client.DB
+
+	mu struct {
+		syncutil.Mutex
+		records []Record
+		// refreshed is when the records were last read, or zero if they
+		// never were.
+		refreshed time.Time
+	}
+}
+
+// NewCache returns a Cache that reads the records with db. It's empty until
+// it's first refreshed.
+func NewCache(db *client.DB) *Cache {
+	return &Cache{db: db}
+}
+
+// MaybeRefresh rereads the records if they were last read longer than
+// interval ago.
+func (c *Cache) MaybeRefresh(ctx context.Context, interval time.Duration) error {
+	c.mu.Lock()
+	refreshed := c.mu.refreshed
+	c.mu.Unlock()
+	if !refreshed.IsZero() && timeutil.Since(refreshed) < interval {
+		return nil
+	}
+
+	start := timeutil.Now()
+	kvs, err := c.db.Scan(
+		ctx, keys.ProtectedTimestampPrefix, keys.ProtectedTimestampPrefix.PrefixEnd(), 0 /* maxRows */)
+	if err != nil {
+		return err
+	}
+	records := make([]Record, len(kvs))
+	for i, kv := range kvs {
+		if err := kv.ValueProto(&records[i]); err != nil {
+			return errors.Wrapf(err, `decoding protected timestamp record %s`, kv.Key)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Don't replace records read by a concurrent refresh that started later.
+	if start.After(c.mu.refreshed) {
+		c.mu.records, c.mu.refreshed = records, start
+	}
+	return nil
+}
+
+// EarliestProtected returns the earliest timestamp that the data of span is
+// protected at, as of now, or the zero timestamp if no cached record protects
+// any of it.
+func (c *Cache) EarliestProtected(span roachpb.Span, now hlc.Timestamp) hlc.Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var earliest hlc.Timestamp
+	for _, rec := range c.mu.records {
+		if rec.Expiration != (hlc.Timestamp{}) && rec.Expiration.Less(now) {
+			continue
+		}
+		if earliest != (hlc.Timestamp{}) && !rec.Timestamp.Less(earliest) {
+			continue
+		}
+		for _, sp := range rec.Spans {
+			if sp.Overlaps(span) {
+				earliest = rec.Timestamp
+				break
+			}
+		}
+	}
+	return earliest
+}
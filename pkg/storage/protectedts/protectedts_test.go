@@ -0,0 +1,78 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package protectedts
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+)
+
+func TestRecordEncoding(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	rec := Record{
+		ID:         `job-1`,
+		Timestamp:  hlc.Timestamp{WallTime: 1, Logical: 2},
+		Spans:      []roachpb.Span{{Key: roachpb.Key(`a`), EndKey: roachpb.Key(`b`)}},
+		Expiration: hlc.Timestamp{WallTime: 3},
+	}
+	buf, err := protoutil.Marshal(&rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Record
+	if err := protoutil.Unmarshal(buf, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rec, decoded) {
+		t.Errorf(`expected %+v got %+v`, rec, decoded)
+	}
+}
+
+func TestCacheEarliestProtected(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	span := func(key, endKey string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(key), EndKey: roachpb.Key(endKey)}
+	}
+	ts := func(wallTime int64) hlc.Timestamp { return hlc.Timestamp{WallTime: wallTime} }
+
+	var c Cache
+	c.mu.records = []Record{
+		{ID: `a`, Timestamp: ts(5), Spans: []roachpb.Span{span(`a`, `c`)}},
+		{ID: `b`, Timestamp: ts(3), Spans: []roachpb.Span{span(`x`, `y`), span(`b`, `d`)}},
+		{ID: `expired`, Timestamp: ts(1), Spans: []roachpb.Span{span(`a`, `z`)}, Expiration: ts(8)},
+	}
+	for _, tc := range []struct {
+		span     roachpb.Span
+		now      hlc.Timestamp
+		expected hlc.Timestamp
+	}{
+		{span(`a`, `b`), ts(7), ts(1)},
+		{span(`a`, `b`), ts(9), ts(5)},
+		{span(`a`, `z`), ts(9), ts(3)},
+		{span(`c`, `d`), ts(9), ts(3)},
+		{span(`d`, `e`), ts(9), hlc.Timestamp{}},
+	} {
+		if earliest := c.EarliestProtected(tc.span, tc.now); earliest != tc.expected {
+			t.Errorf(`%s at %s: expected %s got %s`, tc.span, tc.now, tc.expected, earliest)
+		}
+	}
+}
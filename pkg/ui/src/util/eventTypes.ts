@@ -55,6 +55,17 @@ export const SET_CLUSTER_SETTING = "set_cluster_setting";
 export const SET_ZONE_CONFIG = "set_zone_config";
 // Recorded when a zone config is removed.
 export const REMOVE_ZONE_CONFIG = "remove_zone_config";
+// Recorded when a changefeed falls further behind than its lag_alert option
+// allows.
+export const CHANGEFEED_LAG_ALERT = "changefeed_lag_alert";
+// Recorded when crdb_internal.changefeed_emit_marker is called.
+export const CHANGEFEED_MARKER = "changefeed_marker";
+// Recorded when a changefeed is paused, by a user or on its own.
+export const CHANGEFEED_PAUSED = "changefeed_paused";
+// Recorded when a paused changefeed is resumed.
+export const CHANGEFEED_RESUMED = "changefeed_resumed";
+// Recorded when a changefeed fails.
+export const CHANGEFEED_FAILED = "changefeed_failed";
 
 // Node Event Types
 export const nodeEvents = [NODE_JOIN, NODE_RESTART, NODE_DECOMMISSIONED, NODE_RECOMMISSIONED];
@@ -65,7 +76,11 @@ export const tableEvents = [
   FINISH_SCHEMA_CHANGE, FINISH_SCHEMA_CHANGE_ROLLBACK,
 ];
 export const settingsEvents = [SET_CLUSTER_SETTING, SET_ZONE_CONFIG, REMOVE_ZONE_CONFIG];
-export const allEvents = [...nodeEvents, ...databaseEvents, ...tableEvents, ...settingsEvents];
+export const jobEvents = [
+  CHANGEFEED_LAG_ALERT, CHANGEFEED_MARKER, CHANGEFEED_PAUSED, CHANGEFEED_RESUMED,
+  CHANGEFEED_FAILED,
+];
+export const allEvents = [...nodeEvents, ...databaseEvents, ...tableEvents, ...settingsEvents, ...jobEvents];
 
 const nodeEventSet = _.invert(nodeEvents);
 const databaseEventSet = _.invert(databaseEvents);
@@ -65,6 +65,19 @@ export function getEventDescription(e: Event$Properties): string {
     return `Zone Config Changed: User ${info.User} set the zone config for ${info.Target} to ${info.Config}`;
     case eventTypes.REMOVE_ZONE_CONFIG:
       return `Zone Config Removed: User ${info.User} removed the zone config for ${info.Target}`;
+    case eventTypes.CHANGEFEED_LAG_ALERT:
+      return `Changefeed Lagging: Changefeed job ${info.JobID} is ${info.Lag} behind, more than its lag_alert of ${info.Bound}`;
+    case eventTypes.CHANGEFEED_MARKER:
+      return `Changefeed Marker: Marker ${info.Marker} was emitted from changefeed job ${info.JobID}`;
+    case eventTypes.CHANGEFEED_PAUSED:
+      if (info.User) {
+        return `Changefeed Paused: User ${info.User} paused changefeed job ${info.JobID}`;
+      }
+      return `Changefeed Paused: Changefeed job ${info.JobID} paused itself: ${info.Reason}`;
+    case eventTypes.CHANGEFEED_RESUMED:
+      return `Changefeed Resumed: User ${info.User} resumed changefeed job ${info.JobID}`;
+    case eventTypes.CHANGEFEED_FAILED:
+      return `Changefeed Failed: Changefeed job ${info.JobID} failed: ${info.Reason}`;
     default:
       return `Unknown Event Type: ${e.event_type}, content: ${JSON.stringify(info, null, 2)}`;
   }
@@ -84,6 +97,11 @@ export interface EventInfo {
   Value?: string;
   Target?: string;
   Config?: string;
+  JobID?: string;
+  Lag?: string;
+  Bound?: string;
+  Marker?: string;
+  Reason?: string;
   // The following are three names for the same key (it was renamed twice).
   // All ar included for backwards compatibility.
   DroppedTables?: string[];
@@ -80,6 +80,17 @@ type PrometheusExportable interface {
 	ToPrometheusMetric() *prometheusgo.Metric
 }
 
+// PrometheusIterable is implemented by metrics that have children, each with
+// labels of its own, that are exported to prometheus in the same family as
+// the metric itself. The children are only exported to prometheus, what the
+// metric itself records is usually their aggregate.
+type PrometheusIterable interface {
+	PrometheusExportable
+	// EachChild calls the given closure with the prometheus metric of each
+	// child, with its labels filled in.
+	EachChild(func(*prometheusgo.Metric))
+}
+
 // GetName returns the metric's name.
 func (m *Metadata) GetName() string {
 	return m.Name
@@ -79,6 +79,14 @@ func (pm *PrometheusExporter) ScrapeRegistry(registry *Registry) {
 			family.Metric = append(family.Metric, m)
 		}
 	})
+	registry.eachPrometheusIterable(func(prom PrometheusIterable) {
+		family := pm.findOrCreateFamily(prom)
+		prom.EachChild(func(m *prometheusgo.Metric) {
+			// Set registry labels ahead of the child's.
+			m.Label = append(append([]*prometheusgo.LabelPair(nil), labels...), m.Label...)
+			family.Metric = append(family.Metric, m)
+		})
+	})
 }
 
 // PrintAsText writes all metrics in the families map to the io.Writer in
@@ -128,6 +128,18 @@ func (r *Registry) Each(f func(name string, val interface{})) {
 	}
 }
 
+// eachPrometheusIterable calls the given closure for all metrics that have
+// children to export to prometheus.
+func (r *Registry) eachPrometheusIterable(f func(PrometheusIterable)) {
+	r.Lock()
+	defer r.Unlock()
+	for _, metric := range r.tracked {
+		if prom, ok := metric.(PrometheusIterable); ok {
+			f(prom)
+		}
+	}
+}
+
 // MarshalJSON marshals to JSON.
 func (r *Registry) MarshalJSON() ([]byte, error) {
 	m := make(map[string]interface{})